@@ -2,8 +2,10 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"math/rand"
+	"net"
 	"os"
 	"sleepy/network"
 	"sleepy/network/kad"
@@ -11,13 +13,59 @@ import (
 )
 
 func main() {
-	networkManager := network.NewManager()
+	const udpPort, tcpPort = 4662, 4662
+	networkManager := network.NewManager(udpPort, tcpPort)
+
+	// The filter lists are optional, the ones found are merged
+	for _, path := range []string{"ipfilter.dat", "guarding.p2p"} {
+		loaded, err := networkManager.IPFilter().LoadFile(path)
+		if err == nil {
+			fmt.Println("Loaded", loaded, "IP filter ranges from", path)
+		} else if !os.IsNotExist(err) {
+			fmt.Println("IP filter", path, "not loaded", err)
+		}
+	}
+
+	// The command arguments are the ip:port addresses of the nodes used to bootstrap
+	var bootstrapAddrs []*net.UDPAddr
+	for _, arg := range os.Args[1:] {
+		addr, err := net.ResolveUDPAddr("udp", arg)
+		if err != nil {
+			fmt.Println("Invalid bootstrap address", arg, err)
+			continue
+		}
+		bootstrapAddrs = append(bootstrapAddrs, addr)
+	}
+
+	// The Kad ID is kept between the runs, as the contacts of nodes.dat are bucketed around it
+	preferences, err := kad.ReadPreferencesFile("preferencesKad.dat")
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Println("Kad preferences not loaded", err)
+		}
+		preferences = &kad.Preferences{ClientID: types.NewUInt128(rand.Uint64(), rand.Uint64())}
+		if err = kad.WritePreferencesFile("preferencesKad.dat", preferences); err != nil {
+			fmt.Println("Kad preferences not saved", err)
+		}
+	}
 
 	kadClient := kad.NewClient(kad.Config{
-		UdpPort:  4662,
-		ClientID: types.NewUInt128(rand.Uint64(), rand.Uint64()),
+		UdpPort:        udpPort,
+		TcpPort:        tcpPort,
+		ClientID:       preferences.ClientID,
+		BootstrapAddrs: bootstrapAddrs,
+		NodesFile:      "nodes.dat",
+		IndexFile:      "index.dat",
+		Obfuscation:    true,
 	}, networkManager)
-	kadClient.Start()
+	if err := networkManager.Start(); err != nil {
+		fmt.Println("Error starting the network", err)
+		return
+	}
+	err = kadClient.Start(context.Background())
+	if err != nil {
+		fmt.Println("Error starting KAD", err)
+	}
 
 	fmt.Println("Listening KAD")
 	reader := bufio.NewReader(os.Stdin)
@@ -25,4 +73,5 @@ func main() {
 	fmt.Println("Closing KAD")
 
 	kadClient.Stop()
+	networkManager.Stop()
 }
@@ -0,0 +1,167 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sleepy/network/common/tag"
+	"sleepy/types"
+)
+
+// Reader reads a little endian payload, as the Kad and eD2k packets are written
+type Reader struct {
+	data   []byte
+	offset int64
+}
+
+func NewReader(data []byte) *Reader {
+	return &Reader{
+		data:   data,
+		offset: 0,
+	}
+}
+
+func (reader *Reader) Read(buffer []byte) (n int, err error) {
+	if reader.offset < int64(len(reader.data)) {
+		bytesRead := copy(buffer, reader.data[reader.offset:])
+		reader.offset = reader.offset + int64(bytesRead)
+		return bytesRead, nil
+	} else {
+		return 0, errors.New("out of bounds")
+	}
+}
+
+func (reader *Reader) Seek(offset int64, whence int) (int64, error) {
+	if offset >= int64(len(reader.data)) || offset < 0 {
+		return 0, errors.New("out of bounds")
+	} else {
+		reader.offset = offset
+		return offset, nil
+	}
+}
+
+// Bytes returns the whole payload, including the already read part
+func (reader *Reader) Bytes() []byte {
+	return reader.data
+}
+
+// Remaining returns the part of the payload not read yet
+func (reader *Reader) Remaining() []byte {
+	if reader.offset >= int64(len(reader.data)) {
+		return nil
+	}
+	return reader.data[reader.offset:]
+}
+
+func (reader *Reader) Close() error {
+	return nil
+}
+
+func (reader *Reader) Discard(size int64) error {
+	_, err := reader.Seek(reader.offset+size, 0)
+	return err
+}
+
+func (reader *Reader) ReadByte() (byte, error) {
+	buffer, err := reader.ReadBytes(1)
+	if err != nil {
+		return 0x00, err
+	} else {
+		return buffer[0], nil
+	}
+}
+
+func (reader *Reader) ReadBytes(size uint) ([]byte, error) {
+	buffer := make([]byte, size)
+	count, err := reader.Read(buffer)
+	if err != nil {
+		return nil, err
+	} else if uint(count) != size {
+		return buffer, errors.New("size mismatch")
+	} else {
+		return buffer, nil
+	}
+}
+
+func (reader *Reader) ReadUInt8() (uint8, error) {
+	return reader.ReadByte()
+}
+
+func (reader *Reader) ReadUInt32() (uint32, error) {
+	buffer, err := reader.ReadBytes(4)
+	if err != nil {
+		return 0, err
+	} else {
+		return uint32(buffer[0]) + uint32(buffer[1])<<8 + uint32(buffer[2])<<16 + uint32(buffer[3])<<24, nil
+	}
+}
+
+func (reader *Reader) ReadUInt64() (uint64, error) {
+	buffer, err := reader.ReadBytes(8)
+	if err != nil {
+		return 0, err
+	} else {
+		return binary.LittleEndian.Uint64(buffer), nil
+	}
+}
+
+func (reader *Reader) ReadUInt16() (uint16, error) {
+	buffer, err := reader.ReadBytes(2)
+	if err != nil {
+		return 0, err
+	} else {
+		return uint16(buffer[0]) + uint16(buffer[1])<<8, nil
+	}
+}
+
+func (reader *Reader) ReadInt32() (int32, error) {
+	value, err := reader.ReadUInt32()
+	return int32(value), err
+}
+
+func (reader *Reader) ReadInt() (int, error) {
+	value, err := reader.ReadUInt32()
+	return int(value), err
+}
+
+// ReadUInt128 reads a 128 bits number stored as Kad does, four little endian 32 bits words from the most significant
+func (reader *Reader) ReadUInt128() (types.UInt128, error) {
+	buffer, err := reader.ReadBytes(16)
+	if err != nil {
+		return nil, err
+	} else {
+		for word := 0; word < 16; word += 4 {
+			buffer[word], buffer[word+1], buffer[word+2], buffer[word+3] = buffer[word+3], buffer[word+2], buffer[word+1], buffer[word]
+		}
+		return types.NewUInt128FromByteArray(buffer)
+	}
+}
+
+func (reader *Reader) ReadString(txtSize uint) (string, error) {
+	buffer, err := reader.ReadBytes(txtSize)
+	if err != nil {
+		return "", err
+	} else {
+		return string(buffer), nil
+	}
+}
+
+// ReadIPv4 reads an IP stored as Kad does, a little endian 32 bits number in host order
+func (reader *Reader) ReadIPv4() (net.IP, error) {
+	buffer, err := reader.ReadBytes(4)
+	if err != nil {
+		return net.IPv4zero, err
+	} else {
+		return net.IPv4(buffer[3], buffer[2], buffer[1], buffer[0]), nil
+	}
+}
+
+// ReadTags reads a tag list preceded by his one byte count, as Kad writes them
+func (reader *Reader) ReadTags() (tag.List, error) {
+	return tag.ReadList(reader)
+}
+
+// ReadEd2kTags reads a tag list preceded by his 32 bits count, as the ed2k packets have them
+func (reader *Reader) ReadEd2kTags() (tag.List, error) {
+	return tag.ReadEd2kList(reader)
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"net"
+	"sleepy/types"
+	"testing"
+)
+
+func TestReader_ReadByte(t *testing.T) {
+	data := []byte{0x00, 0x01, 0x02, 0x03}
+	reader := NewReader(data)
+	reader.Discard(1)
+	read, err := reader.ReadByte()
+	if err != nil {
+		t.Errorf("Read errors: %s", err)
+	}
+	if read != data[1] {
+		t.Errorf("Read byte error, got: %d, want: %d", read, data[1])
+	}
+	reader.Discard(1)
+	read, err = reader.ReadByte()
+	if err != nil {
+		t.Errorf("Read errors: %s", err)
+	}
+	if read != data[3] {
+		t.Errorf("Read byte error, got: %d, want: %d", read, data[3])
+	}
+}
+
+func TestReader_Correct(t *testing.T) {
+	data := []byte{0x00, 0x01, 0x02, 0x03}
+	reader := NewReader(data)
+	err := reader.Discard(5)
+	if err == nil {
+		t.Errorf("Must has read errors: %s", err)
+	}
+}
+
+func TestReader_ReadUInt128(t *testing.T) {
+	data := []byte{0x04, 0x03, 0x02, 0x01, 0x08, 0x07, 0x06, 0x05, 0x0c, 0x0b, 0x0a, 0x09, 0x10, 0x0f, 0x0e, 0x0d}
+	reader := NewReader(data)
+	read, err := reader.ReadUInt128()
+	if err != nil {
+		t.Errorf("Read errors: %s", err)
+	} else if read.ToHexString() != "0102030405060708090a0b0c0d0e0f10" {
+		t.Errorf("Read uint128 error, got: %s", read.ToHexString())
+	}
+}
+
+func TestReader_ReadIPv4(t *testing.T) {
+	reader := NewReader([]byte{0x04, 0x03, 0x02, 0x01})
+	read, err := reader.ReadIPv4()
+	if err != nil {
+		t.Errorf("Read errors: %s", err)
+	} else if !read.Equal(net.IPv4(1, 2, 3, 4)) {
+		t.Errorf("Read IP error, got: %s, want: 1.2.3.4", read)
+	}
+}
+
+func TestReader_ReadsWriter(t *testing.T) {
+	writer := NewWriter()
+	writer.WriteUInt8(7)
+	writer.WriteUInt16(4662)
+	writer.WriteUInt32(0xdeadbeef)
+	writer.WriteUInt64(0x100000000)
+	writer.WriteUInt128(types.NewUInt128(1, 2))
+	writer.WriteIPv4(net.IPv4(192, 168, 1, 2))
+	if err := writer.Err(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	reader := NewReader(writer.Bytes())
+	if value, err := reader.ReadUInt8(); err != nil || value != 7 {
+		t.Errorf("Unexpected uint8 %d", value)
+	}
+	if value, err := reader.ReadUInt16(); err != nil || value != 4662 {
+		t.Errorf("Unexpected uint16 %d", value)
+	}
+	if value, err := reader.ReadUInt32(); err != nil || value != 0xdeadbeef {
+		t.Errorf("Unexpected uint32 %x", value)
+	}
+	if value, err := reader.ReadUInt64(); err != nil || value != 0x100000000 {
+		t.Errorf("Unexpected uint64 %x", value)
+	}
+	if value, err := reader.ReadUInt128(); err != nil || !value.Equal(types.NewUInt128(1, 2)) {
+		t.Errorf("Unexpected uint128 %v", value)
+	}
+	if ip, err := reader.ReadIPv4(); err != nil || !ip.Equal(net.IPv4(192, 168, 1, 2)) {
+		t.Errorf("Unexpected IP %s", ip)
+	}
+}
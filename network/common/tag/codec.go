@@ -0,0 +1,316 @@
+package tag
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sleepy/types"
+)
+
+// Set in the type of the ed2k compact tags, whose name is a one byte ID written without size
+const compactNameFlag = 0x80
+
+var errInvalidValue = errors.New("the tag value doesn't match his type")
+
+// Read decodes a tag, either with a sized name as Kad writes them or with a compact one byte name
+func Read(reader io.Reader) (Tag, error) {
+	header := make([]byte, 1)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return Tag{}, err
+	}
+	tag := Tag{Type: Type(header[0] &^ compactNameFlag)}
+
+	if header[0]&compactNameFlag != 0 {
+		name := make([]byte, 1)
+		if _, err := io.ReadFull(reader, name); err != nil {
+			return Tag{}, err
+		}
+		tag.Name = string(name)
+	} else {
+		name, err := readSized(reader, 2)
+		if err != nil {
+			return Tag{}, err
+		}
+		tag.Name = string(name)
+	}
+
+	value, err := readValue(reader, tag.Type)
+	if err != nil {
+		return Tag{}, err
+	}
+	tag.Value = value
+	return tag, nil
+}
+
+// ReadList decodes a list of tags preceded by his one byte count, as Kad writes them
+func ReadList(reader io.Reader) (List, error) {
+	count := make([]byte, 1)
+	if _, err := io.ReadFull(reader, count); err != nil {
+		return nil, err
+	}
+	list := make(List, 0, count[0])
+	for i := 0; i < int(count[0]); i++ {
+		tag, err := Read(reader)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, tag)
+	}
+	return list, nil
+}
+
+// ReadEd2kList decodes a list of tags preceded by his 32 bits count, as the ed2k packets have them
+func ReadEd2kList(reader io.Reader) (List, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	count := binary.LittleEndian.Uint32(header)
+	// The count isn't trusted to reserve the list, a bogus one fails once the data ends
+	list := make(List, 0, minInt(int(count), math.MaxUint8))
+	for i := uint32(0); i < count; i++ {
+		tag, err := Read(reader)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, tag)
+	}
+	return list, nil
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func readValue(reader io.Reader, tagType Type) (interface{}, error) {
+	if tagType >= TypeStr1 && tagType <= TypeStr16 {
+		value, err := readFixed(reader, int(tagType-TypeStr1)+1)
+		return string(value), err
+	}
+
+	switch tagType {
+	case TypeHash:
+		value, err := readFixed(reader, 16)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewUInt128FromByteArray(value)
+	case TypeString:
+		value, err := readSized(reader, 2)
+		return string(value), err
+	case TypeUInt8:
+		value, err := readFixed(reader, 1)
+		if err != nil {
+			return nil, err
+		}
+		return value[0], nil
+	case TypeUInt16:
+		value, err := readFixed(reader, 2)
+		if err != nil {
+			return nil, err
+		}
+		return binary.LittleEndian.Uint16(value), nil
+	case TypeUInt32:
+		value, err := readFixed(reader, 4)
+		if err != nil {
+			return nil, err
+		}
+		return binary.LittleEndian.Uint32(value), nil
+	case TypeUInt64:
+		value, err := readFixed(reader, 8)
+		if err != nil {
+			return nil, err
+		}
+		return binary.LittleEndian.Uint64(value), nil
+	case TypeFloat32:
+		value, err := readFixed(reader, 4)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(value)), nil
+	case TypeBool:
+		value, err := readFixed(reader, 1)
+		if err != nil {
+			return nil, err
+		}
+		return value[0] != 0, nil
+	case TypeBoolArray:
+		size, err := readFixed(reader, 2)
+		if err != nil {
+			return nil, err
+		}
+		count := int(binary.LittleEndian.Uint16(size))
+		bits, err := readFixed(reader, count/8+1)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]bool, count)
+		for i := range value {
+			value[i] = bits[i/8]&(1<<(i%8)) != 0
+		}
+		return value, nil
+	case TypeBlob:
+		return readSized(reader, 4)
+	case TypeBsob:
+		return readSized(reader, 1)
+	default:
+		return nil, errors.New("unknown tag type")
+	}
+}
+
+// Read [size] bytes
+func readFixed(reader io.Reader, size int) ([]byte, error) {
+	value := make([]byte, size)
+	if _, err := io.ReadFull(reader, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Read the bytes preceded by their little endian size of [sizeBytes] bytes
+func readSized(reader io.Reader, sizeBytes int) ([]byte, error) {
+	header, err := readFixed(reader, sizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	size := 0
+	for i := sizeBytes - 1; i >= 0; i-- {
+		size = size<<8 | int(header[i])
+	}
+	return readFixed(reader, size)
+}
+
+// Encode writes the tag with his name preceded by his size, as Kad does
+func (tag Tag) Encode() ([]byte, error) {
+	if len(tag.Name) > math.MaxUint16 {
+		return nil, errors.New("the tag name is too long")
+	}
+	data := []byte{byte(tag.Type)}
+	data = appendUInt16(data, uint16(len(tag.Name)))
+	data = append(data, tag.Name...)
+	return appendValue(data, tag)
+}
+
+// EncodeCompact writes the tag as the ed2k compact tags if his name is a one byte ID, otherwise as Encode does
+func (tag Tag) EncodeCompact() ([]byte, error) {
+	if len(tag.Name) != 1 {
+		return tag.Encode()
+	}
+	return appendValue([]byte{byte(tag.Type) | compactNameFlag, tag.Name[0]}, tag)
+}
+
+// Encode writes the tags preceded by their one byte count, as Kad does
+func (list List) Encode() ([]byte, error) {
+	if len(list) > math.MaxUint8 {
+		return nil, errors.New("too many tags")
+	}
+	data := []byte{uint8(len(list))}
+	for _, tag := range list {
+		encoded, err := tag.Encode()
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, encoded...)
+	}
+	return data, nil
+}
+
+// EncodeEd2k writes the tags preceded by their 32 bits count, with compact names as the ed2k packets have them
+func (list List) EncodeEd2k() ([]byte, error) {
+	data := appendUInt32(nil, uint32(len(list)))
+	for _, tag := range list {
+		encoded, err := tag.EncodeCompact()
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, encoded...)
+	}
+	return data, nil
+}
+
+func appendValue(data []byte, tag Tag) ([]byte, error) {
+	if tag.Type >= TypeStr1 && tag.Type <= TypeStr16 {
+		value, ok := tag.Value.(string)
+		if !ok || len(value) != int(tag.Type-TypeStr1)+1 {
+			return nil, errInvalidValue
+		}
+		return append(data, value...), nil
+	}
+
+	switch tag.Type {
+	case TypeHash:
+		if value, ok := tag.Value.(types.UInt128); ok && value != nil {
+			return append(data, value.ToBytes()...), nil
+		}
+	case TypeString:
+		if value, ok := tag.Value.(string); ok && len(value) <= math.MaxUint16 {
+			return append(appendUInt16(data, uint16(len(value))), value...), nil
+		}
+	case TypeUInt8:
+		if value, ok := tag.Value.(uint8); ok {
+			return append(data, value), nil
+		}
+	case TypeUInt16:
+		if value, ok := tag.Value.(uint16); ok {
+			return appendUInt16(data, value), nil
+		}
+	case TypeUInt32:
+		if value, ok := tag.Value.(uint32); ok {
+			return appendUInt32(data, value), nil
+		}
+	case TypeUInt64:
+		if value, ok := tag.Value.(uint64); ok {
+			buffer := make([]byte, 8)
+			binary.LittleEndian.PutUint64(buffer, value)
+			return append(data, buffer...), nil
+		}
+	case TypeFloat32:
+		if value, ok := tag.Value.(float32); ok {
+			return appendUInt32(data, math.Float32bits(value)), nil
+		}
+	case TypeBool:
+		if value, ok := tag.Value.(bool); ok {
+			if value {
+				return append(data, 1), nil
+			}
+			return append(data, 0), nil
+		}
+	case TypeBoolArray:
+		if value, ok := tag.Value.([]bool); ok && len(value) <= math.MaxUint16 {
+			bits := make([]byte, len(value)/8+1)
+			for i, set := range value {
+				if set {
+					bits[i/8] |= 1 << (i % 8)
+				}
+			}
+			return append(appendUInt16(data, uint16(len(value))), bits...), nil
+		}
+	case TypeBlob:
+		if value, ok := tag.Value.([]byte); ok && uint64(len(value)) <= math.MaxUint32 {
+			return append(appendUInt32(data, uint32(len(value))), value...), nil
+		}
+	case TypeBsob:
+		if value, ok := tag.Value.([]byte); ok && len(value) <= math.MaxUint8 {
+			return append(append(data, uint8(len(value))), value...), nil
+		}
+	default:
+		return nil, errors.New("unknown tag type")
+	}
+	return nil, errInvalidValue
+}
+
+func appendUInt16(data []byte, value uint16) []byte {
+	buffer := make([]byte, 2)
+	binary.LittleEndian.PutUint16(buffer, value)
+	return append(data, buffer...)
+}
+
+func appendUInt32(data []byte, value uint32) []byte {
+	buffer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buffer, value)
+	return append(data, buffer...)
+}
@@ -0,0 +1,209 @@
+package tag
+
+import (
+	"encoding/binary"
+	"math"
+	"sleepy/types"
+)
+
+// Type of the value of a tag, as written in the packets
+type Type byte
+
+const (
+	TypeHash      Type = 0x01
+	TypeString    Type = 0x02
+	TypeUInt32    Type = 0x03
+	TypeFloat32   Type = 0x04
+	TypeBool      Type = 0x05
+	TypeBoolArray Type = 0x06
+	TypeBlob      Type = 0x07
+	TypeUInt16    Type = 0x08
+	TypeUInt8     Type = 0x09
+	TypeBsob      Type = 0x0A
+	TypeUInt64    Type = 0x0B
+	// Strings of 1 to 16 bytes, whose length is given by the type instead of a size
+	TypeStr1  Type = 0x11
+	TypeStr16 Type = 0x20
+)
+
+// Tag is a named value of the ed2k and Kad packets. The Value type depends on the tag Type:
+// types.UInt128 for hashes, string for strings, uint8, uint16, uint32 and uint64 for the integers, float32, bool,
+// []bool for the bool arrays and []byte for the blobs and bsobs
+type Tag struct {
+	// The special tags are named by a one byte ID, see ID
+	Name  string
+	Type  Type
+	Value interface{}
+}
+
+// ID gets the name of a special tag
+func ID(id byte) string {
+	return string([]byte{id})
+}
+
+func NewHash(name string, value types.UInt128) Tag {
+	return Tag{Name: name, Type: TypeHash, Value: value}
+}
+
+func NewString(name string, value string) Tag {
+	return Tag{Name: name, Type: TypeString, Value: value}
+}
+
+// NewShortString creates a string tag with the compact type of his length, if it has between 1 and 16 bytes
+func NewShortString(name string, value string) Tag {
+	if len(value) == 0 || len(value) > 16 {
+		return NewString(name, value)
+	}
+	return Tag{Name: name, Type: TypeStr1 + Type(len(value)-1), Value: value}
+}
+
+func NewUInt8(name string, value uint8) Tag {
+	return Tag{Name: name, Type: TypeUInt8, Value: value}
+}
+
+func NewUInt16(name string, value uint16) Tag {
+	return Tag{Name: name, Type: TypeUInt16, Value: value}
+}
+
+func NewUInt32(name string, value uint32) Tag {
+	return Tag{Name: name, Type: TypeUInt32, Value: value}
+}
+
+func NewUInt64(name string, value uint64) Tag {
+	return Tag{Name: name, Type: TypeUInt64, Value: value}
+}
+
+// NewUInt creates an integer tag with the smallest type able to hold the value, as eMule does
+func NewUInt(name string, value uint64) Tag {
+	if value <= math.MaxUint8 {
+		return NewUInt8(name, uint8(value))
+	} else if value <= math.MaxUint16 {
+		return NewUInt16(name, uint16(value))
+	} else if value <= math.MaxUint32 {
+		return NewUInt32(name, uint32(value))
+	}
+	return NewUInt64(name, value)
+}
+
+func NewFloat32(name string, value float32) Tag {
+	return Tag{Name: name, Type: TypeFloat32, Value: value}
+}
+
+func NewBool(name string, value bool) Tag {
+	return Tag{Name: name, Type: TypeBool, Value: value}
+}
+
+func NewBoolArray(name string, value []bool) Tag {
+	return Tag{Name: name, Type: TypeBoolArray, Value: value}
+}
+
+func NewBlob(name string, value []byte) Tag {
+	return Tag{Name: name, Type: TypeBlob, Value: value}
+}
+
+// NewBsob creates a short blob tag, that can't hold more than 255 bytes
+func NewBsob(name string, value []byte) Tag {
+	return Tag{Name: name, Type: TypeBsob, Value: value}
+}
+
+// IsString checks if the tag holds a string, either with size or with a compact type
+func (tag Tag) IsString() bool {
+	return tag.Type == TypeString || (tag.Type >= TypeStr1 && tag.Type <= TypeStr16)
+}
+
+// AsUInt gets the value of an integer tag, whatever his size
+func (tag Tag) AsUInt() (uint64, bool) {
+	switch v := tag.Value.(type) {
+	case uint8:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	case []byte:
+		// Old clients send the big sizes as a 8 bytes blob
+		if tag.Type == TypeBlob && len(v) == 8 {
+			return binary.LittleEndian.Uint64(v), true
+		}
+		return 0, false
+	default:
+		return 0, false
+	}
+}
+
+// AsString gets the value of a string tag
+func (tag Tag) AsString() (string, bool) {
+	v, ok := tag.Value.(string)
+	return v, ok
+}
+
+// AsHash gets the value of a hash tag
+func (tag Tag) AsHash() (types.UInt128, bool) {
+	v, ok := tag.Value.(types.UInt128)
+	return v, ok
+}
+
+// AsFloat32 gets the value of a float tag
+func (tag Tag) AsFloat32() (float32, bool) {
+	v, ok := tag.Value.(float32)
+	return v, ok
+}
+
+// AsBool gets the value of a bool tag
+func (tag Tag) AsBool() (bool, bool) {
+	v, ok := tag.Value.(bool)
+	return v, ok
+}
+
+// AsBytes gets the value of a blob or bsob tag
+func (tag Tag) AsBytes() ([]byte, bool) {
+	v, ok := tag.Value.([]byte)
+	return v, ok
+}
+
+// List is a sequence of tags, kept in the order they are read or written
+type List []Tag
+
+// Get finds the first tag with the [name]
+func (list List) Get(name string) (Tag, bool) {
+	for _, tag := range list {
+		if tag.Name == name {
+			return tag, true
+		}
+	}
+	return Tag{}, false
+}
+
+// GetByID finds the first special tag with the [id]
+func (list List) GetByID(id byte) (Tag, bool) {
+	return list.Get(ID(id))
+}
+
+// GetUInt gets the value of the integer tag with the [id]
+func (list List) GetUInt(id byte) (uint64, bool) {
+	if tag, ok := list.GetByID(id); ok {
+		return tag.AsUInt()
+	}
+	return 0, false
+}
+
+// GetString gets the value of the string tag with the [id]
+func (list List) GetString(id byte) (string, bool) {
+	if tag, ok := list.GetByID(id); ok {
+		return tag.AsString()
+	}
+	return "", false
+}
+
+// Without returns a copy of the list without the tags with the [name]
+func (list List) Without(name string) List {
+	filtered := make(List, 0, len(list))
+	for _, tag := range list {
+		if tag.Name != name {
+			filtered = append(filtered, tag)
+		}
+	}
+	return filtered
+}
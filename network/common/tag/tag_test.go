@@ -0,0 +1,121 @@
+package tag
+
+import (
+	"bytes"
+	"reflect"
+	"sleepy/types"
+	"testing"
+)
+
+func TestList_RoundTrip(t *testing.T) {
+	list := List{
+		NewHash(ID(0xf8), types.NewUInt128(0x1122, 0x3344)),
+		NewString(ID(0x01), "holidays.avi"),
+		NewShortString("format", "avi"),
+		NewUInt8(ID(0xff), 3),
+		NewUInt16(ID(0xfd), 4662),
+		NewUInt32(ID(0xfe), 0x0a000001),
+		NewUInt64(ID(0x02), 0x100000000),
+		NewFloat32("rating", 4.5),
+		NewBool("complete", true),
+		NewBoolArray("parts", []bool{true, false, true, true, false, false, false, false, true}),
+		NewBlob("blob", []byte{1, 2, 3}),
+		NewBsob("bsob", []byte{4, 5}),
+	}
+
+	data, err := list.Encode()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	decoded, err := ReadList(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(decoded) != len(list) {
+		t.Fatalf("All the tags must be decoded, %d found", len(decoded))
+	}
+	for i, tag := range decoded {
+		if tag.Name != list[i].Name || tag.Type != list[i].Type {
+			t.Errorf("The tag %d must keep his name and type, got %q %x", i, tag.Name, tag.Type)
+		}
+	}
+	if hash, ok := decoded[0].AsHash(); !ok || !hash.Equal(types.NewUInt128(0x1122, 0x3344)) {
+		t.Errorf("The hash must be decoded, got %v", decoded[0].Value)
+	}
+	if !reflect.DeepEqual(decoded[1:], list[1:]) {
+		t.Errorf("The values must be decoded, got %v", decoded)
+	}
+
+	encoded, _ := decoded.Encode()
+	if !bytes.Equal(encoded, data) {
+		t.Errorf("The decoded tags must be encoded to the same bytes")
+	}
+}
+
+func TestRead_Formats(t *testing.T) {
+	// Kad string tag, ed2k compact uint32 tag and compact short string tag
+	data := []byte{
+		0x02, 0x01, 0x00, 0x01, 0x03, 0x00, 'a', 'b', 'c',
+		0x83, 0x02, 0x00, 0x10, 0x00, 0x00,
+		0x93, 0x03, 'a', 'v', 'i',
+	}
+	reader := bytes.NewReader(data)
+
+	name, err := Read(reader)
+	if err != nil || name.Name != ID(0x01) || name.Value != "abc" {
+		t.Errorf("The Kad string tag must be decoded, got %+v %v", name, err)
+	}
+	size, err := Read(reader)
+	if value, ok := size.AsUInt(); err != nil || size.Name != ID(0x02) || !ok || value != 0x1000 {
+		t.Errorf("The compact integer tag must be decoded, got %+v %v", size, err)
+	}
+	format, err := Read(reader)
+	if err != nil || format.Type != TypeStr1+2 || !format.IsString() || format.Value != "avi" {
+		t.Errorf("The short string tag must be decoded, got %+v %v", format, err)
+	}
+
+	compact, _ := size.EncodeCompact()
+	if !bytes.Equal(compact, data[9:15]) {
+		t.Errorf("The compact tags must be encoded as read, got %v", compact)
+	}
+	if _, err := Read(bytes.NewReader([]byte{0x0c, 0x01, 0x00, 0x01})); err == nil {
+		t.Errorf("The unknown tag types must fail")
+	}
+}
+
+func TestNewUInt(t *testing.T) {
+	expected := map[uint64]Type{0xff: TypeUInt8, 0x100: TypeUInt16, 0x10000: TypeUInt32, 0x100000000: TypeUInt64}
+	for value, tagType := range expected {
+		tag := NewUInt("size", value)
+		if decoded, ok := tag.AsUInt(); tag.Type != tagType || !ok || decoded != value {
+			t.Errorf("The value %x must use the type %x, got %x", value, tagType, tag.Type)
+		}
+	}
+	if _, err := (Tag{Name: "size", Type: TypeUInt8, Value: "text"}).Encode(); err == nil {
+		t.Errorf("The values not matching the type must not be encoded")
+	}
+}
+
+func TestList_Ed2kRoundTrip(t *testing.T) {
+	list := List{NewString(ID(0x01), "holidays.avi"), NewUInt32(ID(0x02), 0x1000), NewShortString("codec", "xvid")}
+
+	data, err := list.EncodeEd2k()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !bytes.Equal(data[:6], []byte{0x03, 0x00, 0x00, 0x00, 0x82, 0x01}) {
+		t.Errorf("The ed2k lists must have a 32 bits count and compact names, got %v", data[:6])
+	}
+
+	decoded, err := ReadEd2kList(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(decoded, list) {
+		t.Errorf("The tags must be decoded as encoded, got %+v", decoded)
+	}
+	if _, err := ReadEd2kList(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff})); err == nil {
+		t.Errorf("A count bigger than the tags sent must fail")
+	}
+}
@@ -43,10 +43,13 @@ func NewFixedSizePacket(size int) Packet {
 	return NewFixedSizeRawPacket(size)
 }
 
+// NewFixedSizeRawPacket creates a zeroed packet of the specified size. As the whole
+// packet is already allocated the write position starts at the end, use SetByte
+// to move it before appending
 func NewFixedSizeRawPacket(size int) *RawPacket {
 	return &RawPacket{
 		data:      make([]byte, size),
-		dataSeek:  0,
+		dataSeek:  size,
 		fixedSize: size,
 	}
 }
@@ -67,8 +70,8 @@ func (packet *RawPacket) SetByte(position int, value byte) error {
 	if packet.fixedSize >= 0 && position >= packet.fixedSize {
 		return errors.New("out of bounds")
 	}
-	if position > len(packet.data) {
-		extraSize := position - packet.dataSeek + 1
+	if position >= len(packet.data) {
+		extraSize := position - len(packet.data) + 1
 		packet.data = append(packet.data, make([]byte, extraSize)...)
 	}
 	packet.data[position] = value
@@ -90,9 +93,21 @@ func (packet *RawPacket) SafeGetByte(position int) (byte, error) {
 	return packet.data[position], nil
 }
 
+// Ensure there is room to write [size] bytes at the current position, growing the packet if it isn't fixed size
+func (packet *RawPacket) reserve(size int) error {
+	if packet.fixedSize >= 0 {
+		if packet.dataSeek+size > packet.fixedSize {
+			return errors.New("packet is full")
+		}
+	} else if packet.dataSeek+size > len(packet.data) {
+		packet.data = append(packet.data, make([]byte, packet.dataSeek+size-len(packet.data))...)
+	}
+	return nil
+}
+
 func (packet *RawPacket) AppendUInt8(value uint8) error {
-	if packet.fixedSize >= 0 && packet.dataSeek+1 > packet.fixedSize {
-		return errors.New("packet is full")
+	if err := packet.reserve(1); err != nil {
+		return err
 	}
 
 	packet.data[packet.dataSeek] = value
@@ -101,8 +116,8 @@ func (packet *RawPacket) AppendUInt8(value uint8) error {
 }
 
 func (packet *RawPacket) AppendUInt16(value uint16) error {
-	if packet.fixedSize >= 0 && packet.dataSeek+2 > packet.fixedSize {
-		return errors.New("packet is full")
+	if err := packet.reserve(2); err != nil {
+		return err
 	}
 
 	convertBuffer := make([]byte, 0, 2)
@@ -113,8 +128,8 @@ func (packet *RawPacket) AppendUInt16(value uint16) error {
 }
 
 func (packet *RawPacket) AppendInt(value int) error {
-	if packet.fixedSize >= 0 && packet.dataSeek+4 > packet.fixedSize {
-		return errors.New("packet is full")
+	if err := packet.reserve(4); err != nil {
+		return err
 	}
 
 	convertBuffer := make([]byte, 0, 4)
@@ -124,10 +139,20 @@ func (packet *RawPacket) AppendInt(value int) error {
 	return nil
 }
 
+func (packet *RawPacket) AppendUInt64(value uint64) error {
+	if err := packet.reserve(8); err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint64(packet.data[packet.dataSeek:], value)
+	packet.dataSeek += 8
+	return nil
+}
+
 func (packet *RawPacket) AppendBytes(value []byte) error {
 	dataLen := len(value)
-	if packet.fixedSize >= 0 && packet.dataSeek+dataLen > packet.fixedSize {
-		return errors.New("packet is full")
+	if err := packet.reserve(dataLen); err != nil {
+		return err
 	}
 
 	copy(packet.data[packet.dataSeek:], value)
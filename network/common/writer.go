@@ -0,0 +1,137 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"sleepy/network/common/tag"
+	"sleepy/types"
+)
+
+// Writer builds a little endian payload, mirroring the reads of Reader. It grows as needed; the first failed
+// write is kept and the next ones are ignored, so the builders only check Err once at the end
+type Writer struct {
+	data []byte
+	err  error
+}
+
+func NewWriter() *Writer {
+	return &Writer{data: make([]byte, 0, 64)}
+}
+
+// Bytes returns the written payload
+func (writer *Writer) Bytes() []byte {
+	return writer.data
+}
+
+func (writer *Writer) Len() int {
+	return len(writer.data)
+}
+
+// Err returns the error of the first failed write, if any
+func (writer *Writer) Err() error {
+	return writer.err
+}
+
+func (writer *Writer) fail(err error) {
+	if writer.err == nil {
+		writer.err = err
+	}
+}
+
+// Write appends the [buffer], as an io.Writer
+func (writer *Writer) Write(buffer []byte) (int, error) {
+	if writer.err != nil {
+		return 0, writer.err
+	}
+	writer.data = append(writer.data, buffer...)
+	return len(buffer), nil
+}
+
+func (writer *Writer) WriteBytes(buffer []byte) {
+	writer.Write(buffer)
+}
+
+func (writer *Writer) WriteByte(value byte) error {
+	_, err := writer.Write([]byte{value})
+	return err
+}
+
+func (writer *Writer) WriteUInt8(value uint8) {
+	writer.WriteByte(value)
+}
+
+func (writer *Writer) WriteUInt16(value uint16) {
+	var buffer [2]byte
+	binary.LittleEndian.PutUint16(buffer[:], value)
+	writer.Write(buffer[:])
+}
+
+func (writer *Writer) WriteUInt32(value uint32) {
+	var buffer [4]byte
+	binary.LittleEndian.PutUint32(buffer[:], value)
+	writer.Write(buffer[:])
+}
+
+func (writer *Writer) WriteUInt64(value uint64) {
+	var buffer [8]byte
+	binary.LittleEndian.PutUint64(buffer[:], value)
+	writer.Write(buffer[:])
+}
+
+// WriteUInt128 writes a 128 bits number as Kad does, four little endian 32 bits words from the most significant
+func (writer *Writer) WriteUInt128(value types.UInt128) {
+	if value == nil {
+		writer.fail(errors.New("nil 128 bits number"))
+		return
+	}
+	buffer := value.ToBytes()
+	for word := 0; word < 16; word += 4 {
+		buffer[word], buffer[word+1], buffer[word+2], buffer[word+3] = buffer[word+3], buffer[word+2], buffer[word+1], buffer[word]
+	}
+	writer.Write(buffer)
+}
+
+// WriteIPv4 writes an IP as Kad does, a little endian 32 bits number in host order. A nil IP is written as 0.0.0.0
+func (writer *Writer) WriteIPv4(ip net.IP) {
+	if ip == nil {
+		ip = net.IPv4zero
+	}
+	ipv4 := ip.To4()
+	if ipv4 == nil {
+		writer.fail(errors.New("not an IPv4 address: " + ip.String()))
+		return
+	}
+	writer.Write([]byte{ipv4[3], ipv4[2], ipv4[1], ipv4[0]})
+}
+
+// WriteString writes a string preceded by his 16 bits length
+func (writer *Writer) WriteString(value string) {
+	if len(value) > math.MaxUint16 {
+		writer.fail(errors.New("string too long"))
+		return
+	}
+	writer.WriteUInt16(uint16(len(value)))
+	writer.Write([]byte(value))
+}
+
+// WriteTags writes a tag list preceded by his one byte count, as Reader reads them
+func (writer *Writer) WriteTags(tags tag.List) {
+	data, err := tags.Encode()
+	if err != nil {
+		writer.fail(err)
+		return
+	}
+	writer.Write(data)
+}
+
+// WriteEd2kTags writes a tag list preceded by his 32 bits count and with compact names, as the ed2k packets have them
+func (writer *Writer) WriteEd2kTags(tags tag.List) {
+	data, err := tags.EncodeEd2k()
+	if err != nil {
+		writer.fail(err)
+		return
+	}
+	writer.Write(data)
+}
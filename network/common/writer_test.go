@@ -0,0 +1,81 @@
+package common
+
+import (
+	"bytes"
+	"net"
+	"sleepy/network/common/tag"
+	"sleepy/types"
+	"strings"
+	"testing"
+)
+
+func TestWriter_LittleEndian(t *testing.T) {
+	writer := NewWriter()
+	writer.WriteUInt8(0x01)
+	writer.WriteUInt16(0x0302)
+	writer.WriteUInt32(0x07060504)
+	writer.WriteUInt64(0x0f0e0d0c0b0a0908)
+	writer.WriteString("ab")
+
+	if err := writer.Err(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 2, 0, 'a', 'b'}
+	if !bytes.Equal(writer.Bytes(), expected) {
+		t.Errorf("Unexpected payload %x", writer.Bytes())
+	}
+}
+
+func TestWriter_KadFormats(t *testing.T) {
+	writer := NewWriter()
+	writer.WriteUInt128(types.NewUInt128(0x8899aabbccddeeff, 0x0011223344556677))
+	writer.WriteIPv4(net.IPv4(10, 0, 0, 1))
+	writer.WriteIPv4(nil)
+
+	if err := writer.Err(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []byte{
+		0x33, 0x22, 0x11, 0x00, 0x77, 0x66, 0x55, 0x44, 0xbb, 0xaa, 0x99, 0x88, 0xff, 0xee, 0xdd, 0xcc,
+		1, 0, 0, 10,
+		0, 0, 0, 0,
+	}
+	if !bytes.Equal(writer.Bytes(), expected) {
+		t.Errorf("Unexpected payload %x", writer.Bytes())
+	}
+}
+
+func TestWriter_Tags(t *testing.T) {
+	tags := tag.List{tag.NewString(tag.ID(0x01), "holidays.avi"), tag.NewUInt16(tag.ID(0xfd), 4662)}
+	writer := NewWriter()
+	writer.WriteTags(tags)
+
+	decoded, err := tag.ReadList(bytes.NewReader(writer.Bytes()))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if name, ok := decoded.GetString(0x01); !ok || name != "holidays.avi" {
+		t.Errorf("The written tags must be read back, got %q", name)
+	}
+}
+
+func TestWriter_KeepsFirstError(t *testing.T) {
+	writer := NewWriter()
+	writer.WriteUInt8(1)
+	writer.WriteUInt128(nil)
+	writer.WriteString(strings.Repeat("a", 0x10000))
+	writer.WriteUInt16(2)
+
+	if writer.Err() == nil || writer.Err().Error() != "nil 128 bits number" {
+		t.Errorf("The first error must be kept, got %v", writer.Err())
+	}
+	if writer.Len() != 1 {
+		t.Errorf("The writes after an error must be ignored, %d bytes written", writer.Len())
+	}
+
+	writer = NewWriter()
+	writer.WriteIPv4(net.ParseIP("::1"))
+	if writer.Err() == nil {
+		t.Errorf("The IPv6 addresses can't be written")
+	}
+}
@@ -1,3 +1,30 @@
 package common
 
 type Operation byte
+
+// Operations of the ed2k server UDP protocol
+const (
+	OperationGlobalSearchRequest3 Operation = 0x90
+	OperationGlobalSearchRequest2 Operation = 0x92
+	OperationGlobalGetSources2    Operation = 0x94
+	OperationServerStatusRequest  Operation = 0x96
+	OperationServerStatusResponse Operation = 0x97
+	OperationGlobalSearchRequest  Operation = 0x98
+	OperationGlobalSearchResponse Operation = 0x99
+	OperationGlobalGetSources     Operation = 0x9A
+	OperationGlobalFoundSources   Operation = 0x9B
+	OperationServerDescRequest    Operation = 0xA2
+	OperationServerDescResponse   Operation = 0xA3
+)
+
+// Operations of the ed2k server TCP protocol
+const (
+	OperationLoginRequest  Operation = 0x01
+	OperationGetServerList Operation = 0x14
+	OperationOfferFiles    Operation = 0x15
+	OperationServerList    Operation = 0x32
+	OperationServerStatus  Operation = 0x34
+	OperationServerMessage Operation = 0x38
+	OperationIDChange      Operation = 0x40
+	OperationServerIdent   Operation = 0x41
+)
@@ -10,6 +10,7 @@ const (
 	ProtocolEmuleTcpCompressed Protocol = 0xe3
 
 	ProtEd2kUSP          = 0xc5
+	ProtEd2kTCP          = 0xe3
 	ProtEd2kUDPServer    = 0xe3
 	ProtEd2k2TCP         = 0xf4
 	ProtEd2k2UDP         = 0xf5
@@ -27,3 +28,11 @@ const (
 	ProtocolVersion7     = uint8(7) // eMule 0.49a
 	ProtocolVersion8     = uint8(8) // eMule 0.49b
 )
+
+// Versions announced in the server logins and the client hellos
+const (
+	// Ed2kVersion is the version of the ed2k protocol, as the eMule EDONKEYVERSION constant
+	Ed2kVersion = 0x3C
+	// EmuleVersion is 0.50a: major in the bits 17 to 23, minor in the bits 10 to 16
+	EmuleVersion = 0<<17 | 50<<10 | 0<<7
+)
@@ -0,0 +1,32 @@
+package common
+
+// Tags of the files found in the servers
+const (
+	TagFileName        = 0x01
+	TagFileSize        = 0x02
+	TagFileType        = 0x03
+	TagFileFormat      = 0x04
+	TagSources         = 0x15
+	TagCompleteSources = 0x30
+	TagFileSizeHigh    = 0x3A
+)
+
+// Tags of the server descriptions
+const (
+	TagServerName        = 0x01
+	TagServerDescription = 0x0B
+	TagServerDynIP       = 0x85
+	TagServerVersion     = 0x91
+	TagServerAuxPorts    = 0x93
+)
+
+// Tags of the login requests and the client hellos
+const (
+	TagClientName        = 0x01
+	TagVersion           = 0x11
+	TagServerFlags       = 0x20
+	TagEmuleUDPPorts     = 0xF9
+	TagEmuleMiscOptions1 = 0xFA
+	TagEmuleVersion      = 0xFB
+	TagEmuleMiscOptions2 = 0xFE
+)
@@ -1,7 +1,6 @@
 package packet
 
 import (
-	"fmt"
 	"sleepy/network/common/udp"
 	"sleepy/network/ed2k/common"
 )
@@ -14,7 +13,6 @@ func NewPacket(
 	protocol common.Protocol,
 	size int,
 ) *Packet {
-	fmt.Println("ed2k.NewPacket", protocol, size)
 	packet := &Packet{}
 	if protocol == common.ProtocolEd2kServerUDP {
 		packet.RawPacket = *udp.NewFixedSizeRawPacket(size + 2)
@@ -23,10 +21,21 @@ func NewPacket(
 	} else {
 		packet.RawPacket = *udp.NewFixedSizeRawPacket(size)
 	}
-	packet.SetProtocol(common.ProtocolEd2kServerUDP)
+	packet.SetProtocol(protocol)
 	return packet
 }
 
+// NewServerUDPPacket creates a server UDP packet with the [payload], after the protocol and the command
+func NewServerUDPPacket(opCode common.Operation, payload []byte) (*Packet, error) {
+	packet := &Packet{RawPacket: *udp.NewRawPacket()}
+	packet.SetProtocol(common.ProtocolEd2kServerUDP)
+	packet.SetCommand(byte(opCode))
+	if err := packet.AppendBytes(payload); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
 func (packet *Packet) SetProtocol(protocol common.Protocol) {
 	packet.SetByte(0, byte(protocol))
 }
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	netCommon "sleepy/network/common"
+	"sleepy/network/common/tag"
+	"sleepy/network/ed2k/common"
+)
+
+// Description is the name and details of a server, answered to a description request
+type Description struct {
+	Name        string
+	Description string
+	// DynIP is the host name of the servers with a dynamic IP
+	DynIP    string
+	Version  string
+	AuxPorts string
+	// Tags are all the tags received, empty for the servers answering in the old format
+	Tags tag.List
+}
+
+// Decode a description response. The servers knowing the [challenge] echo it followed by the tags, the old ones only
+// send the name and the description
+func decodeDescription(data []byte, challenge uint32) (*Description, error) {
+	reader := netCommon.NewReader(data)
+	if len(data) >= 8 {
+		if echoed, _ := reader.ReadUInt32(); echoed == challenge {
+			tags, err := reader.ReadEd2kTags()
+			if err != nil {
+				return nil, err
+			}
+			return newDescription(tags), nil
+		}
+		reader = netCommon.NewReader(data)
+	}
+
+	description := &Description{}
+	var err error
+	if description.Name, err = readString(reader); err != nil {
+		return nil, err
+	}
+	if description.Description, err = readString(reader); err != nil {
+		return nil, err
+	}
+	return description, nil
+}
+
+func newDescription(tags tag.List) *Description {
+	description := &Description{Tags: tags}
+	description.Name, _ = tags.GetString(common.TagServerName)
+	description.Description, _ = tags.GetString(common.TagServerDescription)
+	description.DynIP, _ = tags.GetString(common.TagServerDynIP)
+	description.AuxPorts, _ = tags.GetString(common.TagServerAuxPorts)
+	// The version is either a string or the major and minor numbers in the high and low words
+	if version, ok := tags.GetString(common.TagServerVersion); ok {
+		description.Version = version
+	} else if version, ok := tags.GetUInt(common.TagServerVersion); ok {
+		description.Version = fmt.Sprintf("%d.%d", version>>16, version&0xFFFF)
+	}
+	return description
+}
+
+// Read a string preceded by his 16 bits length
+func readString(reader *netCommon.Reader) (string, error) {
+	size, err := reader.ReadUInt16()
+	if err != nil {
+		return "", err
+	}
+	if size == 0 {
+		return "", nil
+	}
+	value, err := reader.ReadString(uint(size))
+	if err != nil {
+		return "", errors.New("truncated string")
+	}
+	return value, nil
+}
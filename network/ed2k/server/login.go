@@ -0,0 +1,85 @@
+package server
+
+import (
+	"errors"
+	netCommon "sleepy/network/common"
+	"sleepy/network/common/tag"
+	"sleepy/network/ed2k/common"
+	"sleepy/types"
+)
+
+// Capabilities announced to the server in the login request
+const (
+	ServerCapZlib       = 0x0001
+	ServerCapIPInLogin  = 0x0002
+	ServerCapAuxPort    = 0x0004
+	ServerCapNewTags    = 0x0008
+	ServerCapUnicode    = 0x0010
+	ServerCapLargeFiles = 0x0100
+)
+
+// Flags of the TCP features supported by a server, sent with the client ID
+const (
+	TCPFlagCompression    = 0x0001
+	TCPFlagNewTags        = 0x0008
+	TCPFlagUnicode        = 0x0010
+	TCPFlagRelatedSearch  = 0x0040
+	TCPFlagTypeTagInteger = 0x0080
+	TCPFlagLargeFiles     = 0x0100
+	TCPFlagTCPObfuscation = 0x0400
+)
+
+// Capabilities we support
+const loginCapabilities = ServerCapZlib | ServerCapNewTags | ServerCapUnicode | ServerCapLargeFiles
+
+// IDChange assigns an ID to the client once logged in, and may be sent again later
+type IDChange struct {
+	ID ClientID
+	// TCPFlags are the features supported by the server, zero for the old servers
+	TCPFlags uint32
+	// AuxPort is the auxiliary port of the server, zero if it has none
+	AuxPort uint32
+}
+
+// Supports checks if the server announces the TCP feature [flag]
+func (change *IDChange) Supports(flag uint32) bool {
+	return change.TCPFlags&flag != 0
+}
+
+// Encode a login request, identifying us by the [userHash] and telling the [port] where we accept connections
+func encodeLogin(userHash types.UInt128, port uint16, name string) ([]byte, error) {
+	if userHash == nil {
+		return nil, errors.New("nil user hash")
+	}
+
+	writer := netCommon.NewWriter()
+	writer.WriteBytes(userHash.ToBytes())
+	// The ID is unknown until the server assigns it
+	writer.WriteUInt32(0)
+	writer.WriteUInt16(port)
+	writer.WriteEd2kTags(tag.List{
+		tag.NewString(tag.ID(common.TagClientName), name),
+		tag.NewUInt32(tag.ID(common.TagVersion), common.Ed2kVersion),
+		tag.NewUInt32(tag.ID(common.TagServerFlags), loginCapabilities),
+		tag.NewUInt32(tag.ID(common.TagEmuleVersion), common.EmuleVersion),
+	})
+	return writer.Bytes(), writer.Err()
+}
+
+// Decode an ID change, whose flags and auxiliary port are only sent by the newer servers
+func decodeIDChange(payload []byte) (*IDChange, error) {
+	reader := netCommon.NewReader(payload)
+	id, err := reader.ReadUInt32()
+	if err != nil {
+		return nil, errors.New("ID change too short")
+	}
+
+	change := &IDChange{ID: ClientID(id)}
+	if len(payload) >= 8 {
+		change.TCPFlags, _ = reader.ReadUInt32()
+	}
+	if len(payload) >= 12 {
+		change.AuxPort, _ = reader.ReadUInt32()
+	}
+	return change, nil
+}
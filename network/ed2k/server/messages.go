@@ -0,0 +1,87 @@
+package server
+
+import (
+	"errors"
+	"net"
+	netCommon "sleepy/network/common"
+	"sleepy/network/common/tag"
+	"sleepy/network/ed2k/common"
+	"sleepy/types"
+)
+
+// Ident is the identity of the server we are logged in
+type Ident struct {
+	Hash        types.UInt128
+	IP          net.IP
+	Port        uint16
+	Name        string
+	Description string
+	Tags        tag.List
+}
+
+func decodeIdent(payload []byte) (*Ident, error) {
+	reader := netCommon.NewReader(payload)
+	hash, err := readHash(reader)
+	if err != nil {
+		return nil, err
+	}
+	ip, err := reader.ReadUInt32()
+	if err != nil {
+		return nil, err
+	}
+	port, err := reader.ReadUInt16()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := reader.ReadEd2kTags()
+	if err != nil {
+		return nil, err
+	}
+
+	ident := &Ident{Hash: hash, IP: ClientID(ip).IP(), Port: port, Tags: tags}
+	ident.Name, _ = tags.GetString(common.TagServerName)
+	ident.Description, _ = tags.GetString(common.TagServerDescription)
+	return ident, nil
+}
+
+// Decode a server list, a one byte count followed by the IP and TCP port of each server
+func decodeServerList(payload []byte) ([]*net.TCPAddr, error) {
+	reader := netCommon.NewReader(payload)
+	count, err := reader.ReadUInt8()
+	if err != nil {
+		return nil, errors.New("empty server list")
+	}
+
+	servers := make([]*net.TCPAddr, 0, count)
+	for i := uint8(0); i < count; i++ {
+		ip, err := reader.ReadUInt32()
+		if err != nil {
+			return nil, err
+		}
+		port, err := reader.ReadUInt16()
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, &net.TCPAddr{IP: ClientID(ip).IP(), Port: int(port)})
+	}
+	return servers, nil
+}
+
+// Decode a server status, the number of users and files
+func decodeServerStatus(payload []byte) (uint32, uint32, error) {
+	reader := netCommon.NewReader(payload)
+	users, err := reader.ReadUInt32()
+	if err != nil {
+		return 0, 0, errors.New("server status too short")
+	}
+	files, err := reader.ReadUInt32()
+	if err != nil {
+		return 0, 0, errors.New("server status too short")
+	}
+	return users, files, nil
+}
+
+// Decode a server message, a string that may hold several lines
+func decodeServerMessage(payload []byte) (string, error) {
+	return readString(netCommon.NewReader(payload))
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	netCommon "sleepy/network/common"
+	"sleepy/network/common/tag"
+	"sleepy/network/ed2k/common"
+	"sleepy/types"
+)
+
+// IDs and ports offered instead of ours to the servers supporting compression, telling if the file is complete
+const (
+	completeFileID   = 0xFBFBFBFB
+	completeFilePort = 0xFBFB
+	partialFileID    = 0xFCFCFCFC
+	partialFilePort  = 0xFCFC
+)
+
+// SharedFile is a file we share, offered to the server so that the other clients find us as a source
+type SharedFile struct {
+	Hash types.UInt128
+	Name string
+	Size uint64
+	// Type is the eD2k type of the file, as "Audio" or "Video", not sent if empty
+	Type     string
+	Complete bool
+}
+
+// Encode an offer of the [files]. A high [id] and the [port] identify us as their source unless the server supports
+// compression, the large files are skipped if the server doesn't support them
+func encodeOfferFiles(files []SharedFile, id ClientID, port uint16, tcpFlags uint32) ([]byte, error) {
+	offered := make([]SharedFile, 0, len(files))
+	for _, file := range files {
+		if file.Hash == nil {
+			return nil, errors.New("nil file hash")
+		}
+		if file.Size > largeFileSize && tcpFlags&TCPFlagLargeFiles == 0 {
+			continue
+		}
+		offered = append(offered, file)
+	}
+
+	writer := netCommon.NewWriter()
+	writer.WriteUInt32(uint32(len(offered)))
+	for _, file := range offered {
+		writer.WriteBytes(file.Hash.ToBytes())
+		if tcpFlags&TCPFlagCompression == 0 {
+			// The low IDs aren't reachable, they are offered as zero
+			if id.IsLowID() {
+				id, port = 0, 0
+			}
+			writer.WriteUInt32(uint32(id))
+			writer.WriteUInt16(port)
+		} else if file.Complete {
+			writer.WriteUInt32(completeFileID)
+			writer.WriteUInt16(completeFilePort)
+		} else {
+			writer.WriteUInt32(partialFileID)
+			writer.WriteUInt16(partialFilePort)
+		}
+
+		tags := tag.List{tag.NewString(tag.ID(common.TagFileName), file.Name)}
+		// The large files, only offered to the servers supporting them, have a 64 bits size as eMule sends it
+		if file.Size > largeFileSize {
+			tags = append(tags, tag.NewUInt64(tag.ID(common.TagFileSize), file.Size))
+		} else {
+			tags = append(tags, tag.NewUInt32(tag.ID(common.TagFileSize), uint32(file.Size)))
+		}
+		if file.Type != "" {
+			tags = append(tags, tag.NewString(tag.ID(common.TagFileType), file.Type))
+		}
+		writer.WriteEd2kTags(tags)
+	}
+	return writer.Bytes(), writer.Err()
+}
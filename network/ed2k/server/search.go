@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+	netCommon "sleepy/network/common"
+	"sleepy/network/common/tag"
+	"sleepy/network/ed2k/common"
+	"sleepy/types"
+)
+
+// SearchResult is a file found by a server search
+type SearchResult struct {
+	FileHash types.UInt128
+	// ClientID and Port of a client sharing the file, zero when the server only knows its sources
+	ClientID        ClientID
+	Port            uint16
+	Name            string
+	Size            uint64
+	Type            string
+	Sources         uint32
+	CompleteSources uint32
+	Tags            tag.List
+}
+
+// Decode a search response. The servers may send several results in one datagram, each one after the first preceded
+// by the protocol and the command
+func decodeSearchResults(data []byte) ([]*SearchResult, error) {
+	reader := netCommon.NewReader(data)
+	var results []*SearchResult
+	for {
+		result, err := readSearchResult(reader)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+
+		if !nextRecord(reader, common.OperationGlobalSearchResponse) {
+			return results, nil
+		}
+	}
+}
+
+func readSearchResult(reader *netCommon.Reader) (*SearchResult, error) {
+	result := &SearchResult{}
+	var err error
+	if result.FileHash, err = readHash(reader); err != nil {
+		return nil, err
+	}
+	id, err := reader.ReadUInt32()
+	if err != nil {
+		return nil, err
+	}
+	result.ClientID = ClientID(id)
+	if result.Port, err = reader.ReadUInt16(); err != nil {
+		return nil, err
+	}
+	if result.Tags, err = reader.ReadEd2kTags(); err != nil {
+		return nil, err
+	}
+
+	result.Name, _ = result.Tags.GetString(common.TagFileName)
+	result.Type, _ = result.Tags.GetString(common.TagFileType)
+	size, _ := result.Tags.GetUInt(common.TagFileSize)
+	sizeHigh, _ := result.Tags.GetUInt(common.TagFileSizeHigh)
+	result.Size = size | sizeHigh<<32
+	sources, _ := result.Tags.GetUInt(common.TagSources)
+	result.Sources = uint32(sources)
+	completeSources, _ := result.Tags.GetUInt(common.TagCompleteSources)
+	result.CompleteSources = uint32(completeSources)
+	return result, nil
+}
+
+// Skip the header of the next record of a datagram holding several ones, telling if there is one
+func nextRecord(reader *netCommon.Reader, operation common.Operation) bool {
+	remaining := reader.Remaining()
+	if len(remaining) < 2 || remaining[0] != common.ProtEd2kUDPServer || remaining[1] != byte(operation) {
+		return false
+	}
+	return reader.Discard(2) == nil
+}
+
+// Read a file hash, sent as its 16 raw bytes
+func readHash(reader *netCommon.Reader) (types.UInt128, error) {
+	data, err := reader.ReadBytes(16)
+	if err != nil {
+		return nil, errors.New("truncated file hash")
+	}
+	return types.NewUInt128FromByteArray(data)
+}
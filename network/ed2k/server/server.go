@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net"
+)
+
+// Client IDs below this are low IDs, given to the clients whose TCP port isn't reachable
+const lowIDLimit = 0x1000000
+
+// Servers listen for UDP four ports above their TCP port
+const udpPortOffset = 4
+
+// ClientID is the ID given by a server to a client. The high IDs are the IP of the client, as a little endian number
+type ClientID uint32
+
+// ClientIDFromIP gets the high ID of the [ip]
+func ClientIDFromIP(ip net.IP) ClientID {
+	ipv4 := ip.To4()
+	if ipv4 == nil {
+		return 0
+	}
+	return ClientID(uint32(ipv4[0]) | uint32(ipv4[1])<<8 | uint32(ipv4[2])<<16 | uint32(ipv4[3])<<24)
+}
+
+// IsLowID checks if the ID was given to a client whose TCP port isn't reachable
+func (id ClientID) IsLowID() bool {
+	return id < lowIDLimit
+}
+
+// IP gets the IP of a high ID
+func (id ClientID) IP() net.IP {
+	return net.IPv4(byte(id), byte(id>>8), byte(id>>16), byte(id>>24))
+}
+
+// UDPAddr gets the UDP address of the server with the [ip] and [tcpPort]
+func UDPAddr(ip net.IP, tcpPort uint16) *net.UDPAddr {
+	return &net.UDPAddr{IP: ip, Port: int(tcpPort) + udpPortOffset}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	netCommon "sleepy/network/common"
+	"sleepy/network/ed2k/common"
+	"sleepy/types"
+)
+
+const (
+	// Bigger files are requested with a 64 bits size, as the eMule OLD_MAX_EMULE_FILE_SIZE constant
+	largeFileSize = 4290048000
+	// Max files asked in one source request
+	maxSourceFiles = 35
+)
+
+// File is a file whose sources are requested
+type File struct {
+	Hash types.UInt128
+	Size uint64
+}
+
+// Source is a client sharing a file
+type Source struct {
+	ID   ClientID
+	Port uint16
+}
+
+// FoundSources are the sources of a file answered by a server
+type FoundSources struct {
+	FileHash types.UInt128
+	Sources  []Source
+}
+
+// Encode a source request, the size of the large files is sent after a zero 32 bits size
+func encodeSourcesRequest(files []File) ([]byte, error) {
+	if len(files) == 0 || len(files) > maxSourceFiles {
+		return nil, errors.New("invalid number of files")
+	}
+
+	writer := netCommon.NewWriter()
+	for _, file := range files {
+		if file.Hash == nil {
+			return nil, errors.New("nil file hash")
+		}
+		writer.WriteBytes(file.Hash.ToBytes())
+		if file.Size > largeFileSize {
+			writer.WriteUInt32(0)
+			writer.WriteUInt64(file.Size)
+		} else {
+			writer.WriteUInt32(uint32(file.Size))
+		}
+	}
+	return writer.Bytes(), writer.Err()
+}
+
+// Decode a found sources response. As the search responses, several ones may be sent in one datagram
+func decodeFoundSources(data []byte) ([]*FoundSources, error) {
+	reader := netCommon.NewReader(data)
+	var found []*FoundSources
+	for {
+		sources, err := readFoundSources(reader)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, sources)
+
+		if !nextRecord(reader, common.OperationGlobalFoundSources) {
+			return found, nil
+		}
+	}
+}
+
+func readFoundSources(reader *netCommon.Reader) (*FoundSources, error) {
+	hash, err := readHash(reader)
+	if err != nil {
+		return nil, err
+	}
+	count, err := reader.ReadUInt8()
+	if err != nil {
+		return nil, err
+	}
+
+	found := &FoundSources{FileHash: hash, Sources: make([]Source, 0, count)}
+	for i := uint8(0); i < count; i++ {
+		id, err := reader.ReadUInt32()
+		if err != nil {
+			return nil, err
+		}
+		port, err := reader.ReadUInt16()
+		if err != nil {
+			return nil, err
+		}
+		found.Sources = append(found.Sources, Source{ID: ClientID(id), Port: port})
+	}
+	return found, nil
+}
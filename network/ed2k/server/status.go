@@ -0,0 +1,71 @@
+package server
+
+import (
+	"errors"
+	netCommon "sleepy/network/common"
+)
+
+// Flags of the UDP features supported by a server, sent in his status
+const (
+	UDPFlagExtGetSources  = 0x0001
+	UDPFlagExtGetFiles    = 0x0002
+	UDPFlagNewTags        = 0x0008
+	UDPFlagUnicode        = 0x0010
+	UDPFlagExtGetSources2 = 0x0020
+	UDPFlagLargeFiles     = 0x0100
+	UDPFlagUDPObfuscation = 0x0200
+	UDPFlagTCPObfuscation = 0x0400
+)
+
+// Status is the load and features of a server, answered to a status request. The fields after the file count are
+// only sent by the newer servers, they are zero otherwise
+type Status struct {
+	Users      uint32
+	Files      uint32
+	MaxUsers   uint32
+	SoftFiles  uint32
+	HardFiles  uint32
+	UDPFlags   uint32
+	LowIDUsers uint32
+	// Ports and key of the obfuscated connections
+	ObfuscationUDPPort uint16
+	ObfuscationTCPPort uint16
+	UDPKey             uint32
+}
+
+// Supports checks if the server announces the UDP feature [flag]
+func (status *Status) Supports(flag uint32) bool {
+	return status.UDPFlags&flag != 0
+}
+
+// Decode a status response, whose fields are known by his size as eMule does
+func decodeStatus(data []byte) (uint32, *Status, error) {
+	if len(data) < 12 {
+		return 0, nil, errors.New("status response too short")
+	}
+
+	reader := netCommon.NewReader(data)
+	challenge, _ := reader.ReadUInt32()
+	status := &Status{}
+	status.Users, _ = reader.ReadUInt32()
+	status.Files, _ = reader.ReadUInt32()
+	if len(data) >= 16 {
+		status.MaxUsers, _ = reader.ReadUInt32()
+	}
+	if len(data) >= 24 {
+		status.SoftFiles, _ = reader.ReadUInt32()
+		status.HardFiles, _ = reader.ReadUInt32()
+	}
+	if len(data) >= 28 {
+		status.UDPFlags, _ = reader.ReadUInt32()
+	}
+	if len(data) >= 32 {
+		status.LowIDUsers, _ = reader.ReadUInt32()
+	}
+	if len(data) >= 40 {
+		status.ObfuscationUDPPort, _ = reader.ReadUInt16()
+		status.ObfuscationTCPPort, _ = reader.ReadUInt16()
+		status.UDPKey, _ = reader.ReadUInt32()
+	}
+	return challenge, status, nil
+}
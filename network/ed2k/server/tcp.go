@@ -0,0 +1,387 @@
+package server
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	netManager "sleepy/network"
+	"sleepy/network/ed2k/common"
+	"sleepy/types"
+	"sleepy/utils/event"
+	"sync"
+	"time"
+)
+
+const (
+	tcpDialTimeout  = 30 * time.Second
+	tcpWriteTimeout = 30 * time.Second
+	// Time the server is given to assign us an ID once the login is sent
+	loginTimeout = time.Minute
+	// Interval of the keep alive messages when the config doesn't set it, below the idle time of the servers
+	keepAliveInterval = 5 * time.Minute
+	// Size of the biggest message accepted, once inflated
+	maxMessageSize = 1 << 20
+)
+
+// TCPConfig identifies us to the servers
+type TCPConfig struct {
+	UserHash types.UInt128
+	// Name is the user name shown to the other clients
+	Name string
+	// Port where we accept the connections of the other clients
+	Port uint16
+	// KeepAlive is the interval of the keep alive messages, keepAliveInterval if zero
+	KeepAlive time.Duration
+}
+
+// MessageEventArgs is a message sent by the server to be shown to the user
+type MessageEventArgs struct {
+	Text string
+}
+
+// StatusEventArgs is the load of the server, sent periodically
+type StatusEventArgs struct {
+	Users uint32
+	Files uint32
+}
+
+// IdentEventArgs is the identity of the server, sent after the login
+type IdentEventArgs struct {
+	Ident *Ident
+}
+
+// ServerListEventArgs are the servers known by the server, sent after the login or when asked
+type ServerListEventArgs struct {
+	Servers []*net.TCPAddr
+}
+
+// IDChangeEventArgs is an ID assigned by the server, the first one once logged in
+type IDChangeEventArgs struct {
+	Change *IDChange
+}
+
+// Connection is a TCP connection to an ed2k server, carrying framed messages: protocol byte, little endian uint32
+// size of the opcode and the payload, opcode and payload. The packed messages have their payload deflated with zlib
+type Connection struct {
+	network netManager.Manager
+	config  TCPConfig
+
+	conn        net.Conn
+	opened      bool
+	writeAccess sync.Mutex
+
+	// Last ID change, nil until logged in
+	change   *IDChange
+	err      error
+	access   sync.RWMutex
+	loggedIn chan struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	messageEvent    *event.Emitter
+	statusEvent     *event.Emitter
+	identEvent      *event.Emitter
+	serverListEvent *event.Emitter
+	idChangeEvent   *event.Emitter
+}
+
+func NewConnection(network netManager.Manager, config TCPConfig) *Connection {
+	if config.KeepAlive == 0 {
+		config.KeepAlive = keepAliveInterval
+	}
+	return &Connection{
+		network:         network,
+		config:          config,
+		loggedIn:        make(chan struct{}),
+		done:            make(chan struct{}),
+		messageEvent:    event.NewEvent(),
+		statusEvent:     event.NewEvent(),
+		identEvent:      event.NewEvent(),
+		serverListEvent: event.NewEvent(),
+		idChangeEvent:   event.NewEvent(),
+	}
+}
+
+// MessageEvent is emitted with the messages of the server. As the other events, it's emitted from the goroutine
+// reading the connection in the order the messages arrive, and the listeners must be added before connecting to get
+// the messages sent along the login
+func (connection *Connection) MessageEvent() *event.Handler {
+	return connection.messageEvent.GetHandler()
+}
+
+func (connection *Connection) StatusEvent() *event.Handler {
+	return connection.statusEvent.GetHandler()
+}
+
+func (connection *Connection) IdentEvent() *event.Handler {
+	return connection.identEvent.GetHandler()
+}
+
+func (connection *Connection) ServerListEvent() *event.Handler {
+	return connection.serverListEvent.GetHandler()
+}
+
+func (connection *Connection) IDChangeEvent() *event.Handler {
+	return connection.idChangeEvent.GetHandler()
+}
+
+// Connect opens the connection to the [server] and logs in, returning once the server assigns us an ID. The
+// connection is kept alive until Close is called or the server closes it
+func (connection *Connection) Connect(ctx context.Context, server *net.TCPAddr) (*IDChange, error) {
+	login, err := encodeLogin(connection.config.UserHash, connection.config.Port, connection.config.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	connection.access.Lock()
+	if connection.opened {
+		connection.access.Unlock()
+		return nil, errors.New("the connection is already opened")
+	}
+	connection.opened = true
+	connection.access.Unlock()
+
+	conn, err := connection.network.DialTCP(server.IP, uint16(server.Port), tcpDialTimeout)
+	if err != nil {
+		connection.closeWith(err)
+		return nil, err
+	}
+	connection.access.Lock()
+	connection.conn = conn
+	connection.access.Unlock()
+	// Closed while dialing
+	select {
+	case <-connection.done:
+		conn.Close()
+		return nil, errors.New("the connection is closed")
+	default:
+	}
+
+	if err = connection.write(common.OperationLoginRequest, login); err != nil {
+		connection.closeWith(err)
+		return nil, err
+	}
+	go connection.receive()
+
+	select {
+	case <-connection.loggedIn:
+		go connection.keepAlive()
+		return connection.lastChange(), nil
+	case <-connection.done:
+		return nil, connection.Err()
+	case <-ctx.Done():
+		connection.closeWith(ctx.Err())
+		return nil, ctx.Err()
+	case <-time.After(loginTimeout):
+		connection.closeWith(ErrReplyTimeout)
+		return nil, ErrReplyTimeout
+	}
+}
+
+// ID gets the ID assigned by the server, zero if not logged in
+func (connection *Connection) ID() ClientID {
+	if change := connection.lastChange(); change != nil {
+		return change.ID
+	}
+	return 0
+}
+
+func (connection *Connection) lastChange() *IDChange {
+	connection.access.RLock()
+	defer connection.access.RUnlock()
+	return connection.change
+}
+
+// OfferFiles publishes the [files] we share in the server, replacing the ones offered before
+func (connection *Connection) OfferFiles(files []SharedFile) error {
+	change := connection.lastChange()
+	if change == nil {
+		return errors.New("not logged in")
+	}
+	payload, err := encodeOfferFiles(files, change.ID, connection.config.Port, change.TCPFlags)
+	if err != nil {
+		return err
+	}
+	return connection.write(common.OperationOfferFiles, payload)
+}
+
+// GetServerList asks the servers known by the server, answered through the server list event
+func (connection *Connection) GetServerList() error {
+	return connection.write(common.OperationGetServerList, nil)
+}
+
+// Done is closed once the connection is closed
+func (connection *Connection) Done() <-chan struct{} {
+	return connection.done
+}
+
+// Err gets the error that closed the connection, nil if it's open or was closed by Close
+func (connection *Connection) Err() error {
+	connection.access.RLock()
+	defer connection.access.RUnlock()
+	return connection.err
+}
+
+// Close closes the connection. Calling it again does nothing
+func (connection *Connection) Close() {
+	connection.closeWith(nil)
+}
+
+func (connection *Connection) closeWith(err error) {
+	connection.closeOnce.Do(func() {
+		connection.access.Lock()
+		connection.err = err
+		if connection.conn != nil {
+			connection.conn.Close()
+		}
+		connection.access.Unlock()
+		close(connection.done)
+	})
+}
+
+// Send an empty file offer periodically, as eMule does to keep the connection alive
+func (connection *Connection) keepAlive() {
+	ticker := time.NewTicker(connection.config.KeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := connection.write(common.OperationOfferFiles, make([]byte, 4)); err != nil {
+				connection.closeWith(err)
+				return
+			}
+		case <-connection.done:
+			return
+		}
+	}
+}
+
+// Read and handle the messages until the connection is closed
+func (connection *Connection) receive() {
+	for {
+		operation, payload, err := connection.read()
+		if err != nil {
+			connection.closeWith(err)
+			return
+		}
+		if err = connection.handle(operation, payload); err != nil {
+			log.Printf("Invalid server message %#x: %s", byte(operation), err)
+		}
+	}
+}
+
+func (connection *Connection) handle(operation common.Operation, payload []byte) error {
+	switch operation {
+	case common.OperationIDChange:
+		change, err := decodeIDChange(payload)
+		if err != nil {
+			return err
+		}
+		connection.access.Lock()
+		first := connection.change == nil
+		connection.change = change
+		connection.access.Unlock()
+		if first {
+			close(connection.loggedIn)
+		}
+		connection.idChangeEvent.EmitSync(connection, IDChangeEventArgs{Change: change})
+	case common.OperationServerMessage:
+		text, err := decodeServerMessage(payload)
+		if err != nil {
+			return err
+		}
+		connection.messageEvent.EmitSync(connection, MessageEventArgs{Text: text})
+	case common.OperationServerStatus:
+		users, files, err := decodeServerStatus(payload)
+		if err != nil {
+			return err
+		}
+		connection.statusEvent.EmitSync(connection, StatusEventArgs{Users: users, Files: files})
+	case common.OperationServerIdent:
+		ident, err := decodeIdent(payload)
+		if err != nil {
+			return err
+		}
+		connection.identEvent.EmitSync(connection, IdentEventArgs{Ident: ident})
+	case common.OperationServerList:
+		servers, err := decodeServerList(payload)
+		if err != nil {
+			return err
+		}
+		connection.serverListEvent.EmitSync(connection, ServerListEventArgs{Servers: servers})
+	}
+	return nil
+}
+
+// Write a message with the [operation] and the [payload]
+func (connection *Connection) write(operation common.Operation, payload []byte) error {
+	frame := make([]byte, 6+len(payload))
+	frame[0] = common.ProtEd2kTCP
+	binary.LittleEndian.PutUint32(frame[1:5], uint32(1+len(payload)))
+	frame[5] = byte(operation)
+	copy(frame[6:], payload)
+
+	connection.access.RLock()
+	conn := connection.conn
+	connection.access.RUnlock()
+	if conn == nil {
+		return errors.New("not connected")
+	}
+
+	connection.writeAccess.Lock()
+	defer connection.writeAccess.Unlock()
+	if err := conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout)); err != nil {
+		return err
+	}
+	_, err := conn.Write(frame)
+	return err
+}
+
+// Read the next message, inflating it if packed
+func (connection *Connection) read() (common.Operation, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(connection.conn, header); err != nil {
+		return 0, nil, err
+	}
+	if header[0] != common.ProtEd2kTCP && header[0] != common.ProtEmuleTCPCompress {
+		return 0, nil, errors.New("unknown server protocol")
+	}
+	size := binary.LittleEndian.Uint32(header[1:])
+	if size == 0 || size > maxMessageSize {
+		return 0, nil, errors.New("invalid server message size")
+	}
+
+	message := make([]byte, size)
+	if _, err := io.ReadFull(connection.conn, message); err != nil {
+		return 0, nil, err
+	}
+	if header[0] == common.ProtEmuleTCPCompress {
+		payload, err := inflate(message[1:])
+		return common.Operation(message[0]), payload, err
+	}
+	return common.Operation(message[0]), message[1:], nil
+}
+
+func inflate(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	inflated := &bytes.Buffer{}
+	size, err := io.Copy(inflated, io.LimitReader(reader, maxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if size > maxMessageSize {
+		return nil, errors.New("packed server message too big")
+	}
+	return inflated.Bytes(), nil
+}
@@ -0,0 +1,338 @@
+package server
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	netManager "sleepy/network"
+	netCommon "sleepy/network/common"
+	"sleepy/network/common/tag"
+	"sleepy/network/ed2k/common"
+	"sleepy/utils/event"
+	"testing"
+	"time"
+)
+
+type frame struct {
+	operation common.Operation
+	payload   []byte
+}
+
+func writeFrame(t *testing.T, conn net.Conn, operation common.Operation, payload []byte) {
+	data := []byte{common.ProtEd2kTCP, 0, 0, 0, 0, byte(operation)}
+	binary.LittleEndian.PutUint32(data[1:5], uint32(1+len(payload)))
+	if _, err := conn.Write(append(data, payload...)); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func writePackedFrame(t *testing.T, conn net.Conn, operation common.Operation, payload []byte) {
+	buffer := &bytes.Buffer{}
+	writer := zlib.NewWriter(buffer)
+	writer.Write(payload)
+	writer.Close()
+
+	data := []byte{common.ProtEmuleTCPCompress, 0, 0, 0, 0, byte(operation)}
+	binary.LittleEndian.PutUint32(data[1:5], uint32(1+buffer.Len()))
+	if _, err := conn.Write(append(data, buffer.Bytes()...)); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func readFrame(t *testing.T, conn net.Conn) frame {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if header[0] != common.ProtEd2kTCP {
+		t.Fatalf("Unexpected protocol %x", header[0])
+	}
+	message := make([]byte, binary.LittleEndian.Uint32(header[1:]))
+	if _, err := io.ReadFull(conn, message); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return frame{operation: common.Operation(message[0]), payload: message[1:]}
+}
+
+// Fake server accepting one connection, handled by [serve]
+func startFakeTCPServer(t *testing.T, serve func(conn net.Conn)) *net.TCPAddr {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}()
+	return listener.Addr().(*net.TCPAddr)
+}
+
+func newTestConnection(t *testing.T, config TCPConfig) *Connection {
+	network := netManager.NewManager(0, 0)
+	if err := network.Start(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	connection := NewConnection(network, config)
+	t.Cleanup(func() {
+		connection.Close()
+		network.Stop()
+	})
+	return connection
+}
+
+// Collect the arguments of the events emitted by [handler]
+func listen(handler *event.Handler) chan event.Args {
+	received := make(chan event.Args, 4)
+	handler.Listen(func(sender interface{}, args event.Args) {
+		received <- args
+	})
+	return received
+}
+
+func waitEvent(t *testing.T, events chan event.Args) event.Args {
+	select {
+	case args := <-events:
+		return args
+	case <-time.After(time.Second):
+		t.Fatalf("The event must be emitted")
+		return nil
+	}
+}
+
+func TestConnection_Login(t *testing.T) {
+	logins := make(chan frame, 1)
+	requests := make(chan frame, 4)
+	server := startFakeTCPServer(t, func(conn net.Conn) {
+		logins <- readFrame(t, conn)
+
+		writer := netCommon.NewWriter()
+		writer.WriteString("Welcome")
+		writeFrame(t, conn, common.OperationServerMessage, writer.Bytes())
+
+		writer = netCommon.NewWriter()
+		writer.WriteUInt32(0x0401A8C0)
+		writer.WriteUInt32(TCPFlagCompression | TCPFlagLargeFiles)
+		writeFrame(t, conn, common.OperationIDChange, writer.Bytes())
+
+		writer = netCommon.NewWriter()
+		writer.WriteUInt32(1500)
+		writer.WriteUInt32(250000)
+		writePackedFrame(t, conn, common.OperationServerStatus, writer.Bytes())
+
+		writer = netCommon.NewWriter()
+		writer.WriteBytes(testHash(7).ToBytes())
+		writer.WriteUInt32(0x0100007F)
+		writer.WriteUInt16(4661)
+		writer.WriteEd2kTags(tag.List{tag.NewString(tag.ID(common.TagServerName), "Test server")})
+		writeFrame(t, conn, common.OperationServerIdent, writer.Bytes())
+
+		for i := 0; i < 2; i++ {
+			requests <- readFrame(t, conn)
+		}
+		writeFrame(t, conn, common.OperationServerList, []byte{2, 10, 0, 0, 1, 0x35, 0x12, 10, 0, 0, 2, 0x36, 0x12})
+		io.Copy(io.Discard, conn)
+	})
+
+	config := TCPConfig{UserHash: testHash(1), Name: "tester", Port: 4662}
+	connection := newTestConnection(t, config)
+	messages := listen(connection.MessageEvent())
+	statuses := listen(connection.StatusEvent())
+	idents := listen(connection.IdentEvent())
+	serverLists := listen(connection.ServerListEvent())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	change, err := connection.Connect(ctx, server)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if change.ID.IsLowID() || !change.ID.IP().Equal(net.IPv4(192, 168, 1, 4)) || connection.ID() != change.ID {
+		t.Errorf("Unexpected ID %s", change.ID.IP())
+	}
+	if !change.Supports(TCPFlagLargeFiles) || change.Supports(TCPFlagUnicode) {
+		t.Errorf("Unexpected TCP flags %x", change.TCPFlags)
+	}
+
+	login := <-logins
+	if login.operation != common.OperationLoginRequest || !bytes.Equal(login.payload[:16], testHash(1).ToBytes()) {
+		t.Fatalf("The login must start with the user hash, got %x", login.payload)
+	}
+	reader := netCommon.NewReader(login.payload[16:])
+	if id, _ := reader.ReadUInt32(); id != 0 {
+		t.Errorf("The login ID must be zero, got %d", id)
+	}
+	if port, _ := reader.ReadUInt16(); port != 4662 {
+		t.Errorf("The login must tell our TCP port, got %d", port)
+	}
+	tags, err := reader.ReadEd2kTags()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if name, _ := tags.GetString(common.TagClientName); name != "tester" {
+		t.Errorf("The login must tell our name, got %s", name)
+	}
+	if flags, _ := tags.GetUInt(common.TagServerFlags); flags&ServerCapZlib == 0 || flags&ServerCapLargeFiles == 0 {
+		t.Errorf("Unexpected capabilities %x", flags)
+	}
+
+	if args := waitEvent(t, messages).(MessageEventArgs); args.Text != "Welcome" {
+		t.Errorf("Unexpected server message %s", args.Text)
+	}
+	if args := waitEvent(t, statuses).(StatusEventArgs); args.Users != 1500 || args.Files != 250000 {
+		t.Errorf("The packed status must be inflated, got %+v", args)
+	}
+	ident := waitEvent(t, idents).(IdentEventArgs).Ident
+	if !ident.Hash.Equal(testHash(7)) || !ident.IP.Equal(net.IPv4(127, 0, 0, 1)) || ident.Port != 4661 || ident.Name != "Test server" {
+		t.Errorf("Unexpected ident %+v", ident)
+	}
+
+	files := []SharedFile{
+		{Hash: testHash(2), Name: "complete.avi", Size: 1000, Type: "Video", Complete: true},
+		{Hash: testHash(3), Name: "partial.iso", Size: 5000000000},
+	}
+	if err = connection.OfferFiles(files); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err = connection.GetServerList(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	offer := <-requests
+	if offer.operation != common.OperationOfferFiles {
+		t.Fatalf("Unexpected operation %x", offer.operation)
+	}
+	reader = netCommon.NewReader(offer.payload)
+	if count, _ := reader.ReadUInt32(); count != 2 {
+		t.Fatalf("Both files must be offered, got %d", count)
+	}
+	reader.Discard(16)
+	// The servers supporting compression are told if the file is complete instead of our ID
+	if id, _ := reader.ReadUInt32(); id != completeFileID {
+		t.Errorf("Unexpected complete file ID %x", id)
+	}
+	reader.Discard(2)
+	if tags, _ = reader.ReadEd2kTags(); len(tags) != 3 {
+		t.Errorf("The name, size and type must be offered, got %d tags", len(tags))
+	}
+	reader.Discard(16)
+	if id, _ := reader.ReadUInt32(); id != partialFileID {
+		t.Errorf("Unexpected partial file ID %x", id)
+	}
+	reader.Discard(2)
+	tags, _ = reader.ReadEd2kTags()
+	if size, found := tags.GetByID(common.TagFileSize); !found || size.Type != tag.TypeUInt64 || size.Value != uint64(5000000000) {
+		t.Errorf("The large files must have a 64 bits size, got %+v", size)
+	}
+	if _, found := tags.GetByID(common.TagFileSizeHigh); found {
+		t.Errorf("The large files must not have the high size")
+	}
+
+	if request := <-requests; request.operation != common.OperationGetServerList || len(request.payload) != 0 {
+		t.Errorf("Unexpected server list request %+v", request)
+	}
+	servers := waitEvent(t, serverLists).(ServerListEventArgs).Servers
+	if len(servers) != 2 || servers[1].String() != "10.0.0.2:4662" {
+		t.Errorf("Unexpected server list %v", servers)
+	}
+}
+
+func TestConnection_KeepAlive(t *testing.T) {
+	keepAlives := make(chan frame, 1)
+	server := startFakeTCPServer(t, func(conn net.Conn) {
+		readFrame(t, conn)
+		writeFrame(t, conn, common.OperationIDChange, []byte{0x34, 0x12, 0, 0})
+		keepAlives <- readFrame(t, conn)
+		io.Copy(io.Discard, conn)
+	})
+
+	connection := newTestConnection(t, TCPConfig{UserHash: testHash(1), Port: 4662, KeepAlive: 20 * time.Millisecond})
+	change, err := connection.Connect(context.Background(), server)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !change.ID.IsLowID() || change.TCPFlags != 0 {
+		t.Errorf("Unexpected ID change %+v", change)
+	}
+
+	keepAlive := <-keepAlives
+	if keepAlive.operation != common.OperationOfferFiles || !bytes.Equal(keepAlive.payload, []byte{0, 0, 0, 0}) {
+		t.Errorf("The keep alive must be an empty file offer, got %x %x", byte(keepAlive.operation), keepAlive.payload)
+	}
+}
+
+func TestConnection_ClosedByServer(t *testing.T) {
+	server := startFakeTCPServer(t, func(conn net.Conn) {
+		readFrame(t, conn)
+	})
+
+	connection := newTestConnection(t, TCPConfig{UserHash: testHash(1)})
+	if _, err := connection.Connect(context.Background(), server); err == nil {
+		t.Fatalf("The login must fail when the server closes the connection")
+	}
+	select {
+	case <-connection.Done():
+	default:
+		t.Errorf("The connection must be done")
+	}
+	if connection.Err() == nil {
+		t.Errorf("The error closing the connection must be kept")
+	}
+	if _, err := connection.Connect(context.Background(), server); err == nil {
+		t.Errorf("A connection can't be opened twice")
+	}
+}
+
+func TestEncodeOfferFiles_LowID(t *testing.T) {
+	files := []SharedFile{{Hash: testHash(1), Name: "small", Size: 10}, {Hash: testHash(2), Name: "large", Size: 5000000000}}
+	payload, err := encodeOfferFiles(files, 0x1234, 4662, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	reader := netCommon.NewReader(payload)
+	if count, _ := reader.ReadUInt32(); count != 1 {
+		t.Fatalf("The large files must be skipped by the servers not supporting them, got %d files", count)
+	}
+	reader.Discard(16)
+	id, _ := reader.ReadUInt32()
+	port, _ := reader.ReadUInt16()
+	if id != 0 || port != 0 {
+		t.Errorf("The low IDs must be offered as zero, got %d:%d", id, port)
+	}
+}
+
+func TestEncodeOfferFiles_LargeFile(t *testing.T) {
+	files := []SharedFile{{Hash: testHash(1), Name: "small", Size: 10}, {Hash: testHash(2), Name: "large", Size: 5000000000}}
+	payload, err := encodeOfferFiles(files, 0x1234, 4662, TCPFlagLargeFiles)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	reader := netCommon.NewReader(payload)
+	if count, _ := reader.ReadUInt32(); count != 2 {
+		t.Fatalf("The large files must be offered to the servers supporting them, got %d files", count)
+	}
+	for _, file := range files {
+		reader.Discard(22)
+		tags, err := reader.ReadEd2kTags()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		size, _ := tags.GetByID(common.TagFileSize)
+		if file.Size > largeFileSize && (size.Type != tag.TypeUInt64 || size.Value != file.Size) {
+			t.Errorf("The size of %s must be a 64 bits tag, got %+v", file.Name, size)
+		} else if file.Size <= largeFileSize && (size.Type != tag.TypeUInt32 || size.Value != uint32(file.Size)) {
+			t.Errorf("The size of %s must be a 32 bits tag, got %+v", file.Name, size)
+		}
+	}
+}
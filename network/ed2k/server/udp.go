@@ -0,0 +1,307 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	netManager "sleepy/network"
+	netCommon "sleepy/network/common"
+	"sleepy/network/ed2k/common"
+	ed2kPacket "sleepy/network/ed2k/packet"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Time a status or description reply is waited
+	udpReplyTimeout = 10 * time.Second
+	// Time the results of a search or source request are collected
+	udpSearchLifetime = 30 * time.Second
+
+	// High words of the challenges, as eMule sends them
+	statusChallenge      = 0x55AA0000
+	descriptionChallenge = 0xF0FF0000
+)
+
+// ErrReplyTimeout is returned while waiting a reply that doesn't arrive in time
+var ErrReplyTimeout = errors.New("reply timeout")
+
+// ErrClientStopped is returned by the requests when the client stops
+var ErrClientStopped = errors.New("client stopped")
+
+// UDPClient sends the UDP requests of the ed2k servers and routes their responses. Only one request of each kind
+// runs by server, as most responses don't tell which request they answer
+type UDPClient struct {
+	network netManager.Manager
+
+	waiters       map[string]*waiter
+	waitersAccess sync.Mutex
+
+	// Done when the client stops, ending the running requests
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+}
+
+// A request waiting for the responses of a server
+type waiter struct {
+	key      string
+	receive  func(w *waiter, payload []byte)
+	done     chan struct{}
+	finished bool
+	access   sync.RWMutex
+}
+
+func NewUDPClient(network netManager.Manager) *UDPClient {
+	client := &UDPClient{
+		network: network,
+		waiters: make(map[string]*waiter),
+	}
+	client.ctx, client.cancel = context.WithCancel(context.Background())
+	return client
+}
+
+// Start handles the server datagrams received by the network manager, that must be started apart. The client runs
+// until Stop is called or the [ctx] is done
+func (client *UDPClient) Start(ctx context.Context) error {
+	if client.ctx.Err() != nil {
+		return errors.New("the client is stopped")
+	}
+
+	client.network.HandleUDP(common.ProtEd2kUDPServer, client.receiveUDP)
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Stop()
+		case <-client.ctx.Done():
+		}
+	}()
+	return nil
+}
+
+// Stop unregisters the handler and ends the running requests. Calling it again does nothing
+func (client *UDPClient) Stop() {
+	client.stopOnce.Do(func() {
+		client.network.HandleUDP(common.ProtEd2kUDPServer, nil)
+		client.cancel()
+	})
+}
+
+// Status asks the [server] its load and features
+func (client *UDPClient) Status(ctx context.Context, server *net.UDPAddr) (*Status, error) {
+	challenge := statusChallenge | uint32(rand.Intn(0x10000))
+	replies := make(chan *Status, 1)
+	w, err := client.newWaiter(server, common.OperationServerStatusResponse, func(w *waiter, payload []byte) {
+		echoed, status, err := decodeStatus(payload)
+		if err != nil {
+			log.Printf("Invalid status from %s: %s", server, err)
+			return
+		}
+		if echoed != challenge {
+			return
+		}
+		select {
+		case replies <- status:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer client.removeWaiter(w)
+
+	if err = client.sendChallenge(server, common.OperationServerStatusRequest, challenge); err != nil {
+		return nil, err
+	}
+	select {
+	case status := <-replies:
+		return status, nil
+	case <-time.After(udpReplyTimeout):
+		return nil, ErrReplyTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-client.ctx.Done():
+		return nil, ErrClientStopped
+	}
+}
+
+// Description asks the [server] its name and details
+func (client *UDPClient) Description(ctx context.Context, server *net.UDPAddr) (*Description, error) {
+	challenge := descriptionChallenge | uint32(rand.Intn(0x10000))
+	replies := make(chan *Description, 1)
+	w, err := client.newWaiter(server, common.OperationServerDescResponse, func(w *waiter, payload []byte) {
+		description, err := decodeDescription(payload, challenge)
+		if err != nil {
+			log.Printf("Invalid description from %s: %s", server, err)
+			return
+		}
+		select {
+		case replies <- description:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer client.removeWaiter(w)
+
+	if err = client.sendChallenge(server, common.OperationServerDescRequest, challenge); err != nil {
+		return nil, err
+	}
+	select {
+	case description := <-replies:
+		return description, nil
+	case <-time.After(udpReplyTimeout):
+		return nil, ErrReplyTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-client.ctx.Done():
+		return nil, ErrClientStopped
+	}
+}
+
+// Search sends a search of the encoded [expression] to the [server], streaming the results through the returned
+// channel. The channel is closed once the search lifetime expires or the [ctx] is done
+func (client *UDPClient) Search(ctx context.Context, server *net.UDPAddr, expression []byte) (<-chan *SearchResult, error) {
+	if len(expression) == 0 {
+		return nil, errors.New("empty search expression")
+	}
+	return stream(client, ctx, server, common.OperationGlobalSearchRequest, common.OperationGlobalSearchResponse,
+		expression, decodeSearchResults)
+}
+
+// GetSources asks the [server] the sources of the [files], streaming them through the returned channel. The channel
+// is closed once the search lifetime expires or the [ctx] is done
+func (client *UDPClient) GetSources(ctx context.Context, server *net.UDPAddr, files []File) (<-chan *FoundSources, error) {
+	payload, err := encodeSourcesRequest(files)
+	if err != nil {
+		return nil, err
+	}
+	return stream(client, ctx, server, common.OperationGlobalGetSources2, common.OperationGlobalFoundSources,
+		payload, decodeFoundSources)
+}
+
+// Send a request whose responses are decoded by [decode] and streamed through the returned channel until the search
+// lifetime expires
+func stream[T any](
+	client *UDPClient,
+	ctx context.Context,
+	server *net.UDPAddr,
+	request common.Operation,
+	response common.Operation,
+	payload []byte,
+	decode func(payload []byte) ([]T, error),
+) (<-chan T, error) {
+	results := make(chan T, 64)
+	w, err := client.newWaiter(server, response, func(w *waiter, payload []byte) {
+		decoded, err := decode(payload)
+		if err != nil {
+			log.Printf("Invalid response from %s: %s", server, err)
+			return
+		}
+		for _, result := range decoded {
+			select {
+			case results <- result:
+			case <-w.done:
+				return
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = client.send(server, request, payload); err != nil {
+		client.removeWaiter(w)
+		return nil, err
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, udpSearchLifetime)
+		defer cancel()
+		select {
+		case <-ctx.Done():
+		case <-client.ctx.Done():
+		}
+		client.removeWaiter(w)
+		close(results)
+	}()
+	return results, nil
+}
+
+func (client *UDPClient) send(server *net.UDPAddr, operation common.Operation, payload []byte) error {
+	packet, err := ed2kPacket.NewServerUDPPacket(operation, payload)
+	if err != nil {
+		return err
+	}
+	return client.network.SendUDP(server.IP, uint16(server.Port), packet)
+}
+
+func (client *UDPClient) sendChallenge(server *net.UDPAddr, operation common.Operation, challenge uint32) error {
+	writer := netCommon.NewWriter()
+	writer.WriteUInt32(challenge)
+	return client.send(server, operation, writer.Bytes())
+}
+
+// Register a waiter for the responses of the [server] to the [operation]
+func (client *UDPClient) newWaiter(server *net.UDPAddr, operation common.Operation, receive func(w *waiter, payload []byte)) (*waiter, error) {
+	w := &waiter{
+		key:     waiterKey(server, operation),
+		receive: receive,
+		done:    make(chan struct{}),
+	}
+
+	client.waitersAccess.Lock()
+	defer client.waitersAccess.Unlock()
+	if client.ctx.Err() != nil {
+		return nil, ErrClientStopped
+	}
+	if _, found := client.waiters[w.key]; found {
+		return nil, errors.New("a request of the same kind is already running for " + server.String())
+	}
+	client.waiters[w.key] = w
+	return w, nil
+}
+
+// Unregister the waiter, returning once the responses being delivered are handled
+func (client *UDPClient) removeWaiter(w *waiter) {
+	client.waitersAccess.Lock()
+	delete(client.waiters, w.key)
+	client.waitersAccess.Unlock()
+
+	close(w.done)
+	w.access.Lock()
+	w.finished = true
+	w.access.Unlock()
+}
+
+// Deliver a response payload, unless the waiter has finished
+func (w *waiter) deliver(payload []byte) {
+	w.access.RLock()
+	defer w.access.RUnlock()
+	if !w.finished {
+		w.receive(w, payload)
+	}
+}
+
+func (client *UDPClient) receiveUDP(data []byte, from *net.UDPAddr) error {
+	if len(data) < 2 {
+		return errors.New("server datagram too short")
+	}
+
+	client.waitersAccess.Lock()
+	w := client.waiters[waiterKey(from, common.Operation(data[1]))]
+	client.waitersAccess.Unlock()
+	if w == nil {
+		return errors.New("unexpected server operation " + strconv.Itoa(int(data[1])))
+	}
+	w.deliver(data[2:])
+	return nil
+}
+
+func waiterKey(server *net.UDPAddr, operation common.Operation) string {
+	return server.String() + "/" + strconv.Itoa(int(operation))
+}
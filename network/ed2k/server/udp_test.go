@@ -0,0 +1,331 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	netManager "sleepy/network"
+	netCommon "sleepy/network/common"
+	"sleepy/network/common/tag"
+	"sleepy/network/ed2k/common"
+	"sleepy/types"
+	"testing"
+	"time"
+)
+
+// Fake server answering the datagrams with the ones returned by [answer], without the protocol byte
+func startFakeServer(t *testing.T, answer func(operation byte, payload []byte) [][]byte) *net.UDPAddr {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buffer := make([]byte, 8192)
+		for {
+			size, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			if size < 2 || buffer[0] != common.ProtEd2kUDPServer {
+				continue
+			}
+			payload := append([]byte(nil), buffer[2:size]...)
+			for _, reply := range answer(buffer[1], payload) {
+				conn.WriteToUDP(append([]byte{common.ProtEd2kUDPServer}, reply...), from)
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+func startTestClient(t *testing.T) *UDPClient {
+	network := netManager.NewManager(0, 0)
+	if err := network.Start(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	client := NewUDPClient(network)
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	t.Cleanup(func() {
+		client.Stop()
+		network.Stop()
+	})
+	return client
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func testHash(seed byte) types.UInt128 {
+	data := make([]byte, 16)
+	for i := range data {
+		data[i] = seed + byte(i)
+	}
+	hash, _ := types.NewUInt128FromByteArray(data)
+	return hash
+}
+
+func TestUDPClient_Status(t *testing.T) {
+	server := startFakeServer(t, func(operation byte, payload []byte) [][]byte {
+		if operation != byte(common.OperationServerStatusRequest) {
+			return nil
+		}
+		writer := netCommon.NewWriter()
+		writer.WriteUInt8(byte(common.OperationServerStatusResponse))
+		writer.WriteBytes(payload)
+		for _, value := range []uint32{1500, 250000, 5000, 100000, 150000, UDPFlagExtGetSources2 | UDPFlagLargeFiles, 300} {
+			writer.WriteUInt32(value)
+		}
+		writer.WriteUInt16(4665)
+		writer.WriteUInt16(4663)
+		writer.WriteUInt32(0xCAFE)
+		// A status with a wrong challenge must be ignored
+		stale := append([]byte(nil), writer.Bytes()...)
+		stale[1] ^= 0xFF
+		return [][]byte{stale, writer.Bytes()}
+	})
+	client := startTestClient(t)
+
+	status, err := client.Status(testContext(t), server)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if status.Users != 1500 || status.Files != 250000 || status.MaxUsers != 5000 || status.LowIDUsers != 300 {
+		t.Errorf("Unexpected status %+v", status)
+	}
+	if status.SoftFiles != 100000 || status.HardFiles != 150000 {
+		t.Errorf("Unexpected file limits %+v", status)
+	}
+	if !status.Supports(UDPFlagLargeFiles) || status.Supports(UDPFlagUnicode) {
+		t.Errorf("Unexpected UDP flags %x", status.UDPFlags)
+	}
+	if status.ObfuscationUDPPort != 4665 || status.ObfuscationTCPPort != 4663 || status.UDPKey != 0xCAFE {
+		t.Errorf("Unexpected obfuscation details %+v", status)
+	}
+}
+
+func TestDecodeStatus_OldServers(t *testing.T) {
+	writer := netCommon.NewWriter()
+	for _, value := range []uint32{0x55AA1234, 10, 20, 30} {
+		writer.WriteUInt32(value)
+	}
+	challenge, status, err := decodeStatus(writer.Bytes())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if challenge != 0x55AA1234 || status.Users != 10 || status.Files != 20 || status.MaxUsers != 30 {
+		t.Errorf("Unexpected status %+v", status)
+	}
+	if status.UDPFlags != 0 || status.HardFiles != 0 {
+		t.Errorf("The fields not sent must be zero, got %+v", status)
+	}
+
+	if _, _, err = decodeStatus(writer.Bytes()[:8]); err == nil {
+		t.Errorf("A status without the counts must fail")
+	}
+}
+
+func TestUDPClient_Description(t *testing.T) {
+	server := startFakeServer(t, func(operation byte, payload []byte) [][]byte {
+		if operation != byte(common.OperationServerDescRequest) {
+			return nil
+		}
+		writer := netCommon.NewWriter()
+		writer.WriteUInt8(byte(common.OperationServerDescResponse))
+		writer.WriteBytes(payload)
+		writer.WriteEd2kTags(tag.List{
+			tag.NewString(tag.ID(common.TagServerName), "Test server"),
+			tag.NewString(tag.ID(common.TagServerDescription), "Only for tests"),
+			tag.NewUInt32(tag.ID(common.TagServerVersion), 17<<16|15),
+		})
+		return [][]byte{writer.Bytes()}
+	})
+	client := startTestClient(t)
+
+	description, err := client.Description(testContext(t), server)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if description.Name != "Test server" || description.Description != "Only for tests" {
+		t.Errorf("Unexpected description %+v", description)
+	}
+	if description.Version != "17.15" {
+		t.Errorf("The version must be formatted as major.minor, got %s", description.Version)
+	}
+	if len(description.Tags) != 3 {
+		t.Errorf("All the tags must be kept, got %d", len(description.Tags))
+	}
+}
+
+func TestDecodeDescription_OldFormat(t *testing.T) {
+	writer := netCommon.NewWriter()
+	writer.WriteString("Old server")
+	writer.WriteString("Without tags")
+	description, err := decodeDescription(writer.Bytes(), 0xF0FF1234)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if description.Name != "Old server" || description.Description != "Without tags" {
+		t.Errorf("Unexpected description %+v", description)
+	}
+}
+
+func TestUDPClient_Search(t *testing.T) {
+	expression := []byte{0x01, 0x04, 0x00, 't', 'e', 's', 't'}
+	received := make(chan []byte, 1)
+	server := startFakeServer(t, func(operation byte, payload []byte) [][]byte {
+		if operation != byte(common.OperationGlobalSearchRequest) {
+			return nil
+		}
+		received <- payload
+
+		writer := netCommon.NewWriter()
+		writer.WriteUInt8(byte(common.OperationGlobalSearchResponse))
+		writer.WriteBytes(testHash(1).ToBytes())
+		writer.WriteUInt32(0x0401A8C0)
+		writer.WriteUInt16(4662)
+		writer.WriteEd2kTags(tag.List{
+			tag.NewString(tag.ID(common.TagFileName), "test.avi"),
+			tag.NewUInt32(tag.ID(common.TagFileSize), 700000000),
+			tag.NewString(tag.ID(common.TagFileType), "Video"),
+			tag.NewUInt32(tag.ID(common.TagSources), 12),
+			tag.NewUInt32(tag.ID(common.TagCompleteSources), 3),
+		})
+		// The second result travels in the same datagram
+		writer.WriteBytes([]byte{common.ProtEd2kUDPServer, byte(common.OperationGlobalSearchResponse)})
+		writer.WriteBytes(testHash(2).ToBytes())
+		writer.WriteUInt32(0)
+		writer.WriteUInt16(0)
+		writer.WriteEd2kTags(tag.List{
+			tag.NewString(tag.ID(common.TagFileName), "test.iso"),
+			tag.NewUInt32(tag.ID(common.TagFileSize), 0x10),
+			tag.NewUInt32(tag.ID(common.TagFileSizeHigh), 0x01),
+		})
+		return [][]byte{writer.Bytes()}
+	})
+	client := startTestClient(t)
+
+	results, err := client.Search(testContext(t), server, expression)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	var found []*SearchResult
+	for result := range results {
+		found = append(found, result)
+	}
+
+	if payload := <-received; !bytes.Equal(payload, expression) {
+		t.Errorf("The expression must be sent as is, got %x", payload)
+	}
+	if len(found) != 2 {
+		t.Fatalf("Both results must be found, got %d", len(found))
+	}
+	first := found[0]
+	if !first.FileHash.Equal(testHash(1)) || first.Name != "test.avi" || first.Size != 700000000 || first.Type != "Video" {
+		t.Errorf("Unexpected result %+v", first)
+	}
+	if first.Sources != 12 || first.CompleteSources != 3 {
+		t.Errorf("Unexpected source counts %+v", first)
+	}
+	if !first.ClientID.IP().Equal(net.IPv4(192, 168, 1, 4)) || first.ClientID.IsLowID() || first.Port != 4662 {
+		t.Errorf("Unexpected client %s:%d", first.ClientID.IP(), first.Port)
+	}
+	if found[1].Name != "test.iso" || found[1].Size != 0x100000010 {
+		t.Errorf("The high size must be added to the size, got %+v", found[1])
+	}
+}
+
+func TestUDPClient_GetSources(t *testing.T) {
+	files := []File{{Hash: testHash(1), Size: 1000}, {Hash: testHash(2), Size: 5000000000}}
+	received := make(chan []byte, 1)
+	server := startFakeServer(t, func(operation byte, payload []byte) [][]byte {
+		if operation != byte(common.OperationGlobalGetSources2) {
+			return nil
+		}
+		received <- payload
+
+		writer := netCommon.NewWriter()
+		writer.WriteUInt8(byte(common.OperationGlobalFoundSources))
+		writer.WriteBytes(testHash(1).ToBytes())
+		writer.WriteUInt8(2)
+		writer.WriteUInt32(0x0401A8C0)
+		writer.WriteUInt16(4662)
+		writer.WriteUInt32(1234)
+		writer.WriteUInt16(4670)
+		writer.WriteBytes([]byte{common.ProtEd2kUDPServer, byte(common.OperationGlobalFoundSources)})
+		writer.WriteBytes(testHash(2).ToBytes())
+		writer.WriteUInt8(0)
+		return [][]byte{writer.Bytes()}
+	})
+	client := startTestClient(t)
+
+	results, err := client.GetSources(testContext(t), server, files)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	var found []*FoundSources
+	for sources := range results {
+		found = append(found, sources)
+	}
+
+	payload := <-received
+	if len(payload) != 16+4+16+12 {
+		t.Fatalf("Unexpected request size %d", len(payload))
+	}
+	if binary.LittleEndian.Uint32(payload[16:]) != 1000 {
+		t.Errorf("The small sizes must be sent in 32 bits")
+	}
+	if binary.LittleEndian.Uint32(payload[36:]) != 0 || binary.LittleEndian.Uint64(payload[40:]) != 5000000000 {
+		t.Errorf("The large sizes must be sent in 64 bits after a zero size, got %x", payload[36:])
+	}
+
+	if len(found) != 2 {
+		t.Fatalf("The sources of both files must be found, got %d", len(found))
+	}
+	if !found[0].FileHash.Equal(testHash(1)) || len(found[0].Sources) != 2 {
+		t.Fatalf("Unexpected sources %+v", found[0])
+	}
+	if source := found[0].Sources[1]; source.ID != 1234 || !source.ID.IsLowID() || source.Port != 4670 {
+		t.Errorf("Unexpected low ID source %+v", source)
+	}
+	if len(found[1].Sources) != 0 {
+		t.Errorf("The second file has no sources, got %d", len(found[1].Sources))
+	}
+
+	if _, err = client.GetSources(testContext(t), server, nil); err == nil {
+		t.Errorf("A request without files must fail")
+	}
+}
+
+func TestUDPClient_Stop(t *testing.T) {
+	// The server never answers
+	server := startFakeServer(t, func(operation byte, payload []byte) [][]byte { return nil })
+	client := startTestClient(t)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.Status(context.Background(), server)
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	client.Stop()
+
+	select {
+	case err := <-errs:
+		if err != ErrClientStopped {
+			t.Errorf("The pending requests must fail with ErrClientStopped, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Stopping the client must end the pending requests")
+	}
+	if _, err := client.Status(context.Background(), server); err != ErrClientStopped {
+		t.Errorf("The requests of a stopped client must fail, got %v", err)
+	}
+}
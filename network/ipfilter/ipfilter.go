@@ -0,0 +1,225 @@
+package ipfilter
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultLevel is the eMule default filter level, the ranges with a lower access level are blocked
+const DefaultLevel = 127
+
+// Access level of the P2P (PeerGuardian) lists, that have no levels. All their ranges are blocked
+const p2pLevel = 0
+
+// Range of blocked IPv4 addresses, both ends included
+type Range struct {
+	Start       uint32
+	End         uint32
+	Level       uint8
+	Description string
+}
+
+// Filter blocks the IPs in the loaded ranges with an access level lower than the filter level
+type Filter struct {
+	level uint8
+	// Blocked ranges, sorted and without overlaps
+	ranges []Range
+	access sync.RWMutex
+}
+
+func NewFilter(level uint8) *Filter {
+	return &Filter{level: level, ranges: make([]Range, 0)}
+}
+
+// Count returns the number of blocked ranges, the overlapped ranges are counted as one
+func (filter *Filter) Count() int {
+	filter.access.RLock()
+	defer filter.access.RUnlock()
+	return len(filter.ranges)
+}
+
+// Add blocks the range from [start] to [end] if its [level] is lower than the filter level
+func (filter *Filter) Add(start net.IP, end net.IP, level uint8, description string) error {
+	if start.To4() == nil || end.To4() == nil {
+		return errors.New("only IPv4 ranges are supported")
+	}
+	blocked := Range{Start: ipToUint32(start), End: ipToUint32(end), Level: level, Description: description}
+	if blocked.Start > blocked.End {
+		return errors.New("the range start is after its end")
+	}
+
+	filter.access.Lock()
+	defer filter.access.Unlock()
+	if level < filter.level {
+		filter.ranges = mergeRanges(append(filter.ranges, blocked))
+	}
+	return nil
+}
+
+// Match returns the blocked range containing the [ip], if any
+func (filter *Filter) Match(ip net.IP) (Range, bool) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return Range{}, false
+	}
+	value := ipToUint32(ip4)
+
+	filter.access.RLock()
+	defer filter.access.RUnlock()
+	i := sort.Search(len(filter.ranges), func(i int) bool {
+		return filter.ranges[i].End >= value
+	})
+	if i < len(filter.ranges) && filter.ranges[i].Start <= value {
+		return filter.ranges[i], true
+	}
+	return Range{}, false
+}
+
+// IsFiltered checks if the [ip] is blocked
+func (filter *Filter) IsFiltered(ip net.IP) bool {
+	_, filtered := filter.Match(ip)
+	return filtered
+}
+
+// LoadFile adds the ranges of an ipfilter.dat, guarding.p2p or P2P plaintext file
+func (filter *Filter) LoadFile(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return filter.Load(file)
+}
+
+// Load adds the ranges read from [reader], returning how many were read. The format is detected by line, and the
+// malformed lines are skipped
+func (filter *Filter) Load(reader io.Reader) (int, error) {
+	loaded := make([]Range, 0)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if parsed, ok := parseDatLine(line); ok {
+			loaded = append(loaded, parsed)
+		} else if parsed, ok := parseP2PLine(line); ok {
+			loaded = append(loaded, parsed)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	filter.access.Lock()
+	defer filter.access.Unlock()
+	for _, parsed := range loaded {
+		if parsed.Level < filter.level {
+			filter.ranges = append(filter.ranges, parsed)
+		}
+	}
+	filter.ranges = mergeRanges(filter.ranges)
+	return len(loaded), nil
+}
+
+// Parse an ipfilter.dat line: "000.000.000.000 - 000.255.255.255 , 000 , Description"
+func parseDatLine(line string) (Range, bool) {
+	fields := strings.SplitN(line, ",", 3)
+	if len(fields) < 2 {
+		return Range{}, false
+	}
+	parsed, ok := parseRange(fields[0])
+	if !ok {
+		return Range{}, false
+	}
+	level, err := strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 8)
+	if err != nil {
+		return Range{}, false
+	}
+	parsed.Level = uint8(level)
+	if len(fields) == 3 {
+		parsed.Description = strings.TrimSpace(fields[2])
+	}
+	return parsed, true
+}
+
+// Parse a P2P (guarding.p2p or PeerGuardian plaintext) line: "Description:0.0.0.0-0.255.255.255". The description
+// may contain colons, so the range is after the last one
+func parseP2PLine(line string) (Range, bool) {
+	separator := strings.LastIndex(line, ":")
+	if separator < 0 {
+		return Range{}, false
+	}
+	parsed, ok := parseRange(line[separator+1:])
+	if !ok {
+		return Range{}, false
+	}
+	parsed.Level = p2pLevel
+	parsed.Description = strings.TrimSpace(line[:separator])
+	return parsed, true
+}
+
+// Parse a "start - end" range of IPv4 addresses
+func parseRange(text string) (Range, bool) {
+	ends := strings.Split(text, "-")
+	if len(ends) != 2 {
+		return Range{}, false
+	}
+	start, ok := parseIPv4(ends[0])
+	if !ok {
+		return Range{}, false
+	}
+	end, ok := parseIPv4(ends[1])
+	if !ok || start > end {
+		return Range{}, false
+	}
+	return Range{Start: start, End: end}, true
+}
+
+// Parse an IPv4 address allowing the leading zeros of the filter files, rejected by net.ParseIP
+func parseIPv4(text string) (uint32, bool) {
+	parts := strings.Split(strings.TrimSpace(text), ".")
+	if len(parts) != 4 {
+		return 0, false
+	}
+	var ip uint32
+	for _, part := range parts {
+		value, err := strconv.ParseUint(part, 10, 8)
+		if err != nil {
+			return 0, false
+		}
+		ip = ip<<8 | uint32(value)
+	}
+	return ip, true
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	ip4 := ip.To4()
+	return uint32(ip4[0])<<24 | uint32(ip4[1])<<16 | uint32(ip4[2])<<8 | uint32(ip4[3])
+}
+
+// Sort the ranges and join the overlapped or adjacent ones, keeping the description and level of the first
+func mergeRanges(ranges []Range) []Range {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+	merged := ranges[:0]
+	for _, current := range ranges {
+		last := len(merged) - 1
+		if last >= 0 && (current.Start <= merged[last].End || current.Start == merged[last].End+1) {
+			if current.End > merged[last].End {
+				merged[last].End = current.End
+			}
+			continue
+		}
+		merged = append(merged, current)
+	}
+	return merged
+}
@@ -0,0 +1,68 @@
+package ipfilter
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestFilter_LoadDat(t *testing.T) {
+	filter := NewFilter(DefaultLevel)
+	loaded, err := filter.Load(strings.NewReader(`# Comment
+001.002.003.000 - 001.002.003.255 , 000 , Bad range
+010.000.000.000 - 010.000.000.255 , 200 , Allowed range
+not a range
+
+005.000.000.000 - 005.000.000.010 , 100 , Other bad range
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if loaded != 3 || filter.Count() != 2 {
+		t.Errorf("The 3 ranges must be loaded and the 2 with low level blocked, %d loaded and %d blocked", loaded, filter.Count())
+	}
+	if blocked, ok := filter.Match(net.IPv4(1, 2, 3, 4)); !ok || blocked.Description != "Bad range" {
+		t.Errorf("The IPs of the bad range must be filtered")
+	}
+	if !filter.IsFiltered(net.IPv4(5, 0, 0, 10)) || filter.IsFiltered(net.IPv4(5, 0, 0, 11)) {
+		t.Errorf("The range ends must be included")
+	}
+	if filter.IsFiltered(net.IPv4(10, 0, 0, 1)) {
+		t.Errorf("The ranges with a level over the filter one must not be filtered")
+	}
+}
+
+func TestFilter_LoadP2P(t *testing.T) {
+	filter := NewFilter(DefaultLevel)
+	loaded, err := filter.Load(strings.NewReader("Bad: the range:1.2.3.0-1.2.3.255\r\nOther:1.2.4.0-1.2.4.10\r\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if loaded != 2 || filter.Count() != 1 {
+		t.Errorf("The adjacent ranges must be merged, %d loaded and %d blocked", loaded, filter.Count())
+	}
+	if blocked, ok := filter.Match(net.IPv4(1, 2, 4, 5)); !ok || blocked.Description != "Bad: the range" {
+		t.Errorf("The merged range must keep the first description, got %+v", blocked)
+	}
+	if filter.IsFiltered(net.IPv4(1, 2, 4, 11)) {
+		t.Errorf("The IPs out of the ranges must not be filtered")
+	}
+}
+
+func TestFilter_Add(t *testing.T) {
+	filter := NewFilter(DefaultLevel)
+	if err := filter.Add(net.IPv4(10, 0, 0, 10), net.IPv4(10, 0, 0, 1), 0, ""); err == nil {
+		t.Errorf("The inverted ranges must be rejected")
+	}
+	filter.Add(net.IPv4(10, 0, 0, 0), net.IPv4(10, 0, 0, 100), 0, "First")
+	filter.Add(net.IPv4(10, 0, 0, 50), net.IPv4(10, 0, 1, 0), 0, "Overlapped")
+
+	if filter.Count() != 1 || !filter.IsFiltered(net.IPv4(10, 0, 0, 200)) {
+		t.Errorf("The overlapped ranges must be merged")
+	}
+	if filter.IsFiltered(net.ParseIP("::1")) {
+		t.Errorf("The IPv6 addresses must not be filtered")
+	}
+}
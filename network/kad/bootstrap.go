@@ -0,0 +1,103 @@
+package kad
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sleepy/network/kad/message"
+	"sleepy/network/kad/packet/factory"
+	kadTypes "sleepy/network/kad/types"
+	"sleepy/types"
+)
+
+const (
+	maxHellosAfterBootstrap = 20
+	bootstrapResponseSize   = 20 // Number of contacts sent in the bootstrap responses
+)
+
+// Contact is the peer information exchanged in the Kad contact lists
+type Contact struct {
+	ClientID types.UInt128
+	IP       net.IP
+	UDPPort  uint16
+	TCPPort  uint16
+	Version  uint8
+}
+
+// Read a contact list entry
+func readContact(reader *Reader) (*Contact, error) {
+	contact, err := message.ReadContact(reader)
+	if err != nil {
+		return nil, err
+	}
+	return contactFromMessage(contact), nil
+}
+
+func contactFromMessage(contact message.Contact) *Contact {
+	return &Contact{
+		ClientID: contact.ClientID,
+		IP:       contact.IP,
+		UDPPort:  contact.UDPPort,
+		TCPPort:  contact.TCPPort,
+		Version:  contact.Version,
+	}
+}
+
+// Bootstrap sends a bootstrap request to each of the seed addresses, the contacts received are added to the router
+func (client *Client) Bootstrap(seeds []*net.UDPAddr) error {
+	if len(seeds) == 0 {
+		return errors.New("no seed addresses to bootstrap from")
+	}
+
+	var lastErr error
+	sent := 0
+	for _, seed := range seeds {
+		client.expectReply(seed.IP, CommKad2BootstrapRes)
+		err := client.sendPacket(seed.IP, uint16(seed.Port), factory.GetBootstrap2Request(), obfuscationKeys{})
+		if err != nil {
+			lastErr = err
+		} else {
+			sent++
+		}
+	}
+
+	if sent == 0 {
+		return lastErr
+	}
+	return nil
+}
+
+// Get the contacts answering a bootstrap request, without the requester at [from]. The Kad1 requests also tell
+// the [requesterID], nil for the Kad2 ones
+func (client *Client) bootstrapPeers(from *net.UDPAddr, requesterID types.UInt128) []kadTypes.Peer {
+	peers := client.router.GetBootstrapPeers(bootstrapResponseSize+1, client.config.ClientID)
+	peers = kadTypes.Filter(peers, func(peer kadTypes.Peer) bool {
+		if requesterID != nil && peer.GetID().Equal(requesterID) {
+			return false
+		}
+		return !peer.GetIP().Equal(from.IP) || int(peer.GetUDPPort()) != from.Port
+	})
+	if len(peers) > bootstrapResponseSize {
+		peers = peers[:bootstrapResponseSize]
+	}
+	return peers
+}
+
+// Add the contacts received in a bootstrap response and greet them, so they get verified
+func (client *Client) addBootstrapContacts(contacts []*Contact, assumeVerified bool) {
+	hellos := 0
+	for _, contact := range contacts {
+		err := client.addContact(contact.ClientID, contact.IP, contact.UDPPort, contact.TCPPort, contact.Version, assumeVerified, false)
+		if err != nil {
+			log.Printf("Bootstrap contact %s not added: %s", contact.ClientID.ToHexString(), err)
+			continue
+		}
+
+		if hellos < maxHellosAfterBootstrap {
+			hellos++
+			if err := client.greetContact(contact); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package kad
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sleepy/network/common/tag"
+	ed2kCommon "sleepy/network/ed2k/common"
+	kadBuddy "sleepy/network/kad/buddy"
+	"sleepy/network/kad/common"
+	"sleepy/network/kad/packet/factory"
+	"sleepy/types"
+	"sleepy/utils/event"
+	"sync"
+	"time"
+)
+
+const (
+	buddyCheckInterval  = time.Minute
+	buddySearchInterval = 5 * time.Minute  // Minimum time between the searches of a buddy while firewalled
+	buddyPingInterval   = 10 * time.Minute // Time between the pings keeping the link with our buddy alive
+	buddySearchTimeout  = 2 * time.Minute
+	buddyHelloTimeout   = 30 * time.Second // Time given to the TCP connections to exchange the hellos
+	buddyLinkTimeout    = buddyPingInterval + time.Minute
+	incomingBuddyTTL    = time.Minute // Time given to an accepted firewalled client to open his link
+	buddyClientName     = "sleepy"
+)
+
+// Buddy is an open node relaying the callbacks to a firewalled client
+type Buddy struct {
+	UserHash types.UInt128
+	IP       net.IP
+	UDPPort  uint16
+	TCPPort  uint16
+}
+
+// CallbackEventArgs asks to connect to the node in [IP]:[TCPPort], that wants the file [FileID] from us
+type CallbackEventArgs struct {
+	FileID  types.UInt128
+	IP      net.IP
+	TCPPort uint16
+}
+
+// Firewalled client accepted as buddy
+type servedBuddy struct {
+	ip       net.IP
+	userHash types.UInt128
+	buddyID  types.UInt128
+	expires  time.Time // Time to open the link, while it's not opened
+	link     *kadBuddy.Link
+}
+
+type buddyState struct {
+	// Our buddy and the link with it, while firewalled
+	buddy      *Buddy
+	link       *kadBuddy.Link
+	connecting bool
+	linked     chan struct{}
+	lastSearch time.Time
+	lastPing   time.Time
+	// The firewalled client we are buddy of, while open
+	served *servedBuddy
+	access sync.Mutex
+}
+
+// GetBuddy gets the buddy relaying our callbacks, if any
+func (client *Client) GetBuddy() (*Buddy, bool) {
+	client.buddies.access.Lock()
+	defer client.buddies.access.Unlock()
+	if client.buddies.buddy == nil {
+		return nil, false
+	}
+	found := *client.buddies.buddy
+	return &found, true
+}
+
+// CallbackEvent is fired when our buddy relays the callback of a node wanting one of our files, as we are firewalled
+// and it can't connect to us
+func (client *Client) CallbackEvent() *event.Handler {
+	return client.callbackEvent.GetHandler()
+}
+
+// FindBuddy asks the closest nodes to our inverted ID to be our buddy, and waits until one of them is linked or the
+// [ctx] is done
+func (client *Client) FindBuddy(ctx context.Context) error {
+	client.buddies.access.Lock()
+	if client.buddies.buddy != nil {
+		client.buddies.access.Unlock()
+		return nil
+	}
+	if client.buddies.linked == nil {
+		client.buddies.linked = make(chan struct{})
+	}
+	linked := client.buddies.linked
+	client.buddies.lastSearch = time.Now()
+	client.buddies.access.Unlock()
+
+	target := types.Not(client.config.ClientID)
+	contacts, err := client.Lookup(ctx, target)
+	if err != nil {
+		return err
+	}
+	if len(contacts) == 0 {
+		return errors.New("no contacts to ask for a buddy")
+	}
+
+	packet, err := factory.GetFindBuddyRequest(target, client.sourceID(), client.config.TcpPort)
+	if err != nil {
+		return err
+	}
+	for _, contact := range contacts {
+		if !contact.speaksKad2() {
+			continue
+		}
+		client.expectReply(contact.IP, CommKadFindbuddyRes)
+		if err := client.sendToContact(contact, packet); err != nil {
+			log.Println(err)
+		}
+	}
+
+	select {
+	case <-linked:
+		return nil
+	case <-ctx.Done():
+		return errors.New("no buddy found")
+	case <-client.ctx.Done():
+		return ErrClientStopped
+	}
+}
+
+// SendCallbackRequest asks the buddy of a firewalled [source] to relay him that we want the [fileID], so he connects
+// to our TCP port
+func (client *Client) SendCallbackRequest(source *SourceResult, fileID types.UInt128) error {
+	if !source.IsFirewalled() || source.BuddyID == nil || source.BuddyIP == nil || source.BuddyPort == 0 {
+		return errors.New("the source has no buddy")
+	}
+
+	packet, err := factory.GetCallbackRequest(source.BuddyID, fileID, client.config.TcpPort)
+	if err != nil {
+		return err
+	}
+	return client.sendPacket(source.BuddyIP, source.BuddyPort, packet, obfuscationKeys{})
+}
+
+// Get the ed2k hello identifying us in the buddy links, with the user hash sent in our FINDBUDDY_REQ
+func (client *Client) buddyHello() *kadBuddy.Hello {
+	return &kadBuddy.Hello{
+		UserHash: client.sourceID(),
+		TCPPort:  client.config.TcpPort,
+		Tags: tag.List{
+			tag.NewString(tag.ID(ed2kCommon.TagClientName), buddyClientName),
+			tag.NewUInt32(tag.ID(ed2kCommon.TagVersion), ed2kCommon.Ed2kVersion),
+			tag.NewUInt32(tag.ID(ed2kCommon.TagEmuleUDPPorts), uint32(client.config.UdpPort)<<16|uint32(client.config.UdpPort)),
+			tag.NewUInt32(tag.ID(ed2kCommon.TagEmuleVersion), ed2kCommon.EmuleVersion),
+			// The Kad version is in the lowest bits
+			tag.NewUInt32(tag.ID(ed2kCommon.TagEmuleMiscOptions2), uint32(common.KadVersion)&0x0F),
+		},
+	}
+}
+
+// Link with the node that accepted to be our buddy, once it answered our hello
+func (client *Client) connectBuddy(found *Buddy) {
+	link, err := client.openLink(found.IP, found.TCPPort)
+	if err != nil {
+		log.Printf("Buddy %s not linked: %s", found.IP, err)
+		client.buddies.access.Lock()
+		client.buddies.connecting = false
+		client.buddies.access.Unlock()
+		return
+	}
+
+	client.buddies.access.Lock()
+	client.buddies.buddy = found
+	client.buddies.link = link
+	client.buddies.connecting = false
+	client.buddies.lastPing = time.Now()
+	if client.buddies.linked != nil {
+		close(client.buddies.linked)
+		client.buddies.linked = nil
+	}
+	client.buddies.access.Unlock()
+
+	log.Printf("Linked with buddy %s", found.IP)
+	client.runBuddyLink(link)
+}
+
+// Open an ed2k TCP connection to [ip]:[port], returning it once the hellos are exchanged
+func (client *Client) openLink(ip net.IP, port uint16) (*kadBuddy.Link, error) {
+	conn, err := client.network.DialTCP(ip, port, firewallConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	link := kadBuddy.NewLink(conn)
+
+	payload, err := client.buddyHello().Encode(true)
+	if err == nil {
+		err = link.Write(ed2kCommon.ProtEd2kTCP, kadBuddy.OpHello, payload)
+	}
+	if err != nil {
+		link.Close()
+		return nil, err
+	}
+
+	protocol, opcode, payload, err := link.Read(buddyHelloTimeout)
+	if err == nil && (protocol != ed2kCommon.ProtEd2kTCP || opcode != kadBuddy.OpHelloAnswer) {
+		err = errors.New("no hello answer")
+	}
+	if err == nil {
+		_, err = kadBuddy.DecodeHello(payload, false)
+	}
+	if err != nil {
+		link.Close()
+		return nil, err
+	}
+	return link, nil
+}
+
+// Accept an ed2k TCP connection, answering its hello, and link it if it comes from the firewalled client we accepted
+// as buddy
+func (client *Client) handleTCP(conn net.Conn) {
+	link := kadBuddy.NewLink(conn)
+	protocol, opcode, payload, err := link.Read(buddyHelloTimeout)
+	if err != nil || protocol != ed2kCommon.ProtEd2kTCP || opcode != kadBuddy.OpHello {
+		link.Close()
+		return
+	}
+	hello, err := kadBuddy.DecodeHello(payload, true)
+	if err != nil {
+		log.Printf("Invalid hello from %s: %s", link.RemoteIP(), err)
+		link.Close()
+		return
+	}
+
+	// Every hello is answered, as the eMule firewall checks only succeed once they get the answer
+	answer, err := client.buddyHello().Encode(false)
+	if err == nil {
+		err = link.Write(ed2kCommon.ProtEd2kTCP, kadBuddy.OpHelloAnswer, answer)
+	}
+	if err != nil {
+		log.Printf("Hello from %s not answered: %s", link.RemoteIP(), err)
+		link.Close()
+		return
+	}
+
+	client.buddies.access.Lock()
+	served := client.buddies.served
+	if served == nil || served.link != nil || served.expires.Before(time.Now()) || !served.ip.Equal(link.RemoteIP()) ||
+		!served.userHash.Equal(hello.UserHash) {
+		client.buddies.access.Unlock()
+		// Other connections, as the firewall checks, are closed once answered, or once the UDP check they may ask
+		// for is sent
+		client.answerUDPCheck(link)
+		link.Close()
+		return
+	}
+	served.link = link
+	client.buddies.access.Unlock()
+
+	log.Printf("Serving as buddy of %s", link.RemoteIP())
+	client.runBuddyLink(link)
+}
+
+// Close the [link] if the client stops before the returned function is called
+func (client *Client) closeOnStop(link *kadBuddy.Link) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-client.ctx.Done():
+			link.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// Handle the messages of a buddy link until it's closed, either if we are the buddy or the firewalled client
+func (client *Client) runBuddyLink(link *kadBuddy.Link) {
+	defer client.dropBuddyLink(link)
+
+	// The link may be registered after the client stopped and closed the known ones
+	defer client.closeOnStop(link)()
+
+	for {
+		protocol, opcode, payload, err := link.Read(buddyLinkTimeout)
+		if err != nil {
+			log.Printf("Buddy link with %s closed: %s", link.RemoteIP(), err)
+			return
+		}
+
+		if protocol != ed2kCommon.ProtEmuleTCP {
+			log.Printf("Ignoring ed2k message %x in the buddy link with %s", opcode, link.RemoteIP())
+			continue
+		}
+
+		switch opcode {
+		case kadBuddy.OpBuddyPing:
+			if err := link.Write(ed2kCommon.ProtEmuleTCP, kadBuddy.OpBuddyPong, nil); err != nil {
+				log.Println(err)
+			}
+		case kadBuddy.OpBuddyPong:
+		case kadBuddy.OpCallback:
+			client.handleBuddyCallback(link, payload)
+		case kadBuddy.OpReaskCallbackTCP:
+			// The reasks are only relayed for the files we download, and we have none
+			log.Printf("Ignoring reask callback from %s", link.RemoteIP())
+		default:
+			log.Printf("Unknown buddy link message %x from %s", opcode, link.RemoteIP())
+		}
+	}
+}
+
+// Forget a closed buddy link
+func (client *Client) dropBuddyLink(link *kadBuddy.Link) {
+	link.Close()
+
+	client.buddies.access.Lock()
+	defer client.buddies.access.Unlock()
+	if client.buddies.link == link {
+		client.buddies.buddy = nil
+		client.buddies.link = nil
+	}
+	if client.buddies.served != nil && client.buddies.served.link == link {
+		client.buddies.served = nil
+	}
+}
+
+// Notify the callback relayed by our buddy
+func (client *Client) handleBuddyCallback(link *kadBuddy.Link, payload []byte) {
+	client.buddies.access.Lock()
+	fromBuddy := client.buddies.link == link
+	client.buddies.access.Unlock()
+	if !fromBuddy {
+		log.Printf("Ignoring callback from %s, not our buddy", link.RemoteIP())
+		return
+	}
+
+	callback, err := kadBuddy.DecodeCallback(payload)
+	if err != nil {
+		log.Printf("Invalid callback from %s: %s", link.RemoteIP(), err)
+		return
+	}
+	if !callback.BuddyID.Equal(types.Not(client.config.ClientID)) {
+		log.Printf("Ignoring callback from %s for other client", link.RemoteIP())
+		return
+	}
+
+	client.callbackEvent.Emit(client, CallbackEventArgs{FileID: callback.FileID, IP: callback.IP, TCPPort: callback.TCPPort})
+}
+
+// Relay a callback to the firewalled client with the [buddyID], if we are his buddy
+func (client *Client) relayCallback(buddyID types.UInt128, callback *kadBuddy.Callback) error {
+	client.buddies.access.Lock()
+	var link *kadBuddy.Link
+	if served := client.buddies.served; served != nil && served.buddyID.Equal(buddyID) {
+		link = served.link
+	}
+	client.buddies.access.Unlock()
+	if link == nil {
+		return errors.New("not the buddy of the callback target")
+	}
+	payload, err := callback.Encode()
+	if err != nil {
+		return err
+	}
+	return link.Write(ed2kCommon.ProtEmuleTCP, kadBuddy.OpCallback, payload)
+}
+
+// Keep the link with our buddy alive, and look for a new one while firewalled without buddy
+func (client *Client) runBuddyKeeper() {
+	ticker := time.NewTicker(buddyCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-client.ctx.Done():
+			return
+		case <-ticker.C:
+			client.keepBuddy()
+		}
+	}
+}
+
+func (client *Client) keepBuddy() {
+	client.buddies.access.Lock()
+	link := client.buddies.link
+	ping := link != nil && time.Since(client.buddies.lastPing) >= buddyPingInterval
+	if ping {
+		client.buddies.lastPing = time.Now()
+	}
+	search := link == nil && !client.buddies.connecting && time.Since(client.buddies.lastSearch) >= buddySearchInterval
+	client.buddies.access.Unlock()
+
+	if ping {
+		if err := link.Write(ed2kCommon.ProtEmuleTCP, kadBuddy.OpBuddyPing, nil); err != nil {
+			log.Printf("Buddy not pinged: %s", err)
+		}
+	}
+	if search && client.TCPFirewallStatus() == FirewallFirewalled {
+		ctx, cancel := context.WithTimeout(client.ctx, buddySearchTimeout)
+		defer cancel()
+		if err := client.FindBuddy(ctx); err != nil {
+			log.Printf("Buddy not found: %s", err)
+		}
+	}
+}
+
+func (client *Client) closeBuddyLinks() {
+	client.buddies.access.Lock()
+	defer client.buddies.access.Unlock()
+	if client.buddies.link != nil {
+		client.buddies.link.Close()
+	}
+	if client.buddies.served != nil && client.buddies.served.link != nil {
+		client.buddies.served.link.Close()
+	}
+}
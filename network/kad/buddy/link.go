@@ -0,0 +1,90 @@
+package buddy
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	ed2kCommon "sleepy/network/ed2k/common"
+	"sync"
+	"time"
+)
+
+// Opcodes of the TCP messages exchanged between a firewalled client and his buddy, as eMule does. The link starts
+// with the ed2k hello exchange, the next messages are eMule extensions
+const (
+	OpHello            = byte(0x01) // ed2k OP_HELLO, sent by the client opening the link
+	OpHelloAnswer      = byte(0x4C) // ed2k OP_HELLOANSWER
+	OpCallback         = byte(0x99) // Asks the firewalled client to connect to a node wanting a file
+	OpReaskCallbackTCP = byte(0x9A) // Relays the UDP reask of a downloader to the firewalled client
+	OpBuddyPing        = byte(0x9D)
+	OpBuddyPong        = byte(0x9E)
+	maxMessageSize     = 1024 // Size of the biggest message accepted in a link, way bigger than the ones used
+)
+
+// OpFirewallCheckUDPRequest is the eMule OP_FWCHECKUDPREQ, sent after the hellos to ask a Kad client to send a
+// KADEMLIA2_FIREWALLUDP to our UDP ports. These connections are framed as the buddy links
+const OpFirewallCheckUDPRequest = byte(0xA7)
+
+// Link is a TCP connection between a firewalled client and his buddy, carrying eMule framed messages:
+// protocol byte, little endian uint32 size of the opcode and the payload, opcode and payload
+type Link struct {
+	conn        net.Conn
+	writeAccess sync.Mutex
+}
+
+func NewLink(conn net.Conn) *Link {
+	return &Link{conn: conn}
+}
+
+// RemoteIP gets the IP of the other side of the link
+func (link *Link) RemoteIP() net.IP {
+	if addr, ok := link.conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
+// Write sends a message of the [protocol] with the [opcode] and the [payload]
+func (link *Link) Write(protocol byte, opcode byte, payload []byte) error {
+	frame := make([]byte, 6+len(payload))
+	frame[0] = protocol
+	binary.LittleEndian.PutUint32(frame[1:5], uint32(1+len(payload)))
+	frame[5] = opcode
+	copy(frame[6:], payload)
+
+	link.writeAccess.Lock()
+	defer link.writeAccess.Unlock()
+	_, err := link.conn.Write(frame)
+	return err
+}
+
+// Read waits for the next message, failing if it doesn't arrive before the [timeout]. Only the ed2k and eMule
+// messages are accepted
+func (link *Link) Read(timeout time.Duration) (byte, byte, []byte, error) {
+	if err := link.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return 0, 0, nil, err
+	}
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(link.conn, header); err != nil {
+		return 0, 0, nil, err
+	}
+	if header[0] != ed2kCommon.ProtEd2kTCP && header[0] != ed2kCommon.ProtEmuleTCP {
+		return 0, 0, nil, errors.New("unknown buddy link protocol")
+	}
+	size := binary.LittleEndian.Uint32(header[1:])
+	if size == 0 || size > maxMessageSize {
+		return 0, 0, nil, errors.New("invalid buddy link message size")
+	}
+
+	message := make([]byte, size)
+	if _, err := io.ReadFull(link.conn, message); err != nil {
+		return 0, 0, nil, err
+	}
+	return header[0], message[0], message[1:], nil
+}
+
+func (link *Link) Close() error {
+	return link.conn.Close()
+}
@@ -0,0 +1,50 @@
+package buddy
+
+import (
+	"bytes"
+	"net"
+	ed2kCommon "sleepy/network/ed2k/common"
+	"testing"
+	"time"
+)
+
+func TestLink_WriteAndRead(t *testing.T) {
+	local, remote := net.Pipe()
+	sender, receiver := NewLink(local), NewLink(remote)
+	defer sender.Close()
+	defer receiver.Close()
+
+	go sender.Write(ed2kCommon.ProtEmuleTCP, OpCallback, []byte{1, 2, 3})
+
+	protocol, opcode, payload, err := receiver.Read(time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if protocol != ed2kCommon.ProtEmuleTCP || opcode != OpCallback || !bytes.Equal(payload, []byte{1, 2, 3}) {
+		t.Errorf("The message must be received as sent, got %x %x %v", protocol, opcode, payload)
+	}
+}
+
+func TestLink_InvalidMessage(t *testing.T) {
+	local, remote := net.Pipe()
+	receiver := NewLink(remote)
+	defer local.Close()
+	defer receiver.Close()
+
+	// Packed messages aren't used in the links
+	go local.Write([]byte{ed2kCommon.ProtEmuleTCPCompress, 1, 0, 0, 0, OpBuddyPing})
+	if _, _, _, err := receiver.Read(time.Second); err == nil {
+		t.Errorf("Messages of other protocols must be rejected")
+	}
+}
+
+func TestLink_ReadTimeout(t *testing.T) {
+	local, remote := net.Pipe()
+	receiver := NewLink(remote)
+	defer local.Close()
+	defer receiver.Close()
+
+	if _, _, _, err := receiver.Read(10 * time.Millisecond); err == nil {
+		t.Errorf("The read must fail once the timeout expires")
+	}
+}
@@ -0,0 +1,141 @@
+package buddy
+
+import (
+	"errors"
+	"net"
+	netCommon "sleepy/network/common"
+	"sleepy/network/common/tag"
+	"sleepy/types"
+)
+
+// Hello is the ed2k hello opening the link, identifying the client by his user hash, and its answer
+type Hello struct {
+	UserHash types.UInt128
+	ClientID uint32
+	TCPPort  uint16
+	Tags     tag.List
+	// Server the client is connected to, zero if none
+	ServerIP   net.IP
+	ServerPort uint16
+}
+
+// Callback is relayed by a buddy to ask the firewalled client to connect to the node wanting the file
+type Callback struct {
+	BuddyID types.UInt128
+	FileID  types.UInt128
+	IP      net.IP
+	TCPPort uint16
+}
+
+// Encode a hello, or its answer if not [request]. Only the requests start with the size of the user hash
+func (hello *Hello) Encode(request bool) ([]byte, error) {
+	if hello.UserHash == nil {
+		return nil, errors.New("nil user hash")
+	}
+
+	writer := netCommon.NewWriter()
+	if request {
+		writer.WriteUInt8(16)
+	}
+	writer.WriteBytes(hello.UserHash.ToBytes())
+	writer.WriteUInt32(hello.ClientID)
+	writer.WriteUInt16(hello.TCPPort)
+	writer.WriteEd2kTags(hello.Tags)
+	// The ed2k IPs are sent in network order
+	serverIP := net.IPv4zero.To4()
+	if hello.ServerIP != nil && hello.ServerIP.To4() != nil {
+		serverIP = hello.ServerIP.To4()
+	}
+	writer.WriteBytes(serverIP)
+	writer.WriteUInt16(hello.ServerPort)
+	return writer.Bytes(), writer.Err()
+}
+
+// DecodeHello decodes a hello, or its answer if not [request]. The server address is missing in some old clients
+func DecodeHello(payload []byte, request bool) (*Hello, error) {
+	reader := netCommon.NewReader(payload)
+	if request {
+		if size, err := reader.ReadUInt8(); err != nil || size != 16 {
+			return nil, errors.New("invalid hello user hash size")
+		}
+	}
+
+	data, err := reader.ReadBytes(16)
+	if err != nil {
+		return nil, errors.New("hello too short")
+	}
+	hello := &Hello{}
+	if hello.UserHash, err = types.NewUInt128FromByteArray(data); err != nil {
+		return nil, err
+	}
+	if hello.ClientID, err = reader.ReadUInt32(); err != nil {
+		return nil, errors.New("hello too short")
+	}
+	if hello.TCPPort, err = reader.ReadUInt16(); err != nil {
+		return nil, errors.New("hello too short")
+	}
+	if hello.Tags, err = reader.ReadEd2kTags(); err != nil {
+		return nil, err
+	}
+
+	if data, err = reader.ReadBytes(4); err == nil {
+		hello.ServerIP = net.IPv4(data[0], data[1], data[2], data[3])
+		hello.ServerPort, _ = reader.ReadUInt16()
+	}
+	return hello, nil
+}
+
+// FirewallCheckUDPRequest asks to send a KADEMLIA2_FIREWALLUDP to the Kad ports of the requester, obfuscated with
+// his [SenderKey]. The [ExternalPort] is zero if unknown
+type FirewallCheckUDPRequest struct {
+	InternalPort uint16
+	ExternalPort uint16
+	SenderKey    uint32
+}
+
+func (callback *Callback) Encode() ([]byte, error) {
+	writer := netCommon.NewWriter()
+	writer.WriteUInt128(callback.BuddyID)
+	writer.WriteUInt128(callback.FileID)
+	writer.WriteIPv4(callback.IP)
+	writer.WriteUInt16(callback.TCPPort)
+	return writer.Bytes(), writer.Err()
+}
+
+func DecodeCallback(payload []byte) (*Callback, error) {
+	if len(payload) != 38 {
+		return nil, errors.New("invalid callback size")
+	}
+
+	reader := netCommon.NewReader(payload)
+	callback := &Callback{}
+	callback.BuddyID, _ = reader.ReadUInt128()
+	callback.FileID, _ = reader.ReadUInt128()
+	callback.IP, _ = reader.ReadIPv4()
+	callback.TCPPort, _ = reader.ReadUInt16()
+	return callback, nil
+}
+
+func (request *FirewallCheckUDPRequest) Encode() ([]byte, error) {
+	writer := netCommon.NewWriter()
+	writer.WriteUInt16(request.InternalPort)
+	writer.WriteUInt16(request.ExternalPort)
+	writer.WriteUInt32(request.SenderKey)
+	return writer.Bytes(), writer.Err()
+}
+
+func DecodeFirewallCheckUDPRequest(payload []byte) (*FirewallCheckUDPRequest, error) {
+	if len(payload) != 8 {
+		return nil, errors.New("invalid UDP firewall check request size")
+	}
+
+	reader := netCommon.NewReader(payload)
+	request := &FirewallCheckUDPRequest{}
+	request.InternalPort, _ = reader.ReadUInt16()
+	request.ExternalPort, _ = reader.ReadUInt16()
+	request.SenderKey, _ = reader.ReadUInt32()
+	if request.InternalPort == 0 {
+		return nil, errors.New("UDP firewall check request without internal port")
+	}
+	return request, nil
+}
@@ -0,0 +1,99 @@
+package buddy
+
+import (
+	"net"
+	"sleepy/network/common/tag"
+	"sleepy/types"
+	"testing"
+)
+
+func TestHello_EncodeDecode(t *testing.T) {
+	hello := &Hello{
+		UserHash:   types.NewUInt128(1, 2),
+		ClientID:   0x1234,
+		TCPPort:    4662,
+		Tags:       tag.List{tag.NewString(tag.ID(0x01), "tester")},
+		ServerIP:   net.IPv4(1, 2, 3, 4),
+		ServerPort: 4661,
+	}
+
+	data, err := hello.Encode(true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	// Only the requests start with the size of the user hash
+	if data[0] != 16 {
+		t.Errorf("The hello must start with the user hash size, got %d", data[0])
+	}
+	decoded, err := DecodeHello(data, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !decoded.UserHash.Equal(hello.UserHash) || decoded.ClientID != 0x1234 || decoded.TCPPort != 4662 ||
+		!decoded.ServerIP.Equal(hello.ServerIP) || decoded.ServerPort != 4661 {
+		t.Errorf("The hello must be decoded as encoded, got %+v", decoded)
+	}
+	if name, _ := decoded.Tags.GetString(0x01); name != "tester" {
+		t.Errorf("The hello tags must be decoded, got %s", name)
+	}
+
+	answer, err := hello.Encode(false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(answer) != len(data)-1 {
+		t.Errorf("The answer must not have the user hash size")
+	}
+	if decoded, err = DecodeHello(answer, false); err != nil || !decoded.UserHash.Equal(hello.UserHash) {
+		t.Errorf("The answer must be decoded as encoded, got %+v %v", decoded, err)
+	}
+
+	if _, err := DecodeHello(data[:16], true); err == nil {
+		t.Errorf("Truncated hellos must be rejected")
+	}
+}
+
+func TestCallback_EncodeDecode(t *testing.T) {
+	callback := &Callback{BuddyID: types.NewUInt128(1, 2), FileID: types.NewUInt128(3, 4), IP: net.IPv4(1, 2, 3, 4), TCPPort: 4662}
+
+	data, err := callback.Encode()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	// The IP is sent as Kad does, a little endian number in host order
+	if data[32] != 4 || data[35] != 1 {
+		t.Errorf("The IP must be sent in host order, got %v", data[32:36])
+	}
+
+	decoded, err := DecodeCallback(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !decoded.BuddyID.Equal(callback.BuddyID) || !decoded.FileID.Equal(callback.FileID) || !decoded.IP.Equal(callback.IP) || decoded.TCPPort != 4662 {
+		t.Errorf("The callback must be decoded as encoded, got %+v", decoded)
+	}
+}
+
+func TestFirewallCheckUDPRequest_EncodeDecode(t *testing.T) {
+	request := &FirewallCheckUDPRequest{InternalPort: 4672, ExternalPort: 5000, SenderKey: 0xCAFE}
+
+	data, err := request.Encode()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(data) != 8 || data[0] != 0x40 || data[1] != 0x12 {
+		t.Errorf("The ports must be sent in little endian, got %v", data)
+	}
+
+	decoded, err := DecodeFirewallCheckUDPRequest(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if *decoded != *request {
+		t.Errorf("The request must be decoded as encoded, got %+v", decoded)
+	}
+
+	if _, err := DecodeFirewallCheckUDPRequest(make([]byte, 8)); err == nil {
+		t.Errorf("The requests without internal port must be rejected")
+	}
+}
@@ -0,0 +1,186 @@
+package kad
+
+import (
+	"context"
+	"net"
+	ed2kCommon "sleepy/network/ed2k/common"
+	kadBuddy "sleepy/network/kad/buddy"
+	"sleepy/network/kad/packet/factory"
+	"sleepy/types"
+	"sleepy/utils/event"
+	"testing"
+	"time"
+)
+
+// Connection reporting a remote address, as the piped ones don't have it
+type addressedConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (conn *addressedConn) RemoteAddr() net.Addr {
+	return conn.remote
+}
+
+// Connect a firewalled client with an open node able to be his buddy
+func newTestBuddies(t *testing.T) (*Client, *Client, *net.UDPAddr) {
+	client, manager := newTestClient()
+	nodeManager := &fakeManager{}
+	node := NewClient(Config{ClientID: types.NewUInt128(0x1111, 0x2222), UdpPort: 4672, TcpPort: 4662}, nodeManager)
+	clientAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 100), Port: 4672}
+	nodeAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672}
+	connectTestClients(client, manager, clientAddr, node, nodeManager, nodeAddr)
+	manager.dial = func(ip net.IP, port uint16) (net.Conn, error) {
+		local, remote := net.Pipe()
+		go node.handleTCP(&addressedConn{Conn: remote, remote: &net.TCPAddr{IP: clientAddr.IP, Port: 50000}})
+		return &addressedConn{Conn: local, remote: &net.TCPAddr{IP: ip, Port: int(port)}}, nil
+	}
+	client.firewall.tcp = FirewallFirewalled
+	node.firewall.tcp = FirewallOpen
+
+	peer := newTestPeer(node.config.ClientID, 1)
+	peer.SetIP(nodeAddr.IP, true)
+	client.router.AddPeer(peer)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.FindBuddy(ctx); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return client, node, nodeAddr
+}
+
+func TestClient_FindBuddy(t *testing.T) {
+	client, node, nodeAddr := newTestBuddies(t)
+	defer client.closeBuddyLinks()
+
+	found, ok := client.GetBuddy()
+	if !ok || !found.IP.Equal(nodeAddr.IP) || found.UDPPort != 4672 || found.TCPPort != 4662 {
+		t.Fatalf("The node must be the buddy, got %+v", found)
+	}
+	if !found.UserHash.Equal(node.config.ClientID) {
+		t.Errorf("The buddy user hash must be known")
+	}
+
+	waitServedLink(t, node)
+}
+
+// Wait until the node is linked with the client it serves
+func waitServedLink(t *testing.T, node *Client) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		node.buddies.access.Lock()
+		served := node.buddies.served
+		linked := served != nil && served.link != nil
+		node.buddies.access.Unlock()
+		if linked {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("The node must be linked with the client")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClient_RelayCallback(t *testing.T) {
+	client, node, _ := newTestBuddies(t)
+	defer client.closeBuddyLinks()
+
+	callbacks := make(chan CallbackEventArgs, 1)
+	client.CallbackEvent().Listen(func(sender interface{}, args event.Args) {
+		callbacks <- args.(CallbackEventArgs)
+	})
+
+	fileID := types.NewUInt128(0xf1, 0xf2)
+	packet, err := factory.GetCallbackRequest(types.Not(client.config.ClientID), fileID, 5555)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	requester := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 50), Port: 4672}
+
+	// The link is opened in background
+	waitServedLink(t, node)
+	if err := node.handleUDP(packet.GetData(), requester); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	select {
+	case callback := <-callbacks:
+		if !callback.FileID.Equal(fileID) || !callback.IP.Equal(requester.IP) || callback.TCPPort != 5555 {
+			t.Errorf("The callback must ask to connect to the requester, got %+v", callback)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("The callback must be relayed to the client")
+	}
+}
+
+func TestClient_AnswerHello(t *testing.T) {
+	client, _ := newTestClient()
+	local, remote := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		client.handleTCP(&addressedConn{Conn: remote, remote: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 50000}})
+		close(done)
+	}()
+
+	link := kadBuddy.NewLink(local)
+	defer link.Close()
+	hello := &kadBuddy.Hello{UserHash: types.NewUInt128(5, 5), TCPPort: 4662}
+	payload, err := hello.Encode(true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	go link.Write(ed2kCommon.ProtEd2kTCP, kadBuddy.OpHello, payload)
+
+	// The hellos of the firewall checks are answered even if we aren't their buddy
+	protocol, opcode, payload, err := link.Read(time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if protocol != ed2kCommon.ProtEd2kTCP || opcode != kadBuddy.OpHelloAnswer {
+		t.Fatalf("The hello must be answered, got %x %x", protocol, opcode)
+	}
+	answer, err := kadBuddy.DecodeHello(payload, false)
+	if err != nil || !answer.UserHash.Equal(client.sourceID()) || answer.TCPPort != client.config.TcpPort {
+		t.Errorf("The answer must identify us, got %+v %v", answer, err)
+	}
+
+	// The TCP firewall checks close the connection once answered, without asking for a UDP check
+	link.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("The connection must be closed, as we aren't its buddy")
+	}
+}
+
+func TestClient_FindBuddyRequestWhileFirewalled(t *testing.T) {
+	client, manager := newTestClient()
+	packet, err := factory.GetFindBuddyRequest(types.NewUInt128(1, 1), types.NewUInt128(2, 2), 4662)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	client.handleUDP(packet.GetData(), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672})
+
+	if len(manager.sentWithCommand(CommKadFindbuddyRes)) != 0 {
+		t.Errorf("Only the nodes with an open TCP port can be buddies")
+	}
+}
+
+func TestClient_SendCallbackRequest(t *testing.T) {
+	client, manager := newTestClient()
+	source := &SourceResult{Type: SourceTypeBuddyV2, BuddyID: types.NewUInt128(1, 1), BuddyIP: net.IPv4(10, 0, 0, 1), BuddyPort: 4672}
+
+	if err := client.SendCallbackRequest(source, types.NewUInt128(2, 2)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	sent := manager.sentWithCommand(CommKadCallbackReq)
+	if len(sent) != 1 || !sent[0].ip.Equal(source.BuddyIP) || sent[0].port != 4672 {
+		t.Errorf("The callback must be sent to the buddy of the source")
+	}
+
+	if err := client.SendCallbackRequest(&SourceResult{Type: SourceTypeOpen}, types.NewUInt128(2, 2)); err == nil {
+		t.Errorf("The open sources have no buddy")
+	}
+}
@@ -4,27 +4,59 @@ import (
 	"context"
 	"encoding/hex"
 	"errors"
-	"fmt"
 	"log"
 	"math/rand"
 	"net"
 	netManager "sleepy/network"
+	"sleepy/network/common/udp"
 	"sleepy/network/ed2k/common"
+	kadBuddy "sleepy/network/kad/buddy"
+	"sleepy/network/kad/flood"
+	"sleepy/network/kad/index"
+	"sleepy/network/kad/obfuscation"
+	kadPacket "sleepy/network/kad/packet"
 	"sleepy/network/kad/router"
-	"sleepy/network/kad/types"
-	types2 "sleepy/types"
-	"strconv"
+	"sleepy/utils/event"
+	"sync"
 	"time"
 )
 
 type Client struct {
 	config     Config
 	router     router.Router
+	index      *index.Index
 	network    netManager.Manager
 	clientAddr *net.UDPAddr
-	clientConn *net.UDPConn
-	serverAddr *net.UDPAddr
-	serverConn *net.UDPConn
+
+	replies replyTracker
+	flood   *flood.Tracker
+
+	lookups       map[string][]*lookup // Several lookups may run for a target, as the source and notes ones of a file
+	lookupsAccess sync.Mutex
+
+	searches       map[string]*search
+	searchesAccess sync.Mutex
+
+	sharedFiles      map[string]*sharedFile
+	keywords         map[string]*publishedKeyword
+	runningPublishes int
+	publisherAccess  sync.Mutex
+
+	publishes       map[string]*publish
+	publishesAccess sync.Mutex
+
+	externalPort externalPortState
+	firewall     firewallState
+
+	buddies       buddyState
+	callbackEvent *event.Emitter
+
+	// Done when the client stops, ending the running tasks
+	ctx         context.Context
+	cancel      context.CancelFunc
+	tasks       sync.WaitGroup
+	tasksAccess sync.Mutex
+	stopOnce    sync.Once
 }
 
 func NewClient(config Config, network netManager.Manager) *Client {
@@ -32,77 +64,198 @@ func NewClient(config Config, network netManager.Manager) *Client {
 	client.config = config
 	client.network = network
 	client.router = router.NewRouter(config.ClientID)
+	client.router.SetIPFilter(network.IPFilter())
+	client.replies.expected = make(map[string][]*ExpectedReply)
+	client.flood = flood.NewTracker(packetLimits)
+	client.lookups = make(map[string][]*lookup)
+	client.searches = make(map[string]*search)
+	client.sharedFiles = make(map[string]*sharedFile)
+	client.keywords = make(map[string]*publishedKeyword)
+	client.publishes = make(map[string]*publish)
+	client.ctx, client.cancel = context.WithCancel(context.Background())
+	client.callbackEvent = event.NewEvent()
+	if client.config.UDPKeySecret == 0 {
+		client.config.UDPKeySecret = rand.Uint32()
+	}
 
-	// Add fake nodes to client
-	p1 := types.NewPeer(types2.NewUInt128(rand.Uint64(), rand.Uint64()))
-	p1.SetIP(net.ParseIP("90.80.70.60"), false)
-	p1.SetUDPPort(4662)
-	p1.SetTCPPort(4661)
-	p1.SetProtocolVersion(8)
-	client.router.AddPeer(p1)
-
-	p2 := types.NewPeer(types2.NewUInt128(rand.Uint64(), rand.Uint64()))
-	p2.SetIP(net.ParseIP("190.180.170.160"), false)
-	p2.SetUDPPort(4662)
-	p2.SetTCPPort(4661)
-	p2.SetProtocolVersion(7)
-	client.router.AddPeer(p2)
+	if config.NodesFile != "" {
+		err := client.loadNodesFile(config.NodesFile)
+		if err != nil {
+			log.Printf("Nodes file %s not loaded: %s", config.NodesFile, err)
+		}
+	}
+
+	client.index = index.NewIndex()
+	if config.IndexFile != "" {
+		loaded, err := index.LoadIndexFromFile(config.IndexFile)
+		if err != nil {
+			log.Printf("Index file %s not loaded: %s", config.IndexFile, err)
+		} else {
+			client.index = loaded
+		}
+	}
+
+	client.router.PeerLookupRequestEvent().Listen(func(sender interface{}, args event.Args) {
+		if lookupArgs, ok := args.(router.PeerIdEventArgs); ok {
+			client.spawn(func() { client.backgroundLookup(lookupArgs.Id) })
+		}
+	})
 
 	return client
 }
 
-func (client *Client) Start() error {
-	serverAddr, err := net.ResolveUDPAddr("udp", ":"+strconv.Itoa(int(client.config.UdpPort)))
-	if err != nil {
-		return err
+// Start handles the Kad datagrams received by the network manager, that must be started apart. The client runs
+// until Stop is called or the [ctx] is done
+func (client *Client) Start(ctx context.Context) error {
+	if client.ctx.Err() != nil {
+		return errors.New("the client is stopped")
 	}
 
-	serverConn, err := net.ListenUDP("udp", serverAddr)
-	if err != nil {
-		return err
-	}
+	client.network.HandleUDP(common.ProtKadUDP, client.receiveUDP)
+	client.network.HandleUDP(common.ProtKadUDPCompress, client.receiveUDP)
+	// Obfuscated datagrams have a random first byte
+	client.network.HandleUnknownUDP(client.receiveUDP)
+	// The TCP port answers the ed2k hellos, as the firewall checks, and links the firewalled clients using us as buddy
+	client.network.HandleEd2kTCP(kadBuddy.OpHello, client.receiveTCP)
 
-	client.serverAddr = serverAddr
-	client.serverConn = serverConn
+	client.spawn(client.runPublisher)
+	client.spawn(client.runIndexCleaner)
+	client.spawn(client.runBuddyKeeper)
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Stop()
+		case <-client.ctx.Done():
+		}
+	}()
 
-	go client.listenUDP()
+	if len(client.config.BootstrapAddrs) > 0 && client.router.CountPeers() == 0 {
+		return client.Bootstrap(client.config.BootstrapAddrs)
+	}
 	return nil
 }
 
+// Stop cancels the running tasks and handlers, waits until all of them have finished and saves the nodes and index
+// files. Calling it again does nothing
 func (client *Client) Stop() {
-	client.serverConn.SetDeadline(time.Now())
-	client.clientConn.Close()
+	client.stopOnce.Do(client.stop)
 }
 
-func (client *Client) listenUDP() {
-	defer client.serverConn.Close()
+func (client *Client) stop() {
+	client.network.HandleUDP(common.ProtKadUDP, nil)
+	client.network.HandleUDP(common.ProtKadUDPCompress, nil)
+	client.network.HandleUnknownUDP(nil)
+	client.network.HandleEd2kTCP(kadBuddy.OpHello, nil)
 
-	buf := make([]byte, 8192)
+	// No task is started once the context is cancelled
+	client.tasksAccess.Lock()
+	client.cancel()
+	client.tasksAccess.Unlock()
 
-	for {
-		n, addr, err := client.serverConn.ReadFromUDP(buf)
+	client.cancelReplies()
+	client.closeBuddyLinks()
+	client.tasks.Wait()
+	client.router.Stop()
 
+	if client.config.NodesFile != "" {
+		err := client.router.SaveFile(client.config.NodesFile)
 		if err != nil {
-			fmt.Println(err)
-		} else {
-			go func() {
-				err := client.handleUDP(buf[0:n], addr)
-				if err != nil {
-					log.Printf("Datagram handle error: %s", err)
-				}
-			}()
+			log.Printf("Nodes file %s not saved: %s", client.config.NodesFile, err)
+		}
+	}
+
+	if client.config.IndexFile != "" {
+		err := client.index.SaveFile(client.config.IndexFile)
+		if err != nil {
+			log.Printf("Index file %s not saved: %s", client.config.IndexFile, err)
+		}
+	}
+}
+
+// Register a task waited by Stop, failing if the client is already stopped. The task must call tasks.Done
+func (client *Client) beginTask() bool {
+	client.tasksAccess.Lock()
+	defer client.tasksAccess.Unlock()
+	if client.ctx.Err() != nil {
+		return false
+	}
+	client.tasks.Add(1)
+	return true
+}
+
+// Run [task] in background, unless the client is stopped
+func (client *Client) spawn(task func()) bool {
+	if !client.beginTask() {
+		return false
+	}
+	go func() {
+		defer client.tasks.Done()
+		task()
+	}()
+	return true
+}
+
+// Derive from [ctx] a context that is also done when the client stops
+func (client *Client) withClient(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-client.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Handle a datagram received by the network manager as a task
+func (client *Client) receiveUDP(data []byte, from *net.UDPAddr) error {
+	if !client.beginTask() {
+		return errors.New("the client is stopped")
+	}
+	defer client.tasks.Done()
+	return client.handleUDP(data, from)
+}
+
+// Handle a connection accepted by the network manager as a task
+func (client *Client) receiveTCP(conn net.Conn) {
+	if !client.beginTask() {
+		conn.Close()
+		return
+	}
+	defer client.tasks.Done()
+	client.handleTCP(conn)
+}
+
+// Add the contacts of a nodes.dat file to the router. The contacts of a bootstrap only file are used as seeds instead
+func (client *Client) loadNodesFile(path string) error {
+	nodes, err := router.ReadNodesFile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, peer := range nodes.Peers {
+		if nodes.BootstrapOnly {
+			seed := &net.UDPAddr{IP: peer.GetIP(), Port: int(peer.GetUDPPort())}
+			client.config.BootstrapAddrs = append(client.config.BootstrapAddrs, seed)
+		} else if err := client.router.AddPeer(peer); err != nil {
+			log.Printf("Contact %s not loaded: %s", peer.GetID().ToHexString(), err)
 		}
 	}
+
+	log.Printf("Loaded %d contacts from %s", len(nodes.Peers), path)
+	return nil
 }
 
 func (client *Client) handleUDP(data []byte, from *net.UDPAddr) error {
 	if from.Port == 53 {
 		return errors.New("dropping incoming ping from port 53. Possible DNS attack")
 	}
+	if client.flood.IsBanned(from.IP) {
+		return errors.New("dropping datagram from banned " + from.IP.String())
+	}
 
-	fmt.Println("Received ", hex.EncodeToString(data), "\n\ttext", string(data), " from ", from)
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	ctx, cancel := context.WithTimeout(client.ctx, time.Second*5)
 	defer cancel()
 
 	ctx = context.WithValue(ctx, "Protocol", "UDP")
@@ -112,13 +265,24 @@ func (client *Client) handleUDP(data []byte, from *net.UDPAddr) error {
 	request := &UDPRequest{
 		from: from,
 		Request: Request{
-			body: Reader{data: data, offset: 0},
+			body: *NewReader(data),
 			ctx:  ctx,
 		},
 	}
 
+	if obfuscation.IsObfuscated(data) {
+		localKey := obfuscation.VerifyKey(client.config.UDPKeySecret, from.IP)
+		datagram, err := obfuscation.Decrypt(data, client.config.ClientID, localKey)
+		if err != nil {
+			return err
+		}
+		request.body = *NewReader(datagram.Data)
+		request.obfuscated = true
+		request.senderKey = datagram.SenderKey
+		request.validReceiverKey = datagram.ReceiverKey == localKey
+	}
+
 	protocolCode, err := request.body.ReadByte()
-	fmt.Printf("Protocol: %s, error: %s", hex.EncodeToString([]byte{protocolCode}), err)
 	if err != nil {
 		return errors.New("datagram read error")
 	}
@@ -126,7 +290,7 @@ func (client *Client) handleUDP(data []byte, from *net.UDPAddr) error {
 	switch protocolCode {
 	case common.ProtKadUDPCompress:
 		log.Println("Compressed KAD datagram. Trying decompression...")
-		return client.decompressKad(data, from)
+		return client.decompressKad(request)
 	case common.ProtKadUDP:
 		log.Println("Handling valid Kad UDP packet...")
 		return client.handleKadDatagram(request)
@@ -135,8 +299,39 @@ func (client *Client) handleUDP(data []byte, from *net.UDPAddr) error {
 	}
 }
 
-func (client *Client) decompressKad(data []byte, from *net.UDPAddr) error {
-	return errors.New("decompress kad not implemented yet")
+// Inflate a compressed datagram and handle it as a plain one
+func (client *Client) decompressKad(request *UDPRequest) error {
+	data, err := kadPacket.Decompress(request.body.Bytes())
+	if err != nil {
+		return err
+	}
+
+	request.body = *NewReader(data)
+	if err = request.body.Discard(1); err != nil {
+		return err
+	}
+	return client.handleKadDatagram(request)
+}
+
+// Send a packet, compressing it if that reduces his size and obfuscating it if there are keys
+func (client *Client) sendPacket(ip net.IP, port uint16, packet *kadPacket.Packet, keys obfuscationKeys) error {
+	if _, err := packet.Compress(); err != nil {
+		return err
+	}
+	if !keys.enabled() {
+		return client.network.SendUDP(ip, port, packet)
+	}
+
+	senderKey := obfuscation.VerifyKey(client.config.UDPKeySecret, ip)
+	encrypted, err := obfuscation.Encrypt(packet.GetData(), keys.targetID, keys.receiverKey, senderKey)
+	if err != nil {
+		return err
+	}
+	obfuscated := udp.NewRawPacket()
+	if err := obfuscated.AppendBytes(encrypted); err != nil {
+		return err
+	}
+	return client.network.SendUDP(ip, port, obfuscated)
 }
 
 func (client *Client) handleKadDatagram(request *UDPRequest) error {
@@ -146,15 +341,82 @@ func (client *Client) handleKadDatagram(request *UDPRequest) error {
 		return errors.New("datagram read error")
 	}
 
+	if !client.flood.Allow(request.from.IP, command) {
+		return errors.New("dropping flood of " + hex.EncodeToString([]byte{command}) + " from " + request.from.IP.String())
+	}
+	if isKad1Command(command) && !client.config.Kad1 {
+		return errors.New("ignoring Kad1 command, the Kad1 compatibility is disabled")
+	}
+
 	response := Response{}
 
 	switch command {
+	case CommKadBootstrapReq:
+		HandleBootstrap1Request(client, request, response)
+		return nil
+	case CommKadHelloReq:
+		HandleHello1Request(client, request, response)
+		return nil
+	case CommKadHelloRes:
+		HandleHello1Response(client, request, response)
+		return nil
+	case CommKadReq:
+		HandleKad1Request(client, request, response)
+		return nil
+	case CommKadRes:
+		HandleKad1Response(client, request, response)
+		return nil
+	case CommKadSearchReq:
+		HandleSearch1Request(client, request, response)
+		return nil
+	case CommKadSearchNotesReq:
+		HandleSearchNotes1Request(client, request, response)
+		return nil
+	case CommKadPublishReq:
+		HandlePublish1Request(client, request, response)
+		return nil
+	case CommKadPublishNotesReq:
+		HandlePublishNotes1Request(client, request, response)
+		return nil
+	case CommKadFirewalledReq:
+		HandleFirewalled1Request(client, request, response)
+		return nil
 	case CommKad2BootstrapReq:
 		HandleBootstrapRequest(client, request)
 		return nil
 	case CommKad2BootstrapRes:
 		HandleBootstrapResponse(client, request, response)
 		return nil
+	case CommKad2Req:
+		HandleKad2Request(client, request, response)
+		return nil
+	case CommKad2Res:
+		HandleKad2Response(client, request, response)
+		return nil
+	case CommKad2SearchRes:
+		HandleSearchResponse(client, request, response)
+		return nil
+	case CommKad2PublishRes:
+		HandlePublishResponse(client, request, response)
+		return nil
+	case CommKad2SearchKeyReq:
+		HandleSearchKeyRequest(client, request, response)
+		return nil
+	case CommKad2SearchSourceReq:
+		HandleSearchSourceRequest(client, request, response)
+		return nil
+	case CommKad2SearchNotesReq:
+		HandleSearchNotesRequest(client, request, response)
+		return nil
+	case CommKad2PublichKeyReq:
+		HandlePublishKeyRequest(client, request, response)
+		return nil
+	case CommKad2PublishSourceReq:
+		HandlePublishSourceRequest(client, request, response)
+		return nil
+	case CommKad2PublishNotesReq:
+		HandlePublishNotesRequest(client, request, response)
+		return nil
 	case CommKad2HelloReq:
 		HandleHelloRequest(client, request, response)
 		return nil
@@ -167,6 +429,24 @@ func (client *Client) handleKadDatagram(request *UDPRequest) error {
 	case CommKadFirewalled2Req:
 		HandleFirewallRequest(client, request, response)
 		return nil
+	case CommKadFirewalledRes:
+		HandleFirewalledResponse(client, request, response)
+		return nil
+	case CommKadFirewalledAckRes:
+		HandleFirewalledAckResponse(client, request, response)
+		return nil
+	case CommKad2FirewallUDP:
+		HandleFirewallUDP(client, request, response)
+		return nil
+	case CommKadFindbuddyReq:
+		HandleFindBuddyRequest(client, request, response)
+		return nil
+	case CommKadFindbuddyRes:
+		HandleFindBuddyResponse(client, request, response)
+		return nil
+	case CommKadCallbackReq:
+		HandleCallbackRequest(client, request, response)
+		return nil
 	case CommKad2Ping:
 		HandlePingRequest(client, request, response)
 		return nil
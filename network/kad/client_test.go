@@ -0,0 +1,294 @@
+package kad
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sleepy/network"
+	"sleepy/network/common/udp"
+	"sleepy/network/ipfilter"
+	"sleepy/network/kad/message"
+	"sleepy/network/kad/packet/factory"
+	kadTypes "sleepy/network/kad/types"
+	"sleepy/types"
+	"sync"
+	"testing"
+	"time"
+)
+
+type sentPacket struct {
+	ip   net.IP
+	port uint16
+	data []byte
+}
+
+// Network manager that records the sent packets instead of sending them
+type fakeManager struct {
+	sent   []sentPacket
+	access sync.Mutex
+	// Called after each sent packet, to simulate the remote nodes
+	onSend func(packet sentPacket)
+	// Error returned by the UDP sends, they succeed if nil
+	sendErr error
+	// Whether the TCP connections succeed
+	tcpReachable bool
+	// Opens the TCP connections instead, if set
+	dial func(ip net.IP, port uint16) (net.Conn, error)
+	// Created empty on the first use
+	filter *ipfilter.Filter
+}
+
+// The fake manager has no sockets, the tests call the client handlers directly
+func (m *fakeManager) Start() error                                          { return nil }
+func (m *fakeManager) Stop()                                                 {}
+func (m *fakeManager) UDPPort() uint16                                       { return 4672 }
+func (m *fakeManager) TCPPort() uint16                                       { return 4662 }
+func (m *fakeManager) HandleUDP(protocol byte, handler network.UDPHandler)   {}
+func (m *fakeManager) HandleUnknownUDP(handler network.UDPHandler)           {}
+func (m *fakeManager) HandleTCP(protocol byte, handler network.TCPHandler)   {}
+func (m *fakeManager) HandleEd2kTCP(opcode byte, handler network.TCPHandler) {}
+
+func (m *fakeManager) IPFilter() *ipfilter.Filter {
+	m.access.Lock()
+	defer m.access.Unlock()
+	if m.filter == nil {
+		m.filter = ipfilter.NewFilter(ipfilter.DefaultLevel)
+	}
+	return m.filter
+}
+
+func (m *fakeManager) SendUDP(ip net.IP, port uint16, packet udp.Packet) error {
+	data := make([]byte, len(packet.GetData()))
+	copy(data, packet.GetData())
+	sent := sentPacket{ip: ip, port: port, data: data}
+
+	m.access.Lock()
+	if m.sendErr != nil {
+		m.access.Unlock()
+		return m.sendErr
+	}
+	m.sent = append(m.sent, sent)
+	onSend := m.onSend
+	m.access.Unlock()
+
+	if onSend != nil {
+		onSend(sent)
+	}
+	return nil
+}
+
+func (m *fakeManager) DialTCP(ip net.IP, port uint16, timeout time.Duration) (net.Conn, error) {
+	if m.dial != nil {
+		return m.dial(ip, port)
+	}
+	if !m.tcpReachable {
+		return nil, errors.New("connection refused")
+	}
+	local, remote := net.Pipe()
+	remote.Close()
+	return local, nil
+}
+
+func (m *fakeManager) sentWithCommand(command byte) []sentPacket {
+	m.access.Lock()
+	defer m.access.Unlock()
+	packets := make([]sentPacket, 0)
+	for _, packet := range m.sent {
+		if len(packet.data) > 1 && packet.data[1] == command {
+			packets = append(packets, packet)
+		}
+	}
+	return packets
+}
+
+func newTestClient() (*Client, *fakeManager) {
+	manager := &fakeManager{}
+	client := NewClient(Config{
+		ClientID: types.NewUInt128(0x0123456789abcdef, 0xfedcba9876543210),
+		UdpPort:  4672,
+		TcpPort:  4662,
+	}, manager)
+	return client, manager
+}
+
+func TestClient_Bootstrap(t *testing.T) {
+	client, manager := newTestClient()
+	seed := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672}
+
+	if err := client.Bootstrap([]*net.UDPAddr{seed}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(manager.sentWithCommand(CommKad2BootstrapReq)) != 1 {
+		t.Fatalf("A bootstrap request must be sent to the seed")
+	}
+
+	contacts := make([]kadTypes.Peer, 0)
+	for i := 1; i <= 3; i++ {
+		peer := kadTypes.NewPeer(types.NewUInt128(uint64(i), uint64(i)<<32))
+		peer.SetIP(net.IPv4(10, 0, 1, byte(i)), false)
+		peer.SetUDPPort(4672)
+		peer.SetTCPPort(4662)
+		peer.SetProtocolVersion(8)
+		contacts = append(contacts, peer)
+	}
+	seedId := types.NewUInt128(0xaa, 0xbb)
+	packet, err := factory.GetBootstrap2Response(seedId, 4662, 8, contacts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if err := client.handleUDP(packet.GetData(), seed); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if client.router.CountPeers() != 4 {
+		t.Errorf("The router must contain the seed and his 3 contacts, %d found", client.router.CountPeers())
+	}
+	seedPeer, err := client.router.GetPeer(seedId)
+	if err != nil || !seedPeer.IsIPVerified() {
+		t.Errorf("The seed must be added as verified")
+	}
+	if len(manager.sentWithCommand(CommKad2HelloReq)) != 3 {
+		t.Errorf("A hello must be sent to each received contact")
+	}
+}
+
+func TestClient_BootstrapRequest(t *testing.T) {
+	client, manager := newTestClient()
+	requester := &net.UDPAddr{IP: net.IPv4(10, 0, 2, 1), Port: 4672}
+	for i := byte(1); i <= 3; i++ {
+		client.router.AddPeer(newTestPeer(types.NewUInt128(uint64(i), uint64(i)<<32), i))
+	}
+
+	if err := client.handleUDP(factory.GetBootstrap2Request().GetData(), requester); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	sent := manager.sentWithCommand(CommKad2BootstrapRes)
+	if len(sent) != 1 {
+		t.Fatalf("The bootstrap request must be answered")
+	}
+	var response message.BootstrapResponse
+	if err := response.Decode(sent[0].data[2:]); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(response.Contacts) != 2 {
+		t.Fatalf("The other 2 contacts must be sent, got %d", len(response.Contacts))
+	}
+	for _, contact := range response.Contacts {
+		if contact.IP.Equal(requester.IP) {
+			t.Errorf("The requester must not be sent his own contact")
+		}
+	}
+}
+
+func TestClient_UnrequestedBootstrapResponse(t *testing.T) {
+	client, _ := newTestClient()
+	packet, err := factory.GetBootstrap2Response(types.NewUInt128(0xaa, 0xbb), 4662, 8, []kadTypes.Peer{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	client.handleUDP(packet.GetData(), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672})
+
+	if client.router.CountPeers() != 0 {
+		t.Errorf("Unrequested bootstrap responses must be ignored")
+	}
+}
+
+func TestClient_CompressedDatagram(t *testing.T) {
+	client, _ := newTestClient()
+	seed := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672}
+	client.expectReply(seed.IP, CommKad2BootstrapRes)
+
+	contacts := make([]kadTypes.Peer, 0)
+	for i := 1; i <= 10; i++ {
+		peer := kadTypes.NewPeer(types.NewUInt128(uint64(i), uint64(i)<<32))
+		peer.SetIP(net.IPv4(10, 0, 1, byte(i)), false)
+		peer.SetUDPPort(4672)
+		contacts = append(contacts, peer)
+	}
+	packet, _ := factory.GetBootstrap2Response(types.NewUInt128(0xaa, 0xbb), 4662, 8, contacts)
+	if compressed, err := packet.Compress(); err != nil || !compressed {
+		t.Fatalf("The bootstrap response must be compressed: %v", err)
+	}
+
+	if err := client.handleUDP(packet.GetData(), seed); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if client.router.CountPeers() != 11 {
+		t.Errorf("The compressed response must be handled, %d peers found", client.router.CountPeers())
+	}
+}
+
+func TestClient_FloodedRequests(t *testing.T) {
+	client, manager := newTestClient()
+	from := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672}
+	hello, err := factory.GetHello2Request(client.helloDetails(false))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		client.handleUDP(hello.GetData(), from)
+	}
+
+	answered := len(manager.sentWithCommand(CommKad2HelloRes))
+	if answered == 0 || answered >= 20 {
+		t.Errorf("Only the hellos under the flood limit must be answered, %d answered", answered)
+	}
+	if err := client.handleUDP(hello.GetData(), from); err == nil {
+		t.Errorf("The datagrams of a flooder must be dropped")
+	}
+}
+
+func TestClient_FilteredContacts(t *testing.T) {
+	client, manager := newTestClient()
+	filtered := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672}
+	manager.IPFilter().Add(net.IPv4(10, 0, 0, 0), net.IPv4(10, 0, 0, 255), 0, "Test range")
+
+	if err := client.addContact(types.NewUInt128(1, 1), filtered.IP, 4672, 4662, 8, true, true); err == nil {
+		t.Errorf("The filtered contacts must not be added to the router")
+	}
+	if err := client.addContact(types.NewUInt128(2, 2), net.IPv4(10, 0, 1, 1), 4672, 4662, 8, true, true); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if client.router.CountPeers() != 1 {
+		t.Errorf("Only the not filtered contact must be in the router, %d found", client.router.CountPeers())
+	}
+}
+
+func TestClient_StopWithContext(t *testing.T) {
+	nodesFile := filepath.Join(t.TempDir(), "nodes.dat")
+	client := NewClient(Config{ClientID: types.NewUInt128(1, 2), UdpPort: 4672, NodesFile: nodesFile}, &fakeManager{})
+	client.router.AddPeer(newTestPeer(types.NewUInt128(3, 4), 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := client.Start(ctx); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	pinged := make(chan error, 1)
+	go func() {
+		pinged <- client.Ping(context.Background(), contactFromPeer(newTestPeer(types.NewUInt128(3, 4), 1)))
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-pinged:
+		if err != ErrClientStopped {
+			t.Errorf("The pending ping must fail as the client stopped, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("The pending ping must be cancelled when the client stops")
+	}
+
+	client.Stop()
+	if _, err := os.Stat(nodesFile); err != nil {
+		t.Errorf("The nodes file must be saved when the client stops: %s", err)
+	}
+	if err := client.receiveUDP([]byte{0xe4, CommKad2Ping}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672}); err == nil {
+		t.Errorf("The stopped client must not handle datagrams")
+	}
+}
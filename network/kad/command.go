@@ -14,6 +14,7 @@ const (
 	CommKadFirewalledReq        = 0x50
 	CommKadFirewalled2Req       = 0x53
 	CommKadFirewalledRes        = 0x58
+	CommKadFirewalledAckRes     = 0x59
 
 	CommKadCallbackReq          = 0x52
 
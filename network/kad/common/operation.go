@@ -7,4 +7,47 @@ import (
 const (
 	OperationBootstrapRequest  ed2kCommon.Operation = 0x00
 	OperationBootstrapResponse ed2kCommon.Operation = 0x08
+
+	OperationHelloRequest  ed2kCommon.Operation = 0x10
+	OperationHelloResponse ed2kCommon.Operation = 0x18
+
+	OperationKadRequest  ed2kCommon.Operation = 0x20
+	OperationKadResponse ed2kCommon.Operation = 0x28
+
+	OperationSearchResponse       ed2kCommon.Operation = 0x38
+	OperationSearchNotesResponse  ed2kCommon.Operation = 0x3A
+	OperationPublishResponse      ed2kCommon.Operation = 0x48
+	OperationPublishNotesResponse ed2kCommon.Operation = 0x4A
+
+	OperationBootstrap2Request  ed2kCommon.Operation = 0x01
+	OperationBootstrap2Response ed2kCommon.Operation = 0x09
+
+	OperationKad2Request  ed2kCommon.Operation = 0x21
+	OperationKad2Response ed2kCommon.Operation = 0x29
+
+	OperationSearchKey2Request    ed2kCommon.Operation = 0x33
+	OperationSearchSource2Request ed2kCommon.Operation = 0x34
+	OperationSearchNotes2Request  ed2kCommon.Operation = 0x35
+	OperationSearch2Response      ed2kCommon.Operation = 0x3B
+
+	OperationPublishKey2Request    ed2kCommon.Operation = 0x43
+	OperationPublishSource2Request ed2kCommon.Operation = 0x44
+	OperationPublishNotes2Request  ed2kCommon.Operation = 0x45
+	OperationPublish2Response      ed2kCommon.Operation = 0x4B
+
+	OperationHello2Request     ed2kCommon.Operation = 0x11
+	OperationHello2Response    ed2kCommon.Operation = 0x19
+	OperationHello2ResponseAck ed2kCommon.Operation = 0x22
+
+	OperationFindBuddyRequest  ed2kCommon.Operation = 0x51
+	OperationCallbackRequest   ed2kCommon.Operation = 0x52
+	OperationFindBuddyResponse ed2kCommon.Operation = 0x5A
+
+	OperationFirewalled2Request    ed2kCommon.Operation = 0x53
+	OperationFirewalledResponse    ed2kCommon.Operation = 0x58
+	OperationFirewalledAckResponse ed2kCommon.Operation = 0x59
+	OperationFirewallUDP2          ed2kCommon.Operation = 0x62
+
+	OperationPing2Request  ed2kCommon.Operation = 0x60
+	OperationPong2Response ed2kCommon.Operation = 0x61
 )
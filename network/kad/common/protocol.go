@@ -6,3 +6,6 @@ const (
 	ProtocolKadUDP           ed2kCommon.Protocol = 0xE4
 	ProtocolKadCompressedUDP ed2kCommon.Protocol = 0xE5
 )
+
+// KadVersion is the Kad version implemented by this client, announced in the hello packets
+const KadVersion = ed2kCommon.ProtocolVersion8
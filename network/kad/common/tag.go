@@ -0,0 +1,30 @@
+package common
+
+const (
+	TagFileName        = 0x01
+	TagFileSize        = 0x02
+	TagFileType        = 0x03
+	TagFileFormat      = 0x04
+	TagDescription     = 0x0B
+	TagSources         = 0x15
+	TagCompleteSources = 0x30
+	TagPublishInfo     = 0x33
+	TagKadMiscOptions  = 0xF2
+	TagEncryption      = 0xF3
+	TagFileRating      = 0xF7
+	TagBuddyHash       = 0xF8
+	TagClientLowID     = 0xF9
+	TagServerPort      = 0xFA
+	TagServerIP        = 0xFB
+	TagSourceUDPPort   = 0xFC
+	TagSourcePort      = 0xFD
+	TagSourceIP        = 0xFE
+	TagSourceType      = 0xFF
+)
+
+// Flags of the TagKadMiscOptions tag sent in the Kad2 hello packets
+const (
+	MiscOptionUDPFirewalled = 0x01
+	MiscOptionTCPFirewalled = 0x02
+	MiscOptionRequestAck    = 0x04
+)
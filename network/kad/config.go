@@ -1,8 +1,26 @@
 package kad
 
-import "sleepy/types"
+import (
+	"net"
+	"sleepy/types"
+)
 
 type Config struct {
 	ClientID types.UInt128
+	// UserHash identifies the client as a source of his shared files, the client ID is used if not set
+	UserHash types.UInt128
 	UdpPort  uint16
+	TcpPort  uint16
+	// Addresses of known nodes used to join the network when the router is empty
+	BootstrapAddrs []*net.UDPAddr
+	// Path of the nodes.dat file where the router contacts are loaded from and saved to
+	NodesFile string
+	// Obfuscation enables sending obfuscated packets to the contacts supporting it
+	Obfuscation bool
+	// UDPKeySecret derives the verify keys given to the other nodes to obfuscate their packets, random if zero
+	UDPKeySecret uint32
+	// Path of the file where the entries published by other nodes are persisted, not persisted if empty
+	IndexFile string
+	// Kad1 enables answering the legacy Kad1 nodes and using them in the lookups, otherwise their packets are ignored
+	Kad1 bool
 }
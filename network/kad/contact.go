@@ -0,0 +1,41 @@
+package kad
+
+import (
+	"errors"
+	"net"
+	kadTypes "sleepy/network/kad/types"
+	"sleepy/types"
+)
+
+// Insert a contact in the router. If it's already known, it's only updated when [update] is set, as when the
+// contact itself has sent the information and not a third party
+func (client *Client) addContact(id types.UInt128, ip net.IP, udpPort uint16, tcpPort uint16, version uint8, verified bool, update bool) error {
+	if ip.To4() == nil || ip.IsUnspecified() || udpPort == 0 {
+		return errors.New("invalid contact address")
+	}
+
+	existing, err := client.router.GetPeer(id)
+	if err == nil && existing != nil {
+		if !update {
+			return nil
+		}
+		if !existing.GetIP().Equal(ip) && existing.IsIPVerified() && !verified {
+			return errors.New("ignoring unverified IP change of a verified contact")
+		}
+		existing.SetIP(ip, verified || (existing.IsIPVerified() && existing.GetIP().Equal(ip)))
+		existing.SetUDPPort(udpPort)
+		existing.SetTCPPort(tcpPort)
+		existing.SetProtocolVersion(version)
+		return client.router.SetPeerAlive(id)
+	}
+
+	peer := kadTypes.NewPeer(id)
+	peer.SetIP(ip, verified)
+	peer.SetUDPPort(udpPort)
+	peer.SetTCPPort(tcpPort)
+	peer.SetProtocolVersion(version)
+	if update {
+		peer.UpdateType()
+	}
+	return client.router.AddPeer(peer)
+}
@@ -0,0 +1,313 @@
+package kad
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	ed2kCommon "sleepy/network/ed2k/common"
+	kadBuddy "sleepy/network/kad/buddy"
+	"sleepy/network/kad/obfuscation"
+	"sleepy/network/kad/packet/factory"
+	kadTypes "sleepy/network/kad/types"
+	"sync"
+	"time"
+)
+
+// FirewallStatus tells if the other nodes are able to connect to one of our ports
+type FirewallStatus uint8
+
+const (
+	FirewallUnknown FirewallStatus = iota
+	FirewallOpen
+	FirewallFirewalled
+)
+
+func (status FirewallStatus) String() string {
+	switch status {
+	case FirewallOpen:
+		return "open"
+	case FirewallFirewalled:
+		return "firewalled"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	firewallChecks         = 4 // Number of contacts asked to check our ports, as the eMule KADEMLIAFIREWALLCHECKS
+	firewallAnswersNeeded  = 2 // Number of contacts that must answer without reaching us to assume a port is firewalled
+	firewallCheckTimeout   = 30 * time.Second
+	firewallConnectTimeout = 10 * time.Second // Time given to a TCP connection back to a requester of a check
+)
+
+// Answers of a contact asked to check our ports
+type firewallAnswer struct {
+	answered   bool // The contact answered the request
+	tcpOpen    bool // The contact connected to our TCP port
+	udpChecked bool // The contact got our request to reach the UDP port
+	udpOpen    bool // The contact reached our UDP port
+}
+
+type firewallState struct {
+	tcp      FirewallStatus
+	udp      FirewallStatus
+	publicIP net.IP
+	// Answers of the running check by contact IP, nil if no check is running
+	answers map[string]*firewallAnswer
+	updated chan struct{}
+	access  sync.Mutex
+}
+
+// TCPFirewallStatus tells if the other nodes are able to connect to our TCP port, as found by the last check
+func (client *Client) TCPFirewallStatus() FirewallStatus {
+	client.firewall.access.Lock()
+	defer client.firewall.access.Unlock()
+	return client.firewall.tcp
+}
+
+// UDPFirewallStatus tells if the other nodes are able to send unrequested packets to our UDP port, as found by the
+// last check
+func (client *Client) UDPFirewallStatus() FirewallStatus {
+	client.firewall.access.Lock()
+	defer client.firewall.access.Unlock()
+	return client.firewall.udp
+}
+
+// PublicIP gets our IP as seen by the last contact answering a firewall check, nil if unknown
+func (client *Client) PublicIP() net.IP {
+	client.firewall.access.Lock()
+	defer client.firewall.access.Unlock()
+	return client.firewall.publicIP
+}
+
+// CheckFirewall asks some contacts to connect to our ports and updates the firewall status with the results. The TCP
+// port is checked through Kad and the UDP port through the ed2k TCP port of the contacts, as eMule does. It waits
+// until all of them reached both ports or the [ctx] is done, as the firewalled ports are never reached
+func (client *Client) CheckFirewall(ctx context.Context) error {
+	candidates := kadTypes.Filter(client.router.GetClosestPeers(client.config.ClientID, 50), func(peer kadTypes.Peer) bool {
+		// The previous versions only understand the old firewall requests
+		return peer.IsIPVerified() && peer.GetProtocolVersion() >= ed2kCommon.ProtocolVersion7
+	})
+	if len(candidates) == 0 {
+		return errors.New("no contacts to check the firewall")
+	}
+
+	answers := make(map[string]*firewallAnswer)
+	updated := make(chan struct{}, 1)
+	client.firewall.access.Lock()
+	if client.firewall.answers != nil {
+		client.firewall.access.Unlock()
+		return errors.New("a firewall check is already running")
+	}
+	client.firewall.answers = answers
+	client.firewall.updated = updated
+	client.firewall.access.Unlock()
+	defer client.finishFirewallCheck()
+
+	packet, err := factory.GetFirewalled2Request(client.config.TcpPort, client.sourceID(), 0)
+	if err != nil {
+		return err
+	}
+	for _, peer := range candidates {
+		if len(answers) >= firewallChecks {
+			break
+		}
+		ip := peer.GetIP()
+		client.firewall.access.Lock()
+		answers[ip.String()] = &firewallAnswer{}
+		client.firewall.access.Unlock()
+		replies := []*ExpectedReply{
+			client.trackReply(peer.GetID(), ip, CommKadFirewalledRes, nil, expectedReplyTTL),
+			client.expectReply(ip, CommKadFirewalledAckRes),
+		}
+		if err := client.sendToContact(contactFromPeer(peer), packet); err != nil {
+			for _, reply := range replies {
+				client.cancelReply(reply, err)
+			}
+			log.Println(err)
+		}
+
+		if tcpPort := peer.GetTCPPort(); tcpPort != 0 {
+			client.spawn(func() { client.requestUDPCheck(ip, tcpPort) })
+		}
+	}
+
+	for !client.firewallCheckComplete() {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-client.ctx.Done():
+			return nil
+		case <-updated:
+		}
+	}
+	return nil
+}
+
+// Run a firewall check in background if the status of any port is unknown
+func (client *Client) checkFirewallIfUnknown() {
+	if client.TCPFirewallStatus() != FirewallUnknown && client.UDPFirewallStatus() != FirewallUnknown {
+		return
+	}
+	client.spawn(func() {
+		ctx, cancel := context.WithTimeout(client.ctx, firewallCheckTimeout)
+		defer cancel()
+		if err := client.CheckFirewall(ctx); err != nil {
+			log.Printf("Firewall not checked: %s", err)
+		}
+	})
+}
+
+// Check if all the asked contacts reached both ports
+func (client *Client) firewallCheckComplete() bool {
+	client.firewall.access.Lock()
+	defer client.firewall.access.Unlock()
+	for _, answer := range client.firewall.answers {
+		if !answer.tcpOpen || !answer.udpOpen {
+			return false
+		}
+	}
+	return true
+}
+
+// Update the firewall status with the answers of the running check. Inconclusive checks keep the previous status
+func (client *Client) finishFirewallCheck() {
+	client.firewall.access.Lock()
+	defer client.firewall.access.Unlock()
+
+	answered, tcpOpen, udpChecked, udpOpen := 0, 0, 0, 0
+	for _, answer := range client.firewall.answers {
+		if answer.answered {
+			answered++
+		}
+		if answer.tcpOpen {
+			tcpOpen++
+		}
+		if answer.udpChecked {
+			udpChecked++
+		}
+		if answer.udpOpen {
+			udpOpen++
+		}
+	}
+	client.firewall.answers = nil
+	client.firewall.updated = nil
+
+	client.firewall.tcp = firewallStatusFrom(client.firewall.tcp, answered, tcpOpen)
+	client.firewall.udp = firewallStatusFrom(client.firewall.udp, udpChecked, udpOpen)
+	log.Printf("Firewall check finished, TCP is %s and UDP is %s", client.firewall.tcp, client.firewall.udp)
+}
+
+func firewallStatusFrom(previous FirewallStatus, answered int, reached int) FirewallStatus {
+	if reached > 0 {
+		return FirewallOpen
+	}
+	if answered >= firewallAnswersNeeded {
+		return FirewallFirewalled
+	}
+	return previous
+}
+
+// Update the answer of the contact in [ip] to the running firewall check
+func (client *Client) recordFirewallAnswer(ip net.IP, update func(answer *firewallAnswer)) {
+	client.firewall.access.Lock()
+	defer client.firewall.access.Unlock()
+
+	answer, ok := client.firewall.answers[ip.String()]
+	if !ok {
+		return
+	}
+	update(answer)
+	select {
+	case client.firewall.updated <- struct{}{}:
+	default:
+	}
+}
+
+// Connect back to the TCP port of the requester of a firewall check, acknowledging him if it's reachable
+func (client *Client) checkRequesterTCP(r *UDPRequest, port uint16) {
+	conn, err := client.network.DialTCP(r.from.IP, port, firewallConnectTimeout)
+	if err != nil {
+		log.Printf("TCP port %d of %s not reachable: %s", port, r.from.IP, err)
+		return
+	}
+	conn.Close()
+
+	if err := client.reply(r, factory.GetFirewalledAckResponse()); err != nil {
+		log.Println(err)
+	}
+}
+
+// Ask the contact in [ip] to send a KADEMLIA2_FIREWALLUDP to our UDP port, through his ed2k [tcpPort]
+func (client *Client) requestUDPCheck(ip net.IP, tcpPort uint16) {
+	reply := client.expectReply(ip, CommKad2FirewallUDP)
+	if err := client.sendUDPCheckRequest(ip, tcpPort); err != nil {
+		client.cancelReply(reply, err)
+		log.Printf("UDP firewall check not requested to %s: %s", ip, err)
+		return
+	}
+	client.recordFirewallAnswer(ip, func(answer *firewallAnswer) {
+		answer.udpChecked = true
+	})
+}
+
+// Exchange the hellos with the ed2k [tcpPort] of the contact in [ip] and send him an OP_FWCHECKUDPREQ. The eMule
+// clients process it even if the connection is closed right after
+func (client *Client) sendUDPCheckRequest(ip net.IP, tcpPort uint16) error {
+	link, err := client.openLink(ip, tcpPort)
+	if err != nil {
+		return err
+	}
+	defer link.Close()
+
+	request := &kadBuddy.FirewallCheckUDPRequest{
+		InternalPort: client.config.UdpPort,
+		SenderKey:    obfuscation.VerifyKey(client.config.UDPKeySecret, ip),
+	}
+	if port, known := client.ExternalUDPPort(); known {
+		request.ExternalPort = port
+	}
+	payload, err := request.Encode()
+	if err != nil {
+		return err
+	}
+	return link.Write(ed2kCommon.ProtEmuleTCP, kadBuddy.OpFirewallCheckUDPRequest, payload)
+}
+
+// Wait for the OP_FWCHECKUDPREQ an eMule client may send once we answered his hello, and send him the requested
+// KADEMLIA2_FIREWALLUDP. The TCP firewall checks close the connection without sending it
+func (client *Client) answerUDPCheck(link *kadBuddy.Link) {
+	release := client.closeOnStop(link)
+	protocol, opcode, payload, err := link.Read(firewallConnectTimeout)
+	release()
+	if err != nil || protocol != ed2kCommon.ProtEmuleTCP || opcode != kadBuddy.OpFirewallCheckUDPRequest {
+		return
+	}
+	request, err := kadBuddy.DecodeFirewallCheckUDPRequest(payload)
+	if err != nil {
+		log.Printf("Invalid UDP firewall check request from %s: %s", link.RemoteIP(), err)
+		return
+	}
+
+	ports := []uint16{request.InternalPort}
+	// The port seen by the other nodes is checked too if a NAT remaps it
+	if request.ExternalPort != 0 && request.ExternalPort != request.InternalPort {
+		ports = append(ports, request.ExternalPort)
+	}
+	for _, port := range ports {
+		client.checkRequesterUDP(link.RemoteIP(), port, request.SenderKey)
+	}
+}
+
+// Send an unrequested packet to the UDP [port] of the requester of a firewall check, obfuscated with his [senderKey]
+func (client *Client) checkRequesterUDP(ip net.IP, port uint16, senderKey uint32) {
+	packet, err := factory.GetFirewallUDP2(0, port)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := client.sendPacket(ip, port, packet, obfuscationKeys{receiverKey: senderKey}); err != nil {
+		log.Println(err)
+	}
+}
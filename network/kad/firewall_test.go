@@ -0,0 +1,180 @@
+package kad
+
+import (
+	"context"
+	"net"
+	ed2kCommon "sleepy/network/ed2k/common"
+	kadBuddy "sleepy/network/kad/buddy"
+	"sleepy/network/kad/packet/factory"
+	"sleepy/types"
+	"testing"
+	"time"
+)
+
+func TestClient_CheckFirewallOpen(t *testing.T) {
+	client, manager := newTestClient()
+	nodeManager := &fakeManager{tcpReachable: true}
+	node := NewClient(Config{ClientID: types.NewUInt128(0x1111, 0x2222), UdpPort: 4672, TcpPort: 4662}, nodeManager)
+	clientAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 100), Port: 4672}
+	nodeAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672}
+	connectTestClients(client, manager, clientAddr, node, nodeManager, nodeAddr)
+	// The UDP check is requested through the ed2k TCP port of the node
+	manager.dial = func(ip net.IP, port uint16) (net.Conn, error) {
+		local, remote := net.Pipe()
+		go node.handleTCP(&addressedConn{Conn: remote, remote: &net.TCPAddr{IP: clientAddr.IP, Port: 50000}})
+		return &addressedConn{Conn: local, remote: &net.TCPAddr{IP: ip, Port: int(port)}}, nil
+	}
+
+	peer := newTestPeer(node.config.ClientID, 1)
+	peer.SetIP(nodeAddr.IP, true)
+	client.router.AddPeer(peer)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.CheckFirewall(ctx); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if ctx.Err() != nil {
+		t.Errorf("The check must finish once the node reached both ports")
+	}
+
+	if client.TCPFirewallStatus() != FirewallOpen || client.UDPFirewallStatus() != FirewallOpen {
+		t.Errorf("Both ports must be open, TCP is %s and UDP is %s", client.TCPFirewallStatus(), client.UDPFirewallStatus())
+	}
+	if !client.PublicIP().Equal(clientAddr.IP) {
+		t.Errorf("The public IP must be the one seen by the node, got %s", client.PublicIP())
+	}
+}
+
+func TestClient_CheckFirewallFirewalled(t *testing.T) {
+	client, manager := newTestClient()
+	for i := byte(1); i <= 3; i++ {
+		client.router.AddPeer(newTestPeer(types.NewUInt128(uint64(i), 0), i))
+	}
+
+	// The contacts answer, but they never reach our ports
+	manager.onSend = func(sent sentPacket) {
+		if sent.data[1] == CommKadFirewalled2Req {
+			packet, _ := factory.GetFirewalledResponse(net.IPv4(1, 2, 3, 4))
+			go client.handleUDP(packet.GetData(), &net.UDPAddr{IP: sent.ip, Port: int(sent.port)})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := client.CheckFirewall(ctx); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(manager.sentWithCommand(CommKadFirewalled2Req)) != 3 {
+		t.Errorf("The 3 contacts must be asked")
+	}
+	if client.TCPFirewallStatus() != FirewallFirewalled {
+		t.Errorf("The TCP port must be firewalled, got %s", client.TCPFirewallStatus())
+	}
+	// The contacts not reachable over TCP can't be asked to check the UDP port
+	if client.UDPFirewallStatus() != FirewallUnknown {
+		t.Errorf("The UDP port must stay unknown, got %s", client.UDPFirewallStatus())
+	}
+	if details := client.helloDetails(false); !details.TCPFirewalled || details.UDPFirewalled {
+		t.Errorf("The hellos must only announce the ports known to be firewalled")
+	}
+}
+
+func TestClient_CheckFirewallUDPFirewalled(t *testing.T) {
+	client, manager := newTestClient()
+	for i := byte(1); i <= 3; i++ {
+		client.router.AddPeer(newTestPeer(types.NewUInt128(uint64(i), 0), i))
+	}
+
+	// The contacts get the UDP check requests, but their packets never reach us
+	node := NewClient(Config{ClientID: types.NewUInt128(0x1111, 0x2222), UdpPort: 4672, TcpPort: 4662}, &fakeManager{})
+	manager.dial = func(ip net.IP, port uint16) (net.Conn, error) {
+		local, remote := net.Pipe()
+		go node.handleTCP(&addressedConn{Conn: remote, remote: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 100), Port: 50000}})
+		return &addressedConn{Conn: local, remote: &net.TCPAddr{IP: ip, Port: int(port)}}, nil
+	}
+	manager.onSend = func(sent sentPacket) {
+		if sent.data[1] == CommKadFirewalled2Req {
+			from := &net.UDPAddr{IP: sent.ip, Port: int(sent.port)}
+			packet, _ := factory.GetFirewalledResponse(net.IPv4(1, 2, 3, 4))
+			ack := factory.GetFirewalledAckResponse()
+			go func() {
+				client.handleUDP(packet.GetData(), from)
+				client.handleUDP(ack.GetData(), from)
+			}()
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := client.CheckFirewall(ctx); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if client.TCPFirewallStatus() != FirewallOpen {
+		t.Errorf("The TCP port must be open, got %s", client.TCPFirewallStatus())
+	}
+	if client.UDPFirewallStatus() != FirewallFirewalled {
+		t.Errorf("The UDP port must be firewalled, got %s", client.UDPFirewallStatus())
+	}
+}
+
+func TestClient_AnswerFirewallRequest(t *testing.T) {
+	client, manager := newTestClient()
+	from := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672}
+
+	packet, err := factory.GetFirewalled2Request(4662, types.NewUInt128(1, 1), 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := client.handleUDP(packet.GetData(), from); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	responses := manager.sentWithCommand(CommKadFirewalledRes)
+	if len(responses) != 1 || len(responses[0].data) != 6 {
+		t.Fatalf("The request must be answered with the IP of the requester")
+	}
+	if ip := responses[0].data[2:]; ip[0] != 1 || ip[3] != 10 {
+		t.Errorf("The IP must be sent in host order, got %v", ip)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if len(manager.sentWithCommand(CommKadFirewalledAckRes)) != 0 || len(manager.sentWithCommand(CommKad2FirewallUDP)) != 0 {
+		t.Errorf("Unreachable or unrequested ports must not be acknowledged")
+	}
+}
+
+func TestClient_AnswerUDPFirewallCheck(t *testing.T) {
+	client, manager := newTestClient()
+	local, remote := net.Pipe()
+	go client.handleTCP(&addressedConn{Conn: remote, remote: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 50000}})
+	link := kadBuddy.NewLink(local)
+	defer link.Close()
+
+	hello, _ := (&kadBuddy.Hello{UserHash: types.NewUInt128(1, 1), TCPPort: 4662}).Encode(true)
+	if err := link.Write(ed2kCommon.ProtEd2kTCP, kadBuddy.OpHello, hello); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, opcode, _, err := link.Read(time.Second); err != nil || opcode != kadBuddy.OpHelloAnswer {
+		t.Fatalf("The hello must be answered: %v", err)
+	}
+	// No sender key, so the answers are sent in plain
+	request, _ := (&kadBuddy.FirewallCheckUDPRequest{InternalPort: 4672, ExternalPort: 5000}).Encode()
+	if err := link.Write(ed2kCommon.ProtEmuleTCP, kadBuddy.OpFirewallCheckUDPRequest, request); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(manager.sentWithCommand(CommKad2FirewallUDP)) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sent := manager.sentWithCommand(CommKad2FirewallUDP)
+	if len(sent) != 2 || sent[0].port != 4672 || sent[1].port != 5000 {
+		t.Fatalf("Both the internal and the external ports must be checked, got %+v", sent)
+	}
+	if !sent[0].ip.Equal(net.IPv4(10, 0, 0, 1)) || sent[0].data[2] != 0 {
+		t.Errorf("The check must be sent without error to the requester, got %+v", sent[0])
+	}
+}
@@ -0,0 +1,27 @@
+package kad
+
+// Requests allowed by minute from each IP, as the eMule packet tracking. The responses aren't limited, as the
+// unrequested ones are already ignored
+var packetLimits = map[byte]int{
+	CommKad2BootstrapReq:     2,
+	CommKad2HelloReq:         3,
+	CommKad2Req:              10,
+	CommKad2SearchNotesReq:   3,
+	CommKad2SearchKeyReq:     3,
+	CommKad2SearchSourceReq:  3,
+	CommKad2PublichKeyReq:    3,
+	CommKad2PublishSourceReq: 2,
+	CommKad2PublishNotesReq:  2,
+	CommKadFirewalled2Req:    2,
+	CommKadFindbuddyReq:      2,
+	CommKadCallbackReq:       1,
+	CommKad2Ping:             2,
+	CommKadBootstrapReq:      2,
+	CommKadHelloReq:          3,
+	CommKadReq:               10,
+	CommKadSearchReq:         3,
+	CommKadSearchNotesReq:    3,
+	CommKadPublishReq:        3,
+	CommKadPublishNotesReq:   2,
+	CommKadFirewalledReq:     2,
+}
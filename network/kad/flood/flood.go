@@ -0,0 +1,119 @@
+package flood
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	trackWindow   = time.Minute
+	banFactor     = 5 // IPs exceeding this times the allowed rate are banned, as eMule does with the massive flooders
+	banDuration   = time.Hour
+	cleanInterval = 5 * time.Minute
+)
+
+// Packets of an opcode received from an IP in the current window
+type counter struct {
+	start time.Time
+	count int
+}
+
+// Tracker limits the packets accepted from each IP, by opcode, and bans the IPs flooding us
+type Tracker struct {
+	// Packets allowed by minute of each opcode, the opcodes without limit are always allowed
+	limits    map[byte]int
+	counters  map[string]map[byte]*counter
+	banned    map[string]time.Time
+	lastClean time.Time
+	now       func() time.Time
+	access    sync.Mutex
+}
+
+func NewTracker(limits map[byte]int) *Tracker {
+	return &Tracker{
+		limits:    limits,
+		counters:  make(map[string]map[byte]*counter),
+		banned:    make(map[string]time.Time),
+		lastClean: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Allow counts a packet with the [opcode] from the [ip], and checks if it's under the limits. The IPs sending way more
+// packets than allowed are banned
+func (tracker *Tracker) Allow(ip net.IP, opcode byte) bool {
+	tracker.access.Lock()
+	defer tracker.access.Unlock()
+
+	now := tracker.now()
+	tracker.clean(now)
+	key := ip.String()
+	if tracker.isBanned(key, now) {
+		return false
+	}
+
+	limit, limited := tracker.limits[opcode]
+	if !limited {
+		return true
+	}
+
+	byOpcode, found := tracker.counters[key]
+	if !found {
+		byOpcode = make(map[byte]*counter)
+		tracker.counters[key] = byOpcode
+	}
+	c, found := byOpcode[opcode]
+	if !found || now.Sub(c.start) >= trackWindow {
+		c = &counter{start: now}
+		byOpcode[opcode] = c
+	}
+	c.count++
+
+	if c.count > limit*banFactor {
+		tracker.banned[key] = now.Add(banDuration)
+		delete(tracker.counters, key)
+		return false
+	}
+	return c.count <= limit
+}
+
+// IsBanned checks if the [ip] is banned for flooding us
+func (tracker *Tracker) IsBanned(ip net.IP) bool {
+	tracker.access.Lock()
+	defer tracker.access.Unlock()
+	return tracker.isBanned(ip.String(), tracker.now())
+}
+
+func (tracker *Tracker) isBanned(key string, now time.Time) bool {
+	until, found := tracker.banned[key]
+	if found && until.Before(now) {
+		delete(tracker.banned, key)
+		return false
+	}
+	return found
+}
+
+// Forget the finished windows and bans from time to time, so the memory doesn't grow with every IP seen
+func (tracker *Tracker) clean(now time.Time) {
+	if now.Sub(tracker.lastClean) < cleanInterval {
+		return
+	}
+	tracker.lastClean = now
+
+	for key, byOpcode := range tracker.counters {
+		for opcode, c := range byOpcode {
+			if now.Sub(c.start) >= trackWindow {
+				delete(byOpcode, opcode)
+			}
+		}
+		if len(byOpcode) == 0 {
+			delete(tracker.counters, key)
+		}
+	}
+	for key, until := range tracker.banned {
+		if until.Before(now) {
+			delete(tracker.banned, key)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package flood
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// Tracker with a clock moved by the test
+func newTestTracker(limits map[byte]int) (*Tracker, *time.Time) {
+	now := time.Now()
+	tracker := NewTracker(limits)
+	tracker.now = func() time.Time { return now }
+	tracker.lastClean = now
+	return tracker, &now
+}
+
+func TestTracker_Limit(t *testing.T) {
+	tracker, now := newTestTracker(map[byte]int{0x11: 3})
+	ip := net.IPv4(10, 0, 0, 1)
+
+	for i := 0; i < 3; i++ {
+		if !tracker.Allow(ip, 0x11) {
+			t.Fatalf("The packet %d must be allowed", i)
+		}
+	}
+	if tracker.Allow(ip, 0x11) {
+		t.Errorf("The packets over the limit must be dropped")
+	}
+	if !tracker.Allow(net.IPv4(10, 0, 0, 2), 0x11) {
+		t.Errorf("The limits must be by IP")
+	}
+	if !tracker.Allow(ip, 0x21) {
+		t.Errorf("The opcodes without limit must be allowed")
+	}
+
+	*now = now.Add(trackWindow)
+	if !tracker.Allow(ip, 0x11) {
+		t.Errorf("The limit must be reset after a minute")
+	}
+}
+
+func TestTracker_Ban(t *testing.T) {
+	tracker, now := newTestTracker(map[byte]int{0x11: 2})
+	ip := net.IPv4(10, 0, 0, 1)
+
+	for i := 0; i <= 2*banFactor; i++ {
+		tracker.Allow(ip, 0x11)
+	}
+	if !tracker.IsBanned(ip) {
+		t.Fatalf("The flooder must be banned")
+	}
+	if tracker.Allow(ip, 0x21) {
+		t.Errorf("All the packets of a banned IP must be dropped")
+	}
+
+	*now = now.Add(banDuration + time.Second)
+	if tracker.IsBanned(ip) || !tracker.Allow(ip, 0x11) {
+		t.Errorf("The ban must expire")
+	}
+}
+
+func TestTracker_Clean(t *testing.T) {
+	tracker, now := newTestTracker(map[byte]int{0x11: 2})
+	tracker.Allow(net.IPv4(10, 0, 0, 1), 0x11)
+
+	*now = now.Add(cleanInterval)
+	tracker.Allow(net.IPv4(10, 0, 0, 2), 0x21)
+	if len(tracker.counters) != 0 {
+		t.Errorf("The finished windows must be forgotten, %d IPs tracked", len(tracker.counters))
+	}
+}
@@ -0,0 +1,75 @@
+package kad
+
+import (
+	"errors"
+	"net"
+	ed2kCommon "sleepy/network/ed2k/common"
+	"sleepy/network/kad/common"
+	"sleepy/network/kad/message"
+	"sleepy/network/kad/packet/factory"
+	"sleepy/types"
+)
+
+// Hello2 is the contact information carried by the Kad2 hello packets
+type Hello2 struct {
+	ClientID      types.UInt128
+	TCPPort       uint16
+	UDPPort       uint16
+	Version       uint8
+	UDPFirewalled bool
+	TCPFirewalled bool
+	RequestsAck   bool
+}
+
+// Read the Kad2 hello payload. The UDP port is the one seen by us unless the peer announces his internal one
+func readHello2(r *UDPRequest) (*Hello2, error) {
+	var msg message.Hello
+	if err := r.decode(&msg); err != nil {
+		return nil, err
+	}
+	hello := &Hello2{ClientID: msg.ClientID, TCPPort: msg.TCPPort, UDPPort: uint16(r.from.Port), Version: msg.Version}
+
+	if port, ok := msg.Tags.GetUInt(common.TagSourceUDPPort); ok && port > 0 {
+		hello.UDPPort = uint16(port)
+	}
+	if options, ok := msg.Tags.GetUInt(common.TagKadMiscOptions); ok {
+		hello.UDPFirewalled = options&common.MiscOptionUDPFirewalled != 0
+		hello.TCPFirewalled = options&common.MiscOptionTCPFirewalled != 0
+		// Only version 8 and newer are able to send an ACK
+		hello.RequestsAck = options&common.MiscOptionRequestAck != 0 && hello.Version >= ed2kCommon.ProtocolVersion8
+	}
+
+	return hello, nil
+}
+
+// Get the details of the local node to be sent in a hello packet
+func (client *Client) helloDetails(requestAck bool) factory.HelloDetails {
+	return factory.HelloDetails{
+		ID:         client.config.ClientID,
+		TCPPort:    client.config.TcpPort,
+		UDPPort:    client.config.UdpPort,
+		Version:    common.KadVersion,
+		RequestAck: requestAck,
+		// Only the ports known to be firewalled are announced, as the nodes don't add the UDP firewalled ones
+		TCPFirewalled: client.TCPFirewallStatus() == FirewallFirewalled,
+		UDPFirewalled: client.UDPFirewallStatus() == FirewallFirewalled,
+	}
+}
+
+// Send a Kad2 hello request to the peer in [ip]:[port], obfuscated with the [keys] if any
+func (client *Client) sendHello(ip net.IP, port uint16, keys obfuscationKeys) error {
+	packet, err := factory.GetHello2Request(client.helloDetails(false))
+	if err != nil {
+		return err
+	}
+	client.expectReply(ip, CommKad2HelloRes)
+	return client.sendPacket(ip, port, packet, keys)
+}
+
+// Insert the hello sender in the router, or update it if it's already known
+func (client *Client) updateContactFromHello(hello *Hello2, ip net.IP, verified bool) error {
+	if hello.UDPFirewalled {
+		return errors.New("firewalled contacts are not added to the router")
+	}
+	return client.addContact(hello.ClientID, ip, hello.UDPPort, hello.TCPPort, hello.Version, verified, true)
+}
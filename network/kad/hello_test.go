@@ -0,0 +1,67 @@
+package kad
+
+import (
+	"net"
+	"sleepy/network/kad/packet/factory"
+	"sleepy/types"
+	"testing"
+)
+
+func TestReadHello2(t *testing.T) {
+	id := types.NewUInt128(0x1122334455667788, 0x99aabbccddeeff00)
+	packet, err := factory.GetHello2Request(factory.HelloDetails{
+		ID:            id,
+		TCPPort:       4662,
+		UDPPort:       4672,
+		Version:       8,
+		TCPFirewalled: true,
+		RequestAck:    true,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error building hello: %s", err)
+	}
+
+	request := &UDPRequest{
+		from:    &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 1234},
+		Request: Request{body: *NewReader(packet.GetData()[2:])},
+	}
+	hello, err := readHello2(request)
+	if err != nil {
+		t.Fatalf("Unexpected error reading hello: %s", err)
+	}
+
+	if !hello.ClientID.Equal(id) {
+		t.Errorf("Client id mismatch, got: %s", hello.ClientID.ToHexString())
+	}
+	if hello.TCPPort != 4662 || hello.UDPPort != 4672 || hello.Version != 8 {
+		t.Errorf("Hello details mismatch: %+v", hello)
+	}
+	if hello.UDPFirewalled || !hello.TCPFirewalled || !hello.RequestsAck {
+		t.Errorf("Hello options mismatch: %+v", hello)
+	}
+}
+
+func TestClient_RepeatedHelloRequests(t *testing.T) {
+	client, manager := newTestClient()
+	packet, err := factory.GetHello2Request(factory.HelloDetails{ID: types.NewUInt128(1, 2), TCPPort: 4662, UDPPort: 4672, Version: 8})
+	if err != nil {
+		t.Fatalf("Unexpected error building hello: %s", err)
+	}
+
+	from := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672}
+	for i := 0; i < 3; i++ {
+		if err = client.handleUDP(packet.GetData(), from); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+
+	if responses := manager.sentWithCommand(CommKad2HelloRes); len(responses) != 3 {
+		t.Fatalf("Every hello must be answered, got %d responses", len(responses))
+	}
+	client.replies.access.Lock()
+	expected := len(client.replies.expected[expectedReplyKey(from.IP, CommKad2HelloResAck)])
+	client.replies.access.Unlock()
+	if expected != 1 {
+		t.Errorf("The repeated hellos must expect a single ack, got %d", expected)
+	}
+}
@@ -0,0 +1,276 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sleepy/network/common/tag"
+	"sleepy/types"
+	"sync"
+	"time"
+)
+
+// Kind of the indexed entries
+type Kind int
+
+const (
+	KindKeyword Kind = iota // Files published under a keyword
+	KindSource              // Sources published for a file
+	KindNotes               // Comments and ratings published for a file
+)
+
+// Limits of an entry kind, as the eMule KADEMLIAMAX* constants
+type limits struct {
+	lifetime  time.Duration
+	maxPerKey int // The load of a key is the percentage of this limit in use
+	maxPerIP  int // Max entries of a key published by the same IP
+	maxTotal  int
+}
+
+var kindLimits = map[Kind]limits{
+	KindKeyword: {lifetime: 24 * time.Hour, maxPerKey: 50000, maxPerIP: 150, maxTotal: 60000},
+	KindSource:  {lifetime: 5 * time.Hour, maxPerKey: 1000, maxPerIP: 3, maxTotal: 60000},
+	KindNotes:   {lifetime: 24 * time.Hour, maxPerKey: 150, maxPerIP: 1, maxTotal: 60000},
+}
+
+// Entry is a value published by a node under a key
+type Entry struct {
+	// Key is the keyword or file hash the entry is published under
+	Key types.UInt128
+	// ID is the file hash for the keywords, and the source hash for the sources and notes
+	ID types.UInt128
+	// IP of the node that published the entry
+	IP      net.IP
+	Tags    tag.List
+	Expires time.Time
+}
+
+// Index stores the entries published by other nodes in the local node
+type Index struct {
+	tables map[Kind]map[string]map[string]*Entry // Entries by kind, key and ID
+	totals map[Kind]int
+	access sync.RWMutex
+}
+
+func NewIndex() *Index {
+	index := &Index{
+		tables: make(map[Kind]map[string]map[string]*Entry),
+		totals: make(map[Kind]int),
+	}
+	for kind := range kindLimits {
+		index.tables[kind] = make(map[string]map[string]*Entry)
+	}
+	return index
+}
+
+// Add stores or refreshes an entry, returning the load of his key after adding it
+func (index *Index) Add(kind Kind, entry Entry) (uint8, error) {
+	kindLimit, found := kindLimits[kind]
+	if !found {
+		return 0, errors.New("unknown entry kind")
+	}
+	if entry.Key == nil || entry.ID == nil {
+		return 0, errors.New("entries need a key and an id")
+	}
+
+	index.access.Lock()
+	defer index.access.Unlock()
+
+	keyHex := entry.Key.ToHexString()
+	entries, found := index.tables[kind][keyHex]
+	if !found {
+		entries = make(map[string]*Entry)
+		index.tables[kind][keyHex] = entries
+	}
+
+	idHex := entry.ID.ToHexString()
+	if _, found := entries[idHex]; !found {
+		if len(entries) >= kindLimit.maxPerKey {
+			return 100, errors.New("the key is full")
+		}
+		if index.totals[kind] >= kindLimit.maxTotal {
+			return 100, errors.New("the index is full")
+		}
+		if index.countByIP(entries, entry.IP) >= kindLimit.maxPerIP {
+			return load(len(entries), kindLimit), errors.New("too many entries published by the IP")
+		}
+		index.totals[kind]++
+	}
+
+	entry.Expires = time.Now().Add(kindLimit.lifetime)
+	entries[idHex] = &entry
+	return load(len(entries), kindLimit), nil
+}
+
+func (index *Index) countByIP(entries map[string]*Entry, ip net.IP) int {
+	count := 0
+	for _, entry := range entries {
+		if entry.IP.Equal(ip) {
+			count++
+		}
+	}
+	return count
+}
+
+func load(count int, kindLimit limits) uint8 {
+	return uint8(count * 100 / kindLimit.maxPerKey)
+}
+
+// Get the alive entries published under the [key] accepted by the [filter], up to [max]. The filter may be nil
+func (index *Index) Get(kind Kind, key types.UInt128, max int, filter func(entry *Entry) bool) []*Entry {
+	index.access.RLock()
+	defer index.access.RUnlock()
+
+	now := time.Now()
+	result := make([]*Entry, 0)
+	for _, entry := range index.tables[kind][key.ToHexString()] {
+		if len(result) >= max {
+			break
+		}
+		if entry.Expires.After(now) && (filter == nil || filter(entry)) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// Count the entries of a kind, including the expired ones not yet cleaned
+func (index *Index) Count(kind Kind) int {
+	index.access.RLock()
+	defer index.access.RUnlock()
+	return index.totals[kind]
+}
+
+// Clean removes the expired entries, returning how many were removed
+func (index *Index) Clean() int {
+	index.access.Lock()
+	defer index.access.Unlock()
+
+	now := time.Now()
+	removed := 0
+	for kind, table := range index.tables {
+		for keyHex, entries := range table {
+			for idHex, entry := range entries {
+				if !entry.Expires.After(now) {
+					delete(entries, idHex)
+					index.totals[kind]--
+					removed++
+				}
+			}
+			if len(entries) == 0 {
+				delete(table, keyHex)
+			}
+		}
+	}
+	return removed
+}
+
+// Stored form of an entry, with the tags encoded as Kad does
+type storedEntry struct {
+	Kind    Kind
+	Key     []byte
+	ID      []byte
+	IP      net.IP
+	Tags    []byte
+	Expires time.Time
+}
+
+// SaveFile saves the alive entries to disk, replacing the previous file
+func (index *Index) SaveFile(path string) error {
+	index.access.RLock()
+	stored := make([]storedEntry, 0)
+	now := time.Now()
+	for kind, table := range index.tables {
+		for _, entries := range table {
+			for _, entry := range entries {
+				if !entry.Expires.After(now) {
+					continue
+				}
+				tags, err := entry.Tags.Encode()
+				if err != nil {
+					index.access.RUnlock()
+					return err
+				}
+				stored = append(stored, storedEntry{
+					Kind:    kind,
+					Key:     entry.Key.ToBytes(),
+					ID:      entry.ID.ToBytes(),
+					IP:      entry.IP,
+					Tags:    tags,
+					Expires: entry.Expires,
+				})
+			}
+		}
+	}
+	index.access.RUnlock()
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmpFile)
+	err = gob.NewEncoder(writer).Encode(stored)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// LoadIndexFromFile creates an index with the alive entries saved in a file
+func LoadIndexFromFile(path string) (*Index, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stored := make([]storedEntry, 0)
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(&stored); err != nil {
+		return nil, err
+	}
+
+	index := NewIndex()
+	now := time.Now()
+	for _, saved := range stored {
+		if _, found := kindLimits[saved.Kind]; !found || !saved.Expires.After(now) {
+			continue
+		}
+		key, err := types.NewUInt128FromByteArray(saved.Key)
+		if err != nil {
+			return nil, err
+		}
+		id, err := types.NewUInt128FromByteArray(saved.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		tags, err := tag.ReadList(bytes.NewReader(saved.Tags))
+		if err != nil {
+			return nil, err
+		}
+
+		entries, found := index.tables[saved.Kind][key.ToHexString()]
+		if !found {
+			entries = make(map[string]*Entry)
+			index.tables[saved.Kind][key.ToHexString()] = entries
+		}
+		if _, found := entries[id.ToHexString()]; !found {
+			index.totals[saved.Kind]++
+		}
+		entries[id.ToHexString()] = &Entry{Key: key, ID: id, IP: saved.IP, Tags: tags, Expires: saved.Expires}
+	}
+	return index, nil
+}
@@ -0,0 +1,114 @@
+package index
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"sleepy/network/common/tag"
+	"sleepy/types"
+	"testing"
+	"time"
+)
+
+func TestIndex_AddAndGet(t *testing.T) {
+	index := NewIndex()
+	key := types.NewUInt128(1, 1)
+
+	for i := 1; i <= 3; i++ {
+		entry := Entry{Key: key, ID: types.NewUInt128FromInt(i), IP: net.IPv4(10, 0, 0, byte(i)), Tags: tag.List{tag.NewString(tag.ID(1), "file")}}
+		if _, err := index.Add(KindSource, entry); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+
+	if len(index.Get(KindSource, key, 10, nil)) != 3 {
+		t.Errorf("The 3 sources must be found")
+	}
+	if len(index.Get(KindSource, key, 2, nil)) != 2 {
+		t.Errorf("The results must be limited")
+	}
+	if len(index.Get(KindKeyword, key, 10, nil)) != 0 {
+		t.Errorf("The kinds must be isolated")
+	}
+	filtered := index.Get(KindSource, key, 10, func(entry *Entry) bool {
+		return entry.IP.Equal(net.IPv4(10, 0, 0, 2))
+	})
+	if len(filtered) != 1 || !filtered[0].ID.Equal(types.NewUInt128FromInt(2)) {
+		t.Errorf("The filter must be applied")
+	}
+}
+
+func TestIndex_Load(t *testing.T) {
+	index := NewIndex()
+	key := types.NewUInt128(1, 1)
+
+	var load uint8
+	for i := 1; i <= 15; i++ {
+		entry := Entry{Key: key, ID: types.NewUInt128FromInt(i), IP: net.IPv4(10, 0, 0, byte(i))}
+		load, _ = index.Add(KindNotes, entry)
+	}
+	if load != 10 {
+		t.Errorf("15 notes of 150 must give a load of 10, got %d", load)
+	}
+
+	// Refreshing an entry doesn't change the load
+	load, err := index.Add(KindNotes, Entry{Key: key, ID: types.NewUInt128FromInt(1), IP: net.IPv4(10, 0, 0, 1)})
+	if err != nil || load != 10 || index.Count(KindNotes) != 15 {
+		t.Errorf("Refreshed entries must not be counted twice")
+	}
+}
+
+func TestIndex_LimitByIP(t *testing.T) {
+	index := NewIndex()
+	key := types.NewUInt128(1, 1)
+	ip := net.IPv4(10, 0, 0, 1)
+
+	if _, err := index.Add(KindNotes, Entry{Key: key, ID: types.NewUInt128FromInt(1), IP: ip}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, err := index.Add(KindNotes, Entry{Key: key, ID: types.NewUInt128FromInt(2), IP: ip}); err == nil {
+		t.Errorf("An IP can publish a unique note by file")
+	}
+	if _, err := index.Add(KindNotes, Entry{Key: types.NewUInt128(2, 2), ID: types.NewUInt128FromInt(2), IP: ip}); err != nil {
+		t.Errorf("The IP limit must be by key: %s", err)
+	}
+}
+
+func TestIndex_Clean(t *testing.T) {
+	index := NewIndex()
+	key := types.NewUInt128(1, 1)
+	index.Add(KindKeyword, Entry{Key: key, ID: types.NewUInt128FromInt(1), IP: net.IPv4(10, 0, 0, 1)})
+	index.Add(KindKeyword, Entry{Key: key, ID: types.NewUInt128FromInt(2), IP: net.IPv4(10, 0, 0, 1)})
+	index.tables[KindKeyword][key.ToHexString()][types.NewUInt128FromInt(1).ToHexString()].Expires = time.Now().Add(-time.Second)
+
+	if len(index.Get(KindKeyword, key, 10, nil)) != 1 {
+		t.Errorf("The expired entries must not be returned")
+	}
+	if index.Clean() != 1 || index.Count(KindKeyword) != 1 {
+		t.Errorf("The expired entry must be removed")
+	}
+}
+
+func TestIndex_SaveAndLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.dat")
+	index := NewIndex()
+	key := types.NewUInt128(1, 1)
+	tags := tag.List{tag.NewString(tag.ID(1), "file.avi"), tag.NewUInt32(tag.ID(2), 0x1000)}
+	index.Add(KindKeyword, Entry{Key: key, ID: types.NewUInt128(2, 2), IP: net.IPv4(10, 0, 0, 1), Tags: tags})
+
+	if err := index.SaveFile(path); err != nil {
+		t.Fatalf("Unexpected error saving: %s", err)
+	}
+	loaded, err := LoadIndexFromFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error loading: %s", err)
+	}
+
+	entries := loaded.Get(KindKeyword, key, 10, nil)
+	if len(entries) != 1 || !entries[0].ID.Equal(types.NewUInt128(2, 2)) || !entries[0].IP.Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Fatalf("The entry must be loaded")
+	}
+	if !reflect.DeepEqual(entries[0].Tags, tags) {
+		t.Errorf("The entry tags must be loaded, got %v", entries[0].Tags)
+	}
+}
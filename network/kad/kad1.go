@@ -0,0 +1,335 @@
+package kad
+
+import (
+	"errors"
+	"log"
+	"net"
+	ed2kCommon "sleepy/network/ed2k/common"
+	kadCommon "sleepy/network/kad/common"
+	"sleepy/network/kad/index"
+	kadPacket "sleepy/network/kad/packet"
+	"sleepy/network/kad/packet/factory"
+	"sleepy/types"
+)
+
+// Version given to the contacts greeting us with a Kad1 hello, which doesn't carry it
+const kad1Version = uint8(1)
+
+// Commands only used by the Kad1 nodes, ignored unless the Kad1 compatibility is enabled
+var kad1Commands = map[byte]bool{
+	CommKadBootstrapReq:    true,
+	CommKadBootstrapRes:    true,
+	CommKadHelloReq:        true,
+	CommKadHelloRes:        true,
+	CommKadReq:             true,
+	CommKadRes:             true,
+	CommKadSearchReq:       true,
+	CommKadSearchRes:       true,
+	CommKadSearchNotesReq:  true,
+	CommKadSearchNotesRes:  true,
+	CommKadPublishReq:      true,
+	CommKadPublishRes:      true,
+	CommKadPublishNotesReq: true,
+	CommKadPublishNotesRes: true,
+	CommKadFirewalledReq:   true,
+}
+
+func isKad1Command(command byte) bool {
+	return kad1Commands[command]
+}
+
+// Check if the contact understands the Kad2 packets, the older ones only speak Kad1
+func (contact *Contact) speaksKad2() bool {
+	return contact.Version >= ed2kCommon.ProtocolVersion2
+}
+
+// Send a Kad1 hello request to the peer in [ip]:[port]
+func (client *Client) sendHello1(ip net.IP, port uint16) error {
+	packet, err := factory.GetHello1Request(client.hello1Details())
+	if err != nil {
+		return err
+	}
+	client.expectReply(ip, CommKadHelloRes)
+	return client.sendPacket(ip, port, packet, obfuscationKeys{})
+}
+
+// Greet a contact with the hello of his protocol, the Kad1 ones are skipped unless the Kad1 compatibility is enabled
+func (client *Client) greetContact(contact *Contact) error {
+	if contact.speaksKad2() {
+		return client.sendHello(contact.IP, contact.UDPPort, client.contactKeys(contact.ClientID, contact.Version))
+	}
+	if !client.config.Kad1 {
+		return errors.New("Kad1 contacts are disabled")
+	}
+	return client.sendHello1(contact.IP, contact.UDPPort)
+}
+
+func (client *Client) hello1Details() factory.Hello1Details {
+	return factory.Hello1Details{
+		ID:      client.config.ClientID,
+		IP:      client.PublicIP(),
+		UDPPort: client.config.UdpPort,
+		TCPPort: client.config.TcpPort,
+	}
+}
+
+func HandleBootstrap1Request(client *Client, r *UDPRequest, w Response) {
+	sender, err := readContact(&r.body)
+	if err != nil {
+		log.Printf("Invalid Kad1 bootstrap request from %s: %s", r.from, err)
+		return
+	}
+	err = client.addContact(sender.ClientID, r.from.IP, uint16(r.from.Port), sender.TCPPort, kad1Version, false, true)
+	if err != nil {
+		log.Printf("Kad1 bootstrap sender %s not added: %s", sender.ClientID.ToHexString(), err)
+	}
+
+	packet, err := factory.GetBootstrap1Response(client.bootstrapPeers(r.from, sender.ClientID))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err = client.reply(r, packet); err != nil {
+		log.Println(err)
+	}
+}
+
+func HandleHello1Request(client *Client, r *UDPRequest, w Response) {
+	sender, err := readContact(&r.body)
+	if err != nil {
+		log.Printf("Invalid Kad1 hello request from %s: %s", r.from, err)
+		return
+	}
+	err = client.addContact(sender.ClientID, r.from.IP, uint16(r.from.Port), sender.TCPPort, kad1Version, false, true)
+	if err != nil {
+		log.Printf("Contact %s not updated: %s", sender.ClientID.ToHexString(), err)
+	}
+
+	packet, err := factory.GetHello1Response(client.hello1Details())
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err = client.reply(r, packet); err != nil {
+		log.Println(err)
+	}
+}
+
+func HandleHello1Response(client *Client, r *UDPRequest, w Response) {
+	if !client.consumeExpectedReply(r.from.IP, CommKadHelloRes, nil) {
+		log.Printf("Ignoring unrequested Kad1 hello response from %s", r.from)
+		return
+	}
+
+	sender, err := readContact(&r.body)
+	if err != nil {
+		log.Printf("Invalid Kad1 hello response from %s: %s", r.from, err)
+		return
+	}
+	// The peer answered to our request, so his IP is verified
+	err = client.addContact(sender.ClientID, r.from.IP, uint16(r.from.Port), sender.TCPPort, kad1Version, true, true)
+	if err != nil {
+		log.Printf("Contact %s not updated: %s", sender.ClientID.ToHexString(), err)
+	}
+}
+
+func HandleKad1Request(client *Client, r *UDPRequest, w Response) {
+	count, err := r.body.ReadUInt8()
+	if err != nil {
+		log.Printf("Invalid Kad1 request from %s: %s", r.from, err)
+		return
+	}
+	count &= 0x1F
+	if count == 0 {
+		log.Printf("Invalid Kad1 request from %s: no contacts requested", r.from)
+		return
+	}
+
+	target, err := r.body.ReadUInt128()
+	if err != nil {
+		log.Printf("Invalid Kad1 request from %s: %s", r.from, err)
+		return
+	}
+	receiver, err := r.body.ReadUInt128()
+	if err != nil {
+		log.Printf("Invalid Kad1 request from %s: %s", r.from, err)
+		return
+	}
+	if !receiver.Equal(client.config.ClientID) {
+		log.Printf("Ignoring Kad1 request from %s addressed to %s", r.from, receiver.ToHexString())
+		return
+	}
+
+	packet, err := factory.GetKad1Response(target, client.router.GetClosestPeers(target, int(count)))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err = client.reply(r, packet); err != nil {
+		log.Println(err)
+	}
+}
+
+func HandleKad1Response(client *Client, r *UDPRequest, w Response) {
+	target, err := r.body.ReadUInt128()
+	if err != nil {
+		log.Printf("Invalid Kad1 response from %s: %s", r.from, err)
+		return
+	}
+	if !client.consumeExpectedReply(r.from.IP, CommKadRes, target) {
+		log.Printf("Ignoring unrequested Kad1 response from %s", r.from)
+		return
+	}
+	count, err := r.body.ReadUInt8()
+	if err != nil {
+		log.Printf("Invalid Kad1 response from %s: %s", r.from, err)
+		return
+	}
+
+	contacts := make([]*Contact, 0, count)
+	for ; count > 0; count-- {
+		contact, err := readContact(&r.body)
+		if err != nil {
+			log.Printf("Invalid Kad1 response from %s: %s", r.from, err)
+			break
+		}
+		// The Kad1 lists carry the contact type instead of the version, which stays unknown until a hello
+		contact.Version = 0
+		contacts = append(contacts, contact)
+
+		_ = client.addContact(contact.ClientID, contact.IP, contact.UDPPort, contact.TCPPort, contact.Version, false, false)
+	}
+
+	client.deliverLookupResponse(target, r.from, contacts)
+}
+
+func HandleSearch1Request(client *Client, r *UDPRequest, w Response) {
+	target, err := r.body.ReadUInt128()
+	if err != nil {
+		log.Printf("Invalid Kad1 search from %s: %s", r.from, err)
+		return
+	}
+	// The restrictive searches are the keyword ones, followed by his search expression
+	restrictive, err := r.body.ReadUInt8()
+	if err != nil {
+		log.Printf("Invalid Kad1 search from %s: %s", r.from, err)
+		return
+	}
+
+	var entries []*index.Entry
+	if restrictive != 0 {
+		expression, err := readSearchExpression(&r.body)
+		if err != nil {
+			log.Printf("Invalid Kad1 search from %s: %s", r.from, err)
+			return
+		}
+		entries = client.index.Get(index.KindKeyword, target, maxIndexResults, func(entry *index.Entry) bool {
+			return expression.Matches(entry.Tags)
+		})
+	} else {
+		entries = client.index.Get(index.KindSource, target, maxIndexResults, nil)
+	}
+
+	client.answerSearchWith(r, entries, 0, func(answers []factory.SearchEntry) (*kadPacket.Packet, error) {
+		return factory.GetSearch1Response(target, answers)
+	})
+}
+
+func HandleSearchNotes1Request(client *Client, r *UDPRequest, w Response) {
+	target, err := r.body.ReadUInt128()
+	if err != nil {
+		log.Printf("Invalid Kad1 notes search from %s: %s", r.from, err)
+		return
+	}
+
+	entries := client.index.Get(index.KindNotes, target, maxIndexResults, nil)
+	client.answerSearchWith(r, entries, 0, func(answers []factory.SearchEntry) (*kadPacket.Packet, error) {
+		return factory.GetSearchNotes1Response(target, answers)
+	})
+}
+
+// The Kad1 publishes carry keyword or source entries, told apart by the source type tag
+func HandlePublish1Request(client *Client, r *UDPRequest, w Response) {
+	target, err := r.body.ReadUInt128()
+	if err != nil {
+		log.Printf("Invalid Kad1 publish from %s: %s", r.from, err)
+		return
+	}
+	if !client.acceptsPublish(target) {
+		log.Printf("Ignoring Kad1 publish from %s for the distant %s", r.from, target.ToHexString())
+		return
+	}
+	count, err := r.body.ReadUInt16()
+	if err != nil {
+		log.Printf("Invalid Kad1 publish from %s: %s", r.from, err)
+		return
+	}
+
+	load := uint8(0)
+	for ; count > 0; count-- {
+		id, err := r.body.ReadUInt128()
+		if err != nil {
+			log.Printf("Invalid Kad1 publish from %s: %s", r.from, err)
+			return
+		}
+		tags, err := r.body.ReadTags()
+		if err != nil {
+			log.Printf("Invalid Kad1 publish from %s: %s", r.from, err)
+			return
+		}
+
+		var entryLoad uint8
+		if _, source := tags.GetUInt(kadCommon.TagSourceType); source {
+			entry := index.Entry{Key: target, ID: id, IP: r.from.IP, Tags: publishedSourceTags(tags, r.from.IP)}
+			entryLoad, err = client.index.Add(index.KindSource, entry)
+		} else {
+			entryLoad, err = client.storeKeywordEntry(target, id, r.from.IP, tags)
+		}
+		if err != nil {
+			log.Printf("Kad1 entry from %s not stored: %s", r.from, err)
+			continue
+		}
+		load = entryLoad
+	}
+
+	packet, err := factory.GetPublish1Response(target, load)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err = client.reply(r, packet); err != nil {
+		log.Println(err)
+	}
+}
+
+func HandlePublishNotes1Request(client *Client, r *UDPRequest, w Response) {
+	handlePublishFileEntry(client, r, index.KindNotes, func(r *UDPRequest, target types.UInt128, load uint8) {
+		packet, err := factory.GetPublishNotes1Response(target, load)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if err = client.reply(r, packet); err != nil {
+			log.Println(err)
+		}
+	})
+}
+
+func HandleFirewalled1Request(client *Client, r *UDPRequest, w Response) {
+	tcpPort, err := r.body.ReadUInt16()
+	if err != nil {
+		log.Printf("Invalid Kad1 firewall request from %s: %s", r.from, err)
+		return
+	}
+
+	packet, err := factory.GetFirewalledResponse(r.from.IP)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err = client.reply(r, packet); err != nil {
+		log.Println(err)
+	}
+
+	client.spawn(func() { client.checkRequesterTCP(r, tcpPort) })
+}
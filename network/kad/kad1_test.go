@@ -0,0 +1,110 @@
+package kad
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sleepy/network/kad/index"
+	"sleepy/network/kad/packet/factory"
+	"sleepy/types"
+	"testing"
+	"time"
+)
+
+func newTestKad1Client() (*Client, *fakeManager) {
+	client, manager := newTestClient()
+	client.config.Kad1 = true
+	return client, manager
+}
+
+func TestClient_Kad1Disabled(t *testing.T) {
+	client, manager := newTestClient()
+	hello, _ := factory.GetHello1Request(factory.Hello1Details{ID: types.NewUInt128(1, 1), IP: net.IPv4(10, 0, 0, 1), UDPPort: 4672, TCPPort: 4662})
+
+	if err := client.handleUDP(hello.GetData(), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672}); err == nil {
+		t.Errorf("The Kad1 packets must be rejected")
+	}
+	if len(manager.sent) != 0 || client.router.CountPeers() != 0 {
+		t.Errorf("The Kad1 packets must be ignored")
+	}
+}
+
+func TestClient_Kad1Hello(t *testing.T) {
+	client, manager := newTestKad1Client()
+	senderId := types.NewUInt128(1, 1)
+	hello, _ := factory.GetHello1Request(factory.Hello1Details{ID: senderId, IP: net.IPv4(10, 0, 0, 1), UDPPort: 4672, TCPPort: 4662})
+
+	if err := client.handleUDP(hello.GetData(), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	responses := manager.sentWithCommand(CommKadHelloRes)
+	if len(responses) != 1 || len(responses[0].data) != 27 {
+		t.Fatalf("The Kad1 hello must be answered with a Kad1 hello")
+	}
+	peer, err := client.router.GetPeer(senderId)
+	if err != nil || peer == nil || peer.GetProtocolVersion() != kad1Version || peer.GetTCPPort() != 4662 {
+		t.Errorf("The sender must be added as a Kad1 contact")
+	}
+}
+
+func TestClient_Kad1Lookup(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		client, manager := newTestClient()
+		client.config.Kad1 = enabled
+		peer := newTestPeer(types.NewUInt128(1, 0), 1)
+		peer.SetProtocolVersion(kad1Version)
+		client.router.AddPeer(peer)
+
+		target := types.NewUInt128(2, 0)
+		manager.onSend = func(sent sentPacket) {
+			if sent.data[1] == CommKadReq {
+				response, _ := factory.GetKad1Response(target, nil)
+				go client.handleUDP(response.GetData(), &net.UDPAddr{IP: sent.ip, Port: int(sent.port)})
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		contacts, _ := client.Lookup(ctx, target)
+		cancel()
+		sent := len(manager.sentWithCommand(CommKadReq))
+		if enabled && (sent != 1 || len(contacts) != 1) {
+			t.Errorf("The Kad1 contact must be asked with a Kad1 request and answer, %d sent and %d answered", sent, len(contacts))
+		}
+		if !enabled && (sent != 0 || len(manager.sentWithCommand(CommKad2Req)) != 0) {
+			t.Errorf("The Kad1 contacts must not be asked while disabled")
+		}
+	}
+}
+
+func TestClient_Kad1PublishAndSearch(t *testing.T) {
+	client, manager := newTestKad1Client()
+	from := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672}
+	keyword := client.config.ClientID.Clone()
+
+	// The Kad1 keyword publishes have the layout of the Kad2 ones
+	publish, _ := factory.GetPublishKey2Request(keyword, []factory.KeywordEntry{
+		{FileHash: types.NewUInt128(0x1111, 0x2222), Name: "holidays.avi", Size: 0x1000},
+		{FileHash: types.NewUInt128(0x3333, 0x4444), Name: "holidays.mp3", Size: 0x1000},
+	})
+	data := publish.GetData()
+	data[1] = CommKadPublishReq
+	client.handleUDP(data, from)
+
+	if len(manager.sentWithCommand(CommKadPublishRes)) != 1 || client.index.Count(index.KindKeyword) != 2 {
+		t.Fatalf("The Kad1 keyword entries must be stored and acknowledged")
+	}
+
+	search := append([]byte{0xE4, CommKadSearchReq}, kadUInt128Bytes(keyword)...)
+	search = append(search, 1)
+	search = append(search, SearchWord("avi").Encode()...)
+	client.handleUDP(search, from)
+
+	responses := manager.sentWithCommand(CommKadSearchRes)
+	if len(responses) != 1 {
+		t.Fatalf("The Kad1 search must be answered with a Kad1 response")
+	}
+	if count := binary.LittleEndian.Uint16(responses[0].data[18:20]); count != 1 {
+		t.Errorf("Only the file matching the expression must be answered, got %d", count)
+	}
+}
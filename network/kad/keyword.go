@@ -0,0 +1,78 @@
+package kad
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sleepy/network/common/tag"
+	"sleepy/network/kad/common"
+	"sleepy/network/kad/index"
+	kadPacket "sleepy/network/kad/packet"
+	"sleepy/network/kad/packet/factory"
+	"sleepy/types"
+	"sleepy/utils/md4"
+	"strings"
+)
+
+// Max number of results of a keyword search, as the eMule SEARCHKEYWORD_TOTAL
+const maxKeywordResults = 300
+
+// KeywordResult is a file found by a keyword search
+type KeywordResult struct {
+	FileHash     types.UInt128
+	Name         string
+	Size         uint64
+	Type         string
+	Availability uint32
+	// Tags are all the tags received, including the media ones
+	Tags tag.List
+}
+
+// KeywordHash gets the Kad target of a keyword, the MD4 of his lowercase UTF-8 form
+func KeywordHash(keyword string) types.UInt128 {
+	sum := md4.Sum([]byte(strings.ToLower(keyword)))
+	hash, _ := types.NewUInt128FromByteArray(sum[:])
+	return hash
+}
+
+// SearchKeyword searches the files published under the first word of [keyword]. The results must match the
+// [expression] if provided, or contain all the words of [keyword] otherwise. The results are streamed through the
+// returned channel, that is closed when the search finishes
+func (client *Client) SearchKeyword(ctx context.Context, keyword string, expression *SearchExpression) (<-chan *KeywordResult, error) {
+	words := strings.Fields(strings.ToLower(keyword))
+	if len(words) == 0 {
+		return nil, errors.New("no keyword to search")
+	}
+
+	if expression == nil && len(words) > 1 {
+		expression = SearchWord(words[0])
+		for _, word := range words[1:] {
+			expression = SearchAnd(expression, SearchWord(word))
+		}
+	}
+
+	var encodedExpression []byte
+	if expression != nil {
+		encodedExpression = expression.Encode()
+	}
+
+	target := KeywordHash(words[0])
+	return streamSearch(client, ctx, index.KindKeyword, target, maxKeywordResults,
+		func(from *net.UDPAddr, answer types.UInt128, tags tag.List) (*KeywordResult, string, bool) {
+			return newKeywordResult(answer, tags), answer.ToHexString(), true
+		},
+		func(contact *Contact) (*kadPacket.Packet, error) {
+			return factory.GetSearchKey2Request(target, 0, encodedExpression)
+		})
+}
+
+func newKeywordResult(hash types.UInt128, tags tag.List) *KeywordResult {
+	result := &KeywordResult{FileHash: hash, Tags: tags}
+	result.Name, _ = tags.GetString(common.TagFileName)
+	result.Size, _ = tags.GetUInt(common.TagFileSize)
+	result.Type, _ = tags.GetString(common.TagFileType)
+	if sources, ok := tags.GetUInt(common.TagSources); ok {
+		result.Availability = uint32(sources)
+	}
+	return result
+}
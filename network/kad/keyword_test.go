@@ -0,0 +1,92 @@
+package kad
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"sleepy/network/kad/packet/factory"
+	kadTypes "sleepy/network/kad/types"
+	"sleepy/types"
+	"testing"
+	"time"
+)
+
+// Encode a 128 bits number as Kad does
+func kadUInt128Bytes(value types.UInt128) []byte {
+	data := value.ToBytes()
+	for word := 0; word < 16; word += 4 {
+		data[word], data[word+1], data[word+2], data[word+3] = data[word+3], data[word+2], data[word+1], data[word]
+	}
+	return data
+}
+
+func TestKeywordHash(t *testing.T) {
+	if KeywordHash("Test").ToHexString() != "db346d691d7acc4dc2625db19f9e3f52" {
+		t.Errorf("Keyword hash mismatch, got %s", KeywordHash("Test").ToHexString())
+	}
+}
+
+func TestSearchExpression_Encode(t *testing.T) {
+	expr := SearchAnd(SearchWord("ab"), SearchNumber(0x02, SearchGreater, 10))
+	expected := []byte{0x00, 0x00, 0x01, 0x02, 0x00, 'a', 'b', 0x03, 10, 0, 0, 0, 0x01, 0x01, 0x00, 0x02}
+	if !bytes.Equal(expr.Encode(), expected) {
+		t.Errorf("Expression encoding mismatch, got %v", expr.Encode())
+	}
+}
+
+func TestClient_SearchKeyword(t *testing.T) {
+	client, manager := newTestClient()
+	node := newTestPeer(types.NewUInt128(1, 2), 1)
+	client.router.AddPeer(node)
+	fileHash := types.NewUInt128(0x1111, 0x2222)
+
+	manager.onSend = func(sent sentPacket) {
+		target, _ := NewReader(sent.data[2:]).ReadUInt128()
+		var data []byte
+		switch sent.data[1] {
+		case CommKad2Req:
+			target, _ = NewReader(sent.data[3:]).ReadUInt128()
+			packet, _ := factory.GetKad2Response(target, []kadTypes.Peer{})
+			data = packet.GetData()
+		case CommKad2SearchKeyReq:
+			buffer := &bytes.Buffer{}
+			buffer.Write([]byte{0xE4, CommKad2SearchRes})
+			buffer.Write(kadUInt128Bytes(node.GetID()))
+			buffer.Write(kadUInt128Bytes(target))
+			binary.Write(buffer, binary.LittleEndian, uint16(1))
+			buffer.Write(kadUInt128Bytes(fileHash))
+			buffer.WriteByte(3)
+			buffer.Write([]byte{0x02, 0x01, 0x00, 0x01, 0x08, 0x00})
+			buffer.WriteString("file.avi")
+			buffer.Write([]byte{0x03, 0x01, 0x00, 0x02, 0x00, 0x10, 0x00, 0x00})
+			buffer.Write([]byte{0x09, 0x01, 0x00, 0x15, 0x07})
+			data = buffer.Bytes()
+		default:
+			return
+		}
+		go client.handleUDP(data, &net.UDPAddr{IP: sent.ip, Port: int(sent.port)})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	results, err := client.SearchKeyword(ctx, "File avi", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	found := make([]*KeywordResult, 0)
+	for result := range results {
+		found = append(found, result)
+	}
+
+	if len(found) != 1 {
+		t.Fatalf("A unique result must be found, %d found", len(found))
+	}
+	if !found[0].FileHash.Equal(fileHash) || found[0].Name != "file.avi" || found[0].Size != 0x1000 || found[0].Availability != 7 {
+		t.Errorf("Result mismatch: %+v", found[0])
+	}
+	if len(manager.sentWithCommand(CommKad2SearchKeyReq)) != 1 {
+		t.Errorf("The search request must be sent to the closest node")
+	}
+}
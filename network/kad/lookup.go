@@ -0,0 +1,327 @@
+package kad
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	kadPacket "sleepy/network/kad/packet"
+	"sleepy/network/kad/packet/factory"
+	kadTypes "sleepy/network/kad/types"
+	"sleepy/types"
+	"sort"
+	"time"
+)
+
+// Kinds of lookup, sent in the KADEMLIA2_REQ as the number of contacts requested
+const (
+	LookupFindValue = uint8(0x02)
+	LookupStore     = uint8(0x04)
+	LookupFindNode  = uint8(0x0B)
+)
+
+const (
+	lookupAlpha          = 3  // Number of parallel requests
+	lookupK              = 10 // Number of closest contacts the lookup converges on
+	lookupSeedPeers      = 50 // Number of router peers used to start the lookup
+	lookupRequestTimeout = 3 * time.Second
+	lookupLifetime       = 45 * time.Second // Max duration of the lookups started by the client itself
+)
+
+type lookupState int
+
+const (
+	lookupPending lookupState = iota
+	lookupQueried
+	lookupResponded
+	lookupFailed
+)
+
+type lookupContact struct {
+	*Contact
+	distance  types.UInt128
+	state     lookupState
+	queriedAt time.Time
+}
+
+type lookupResponse struct {
+	from     *net.UDPAddr
+	contacts []*Contact
+}
+
+// An iterative lookup of the contacts closest to a target
+type lookup struct {
+	client    *Client
+	target    types.UInt128
+	kind      uint8
+	contacts  []*lookupContact // Sorted by distance to the target
+	known     map[string]*lookupContact
+	responses chan lookupResponse
+	done      chan struct{}
+}
+
+func contactFromPeer(peer kadTypes.Peer) *Contact {
+	return &Contact{
+		ClientID: peer.GetID(),
+		IP:       peer.GetIP(),
+		UDPPort:  peer.GetUDPPort(),
+		TCPPort:  peer.GetTCPPort(),
+		Version:  peer.GetProtocolVersion(),
+	}
+}
+
+// Lookup runs an iterative node lookup and returns the closest contacts to the [target] that answered
+func (client *Client) Lookup(ctx context.Context, target types.UInt128) ([]*Contact, error) {
+	return client.lookup(ctx, target, LookupFindNode)
+}
+
+func (client *Client) lookup(ctx context.Context, target types.UInt128, kind uint8) ([]*Contact, error) {
+	l, err := client.newLookup(target, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer client.removeLookup(l)
+
+	ctx, cancel := client.withClient(ctx)
+	defer cancel()
+	return l.run(ctx), nil
+}
+
+// Create a lookup seeded with the closest router peers and register it to receive the responses
+func (client *Client) newLookup(target types.UInt128, kind uint8) (*lookup, error) {
+	l := &lookup{
+		client:    client,
+		target:    target.Clone(),
+		kind:      kind,
+		contacts:  make([]*lookupContact, 0),
+		known:     make(map[string]*lookupContact),
+		responses: make(chan lookupResponse),
+		done:      make(chan struct{}),
+	}
+
+	for _, peer := range client.router.GetClosestPeers(target, lookupSeedPeers) {
+		l.addContact(contactFromPeer(peer))
+	}
+	if len(l.contacts) == 0 {
+		return nil, errors.New("no contacts to start the lookup")
+	}
+
+	client.lookupsAccess.Lock()
+	defer client.lookupsAccess.Unlock()
+	key := target.ToHexString()
+	client.lookups[key] = append(client.lookups[key], l)
+	return l, nil
+}
+
+func (client *Client) removeLookup(l *lookup) {
+	client.lookupsAccess.Lock()
+	defer client.lookupsAccess.Unlock()
+	key := l.target.ToHexString()
+	lookups := client.lookups[key]
+	for i, running := range lookups {
+		if running == l {
+			lookups = append(lookups[:i:i], lookups[i+1:]...)
+			break
+		}
+	}
+	if len(lookups) == 0 {
+		delete(client.lookups, key)
+	} else {
+		client.lookups[key] = lookups
+	}
+}
+
+// Deliver the contacts received from [from] to the lookups running for [target], if any. Each lookup ignores the
+// responses of the contacts it didn't query
+func (client *Client) deliverLookupResponse(target types.UInt128, from *net.UDPAddr, contacts []*Contact) bool {
+	client.lookupsAccess.Lock()
+	lookups := client.lookups[target.ToHexString()]
+	client.lookupsAccess.Unlock()
+
+	delivered := false
+	for _, l := range lookups {
+		select {
+		case l.responses <- lookupResponse{from: from, contacts: contacts}:
+			delivered = true
+		case <-l.done:
+		}
+	}
+	return delivered
+}
+
+// Run a lookup in background, as the ones requested by the router to fill itself
+func (client *Client) backgroundLookup(target types.UInt128) {
+	ctx, cancel := context.WithTimeout(client.ctx, lookupLifetime)
+	defer cancel()
+
+	contacts, err := client.Lookup(ctx, target)
+	if err != nil {
+		log.Printf("Lookup of %s not run: %s", target.ToHexString(), err)
+	} else {
+		log.Printf("Lookup of %s finished with %d contacts", target.ToHexString(), len(contacts))
+	}
+}
+
+// Add a contact to the lookup candidates, keeping them sorted by distance
+func (l *lookup) addContact(contact *Contact) {
+	key := contact.ClientID.ToHexString()
+	if _, found := l.known[key]; found || contact.ClientID.Equal(l.client.config.ClientID) {
+		return
+	}
+
+	candidate := &lookupContact{
+		Contact:  contact,
+		distance: types.Xor(contact.ClientID, l.target),
+		state:    lookupPending,
+	}
+	l.known[key] = candidate
+
+	position := sort.Search(len(l.contacts), func(i int) bool {
+		return l.contacts[i].distance.Compare(candidate.distance) > 0
+	})
+	l.contacts = append(l.contacts, nil)
+	copy(l.contacts[position+1:], l.contacts[position:])
+	l.contacts[position] = candidate
+}
+
+func (l *lookup) run(ctx context.Context) []*Contact {
+	defer close(l.done)
+
+	ticker := time.NewTicker(lookupRequestTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		l.expireRequests()
+		l.queryNext()
+		if l.finished() {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return l.closestResponded()
+		case response := <-l.responses:
+			l.handleResponse(response)
+		case <-ticker.C:
+		}
+	}
+
+	return l.closestResponded()
+}
+
+// Send requests to the closest pending candidates while there are less than alpha in flight
+func (l *lookup) queryNext() {
+	inFlight := 0
+	for _, candidate := range l.contacts {
+		if candidate.state == lookupQueried {
+			inFlight++
+		}
+	}
+
+	considered := 0
+	for _, candidate := range l.contacts {
+		if inFlight >= lookupAlpha || considered >= lookupK {
+			return
+		}
+		if candidate.state == lookupFailed {
+			continue
+		}
+		considered++
+
+		if candidate.state == lookupPending {
+			if err := l.query(candidate); err != nil {
+				candidate.state = lookupFailed
+			} else {
+				inFlight++
+			}
+		}
+	}
+}
+
+// Ask a candidate for closer contacts, with a Kad1 request if it doesn't speak Kad2
+func (l *lookup) query(candidate *lookupContact) error {
+	var packet *kadPacket.Packet
+	var err error
+	reply := byte(CommKad2Res)
+	if candidate.speaksKad2() {
+		packet, err = factory.GetKad2Request(l.kind, l.target, candidate.ClientID)
+	} else if l.client.config.Kad1 {
+		packet, err = factory.GetKad1Request(l.kind, l.target, candidate.ClientID)
+		reply = CommKadRes
+	} else {
+		err = errors.New("Kad1 contacts are disabled")
+	}
+	if err != nil {
+		return err
+	}
+
+	// The reply is expected before sending, as it may arrive before the send returns. The contacts not answering in
+	// time are degraded
+	expected := l.client.trackReply(candidate.ClientID, candidate.IP, reply, l.target, lookupRequestTimeout)
+	if err = l.client.sendToContact(candidate.Contact, packet); err != nil {
+		l.client.cancelReply(expected, err)
+		return err
+	}
+	candidate.state = lookupQueried
+	candidate.queriedAt = time.Now()
+	return nil
+}
+
+// Mark as failed the candidates that didn't answer in time
+func (l *lookup) expireRequests() {
+	deadline := time.Now().Add(-lookupRequestTimeout)
+	for _, candidate := range l.contacts {
+		if candidate.state == lookupQueried && candidate.queriedAt.Before(deadline) {
+			candidate.state = lookupFailed
+		}
+	}
+}
+
+// The lookup finishes when the k closest candidates have answered or failed
+func (l *lookup) finished() bool {
+	considered := 0
+	for _, candidate := range l.contacts {
+		if considered >= lookupK {
+			break
+		}
+		switch candidate.state {
+		case lookupPending, lookupQueried:
+			return false
+		case lookupResponded:
+			considered++
+		}
+	}
+	return true
+}
+
+func (l *lookup) handleResponse(response lookupResponse) {
+	var responder *lookupContact
+	for _, candidate := range l.contacts {
+		if candidate.state == lookupQueried && candidate.IP.Equal(response.from.IP) && int(candidate.UDPPort) == response.from.Port {
+			responder = candidate
+			break
+		}
+	}
+	if responder == nil {
+		return
+	}
+
+	responder.state = lookupResponded
+	for _, contact := range response.contacts {
+		l.addContact(contact)
+	}
+}
+
+// Get the [lookupK] closest candidates that answered
+func (l *lookup) closestResponded() []*Contact {
+	closest := make([]*Contact, 0, lookupK)
+	for _, candidate := range l.contacts {
+		if len(closest) >= lookupK {
+			break
+		}
+		if candidate.state == lookupResponded {
+			closest = append(closest, candidate.Contact)
+		}
+	}
+	return closest
+}
@@ -0,0 +1,115 @@
+package kad
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sleepy/network/kad/packet/factory"
+	kadTypes "sleepy/network/kad/types"
+	"sleepy/types"
+	"testing"
+	"time"
+)
+
+func newTestPeer(id types.UInt128, lastIPByte byte) kadTypes.Peer {
+	peer := kadTypes.NewPeer(id)
+	peer.SetIP(net.IPv4(10, 0, 2, lastIPByte), true)
+	peer.SetUDPPort(4672)
+	peer.SetTCPPort(4662)
+	peer.SetProtocolVersion(8)
+	return peer
+}
+
+func TestClient_Lookup(t *testing.T) {
+	client, manager := newTestClient()
+	target := types.NewUInt128(0, 0x1000000000000000)
+
+	// Far seeds known by the router, each of them knows a closer node
+	network := make(map[string][]kadTypes.Peer)
+	for i := byte(1); i <= 3; i++ {
+		seed := newTestPeer(types.NewUInt128(uint64(i), 0xf000000000000000), i)
+		closer := newTestPeer(types.NewUInt128(uint64(i), 0x1000000000000000), 100+i)
+		client.router.AddPeer(seed)
+		network[seed.GetIP().String()] = []kadTypes.Peer{closer}
+		network[closer.GetIP().String()] = []kadTypes.Peer{}
+	}
+
+	manager.onSend = func(sent sentPacket) {
+		if sent.data[1] != CommKad2Req {
+			return
+		}
+		packet, err := factory.GetKad2Response(target, network[sent.ip.String()])
+		if err != nil {
+			t.Errorf("Unexpected error: %s", err)
+			return
+		}
+		go client.handleUDP(packet.GetData(), &net.UDPAddr{IP: sent.ip, Port: int(sent.port)})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	contacts, err := client.Lookup(ctx, target)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(contacts) != 6 {
+		t.Fatalf("All the 6 nodes must answer, %d found", len(contacts))
+	}
+	for i := 0; i < 3; i++ {
+		if contacts[i].IP.To4()[3] <= 100 {
+			t.Errorf("The closest contacts must be first, got %s in position %d", contacts[i].IP, i)
+		}
+	}
+	if client.router.CountPeers() != 6 {
+		t.Errorf("The discovered contacts must be added to the router, %d found", client.router.CountPeers())
+	}
+}
+
+func TestClient_LookupWithoutContacts(t *testing.T) {
+	client, _ := newTestClient()
+	if _, err := client.Lookup(context.Background(), types.NewUInt128FromInt(1)); err == nil {
+		t.Errorf("A lookup without contacts must fail")
+	}
+}
+
+func TestClient_LookupSendFailure(t *testing.T) {
+	client, manager := newTestClient()
+	peer := newTestPeer(types.NewUInt128(1, 0xf000000000000000), 1)
+	client.router.AddPeer(peer)
+	manager.sendErr = errors.New("network unreachable")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := client.Lookup(ctx, types.NewUInt128(0, 0x1000000000000000)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	client.replies.access.Lock()
+	expected := len(client.replies.expected[expectedReplyKey(peer.GetIP(), CommKad2Res)])
+	client.replies.access.Unlock()
+	if expected != 0 {
+		t.Errorf("No reply must be expected for the requests not sent, got %d", expected)
+	}
+}
+
+func TestClient_LookupExpectsBeforeSending(t *testing.T) {
+	client, manager := newTestClient()
+	peer := newTestPeer(types.NewUInt128(1, 0xf000000000000000), 1)
+	client.router.AddPeer(peer)
+
+	// A fast reply may be handled before the send returns
+	expectedOnSend := make(chan bool, 1)
+	manager.onSend = func(sent sentPacket) {
+		client.replies.access.Lock()
+		expectedOnSend <- len(client.replies.expected[expectedReplyKey(sent.ip, CommKad2Res)]) == 1
+		client.replies.access.Unlock()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	client.Lookup(ctx, types.NewUInt128(0, 0x1000000000000000))
+	if !<-expectedOnSend {
+		t.Errorf("The reply must be expected when the request is sent")
+	}
+}
@@ -0,0 +1,46 @@
+package message
+
+import (
+	netCommon "sleepy/network/common"
+	"sleepy/types"
+)
+
+// BootstrapRequest asks a node for contacts to join the network, as KADEMLIA2_BOOTSTRAP_REQ. It has no fields
+type BootstrapRequest struct{}
+
+func (m *BootstrapRequest) Decode(data []byte) error {
+	return nil
+}
+
+func (m *BootstrapRequest) Encode() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// BootstrapResponse carries the sender details and some of his contacts, as KADEMLIA2_BOOTSTRAP_RES
+type BootstrapResponse struct {
+	ClientID types.UInt128
+	TCPPort  uint16
+	Version  uint8
+	Contacts []Contact
+}
+
+func (m *BootstrapResponse) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.ClientID = d.uint128()
+	m.TCPPort = d.uint16()
+	m.Version = d.uint8()
+	m.Contacts = d.contacts(int(d.uint16()))
+	return d.err
+}
+
+func (m *BootstrapResponse) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteUInt128(m.ClientID)
+		writer.WriteUInt16(m.TCPPort)
+		writer.WriteUInt8(m.Version)
+		writer.WriteUInt16(uint16(len(m.Contacts)))
+		for _, contact := range m.Contacts {
+			WriteContact(writer, contact)
+		}
+	})
+}
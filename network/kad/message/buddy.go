@@ -0,0 +1,54 @@
+package message
+
+import (
+	netCommon "sleepy/network/common"
+	"sleepy/types"
+)
+
+// FindBuddy asks an open node to be the buddy of a firewalled client, or accepts it, as KADEMLIA_FINDBUDDY_REQ and
+// KADEMLIA_FINDBUDDY_RES. The [BuddyID] is the inverted Kad ID of the firewalled client
+type FindBuddy struct {
+	BuddyID  types.UInt128
+	UserHash types.UInt128
+	TCPPort  uint16
+}
+
+func (m *FindBuddy) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.BuddyID = d.uint128()
+	m.UserHash = d.uint128()
+	m.TCPPort = d.uint16()
+	return d.err
+}
+
+func (m *FindBuddy) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteUInt128(m.BuddyID)
+		writer.WriteUInt128(m.UserHash)
+		writer.WriteUInt16(m.TCPPort)
+	})
+}
+
+// CallbackRequest asks the buddy of a firewalled source to relay him that the sender wants to receive the [FileID]
+// through his [TCPPort], as KADEMLIA_CALLBACK_REQ
+type CallbackRequest struct {
+	BuddyID types.UInt128
+	FileID  types.UInt128
+	TCPPort uint16
+}
+
+func (m *CallbackRequest) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.BuddyID = d.uint128()
+	m.FileID = d.uint128()
+	m.TCPPort = d.uint16()
+	return d.err
+}
+
+func (m *CallbackRequest) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteUInt128(m.BuddyID)
+		writer.WriteUInt128(m.FileID)
+		writer.WriteUInt16(m.TCPPort)
+	})
+}
@@ -0,0 +1,81 @@
+package message
+
+import (
+	"net"
+	netCommon "sleepy/network/common"
+	"sleepy/types"
+)
+
+// FirewalledRequest asks a node to check if our [TCPPort] is reachable, as KADEMLIA_FIREWALLED2_REQ
+type FirewalledRequest struct {
+	TCPPort        uint16
+	UserHash       types.UInt128
+	ConnectOptions uint8
+}
+
+func (m *FirewalledRequest) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.TCPPort = d.uint16()
+	m.UserHash = d.uint128()
+	m.ConnectOptions = d.uint8()
+	return d.err
+}
+
+func (m *FirewalledRequest) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteUInt16(m.TCPPort)
+		writer.WriteUInt128(m.UserHash)
+		writer.WriteUInt8(m.ConnectOptions)
+	})
+}
+
+// FirewalledResponse tells the requester of a firewall check the [IP] his request came from, as
+// KADEMLIA_FIREWALLED_RES
+type FirewalledResponse struct {
+	IP net.IP
+}
+
+func (m *FirewalledResponse) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.IP = d.ipv4()
+	return d.err
+}
+
+func (m *FirewalledResponse) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteIPv4(m.IP)
+	})
+}
+
+// FirewalledAck confirms the requester of a firewall check that his TCP port is reachable, as
+// KADEMLIA_FIREWALLED_ACK_RES. It has no fields
+type FirewalledAck struct{}
+
+func (m *FirewalledAck) Decode(data []byte) error {
+	return nil
+}
+
+func (m *FirewalledAck) Encode() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// FirewallUDP is sent unrequested to the checked UDP [Port], failing the check if an [ErrorCode] is set, as
+// KADEMLIA2_FIREWALLUDP
+type FirewallUDP struct {
+	ErrorCode uint8
+	Port      uint16
+}
+
+func (m *FirewallUDP) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.ErrorCode = d.uint8()
+	m.Port = d.uint16()
+	return d.err
+}
+
+func (m *FirewallUDP) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteUInt8(m.ErrorCode)
+		writer.WriteUInt16(m.Port)
+	})
+}
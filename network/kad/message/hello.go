@@ -0,0 +1,59 @@
+package message
+
+import (
+	netCommon "sleepy/network/common"
+	"sleepy/network/common/tag"
+	"sleepy/types"
+)
+
+// Hello announces the sender to a node, as KADEMLIA2_HELLO_REQ and KADEMLIA2_HELLO_RES. The tags carry his UDP port
+// and the misc options
+type Hello struct {
+	ClientID types.UInt128
+	TCPPort  uint16
+	Version  uint8
+	Tags     tag.List
+}
+
+func (m *Hello) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.ClientID = d.uint128()
+	m.TCPPort = d.uint16()
+	m.Version = d.uint8()
+	m.Tags = d.tags()
+	return d.err
+}
+
+func (m *Hello) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteUInt128(m.ClientID)
+		writer.WriteUInt16(m.TCPPort)
+		writer.WriteUInt8(m.Version)
+		writer.WriteTags(m.Tags)
+	})
+}
+
+// HelloAck acknowledges a hello response that requested it, verifying the IP of the sender, as
+// KADEMLIA2_HELLO_RES_ACK
+type HelloAck struct {
+	ClientID types.UInt128
+	Tags     tag.List
+}
+
+func (m *HelloAck) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.ClientID = d.uint128()
+	m.Tags = nil
+	// Some clients only send the ID
+	if len(d.remaining()) > 0 {
+		m.Tags = d.tags()
+	}
+	return d.err
+}
+
+func (m *HelloAck) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteUInt128(m.ClientID)
+		writer.WriteTags(m.Tags)
+	})
+}
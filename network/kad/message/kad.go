@@ -0,0 +1,53 @@
+package message
+
+import (
+	netCommon "sleepy/network/common"
+	"sleepy/types"
+)
+
+// KadRequest asks the [Receiver] for the closest contacts to the [Target], as KADEMLIA2_REQ. The low five bits of
+// the [Kind] are the number of contacts wanted
+type KadRequest struct {
+	Kind     uint8
+	Target   types.UInt128
+	Receiver types.UInt128
+}
+
+func (m *KadRequest) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.Kind = d.uint8()
+	m.Target = d.uint128()
+	m.Receiver = d.uint128()
+	return d.err
+}
+
+func (m *KadRequest) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteUInt8(m.Kind)
+		writer.WriteUInt128(m.Target)
+		writer.WriteUInt128(m.Receiver)
+	})
+}
+
+// KadResponse answers a KadRequest with the closest contacts to the [Target], as KADEMLIA2_RES
+type KadResponse struct {
+	Target   types.UInt128
+	Contacts []Contact
+}
+
+func (m *KadResponse) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.Target = d.uint128()
+	m.Contacts = d.contacts(int(d.uint8()))
+	return d.err
+}
+
+func (m *KadResponse) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteUInt128(m.Target)
+		writer.WriteUInt8(uint8(len(m.Contacts)))
+		for _, contact := range m.Contacts {
+			WriteContact(writer, contact)
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package message
+
+import (
+	"net"
+	netCommon "sleepy/network/common"
+	"sleepy/network/common/tag"
+	"sleepy/types"
+)
+
+// Message is the payload of a Kad packet, without the protocol and opcode bytes
+type Message interface {
+	// Decode parses the [data] into the message. The bytes after the known fields are ignored, as eMule does
+	Decode(data []byte) error
+	Encode() ([]byte, error)
+}
+
+// Contact is an entry of the Kad contact lists
+type Contact struct {
+	ClientID types.UInt128
+	IP       net.IP
+	UDPPort  uint16
+	TCPPort  uint16
+	Version  uint8
+}
+
+// ReadContact reads a contact list entry
+func ReadContact(reader *netCommon.Reader) (Contact, error) {
+	d := decoder{reader: reader}
+	contact := d.contact()
+	return contact, d.err
+}
+
+// WriteContact writes a contact list entry
+func WriteContact(writer *netCommon.Writer, contact Contact) {
+	writer.WriteUInt128(contact.ClientID)
+	writer.WriteIPv4(contact.IP)
+	writer.WriteUInt16(contact.UDPPort)
+	writer.WriteUInt16(contact.TCPPort)
+	writer.WriteUInt8(contact.Version)
+}
+
+// Reads the fields of a message keeping the first error, so the decoders only check it once at the end
+type decoder struct {
+	reader *netCommon.Reader
+	err    error
+}
+
+func newDecoder(data []byte) *decoder {
+	return &decoder{reader: netCommon.NewReader(data)}
+}
+
+func (d *decoder) uint8() uint8 {
+	if d.err != nil {
+		return 0
+	}
+	var value uint8
+	value, d.err = d.reader.ReadUInt8()
+	return value
+}
+
+func (d *decoder) uint16() uint16 {
+	if d.err != nil {
+		return 0
+	}
+	var value uint16
+	value, d.err = d.reader.ReadUInt16()
+	return value
+}
+
+func (d *decoder) uint64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	var value uint64
+	value, d.err = d.reader.ReadUInt64()
+	return value
+}
+
+func (d *decoder) uint128() types.UInt128 {
+	if d.err != nil {
+		return nil
+	}
+	var value types.UInt128
+	value, d.err = d.reader.ReadUInt128()
+	return value
+}
+
+func (d *decoder) ipv4() net.IP {
+	if d.err != nil {
+		return nil
+	}
+	var value net.IP
+	value, d.err = d.reader.ReadIPv4()
+	return value
+}
+
+func (d *decoder) tags() tag.List {
+	if d.err != nil {
+		return nil
+	}
+	var value tag.List
+	value, d.err = d.reader.ReadTags()
+	return value
+}
+
+func (d *decoder) contact() Contact {
+	return Contact{ClientID: d.uint128(), IP: d.ipv4(), UDPPort: d.uint16(), TCPPort: d.uint16(), Version: d.uint8()}
+}
+
+// Read [count] contacts
+func (d *decoder) contacts(count int) []Contact {
+	contacts := make([]Contact, 0, count)
+	for ; count > 0 && d.err == nil; count-- {
+		contact := d.contact()
+		if d.err == nil {
+			contacts = append(contacts, contact)
+		}
+	}
+	return contacts
+}
+
+// The bytes not read yet
+func (d *decoder) remaining() []byte {
+	if d.err != nil {
+		return nil
+	}
+	return d.reader.Remaining()
+}
+
+// Encode the fields written by [write]
+func encode(write func(writer *netCommon.Writer)) ([]byte, error) {
+	writer := netCommon.NewWriter()
+	write(writer)
+	if err := writer.Err(); err != nil {
+		return nil, err
+	}
+	return writer.Bytes(), nil
+}
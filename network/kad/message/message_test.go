@@ -0,0 +1,129 @@
+package message
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Kad IDs as they travel, four little endian 32 bits words
+const (
+	wireID    = "04030201080706050c0b0a09100f0e0d" // 0102030405060708090a0b0c0d0e0f10
+	wireOther = "11111111222222223333333344444444"
+	// Contact 192.168.1.2, UDP 4672, TCP 4662, version 8
+	wireContact   = wireOther + "0201a8c0" + "4012" + "3612" + "08"
+	wireNameTag   = "02" + "0100" + "01" + "0c00" + "686f6c69646179732e617669" // "holidays.avi"
+	wireHelloTags = "02" + "08" + "0100" + "fc" + "4012" + "09" + "0100" + "f2" + "04"
+)
+
+func decodeHex(t *testing.T, parts ...string) []byte {
+	data, err := hex.DecodeString(strings.Join(parts, ""))
+	if err != nil {
+		t.Fatalf("Invalid test data: %s", err)
+	}
+	return data
+}
+
+func TestMessages_RoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		msg  Message
+		data []string
+	}{
+		{"bootstrap response", &BootstrapResponse{}, []string{wireID, "3612", "09", "0100", wireContact}},
+		{"hello", &Hello{}, []string{wireID, "3612", "09", wireHelloTags}},
+		{"hello ack", &HelloAck{}, []string{wireID, "00"}},
+		{"kad request", &KadRequest{}, []string{"0b", wireID, wireOther}},
+		{"kad response", &KadResponse{}, []string{wireID, "01", wireContact}},
+		{"keyword search", &SearchKeyRequest{}, []string{wireID, "0080", "01", "0400", "74657374"}},
+		{"source search", &SearchSourceRequest{}, []string{wireID, "0000", "0000100000000000"}},
+		{"notes search", &SearchNotesRequest{}, []string{wireID, "0000100000000000"}},
+		{"search response", &SearchResponse{}, []string{wireID, wireOther, "0100", wireOther, "01", wireNameTag}},
+		{"keyword publish", &PublishKeyRequest{}, []string{wireID, "0100", wireOther, "01", wireNameTag}},
+		{"source publish", &PublishSourceRequest{}, []string{wireID, wireOther, "02", "090100ff01", "080100fd3612"}},
+		{"notes publish", &PublishNotesRequest{}, []string{wireID, wireOther, "01", wireNameTag}},
+		{"publish response", &PublishResponse{}, []string{wireID, "32"}},
+		{"pong", &Pong{}, []string{"4012"}},
+		{"firewalled request", &FirewalledRequest{}, []string{"3612", wireOther, "00"}},
+		{"firewalled response", &FirewalledResponse{}, []string{"0201a8c0"}},
+		{"firewall UDP", &FirewallUDP{}, []string{"00", "4012"}},
+		{"find buddy", &FindBuddy{}, []string{wireID, wireOther, "3612"}},
+		{"callback", &CallbackRequest{}, []string{wireID, wireOther, "3612"}},
+	}
+
+	for _, c := range cases {
+		data := decodeHex(t, c.data...)
+		if err := c.msg.Decode(data); err != nil {
+			t.Errorf("The %s must be decoded: %s", c.name, err)
+			continue
+		}
+		encoded, err := c.msg.Encode()
+		if err != nil {
+			t.Errorf("The %s must be encoded: %s", c.name, err)
+			continue
+		}
+		if !bytes.Equal(encoded, data) {
+			t.Errorf("The %s must be encoded to the same bytes, got %x", c.name, encoded)
+		}
+	}
+}
+
+func TestHello_Decode(t *testing.T) {
+	var hello Hello
+	if err := hello.Decode(decodeHex(t, wireID, "3612", "09", wireHelloTags)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if hello.ClientID.ToHexString() != "0102030405060708090a0b0c0d0e0f10" {
+		t.Errorf("Unexpected client ID %s", hello.ClientID.ToHexString())
+	}
+	if hello.TCPPort != 4662 || hello.Version != 9 {
+		t.Errorf("Unexpected hello %+v", hello)
+	}
+	if port, ok := hello.Tags.GetUInt(0xfc); !ok || port != 4672 {
+		t.Errorf("The UDP port tag must be decoded, got %d", port)
+	}
+	if options, ok := hello.Tags.GetUInt(0xf2); !ok || options != 4 {
+		t.Errorf("The misc options tag must be decoded, got %d", options)
+	}
+}
+
+func TestKadResponse_Decode(t *testing.T) {
+	var response KadResponse
+	if err := response.Decode(decodeHex(t, wireID, "01", wireContact)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(response.Contacts) != 1 {
+		t.Fatalf("One contact must be decoded, got %d", len(response.Contacts))
+	}
+	contact := response.Contacts[0]
+	if !contact.IP.Equal(net.IPv4(192, 168, 1, 2)) || contact.UDPPort != 4672 || contact.TCPPort != 4662 || contact.Version != 8 {
+		t.Errorf("Unexpected contact %+v", contact)
+	}
+
+	// The contacts announced but not sent make the message invalid
+	if err := response.Decode(decodeHex(t, wireID, "02", wireContact)); err == nil {
+		t.Errorf("A truncated contact list must fail")
+	}
+}
+
+func TestSearchKeyRequest_Expression(t *testing.T) {
+	var request SearchKeyRequest
+	if err := request.Decode(decodeHex(t, wireID, "0580", "01", "0400", "74657374")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if request.StartPosition != 5 {
+		t.Errorf("The expression flag must be removed from the start position, got %d", request.StartPosition)
+	}
+	if !bytes.Equal(request.Expression, decodeHex(t, "01", "0400", "74657374")) {
+		t.Errorf("Unexpected expression %x", request.Expression)
+	}
+
+	if err := request.Decode(decodeHex(t, wireID, "0500", "ff")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if request.Expression != nil {
+		t.Errorf("Without the flag the trailing bytes aren't an expression")
+	}
+}
@@ -0,0 +1,31 @@
+package message
+
+import netCommon "sleepy/network/common"
+
+// Ping asks a node for the UDP port it sees us from, as KADEMLIA2_PING. It has no fields
+type Ping struct{}
+
+func (m *Ping) Decode(data []byte) error {
+	return nil
+}
+
+func (m *Ping) Encode() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Pong answers a ping with the UDP [Port] the sender has been seen from, as KADEMLIA2_PONG
+type Pong struct {
+	Port uint16
+}
+
+func (m *Pong) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.Port = d.uint16()
+	return d.err
+}
+
+func (m *Pong) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteUInt16(m.Port)
+	})
+}
@@ -0,0 +1,101 @@
+package message
+
+import (
+	netCommon "sleepy/network/common"
+	"sleepy/network/common/tag"
+	"sleepy/types"
+)
+
+// KeywordEntry is a file announced under a keyword with his tags
+type KeywordEntry struct {
+	FileHash types.UInt128
+	Tags     tag.List
+}
+
+// PublishKeyRequest announces the [Entries] under the [Keyword], as KADEMLIA2_PUBLISH_KEY_REQ
+type PublishKeyRequest struct {
+	Keyword types.UInt128
+	Entries []KeywordEntry
+}
+
+func (m *PublishKeyRequest) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.Keyword = d.uint128()
+	count := int(d.uint16())
+	m.Entries = make([]KeywordEntry, 0, count)
+	for ; count > 0 && d.err == nil; count-- {
+		entry := KeywordEntry{FileHash: d.uint128(), Tags: d.tags()}
+		if d.err == nil {
+			m.Entries = append(m.Entries, entry)
+		}
+	}
+	return d.err
+}
+
+func (m *PublishKeyRequest) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteUInt128(m.Keyword)
+		writer.WriteUInt16(uint16(len(m.Entries)))
+		for _, entry := range m.Entries {
+			writer.WriteUInt128(entry.FileHash)
+			writer.WriteTags(entry.Tags)
+		}
+	})
+}
+
+// PublishSourceRequest announces the [Source] as sharing the file with the [FileHash], as
+// KADEMLIA2_PUBLISH_SOURCE_REQ
+type PublishSourceRequest struct {
+	FileHash types.UInt128
+	Source   types.UInt128
+	Tags     tag.List
+}
+
+func (m *PublishSourceRequest) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.FileHash = d.uint128()
+	m.Source = d.uint128()
+	m.Tags = d.tags()
+	return d.err
+}
+
+func (m *PublishSourceRequest) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteUInt128(m.FileHash)
+		writer.WriteUInt128(m.Source)
+		writer.WriteTags(m.Tags)
+	})
+}
+
+// PublishNotesRequest announces the note of the [Source] about the file with the [FileHash], as
+// KADEMLIA2_PUBLISH_NOTES_REQ. It has the layout of the source publishes
+type PublishNotesRequest PublishSourceRequest
+
+func (m *PublishNotesRequest) Decode(data []byte) error {
+	return (*PublishSourceRequest)(m).Decode(data)
+}
+
+func (m *PublishNotesRequest) Encode() ([]byte, error) {
+	return (*PublishSourceRequest)(m).Encode()
+}
+
+// PublishResponse acknowledges a publish of the [Target], informing the [Load] percentage of the index of the
+// sender, as KADEMLIA2_PUBLISH_RES
+type PublishResponse struct {
+	Target types.UInt128
+	Load   uint8
+}
+
+func (m *PublishResponse) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.Target = d.uint128()
+	m.Load = d.uint8()
+	return d.err
+}
+
+func (m *PublishResponse) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteUInt128(m.Target)
+		writer.WriteUInt8(m.Load)
+	})
+}
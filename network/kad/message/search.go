@@ -0,0 +1,137 @@
+package message
+
+import (
+	netCommon "sleepy/network/common"
+	"sleepy/network/common/tag"
+	"sleepy/types"
+)
+
+// Flag of the start position announcing that a search expression follows
+const searchExpressionFlag = 0x8000
+
+// SearchKeyRequest searches the files published under the [Keyword], as KADEMLIA2_SEARCH_KEY_REQ. The [Expression]
+// is the encoded search expression filtering them, empty if there is none
+type SearchKeyRequest struct {
+	Keyword       types.UInt128
+	StartPosition uint16
+	Expression    []byte
+}
+
+func (m *SearchKeyRequest) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.Keyword = d.uint128()
+	start := d.uint16()
+	m.StartPosition = start &^ searchExpressionFlag
+	m.Expression = nil
+	if start&searchExpressionFlag != 0 {
+		m.Expression = d.remaining()
+	}
+	return d.err
+}
+
+func (m *SearchKeyRequest) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteUInt128(m.Keyword)
+		start := m.StartPosition &^ searchExpressionFlag
+		if len(m.Expression) > 0 {
+			start |= searchExpressionFlag
+		}
+		writer.WriteUInt16(start)
+		writer.WriteBytes(m.Expression)
+	})
+}
+
+// SearchSourceRequest searches the sources of the file with the [FileHash] and [FileSize], as
+// KADEMLIA2_SEARCH_SOURCE_REQ
+type SearchSourceRequest struct {
+	FileHash      types.UInt128
+	StartPosition uint16
+	FileSize      uint64
+}
+
+func (m *SearchSourceRequest) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.FileHash = d.uint128()
+	m.StartPosition = d.uint16() &^ searchExpressionFlag
+	m.FileSize = d.uint64()
+	return d.err
+}
+
+func (m *SearchSourceRequest) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteUInt128(m.FileHash)
+		writer.WriteUInt16(m.StartPosition)
+		writer.WriteUInt64(m.FileSize)
+	})
+}
+
+// SearchNotesRequest searches the notes of the file with the [FileHash] and [FileSize], as
+// KADEMLIA2_SEARCH_NOTES_REQ
+type SearchNotesRequest struct {
+	FileHash types.UInt128
+	FileSize uint64
+}
+
+func (m *SearchNotesRequest) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.FileHash = d.uint128()
+	m.FileSize = d.uint64()
+	return d.err
+}
+
+func (m *SearchNotesRequest) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteUInt128(m.FileHash)
+		writer.WriteUInt64(m.FileSize)
+	})
+}
+
+// SearchEntry is an answer of a search response with his tags
+type SearchEntry struct {
+	Answer types.UInt128
+	Tags   tag.List
+}
+
+// SearchResponse answers a search of the [Target] with the [Entries] found by the [Sender], as KADEMLIA2_SEARCH_RES
+type SearchResponse struct {
+	Sender  types.UInt128
+	Target  types.UInt128
+	Entries []SearchEntry
+}
+
+func (m *SearchResponse) Decode(data []byte) error {
+	d := newDecoder(data)
+	m.Sender = d.uint128()
+	m.Target = d.uint128()
+	m.Entries = d.searchEntries()
+	return d.err
+}
+
+func (m *SearchResponse) Encode() ([]byte, error) {
+	return encode(func(writer *netCommon.Writer) {
+		writer.WriteUInt128(m.Sender)
+		writer.WriteUInt128(m.Target)
+		WriteSearchEntries(writer, m.Entries)
+	})
+}
+
+// WriteSearchEntries writes the entries of a search response preceded by their count
+func WriteSearchEntries(writer *netCommon.Writer, entries []SearchEntry) {
+	writer.WriteUInt16(uint16(len(entries)))
+	for _, entry := range entries {
+		writer.WriteUInt128(entry.Answer)
+		writer.WriteTags(entry.Tags)
+	}
+}
+
+func (d *decoder) searchEntries() []SearchEntry {
+	count := int(d.uint16())
+	entries := make([]SearchEntry, 0, count)
+	for ; count > 0 && d.err == nil; count-- {
+		entry := SearchEntry{Answer: d.uint128(), Tags: d.tags()}
+		if d.err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
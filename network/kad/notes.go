@@ -0,0 +1,97 @@
+package kad
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sleepy/network/common/tag"
+	"sleepy/network/kad/common"
+	"sleepy/network/kad/index"
+	kadPacket "sleepy/network/kad/packet"
+	"sleepy/network/kad/packet/factory"
+	"sleepy/types"
+	"unicode/utf8"
+)
+
+const (
+	maxNoteResults    = 150 // As the eMule KADEMLIAMAXNOTESPERFILE
+	maxNoteRating     = 5
+	maxNoteCommentLen = 128 // Max characters of a comment, as the eMule MAXFILECOMMENTLEN
+)
+
+// Note is the rating and comment of a file
+type Note struct {
+	FileName string
+	FileSize uint64
+	// Rating from 1 (fake) to 5 (excellent), 0 if not rated
+	Rating  uint8
+	Comment string
+}
+
+// NoteResult is a note published by a peer, found by a notes search
+type NoteResult struct {
+	Note
+	SourceID types.UInt128
+	// IP of the peer that published the note, if known
+	IP   net.IP
+	Tags tag.List
+}
+
+// SearchNotes searches the notes published about the file with the [fileHash] and [fileSize]. The notes are streamed
+// through the returned channel, that is closed when the search finishes
+func (client *Client) SearchNotes(ctx context.Context, fileHash types.UInt128, fileSize uint64) (<-chan *NoteResult, error) {
+	return streamSearch(client, ctx, index.KindNotes, fileHash, maxNoteResults,
+		func(from *net.UDPAddr, answer types.UInt128, tags tag.List) (*NoteResult, string, bool) {
+			return newNoteResult(answer, tags), answer.ToHexString(), true
+		},
+		func(contact *Contact) (*kadPacket.Packet, error) {
+			return factory.GetSearchNotes2Request(fileHash, fileSize)
+		})
+}
+
+// PublishNote announces our note about the file with the [fileHash] to the closest nodes, returning how many of them
+// acknowledged it
+func (client *Client) PublishNote(ctx context.Context, fileHash types.UInt128, note Note) (int, error) {
+	if note.FileName == "" {
+		return 0, errors.New("notes need the file name")
+	}
+	if note.Rating > maxNoteRating {
+		return 0, errors.New("invalid note rating")
+	}
+	if utf8.RuneCountInString(note.Comment) > maxNoteCommentLen {
+		return 0, errors.New("note comment too long")
+	}
+	if note.Rating == 0 && note.Comment == "" {
+		return 0, errors.New("empty note")
+	}
+
+	source := client.sourceID()
+	details := factory.NoteDetails{
+		FileName: note.FileName,
+		FileSize: note.FileSize,
+		Rating:   note.Rating,
+		Comment:  note.Comment,
+	}
+
+	_, responses := client.publish(ctx, index.KindNotes, fileHash, func(contact *Contact) (*kadPacket.Packet, error) {
+		return factory.GetPublishNotes2Request(fileHash, source, details)
+	})
+	if responses == 0 {
+		return 0, errors.New("no node acknowledged the note")
+	}
+	return responses, nil
+}
+
+func newNoteResult(sourceId types.UInt128, tags tag.List) *NoteResult {
+	result := &NoteResult{SourceID: sourceId, Tags: tags}
+	result.FileName, _ = tags.GetString(common.TagFileName)
+	result.FileSize, _ = tags.GetUInt(common.TagFileSize)
+	result.Comment, _ = tags.GetString(common.TagDescription)
+	if rating, ok := tags.GetUInt(common.TagFileRating); ok && rating <= maxNoteRating {
+		result.Rating = uint8(rating)
+	}
+	if ip, ok := tags.GetUInt(common.TagSourceIP); ok {
+		result.IP = tagIntAsIP(ip)
+	}
+	return result
+}
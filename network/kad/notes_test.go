@@ -0,0 +1,122 @@
+package kad
+
+import (
+	"context"
+	"net"
+	"sleepy/network/kad/index"
+	kadPacket "sleepy/network/kad/packet"
+	"sleepy/network/kad/packet/factory"
+	"sleepy/types"
+	"testing"
+	"time"
+)
+
+// Connect two test clients, delivering the packets sent by each one to the other
+func connectTestClients(first *Client, firstManager *fakeManager, firstAddr *net.UDPAddr, second *Client, secondManager *fakeManager, secondAddr *net.UDPAddr) {
+	firstManager.onSend = func(sent sentPacket) {
+		go second.handleUDP(sent.data, firstAddr)
+	}
+	secondManager.onSend = func(sent sentPacket) {
+		go first.handleUDP(sent.data, secondAddr)
+	}
+}
+
+func TestClient_PublishAndSearchNotes(t *testing.T) {
+	client, manager := newTestClient()
+	nodeManager := &fakeManager{}
+	node := NewClient(Config{ClientID: types.NewUInt128(0x1111, 0x2222), UdpPort: 4672, TcpPort: 4662}, nodeManager)
+	nodePeer := newTestPeer(node.config.ClientID, 1)
+	client.router.AddPeer(nodePeer)
+	connectTestClients(client, manager, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 100), Port: 4672},
+		node, nodeManager, &net.UDPAddr{IP: nodePeer.GetIP(), Port: int(nodePeer.GetUDPPort())})
+
+	fileHash := node.config.ClientID.Clone()
+	note := Note{FileName: "holidays.avi", FileSize: 0x1000, Rating: 4, Comment: "Good quality"}
+
+	if _, err := client.PublishNote(context.Background(), fileHash, Note{FileName: "holidays.avi", Rating: 9}); err == nil {
+		t.Errorf("Invalid ratings must be refused")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	stored, err := client.PublishNote(ctx, fileHash, note)
+	if err != nil || stored != 1 {
+		t.Fatalf("The note must be stored in the node: %v", err)
+	}
+	if node.index.Count(index.KindNotes) != 1 {
+		t.Fatalf("The node must store the note")
+	}
+
+	results, err := client.SearchNotes(ctx, fileHash, 0x1000)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	found := make([]*NoteResult, 0)
+	for result := range results {
+		found = append(found, result)
+	}
+
+	if len(found) != 1 {
+		t.Fatalf("A unique note must be found, %d found", len(found))
+	}
+	if found[0].Note != note || !found[0].SourceID.Equal(client.config.ClientID) || !found[0].IP.Equal(net.IPv4(10, 0, 0, 100)) {
+		t.Errorf("Note mismatch: %+v", found[0])
+	}
+}
+
+func TestClient_NotesAlongSources(t *testing.T) {
+	client, manager := newTestClient()
+	nodeManager := &fakeManager{}
+	node := NewClient(Config{ClientID: types.NewUInt128(0x1111, 0x2222), UdpPort: 4672, TcpPort: 4662}, nodeManager)
+	nodePeer := newTestPeer(node.config.ClientID, 1)
+	client.router.AddPeer(nodePeer)
+	connectTestClients(client, manager, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 100), Port: 4672},
+		node, nodeManager, &net.UDPAddr{IP: nodePeer.GetIP(), Port: int(nodePeer.GetUDPPort())})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	fileHash := node.config.ClientID.Clone()
+
+	// The source and notes operations of a file share the target, they run at once
+	sourceResponses := make(chan int, 1)
+	go func() {
+		_, responses := client.publish(ctx, index.KindSource, fileHash, func(contact *Contact) (*kadPacket.Packet, error) {
+			details := factory.SourceDetails{Type: SourceTypeOpen, TCPPort: 4662, UDPPort: 4672, FileSize: 0x1000}
+			return factory.GetPublishSource2Request(fileHash, client.sourceID(), details)
+		})
+		sourceResponses <- responses
+	}()
+	note := Note{FileName: "holidays.avi", FileSize: 0x1000, Rating: 4}
+	if stored, err := client.PublishNote(ctx, fileHash, note); err != nil || stored != 1 {
+		t.Fatalf("The note must be stored in the node: %v", err)
+	}
+	if responses := <-sourceResponses; responses != 1 {
+		t.Fatalf("The source must be stored in the node, got %d responses", responses)
+	}
+
+	sources, err := client.SearchSources(ctx, fileHash, 0x1000)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	notes, err := client.SearchNotes(ctx, fileHash, 0x1000)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	foundNotes := 0
+	for result := range notes {
+		if result.Rating != 4 {
+			t.Errorf("Only notes must be found, got %+v", result)
+		}
+		foundNotes++
+	}
+	foundSources := 0
+	for result := range sources {
+		if result.Type != SourceTypeOpen {
+			t.Errorf("Only sources must be found, got %+v", result)
+		}
+		foundSources++
+	}
+	if foundNotes != 1 || foundSources != 1 {
+		t.Errorf("Each search must find his entry, got %d notes and %d sources", foundNotes, foundSources)
+	}
+}
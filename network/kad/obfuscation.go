@@ -0,0 +1,71 @@
+package kad
+
+import (
+	ed2kCommon "sleepy/network/ed2k/common"
+	kadPacket "sleepy/network/kad/packet"
+	kadTypes "sleepy/network/kad/types"
+	"sleepy/types"
+)
+
+// Keys to obfuscate a packet, the zero value sends it in plain
+type obfuscationKeys struct {
+	// Node ID of the receiver, if known
+	targetID types.UInt128
+	// Verify key the receiver gave us, if known
+	receiverKey uint32
+}
+
+func (keys obfuscationKeys) enabled() bool {
+	return keys.targetID != nil || keys.receiverKey != 0
+}
+
+// Get the keys to obfuscate the packets sent to a contact, if the obfuscation is enabled and the contact supports it
+func (client *Client) contactKeys(id types.UInt128, version uint8) obfuscationKeys {
+	if !client.config.Obfuscation || id == nil {
+		return obfuscationKeys{}
+	}
+
+	keys := obfuscationKeys{targetID: id}
+	supported := version >= ed2kCommon.ProtocolVersion6
+	peer, err := client.router.GetPeer(id)
+	if err == nil && peer != nil {
+		supported = supported || peer.SupportsObfuscation()
+		keys.receiverKey = peer.GetUDPKey().Key
+	}
+
+	if !supported {
+		return obfuscationKeys{}
+	}
+	return keys
+}
+
+// Send a packet to a contact, obfuscated if supported
+func (client *Client) sendToContact(contact *Contact, packet *kadPacket.Packet) error {
+	return client.sendPacket(contact.IP, contact.UDPPort, packet, client.contactKeys(contact.ClientID, contact.Version))
+}
+
+// Answer a request, obfuscating the answer if the request was obfuscated
+func (client *Client) reply(r *UDPRequest, packet *kadPacket.Packet) error {
+	keys := obfuscationKeys{}
+	if r.obfuscated {
+		keys.receiverKey = r.senderKey
+	}
+	return client.sendPacket(r.from.IP, uint16(r.from.Port), packet, keys)
+}
+
+// Remember that the contact with [id] supports obfuscation and the key it gave us, if the request was obfuscated
+func (client *Client) updateContactObfuscation(id types.UInt128, r *UDPRequest) {
+	if !r.obfuscated {
+		return
+	}
+	peer, err := client.router.GetPeer(id)
+	if err != nil || peer == nil {
+		return
+	}
+
+	peer.SetObfuscationSupport(true)
+	if r.senderKey != 0 {
+		// Our public IP isn't known yet, so the key isn't bound to it
+		peer.SetUDPKey(kadTypes.UDPKey{Key: r.senderKey})
+	}
+}
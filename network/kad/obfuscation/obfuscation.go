@@ -0,0 +1,170 @@
+package obfuscation
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sleepy/types"
+)
+
+const (
+	// Magic value encrypted after the key part, used to check the key is right
+	magicValueSync = uint32(0x395F2EC1)
+	// Size of the marker, random key part, magic value and padding length
+	headerSize = 8
+	// Size of the receiver and sender verify keys appended to the Kad header
+	kadVerifyKeysSize = 8
+)
+
+// First bytes of the plain datagrams, never used as obfuscation markers
+var plainProtocols = map[byte]bool{
+	0xC5: true, // eMule
+	0xD4: true, // Packed eMule
+	0xE3: true, // ed2k
+	0xE4: true, // Kad
+	0xE5: true, // Packed Kad
+	0xA3: true, // Reserved
+	0xB2: true, // Reserved
+}
+
+// Marker bits of the first byte
+const (
+	markerEd2k        = 0x01 // Set for the ed2k datagrams, clear for the Kad ones
+	markerReceiverKey = 0x02 // Set for the Kad datagrams keyed with the receiver verify key instead of his node ID
+)
+
+// Datagram is a decrypted Kad datagram with the verify keys it carried
+type Datagram struct {
+	Data []byte
+	// ReceiverKey is the key we gave to the sender, zero if the sender had none
+	ReceiverKey uint32
+	// SenderKey is the key the sender gives us to obfuscate the packets we send to it
+	SenderKey uint32
+	// ByNodeID is set if the datagram was keyed with our node ID, otherwise the receiver verify key was used
+	ByNodeID bool
+}
+
+// IsObfuscated checks if a datagram isn't a plain one, so it may be an obfuscated one
+func IsObfuscated(data []byte) bool {
+	return len(data) > 0 && !plainProtocols[data[0]]
+}
+
+// VerifyKey calculates the key given to the node in [ip], derived from the local [secret]. It's never zero
+func VerifyKey(secret uint32, ip net.IP) uint32 {
+	buffer := make([]byte, 8)
+	if ipv4 := ip.To4(); ipv4 != nil {
+		copy(buffer, ipv4)
+	}
+	binary.LittleEndian.PutUint32(buffer[4:], secret)
+
+	hash := md5.Sum(buffer)
+	key := binary.LittleEndian.Uint32(hash[0:]) ^ binary.LittleEndian.Uint32(hash[4:]) ^
+		binary.LittleEndian.Uint32(hash[8:]) ^ binary.LittleEndian.Uint32(hash[12:])
+	return key%0xFFFFFFFE + 1
+}
+
+// Encrypt obfuscates a Kad datagram. It's keyed with the [targetID] of the receiver if known, otherwise with the
+// [receiverKey] it gave us. The [senderKey] is our verify key for the receiver
+func Encrypt(data []byte, targetID types.UInt128, receiverKey uint32, senderKey uint32) ([]byte, error) {
+	if targetID == nil && receiverKey == 0 {
+		return nil, errors.New("no key to obfuscate the datagram")
+	}
+
+	random := make([]byte, 3)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	keyPart := random[1:3]
+
+	byNodeID := targetID != nil
+	cipher, err := newCipher(targetID, receiverKey, keyPart, byNodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := make([]byte, headerSize+kadVerifyKeysSize+len(data))
+	encrypted[0] = marker(random[0], byNodeID)
+	copy(encrypted[1:3], keyPart)
+
+	plain := make([]byte, len(encrypted)-3)
+	binary.LittleEndian.PutUint32(plain[0:], magicValueSync)
+	plain[4] = 0 // No padding
+	binary.LittleEndian.PutUint32(plain[5:], receiverKey)
+	binary.LittleEndian.PutUint32(plain[9:], senderKey)
+	copy(plain[13:], data)
+	cipher.XORKeyStream(encrypted[3:], plain)
+
+	return encrypted, nil
+}
+
+// Get a marker from a random byte, with the Kad bits set and not clashing with the plain protocols
+func marker(random byte, byNodeID bool) byte {
+	for i := 0; i < 128; i++ {
+		value := random &^ markerEd2k
+		if byNodeID {
+			value &^= markerReceiverKey
+		} else {
+			value |= markerReceiverKey
+		}
+		if !plainProtocols[value] {
+			return value
+		}
+		random = random*31 + 7
+	}
+	if byNodeID {
+		return 0x00
+	}
+	return markerReceiverKey
+}
+
+// Decrypt an obfuscated Kad datagram sent to the local node with [localID], whose verify key for the sender is
+// [localKey]
+func Decrypt(data []byte, localID types.UInt128, localKey uint32) (*Datagram, error) {
+	if len(data) <= headerSize+kadVerifyKeysSize || !IsObfuscated(data) {
+		return nil, errors.New("not an obfuscated datagram")
+	}
+	if data[0]&markerEd2k != 0 {
+		return nil, errors.New("not an obfuscated kad datagram")
+	}
+
+	byNodeID := data[0]&markerReceiverKey == 0
+	cipher, err := newCipher(localID, localKey, data[1:3], byNodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	plain := make([]byte, len(data)-3)
+	cipher.XORKeyStream(plain, data[3:])
+	if binary.LittleEndian.Uint32(plain[0:]) != magicValueSync {
+		return nil, errors.New("wrong obfuscation key")
+	}
+
+	padding := int(plain[4])
+	if len(plain) < 5+padding+kadVerifyKeysSize {
+		return nil, errors.New("obfuscated datagram too short")
+	}
+	plain = plain[5+padding:]
+
+	return &Datagram{
+		ReceiverKey: binary.LittleEndian.Uint32(plain[0:]),
+		SenderKey:   binary.LittleEndian.Uint32(plain[4:]),
+		Data:        plain[kadVerifyKeysSize:],
+		ByNodeID:    byNodeID,
+	}, nil
+}
+
+// Create the RC4 cipher from the MD5 of the node ID or verify key followed by the random key part
+func newCipher(nodeID types.UInt128, verifyKey uint32, keyPart []byte, byNodeID bool) (*rc4.Cipher, error) {
+	var keyData []byte
+	if byNodeID {
+		keyData = append(nodeID.ToBytes(), keyPart...)
+	} else {
+		keyData = binary.LittleEndian.AppendUint32(make([]byte, 0, 6), verifyKey)
+		keyData = append(keyData, keyPart...)
+	}
+	key := md5.Sum(keyData)
+	return rc4.NewCipher(key[:])
+}
@@ -0,0 +1,64 @@
+package obfuscation
+
+import (
+	"bytes"
+	"net"
+	"sleepy/types"
+	"testing"
+)
+
+func TestObfuscation_ByNodeID(t *testing.T) {
+	nodeID := types.NewUInt128(0x0123456789abcdef, 0xfedcba9876543210)
+	data := []byte{0xE4, 0x11, 1, 2, 3, 4}
+
+	encrypted, err := Encrypt(data, nodeID, 0, 0xcafe)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !IsObfuscated(encrypted) || encrypted[0]&(markerEd2k|markerReceiverKey) != 0 {
+		t.Errorf("The marker must identify a Kad datagram keyed by node ID, got %x", encrypted[0])
+	}
+
+	datagram, err := Decrypt(encrypted, nodeID, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !bytes.Equal(datagram.Data, data) || datagram.SenderKey != 0xcafe || datagram.ReceiverKey != 0 || !datagram.ByNodeID {
+		t.Errorf("Decrypted datagram mismatch: %+v", datagram)
+	}
+
+	if _, err := Decrypt(encrypted, types.NewUInt128(1, 1), 0); err == nil {
+		t.Errorf("Datagrams for other nodes must not be decrypted")
+	}
+}
+
+func TestObfuscation_ByReceiverKey(t *testing.T) {
+	localKey := VerifyKey(0x12345678, net.IPv4(10, 0, 0, 1))
+	data := []byte{0xE4, 0x19, 5, 6}
+
+	encrypted, err := Encrypt(data, nil, localKey, 0xbeef)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if encrypted[0]&markerReceiverKey == 0 {
+		t.Errorf("The marker must identify a datagram keyed by receiver key, got %x", encrypted[0])
+	}
+
+	datagram, err := Decrypt(encrypted, types.NewUInt128(1, 1), localKey)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !bytes.Equal(datagram.Data, data) || datagram.ReceiverKey != localKey || datagram.SenderKey != 0xbeef || datagram.ByNodeID {
+		t.Errorf("Decrypted datagram mismatch: %+v", datagram)
+	}
+}
+
+func TestVerifyKey(t *testing.T) {
+	first := VerifyKey(0x12345678, net.IPv4(10, 0, 0, 1))
+	if first == 0 || first != VerifyKey(0x12345678, net.IPv4(10, 0, 0, 1)) {
+		t.Errorf("The verify key must be stable and never zero")
+	}
+	if first == VerifyKey(0x12345678, net.IPv4(10, 0, 0, 2)) || first == VerifyKey(0x87654321, net.IPv4(10, 0, 0, 1)) {
+		t.Errorf("The verify key must depend on the secret and the IP")
+	}
+}
@@ -0,0 +1,53 @@
+package kad
+
+import (
+	"net"
+	"sleepy/types"
+	"testing"
+	"time"
+)
+
+func TestClient_ObfuscatedHello(t *testing.T) {
+	client, manager := newTestClient()
+	client.config.Obfuscation = true
+	nodeManager := &fakeManager{}
+	node := NewClient(Config{ClientID: types.NewUInt128(0x1111, 0x2222), UdpPort: 4672, TcpPort: 4662, Obfuscation: true}, nodeManager)
+	clientAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 100), Port: 4672}
+	nodeAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672}
+	connectTestClients(client, manager, clientAddr, node, nodeManager, nodeAddr)
+
+	keys := client.contactKeys(node.config.ClientID, 8)
+	if !keys.enabled() {
+		t.Fatalf("Version 8 contacts must be obfuscated")
+	}
+	if err := client.sendHello(nodeAddr.IP, uint16(nodeAddr.Port), keys); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		peer, err := client.router.GetPeer(node.config.ClientID)
+		if err == nil && peer != nil && peer.IsIPVerified() {
+			if !peer.SupportsObfuscation() || peer.GetUDPKey().Key == 0 {
+				t.Errorf("The node must be known as supporting obfuscation, with his key")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("The node must answer the obfuscated hello")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	manager.access.Lock()
+	sent := manager.sent[0].data
+	manager.access.Unlock()
+	if sent[0] == 0xE4 || sent[0] == 0xE5 {
+		t.Errorf("The hello must be sent obfuscated")
+	}
+
+	nodePeer, err := node.router.GetPeer(client.config.ClientID)
+	if err != nil || nodePeer == nil || !nodePeer.SupportsObfuscation() {
+		t.Errorf("The node must know the client supports obfuscation")
+	}
+}
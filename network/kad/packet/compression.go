@@ -0,0 +1,77 @@
+package packet
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"sleepy/network/common/udp"
+	"sleepy/network/kad/common"
+)
+
+const (
+	// Min size of the packets worth compressing, as eMule does
+	compressThreshold = 200
+	// Max size of an inflated datagram, to refuse the compression bombs
+	maxDecompressedSize = 64 * 1024
+)
+
+// Compress deflates the payload of the packet with zlib when it's big enough and the compressed form is smaller,
+// marking it with the compressed Kad protocol. Returns if the packet has been compressed
+func (packet *Packet) Compress() (bool, error) {
+	data := packet.GetData()
+	if packet.GetProtocol() != common.ProtocolKadUDP || len(data) <= compressThreshold {
+		return false, nil
+	}
+
+	buffer := &bytes.Buffer{}
+	writer, err := zlib.NewWriterLevel(buffer, zlib.BestCompression)
+	if err != nil {
+		return false, err
+	}
+	if _, err := writer.Write(data[2:]); err != nil {
+		return false, err
+	}
+	if err := writer.Close(); err != nil {
+		return false, err
+	}
+	if buffer.Len()+2 >= len(data) {
+		return false, nil
+	}
+
+	compressed := udp.NewRawPacket()
+	if err := compressed.AppendUInt8(byte(common.ProtocolKadCompressedUDP)); err != nil {
+		return false, err
+	}
+	if err := compressed.AppendUInt8(packet.GetCommand()); err != nil {
+		return false, err
+	}
+	if err := compressed.AppendBytes(buffer.Bytes()); err != nil {
+		return false, err
+	}
+	packet.RawPacket = *compressed
+	return true, nil
+}
+
+// Decompress inflates a compressed Kad datagram, returning it as a plain Kad datagram
+func Decompress(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != byte(common.ProtocolKadCompressedUDP) {
+		return nil, errors.New("not a compressed kad datagram")
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(data[2:]))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	inflated := bytes.NewBuffer([]byte{byte(common.ProtocolKadUDP), data[1]})
+	size, err := io.Copy(inflated, io.LimitReader(reader, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if size > maxDecompressedSize {
+		return nil, errors.New("compressed kad datagram too big")
+	}
+	return inflated.Bytes(), nil
+}
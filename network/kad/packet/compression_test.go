@@ -0,0 +1,42 @@
+package packet
+
+import (
+	"bytes"
+	"sleepy/network/kad/common"
+	"testing"
+)
+
+func TestPacket_CompressAndDecompress(t *testing.T) {
+	packet := NewPacket(common.OperationSearch2Response)
+	payload := bytes.Repeat([]byte("holidays.avi "), 50)
+	packet.AppendBytes(payload)
+	plain := append([]byte{}, packet.GetData()...)
+
+	compressed, err := packet.Compress()
+	if err != nil || !compressed {
+		t.Fatalf("Big repetitive packets must be compressed: %v", err)
+	}
+	if packet.GetProtocol() != common.ProtocolKadCompressedUDP || packet.GetCommand() != byte(common.OperationSearch2Response) {
+		t.Errorf("Compressed packets must keep the command with the compressed protocol")
+	}
+	if packet.GetSize() >= len(plain) {
+		t.Errorf("Compressed packet must be smaller")
+	}
+
+	inflated, err := Decompress(packet.GetData())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !bytes.Equal(inflated, plain) {
+		t.Errorf("Decompressed packet mismatch")
+	}
+}
+
+func TestPacket_CompressSmall(t *testing.T) {
+	packet := NewPacket(common.OperationSearch2Response)
+	packet.AppendBytes([]byte("small"))
+
+	if compressed, _ := packet.Compress(); compressed || packet.GetProtocol() != common.ProtocolKadUDP {
+		t.Errorf("Small packets must not be compressed")
+	}
+}
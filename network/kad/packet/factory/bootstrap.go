@@ -1,16 +1,32 @@
 package factory
 
 import (
+	netCommon "sleepy/network/common"
 	"sleepy/network/kad/common"
+	"sleepy/network/kad/message"
 	kadPacket "sleepy/network/kad/packet"
 	kadTypes "sleepy/network/kad/types"
+	"sleepy/types"
 )
 
-func GetBootstrap1Response(peers []kadTypes.Peer) *kadPacket.Packet {
-	packet := kadPacket.NewFixedSizePacket(common.OperationBootstrapResponse, 2+len(peers)*(16+4+2+2+1))
-	packet.AppendUInt16(uint16(len(peers)))
-	for _, peer := range peers {
-		insertContact(packet, peer)
+func GetBootstrap1Response(peers []kadTypes.Peer) (*kadPacket.Packet, error) {
+	payload := netCommon.NewWriter()
+	payload.WriteUInt16(uint16(len(peers)))
+	for _, contact := range contactsOf(peers) {
+		message.WriteContact(payload, contact)
 	}
-	return packet
+	return newPacket(common.OperationBootstrapResponse, payload)
+}
+
+func GetBootstrap2Request() *kadPacket.Packet {
+	return kadPacket.NewPacket(common.OperationBootstrap2Request)
+}
+
+func GetBootstrap2Response(id types.UInt128, tcpPort uint16, version uint8, peers []kadTypes.Peer) (*kadPacket.Packet, error) {
+	return encodePacket(common.OperationBootstrap2Response, &message.BootstrapResponse{
+		ClientID: id,
+		TCPPort:  tcpPort,
+		Version:  version,
+		Contacts: contactsOf(peers),
+	})
 }
@@ -0,0 +1,31 @@
+package factory
+
+import (
+	"sleepy/network/ed2k/common"
+	kadCommon "sleepy/network/kad/common"
+	"sleepy/network/kad/message"
+	kadPacket "sleepy/network/kad/packet"
+	"sleepy/types"
+)
+
+// GetFindBuddyRequest asks an open node to be the buddy of the firewalled client with the [userHash]. The [buddyID]
+// is the inverted Kad ID of the client, that the node must echo to be trusted
+func GetFindBuddyRequest(buddyID types.UInt128, userHash types.UInt128, tcpPort uint16) (*kadPacket.Packet, error) {
+	return getBuddyPacket(kadCommon.OperationFindBuddyRequest, buddyID, userHash, tcpPort)
+}
+
+// GetFindBuddyResponse accepts to be the buddy of a firewalled client, telling him the [userHash] and the [tcpPort]
+// where he must connect
+func GetFindBuddyResponse(buddyID types.UInt128, userHash types.UInt128, tcpPort uint16) (*kadPacket.Packet, error) {
+	return getBuddyPacket(kadCommon.OperationFindBuddyResponse, buddyID, userHash, tcpPort)
+}
+
+// GetCallbackRequest asks the buddy of a firewalled source to relay him that we want to receive the [fileID] through
+// our [tcpPort]
+func GetCallbackRequest(buddyID types.UInt128, fileID types.UInt128, tcpPort uint16) (*kadPacket.Packet, error) {
+	return encodePacket(kadCommon.OperationCallbackRequest, &message.CallbackRequest{BuddyID: buddyID, FileID: fileID, TCPPort: tcpPort})
+}
+
+func getBuddyPacket(operation common.Operation, buddyID types.UInt128, userHash types.UInt128, port uint16) (*kadPacket.Packet, error) {
+	return encodePacket(operation, &message.FindBuddy{BuddyID: buddyID, UserHash: userHash, TCPPort: port})
+}
@@ -0,0 +1,33 @@
+package factory
+
+import (
+	"net"
+	"sleepy/network/kad/common"
+	"sleepy/network/kad/message"
+	kadPacket "sleepy/network/kad/packet"
+	"sleepy/types"
+)
+
+// GetFirewalled2Request asks a contact to check if our [tcpPort] is reachable
+func GetFirewalled2Request(tcpPort uint16, userHash types.UInt128, connectOptions uint8) (*kadPacket.Packet, error) {
+	return encodePacket(common.OperationFirewalled2Request, &message.FirewalledRequest{
+		TCPPort:        tcpPort,
+		UserHash:       userHash,
+		ConnectOptions: connectOptions,
+	})
+}
+
+// GetFirewalledResponse tells the requester of a firewall check the [ip] his request came from
+func GetFirewalledResponse(ip net.IP) (*kadPacket.Packet, error) {
+	return encodePacket(common.OperationFirewalledResponse, &message.FirewalledResponse{IP: ip})
+}
+
+// GetFirewalledAckResponse confirms the requester of a firewall check that his TCP port is reachable
+func GetFirewalledAckResponse() *kadPacket.Packet {
+	return kadPacket.NewPacket(common.OperationFirewalledAckResponse)
+}
+
+// GetFirewallUDP2 is sent unrequested to the checked UDP [port], failing the check if an [errorCode] is set
+func GetFirewallUDP2(errorCode uint8, port uint16) (*kadPacket.Packet, error) {
+	return encodePacket(common.OperationFirewallUDP2, &message.FirewallUDP{ErrorCode: errorCode, Port: port})
+}
@@ -0,0 +1,53 @@
+package factory
+
+import (
+	"sleepy/network/common/tag"
+	ed2kCommon "sleepy/network/ed2k/common"
+	"sleepy/network/kad/common"
+	"sleepy/network/kad/message"
+	kadPacket "sleepy/network/kad/packet"
+	"sleepy/types"
+)
+
+// HelloDetails are the details of the local node announced in the Kad2 hello packets
+type HelloDetails struct {
+	ID            types.UInt128
+	TCPPort       uint16
+	UDPPort       uint16
+	Version       uint8
+	UDPFirewalled bool
+	TCPFirewalled bool
+	RequestAck    bool
+}
+
+func GetHello2Request(details HelloDetails) (*kadPacket.Packet, error) {
+	return getHello2(common.OperationHello2Request, details)
+}
+
+func GetHello2Response(details HelloDetails) (*kadPacket.Packet, error) {
+	return getHello2(common.OperationHello2Response, details)
+}
+
+func GetHello2ResponseAck(id types.UInt128) (*kadPacket.Packet, error) {
+	// No tags at this time
+	return encodePacket(common.OperationHello2ResponseAck, &message.HelloAck{ClientID: id})
+}
+
+func getHello2(opCode ed2kCommon.Operation, details HelloDetails) (*kadPacket.Packet, error) {
+	var miscOptions uint8
+	if details.UDPFirewalled {
+		miscOptions |= common.MiscOptionUDPFirewalled
+	}
+	if details.TCPFirewalled {
+		miscOptions |= common.MiscOptionTCPFirewalled
+	}
+	if details.RequestAck {
+		miscOptions |= common.MiscOptionRequestAck
+	}
+
+	tags := tag.List{tag.NewUInt16(tag.ID(common.TagSourceUDPPort), details.UDPPort)}
+	if miscOptions != 0 {
+		tags = append(tags, tag.NewUInt8(tag.ID(common.TagKadMiscOptions), miscOptions))
+	}
+	return encodePacket(opCode, &message.Hello{ClientID: details.ID, TCPPort: details.TCPPort, Version: details.Version, Tags: tags})
+}
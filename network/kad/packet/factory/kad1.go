@@ -0,0 +1,93 @@
+package factory
+
+import (
+	"net"
+	netCommon "sleepy/network/common"
+	ed2kCommon "sleepy/network/ed2k/common"
+	"sleepy/network/kad/common"
+	"sleepy/network/kad/message"
+	kadPacket "sleepy/network/kad/packet"
+	kadTypes "sleepy/network/kad/types"
+	"sleepy/types"
+)
+
+// Hello1Details are the details of the local node sent in the Kad1 hello packets
+type Hello1Details struct {
+	ID      types.UInt128
+	IP      net.IP
+	UDPPort uint16
+	TCPPort uint16
+}
+
+func GetHello1Request(details Hello1Details) (*kadPacket.Packet, error) {
+	return getHello1(common.OperationHelloRequest, details)
+}
+
+func GetHello1Response(details Hello1Details) (*kadPacket.Packet, error) {
+	return getHello1(common.OperationHelloResponse, details)
+}
+
+// The Kad1 hellos carry the sender as a contact list entry, with a zero type
+func getHello1(operation ed2kCommon.Operation, details Hello1Details) (*kadPacket.Packet, error) {
+	payload := netCommon.NewWriter()
+	payload.WriteUInt128(details.ID)
+	payload.WriteIPv4(details.IP)
+	payload.WriteUInt16(details.UDPPort)
+	payload.WriteUInt16(details.TCPPort)
+	payload.WriteUInt8(0)
+	return newPacket(operation, payload)
+}
+
+// GetKad1Request asks the [receiver] for the closest contacts to the [target], the number of them depends on the [kind]
+func GetKad1Request(kind uint8, target types.UInt128, receiver types.UInt128) (*kadPacket.Packet, error) {
+	payload := netCommon.NewWriter()
+	payload.WriteUInt8(kind)
+	payload.WriteUInt128(target)
+	payload.WriteUInt128(receiver)
+	return newPacket(common.OperationKadRequest, payload)
+}
+
+func GetKad1Response(target types.UInt128, peers []kadTypes.Peer) (*kadPacket.Packet, error) {
+	payload := netCommon.NewWriter()
+	payload.WriteUInt128(target)
+	payload.WriteUInt8(uint8(len(peers)))
+	for _, contact := range contactsOf(peers) {
+		message.WriteContact(payload, contact)
+	}
+	return newPacket(common.OperationKadResponse, payload)
+}
+
+// GetSearch1Response answers a Kad1 keyword or source search of the [target] with the [entries]
+func GetSearch1Response(target types.UInt128, entries []SearchEntry) (*kadPacket.Packet, error) {
+	return getSearch1Response(common.OperationSearchResponse, target, entries)
+}
+
+// GetSearchNotes1Response answers a Kad1 notes search of the [target] with the [entries]
+func GetSearchNotes1Response(target types.UInt128, entries []SearchEntry) (*kadPacket.Packet, error) {
+	return getSearch1Response(common.OperationSearchNotesResponse, target, entries)
+}
+
+// The Kad1 search responses are the Kad2 ones without the sender
+func getSearch1Response(operation ed2kCommon.Operation, target types.UInt128, entries []SearchEntry) (*kadPacket.Packet, error) {
+	payload := netCommon.NewWriter()
+	payload.WriteUInt128(target)
+	message.WriteSearchEntries(payload, entries)
+	return newPacket(operation, payload)
+}
+
+// GetPublish1Response acknowledges a Kad1 keyword or source publish of the [target] with the index [load]
+func GetPublish1Response(target types.UInt128, load uint8) (*kadPacket.Packet, error) {
+	return getPublish1Response(common.OperationPublishResponse, target, load)
+}
+
+// GetPublishNotes1Response acknowledges a Kad1 notes publish of the [target] with the index [load]
+func GetPublishNotes1Response(target types.UInt128, load uint8) (*kadPacket.Packet, error) {
+	return getPublish1Response(common.OperationPublishNotesResponse, target, load)
+}
+
+func getPublish1Response(operation ed2kCommon.Operation, target types.UInt128, load uint8) (*kadPacket.Packet, error) {
+	payload := netCommon.NewWriter()
+	payload.WriteUInt128(target)
+	payload.WriteUInt8(load)
+	return newPacket(operation, payload)
+}
@@ -0,0 +1,18 @@
+package factory
+
+import (
+	"sleepy/network/kad/common"
+	"sleepy/network/kad/message"
+	kadPacket "sleepy/network/kad/packet"
+	kadTypes "sleepy/network/kad/types"
+	"sleepy/types"
+)
+
+// GetKad2Request asks the [receiver] for the [count] closest contacts to the [target] it knows
+func GetKad2Request(count uint8, target types.UInt128, receiver types.UInt128) (*kadPacket.Packet, error) {
+	return encodePacket(common.OperationKad2Request, &message.KadRequest{Kind: count, Target: target, Receiver: receiver})
+}
+
+func GetKad2Response(target types.UInt128, peers []kadTypes.Peer) (*kadPacket.Packet, error) {
+	return encodePacket(common.OperationKad2Response, &message.KadResponse{Target: target, Contacts: contactsOf(peers)})
+}
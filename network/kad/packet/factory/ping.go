@@ -0,0 +1,16 @@
+package factory
+
+import (
+	"sleepy/network/kad/common"
+	"sleepy/network/kad/message"
+	kadPacket "sleepy/network/kad/packet"
+)
+
+func GetPing2Request() *kadPacket.Packet {
+	return kadPacket.NewPacket(common.OperationPing2Request)
+}
+
+// GetPong2Response answers a ping with the UDP [port] the sender has been seen from
+func GetPong2Response(port uint16) (*kadPacket.Packet, error) {
+	return encodePacket(common.OperationPong2Response, &message.Pong{Port: port})
+}
@@ -0,0 +1,123 @@
+package factory
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sleepy/network/common/tag"
+	"sleepy/network/kad/common"
+	"sleepy/network/kad/message"
+	kadPacket "sleepy/network/kad/packet"
+	"sleepy/types"
+)
+
+// Max number of files announced in a keyword publish packet, as eMule does
+const MaxPublishKeyEntries = 150
+
+// KeywordEntry is a file announced under a keyword
+type KeywordEntry struct {
+	FileHash types.UInt128
+	Name     string
+	Size     uint64
+	Type     string
+	// Sources is the number of known sources of the file, omitted if zero
+	Sources uint32
+}
+
+// SourceDetails are the details of the local node announced as a source of a file
+type SourceDetails struct {
+	Type         uint8
+	TCPPort      uint16
+	UDPPort      uint16
+	CryptOptions uint8
+	FileSize     uint64
+	// Buddy of the firewalled sources, omitted if the buddy ID is nil
+	BuddyID   types.UInt128
+	BuddyIP   net.IP
+	BuddyPort uint16
+}
+
+// GetPublishKey2Request announces the [entries] under the [keyword]
+func GetPublishKey2Request(keyword types.UInt128, entries []KeywordEntry) (*kadPacket.Packet, error) {
+	if len(entries) > MaxPublishKeyEntries {
+		return nil, errors.New("too many entries for a keyword publish")
+	}
+
+	request := &message.PublishKeyRequest{Keyword: keyword, Entries: make([]message.KeywordEntry, 0, len(entries))}
+	for _, entry := range entries {
+		request.Entries = append(request.Entries, message.KeywordEntry{FileHash: entry.FileHash, Tags: keywordEntryTags(entry)})
+	}
+	return encodePacket(common.OperationPublishKey2Request, request)
+}
+
+func keywordEntryTags(entry KeywordEntry) tag.List {
+	tags := tag.List{
+		tag.NewString(tag.ID(common.TagFileName), entry.Name),
+		tag.NewUInt(tag.ID(common.TagFileSize), entry.Size),
+	}
+	if entry.Type != "" {
+		tags = append(tags, tag.NewString(tag.ID(common.TagFileType), entry.Type))
+	}
+	if entry.Sources > 0 {
+		tags = append(tags, tag.NewUInt(tag.ID(common.TagSources), uint64(entry.Sources)))
+	}
+	return tags
+}
+
+// GetPublishSource2Request announces the [source] as sharing the file with the [fileHash]
+func GetPublishSource2Request(fileHash types.UInt128, source types.UInt128, details SourceDetails) (*kadPacket.Packet, error) {
+	tags := tag.List{
+		tag.NewUInt8(tag.ID(common.TagSourceType), details.Type),
+		tag.NewUInt16(tag.ID(common.TagSourcePort), details.TCPPort),
+	}
+	if details.UDPPort != 0 {
+		tags = append(tags, tag.NewUInt16(tag.ID(common.TagSourceUDPPort), details.UDPPort))
+	}
+	tags = append(tags,
+		tag.NewUInt(tag.ID(common.TagFileSize), details.FileSize),
+		tag.NewUInt8(tag.ID(common.TagEncryption), details.CryptOptions))
+	if details.BuddyID != nil {
+		tags = append(tags, buddyTags(details)...)
+	}
+	return encodePacket(common.OperationPublishSource2Request, &message.PublishSourceRequest{FileHash: fileHash, Source: source, Tags: tags})
+}
+
+// Tags announcing the buddy of a firewalled source as eMule does, through the server tags
+func buddyTags(details SourceDetails) tag.List {
+	ip := uint64(0)
+	if ipv4 := details.BuddyIP.To4(); ipv4 != nil {
+		ip = uint64(binary.BigEndian.Uint32(ipv4))
+	}
+	return tag.List{
+		tag.NewString(tag.ID(common.TagBuddyHash), hex.EncodeToString(details.BuddyID.ToBytes())),
+		tag.NewUInt(tag.ID(common.TagServerIP), ip),
+		tag.NewUInt(tag.ID(common.TagServerPort), uint64(details.BuddyPort)),
+	}
+}
+
+// GetPublish2Response acknowledges a publish of the [target], informing the [load] percentage of the local index
+func GetPublish2Response(target types.UInt128, load uint8) (*kadPacket.Packet, error) {
+	return encodePacket(common.OperationPublish2Response, &message.PublishResponse{Target: target, Load: load})
+}
+
+// NoteDetails are the rating and comment of a file published by the local node
+type NoteDetails struct {
+	FileName string
+	FileSize uint64
+	Rating   uint8
+	Comment  string
+}
+
+// GetPublishNotes2Request announces the note of the [source] about the file with the [fileHash]
+func GetPublishNotes2Request(fileHash types.UInt128, source types.UInt128, details NoteDetails) (*kadPacket.Packet, error) {
+	tags := tag.List{
+		tag.NewString(tag.ID(common.TagFileName), details.FileName),
+		tag.NewUInt(tag.ID(common.TagFileSize), details.FileSize),
+		tag.NewUInt8(tag.ID(common.TagFileRating), details.Rating),
+	}
+	if details.Comment != "" {
+		tags = append(tags, tag.NewString(tag.ID(common.TagDescription), details.Comment))
+	}
+	return encodePacket(common.OperationPublishNotes2Request, &message.PublishNotesRequest{FileHash: fileHash, Source: source, Tags: tags})
+}
@@ -0,0 +1,39 @@
+package factory
+
+import (
+	"sleepy/network/kad/common"
+	"sleepy/network/kad/message"
+	kadPacket "sleepy/network/kad/packet"
+	"sleepy/types"
+)
+
+// GetSearchKey2Request searches the files published under the [keyword], the encoded [expression] may be empty
+func GetSearchKey2Request(keyword types.UInt128, startPosition uint16, expression []byte) (*kadPacket.Packet, error) {
+	return encodePacket(common.OperationSearchKey2Request, &message.SearchKeyRequest{
+		Keyword:       keyword,
+		StartPosition: startPosition,
+		Expression:    expression,
+	})
+}
+
+// GetSearchSource2Request searches the sources of the file with the [fileHash] and [fileSize]
+func GetSearchSource2Request(fileHash types.UInt128, startPosition uint16, fileSize uint64) (*kadPacket.Packet, error) {
+	return encodePacket(common.OperationSearchSource2Request, &message.SearchSourceRequest{
+		FileHash:      fileHash,
+		StartPosition: startPosition,
+		FileSize:      fileSize,
+	})
+}
+
+// SearchEntry is an answer of a search response with his tags
+type SearchEntry = message.SearchEntry
+
+// GetSearch2Response answers a search of the [target] with the [entries] found by the [sender]
+func GetSearch2Response(sender types.UInt128, target types.UInt128, entries []SearchEntry) (*kadPacket.Packet, error) {
+	return encodePacket(common.OperationSearch2Response, &message.SearchResponse{Sender: sender, Target: target, Entries: entries})
+}
+
+// GetSearchNotes2Request searches the notes of the file with the [fileHash] and [fileSize]
+func GetSearchNotes2Request(fileHash types.UInt128, fileSize uint64) (*kadPacket.Packet, error) {
+	return encodePacket(common.OperationSearchNotes2Request, &message.SearchNotesRequest{FileHash: fileHash, FileSize: fileSize})
+}
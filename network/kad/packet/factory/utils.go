@@ -1,14 +1,48 @@
 package factory
 
 import (
-	"sleepy/network/kad/packet"
+	netCommon "sleepy/network/common"
+	ed2kCommon "sleepy/network/ed2k/common"
+	"sleepy/network/kad/message"
+	kadPacket "sleepy/network/kad/packet"
 	kadTypes "sleepy/network/kad/types"
 )
 
-func insertContact(packet *packet.Packet, peer kadTypes.Peer) {
-	packet.AppendBytes(peer.GetID().ToBytes())
-	packet.AppendBytes(peer.GetIP().To4())
-	packet.AppendUInt16(peer.GetUDPPort())
-	packet.AppendUInt16(peer.GetTCPPort())
-	packet.AppendUInt8(peer.GetProtocolVersion())
+// Create a packet with the [payload], failing if any of his writes failed
+func newPacket(opCode ed2kCommon.Operation, payload *netCommon.Writer) (*kadPacket.Packet, error) {
+	if err := payload.Err(); err != nil {
+		return nil, err
+	}
+	return payloadPacket(opCode, payload.Bytes())
+}
+
+// Create a packet with the encoded [msg]
+func encodePacket(opCode ed2kCommon.Operation, msg message.Message) (*kadPacket.Packet, error) {
+	payload, err := msg.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return payloadPacket(opCode, payload)
+}
+
+func payloadPacket(opCode ed2kCommon.Operation, payload []byte) (*kadPacket.Packet, error) {
+	packet := kadPacket.NewPacket(opCode)
+	if err := packet.AppendBytes(payload); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+func contactsOf(peers []kadTypes.Peer) []message.Contact {
+	contacts := make([]message.Contact, 0, len(peers))
+	for _, peer := range peers {
+		contacts = append(contacts, message.Contact{
+			ClientID: peer.GetID(),
+			IP:       peer.GetIP(),
+			UDPPort:  peer.GetUDPPort(),
+			TCPPort:  peer.GetTCPPort(),
+			Version:  peer.GetProtocolVersion(),
+		})
+	}
+	return contacts
 }
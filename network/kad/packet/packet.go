@@ -1,7 +1,7 @@
 package packet
 
 import (
-	"fmt"
+	"sleepy/network/common/udp"
 	ed2kCommon "sleepy/network/ed2k/common"
 	ed2kPacket "sleepy/network/ed2k/packet"
 	"sleepy/network/kad/common"
@@ -11,30 +11,20 @@ type Packet struct {
 	ed2kPacket.Packet
 }
 
+// NewPacket creates a kad packet that grows as the payload is appended
 func NewPacket(
 	opCode ed2kCommon.Operation,
 ) *Packet {
 	p := &Packet{
-		Packet: *ed2kPacket.NewPacket(common.ProtocolKadUDP, 2),
-	}
-	p.SetCommand(byte(opCode))
-	return p
-}
-
-func NewFixedSizePacket(
-	opCode ed2kCommon.Operation,
-	size int,
-) *Packet {
-	fmt.Println("kad.NewFixedSizePacket", opCode, size)
-	p := &Packet{
-		Packet: *ed2kPacket.NewPacket(common.ProtocolKadUDP, size+2),
+		Packet: ed2kPacket.Packet{RawPacket: *udp.NewRawPacket()},
 	}
+	p.SetProtocol(common.ProtocolKadUDP)
 	p.SetCommand(byte(opCode))
 	return p
 }
 
 func (packet *Packet) SetCommand(command byte) {
-	if packet.GetProtocol() == ed2kCommon.ProtocolEd2kServerUDP || packet.GetProtocol() == common.ProtocolKadUDP {
+	if packet.hasShortHeader() {
 		packet.SetByte(1, command)
 	} else {
 		packet.SetByte(5, command)
@@ -42,9 +32,15 @@ func (packet *Packet) SetCommand(command byte) {
 }
 
 func (packet *Packet) GetCommand() byte {
-	if packet.GetProtocol() == ed2kCommon.ProtocolEd2kServerUDP || packet.GetProtocol() == common.ProtocolKadUDP {
+	if packet.hasShortHeader() {
 		return packet.GetByte(1)
 	} else {
 		return packet.GetByte(5)
 	}
 }
+
+// The ed2k server and Kad packets have the command right after the protocol
+func (packet *Packet) hasShortHeader() bool {
+	protocol := packet.GetProtocol()
+	return protocol == ed2kCommon.ProtocolEd2kServerUDP || protocol == common.ProtocolKadUDP || protocol == common.ProtocolKadCompressedUDP
+}
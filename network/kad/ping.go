@@ -0,0 +1,110 @@
+package kad
+
+import (
+	"context"
+	"errors"
+	"log"
+	ed2kCommon "sleepy/network/ed2k/common"
+	"sleepy/network/kad/packet/factory"
+	kadTypes "sleepy/network/kad/types"
+	"sync"
+	"time"
+)
+
+const (
+	externalPortPings     = 3 // Number of contacts asked for our external port, as the eMule EXTERNAL_PORT_ASKIPS
+	externalPortAgreement = 2 // Number of contacts that must see the same port to trust it
+	pingTimeout           = 10 * time.Second
+)
+
+// Ports our UDP socket has been seen from by the contacts that answered our pings
+type externalPortState struct {
+	seen   map[string]uint16 // Port seen by each contact IP
+	port   uint16
+	known  bool
+	access sync.Mutex
+}
+
+// ExternalUDPPort gets the UDP port the other nodes see us from, once enough contacts agree on it
+func (client *Client) ExternalUDPPort() (uint16, bool) {
+	client.externalPort.access.Lock()
+	defer client.externalPort.access.Unlock()
+	return client.externalPort.port, client.externalPort.known
+}
+
+// UDPPortRemapped checks if the other nodes see us from a UDP port different to the configured one, as happens
+// behind some NATs
+func (client *Client) UDPPortRemapped() bool {
+	port, known := client.ExternalUDPPort()
+	return known && port != client.config.UdpPort
+}
+
+// CheckExternalUDPPort pings some contacts to find out the UDP port they see us from
+func (client *Client) CheckExternalUDPPort() error {
+	client.externalPort.access.Lock()
+	client.externalPort.seen = make(map[string]uint16)
+	client.externalPort.known = false
+	client.externalPort.access.Unlock()
+
+	candidates := kadTypes.Filter(client.router.GetClosestPeers(client.config.ClientID, 50), func(peer kadTypes.Peer) bool {
+		// Only the Kad2 nodes understand the pings
+		return peer.IsIPVerified() && peer.GetProtocolVersion() >= ed2kCommon.ProtocolVersion6
+	})
+	if len(candidates) == 0 {
+		return errors.New("no contacts to check the external port")
+	}
+
+	sent := 0
+	for _, peer := range candidates {
+		if sent >= externalPortPings {
+			break
+		}
+		reply := client.trackReply(peer.GetID(), peer.GetIP(), CommKad2Pong, nil, pingTimeout)
+		if err := client.sendToContact(contactFromPeer(peer), factory.GetPing2Request()); err != nil {
+			client.cancelReply(reply, err)
+			log.Println(err)
+			continue
+		}
+		sent++
+	}
+	return nil
+}
+
+// Ping sends a ping to the [contact] and waits for his pong. The contacts not answering in time are degraded
+func (client *Client) Ping(ctx context.Context, contact *Contact) error {
+	if !contact.speaksKad2() {
+		return errors.New("the Kad1 contacts can't be pinged")
+	}
+
+	reply := client.trackReply(contact.ClientID, contact.IP, CommKad2Pong, nil, pingTimeout)
+	if err := client.sendToContact(contact, factory.GetPing2Request()); err != nil {
+		client.cancelReply(reply, err)
+		return err
+	}
+	return reply.Wait(ctx)
+}
+
+// Record the port a contact sees us from, trusting it once enough contacts agree
+func (client *Client) recordExternalPort(from string, port uint16) {
+	client.externalPort.access.Lock()
+	defer client.externalPort.access.Unlock()
+
+	if client.externalPort.seen == nil {
+		client.externalPort.seen = make(map[string]uint16)
+	}
+	client.externalPort.seen[from] = port
+
+	agreeing := 0
+	for _, seenPort := range client.externalPort.seen {
+		if seenPort == port {
+			agreeing++
+		}
+	}
+	if agreeing >= externalPortAgreement {
+		if !client.externalPort.known || client.externalPort.port != port {
+			log.Printf("External UDP port is %d", port)
+		}
+		client.externalPort.port = port
+		client.externalPort.known = true
+	}
+}
@@ -0,0 +1,86 @@
+package kad
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sleepy/types"
+	"testing"
+)
+
+func TestClient_AnswerPing(t *testing.T) {
+	client, manager := newTestClient()
+
+	if err := client.handleUDP([]byte{0xE4, CommKad2Ping}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5123}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	pongs := manager.sentWithCommand(CommKad2Pong)
+	if len(pongs) != 1 {
+		t.Fatalf("The ping must be answered with a pong")
+	}
+	if len(pongs[0].data) != 4 || binary.LittleEndian.Uint16(pongs[0].data[2:]) != 5123 {
+		t.Errorf("The pong must carry the port the ping came from, got %v", pongs[0].data)
+	}
+}
+
+func TestClient_ExternalUDPPort(t *testing.T) {
+	client, manager := newTestClient()
+	for i := byte(1); i <= 3; i++ {
+		client.router.AddPeer(newTestPeer(types.NewUInt128(uint64(i), 0), i))
+	}
+
+	if _, known := client.ExternalUDPPort(); known {
+		t.Fatalf("The external port must be unknown before checking it")
+	}
+
+	// The contacts see us from a remapped port
+	manager.onSend = func(sent sentPacket) {
+		if sent.data[1] == CommKad2Ping {
+			client.handleUDP([]byte{0xE4, CommKad2Pong, 0x88, 0x13}, &net.UDPAddr{IP: sent.ip, Port: int(sent.port)})
+		}
+	}
+	if err := client.CheckExternalUDPPort(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(manager.sentWithCommand(CommKad2Ping)) != 3 {
+		t.Errorf("The 3 contacts must be pinged")
+	}
+	port, known := client.ExternalUDPPort()
+	if !known || port != 5000 {
+		t.Errorf("The external port must be 5000, got %d (known %t)", port, known)
+	}
+	if !client.UDPPortRemapped() {
+		t.Errorf("The port must be detected as remapped")
+	}
+}
+
+func TestClient_UnrequestedPong(t *testing.T) {
+	client, _ := newTestClient()
+
+	for i := byte(1); i <= 3; i++ {
+		client.handleUDP([]byte{0xE4, CommKad2Pong, 0x88, 0x13}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, i), Port: 4672})
+	}
+
+	if _, known := client.ExternalUDPPort(); known {
+		t.Errorf("The unrequested pongs must be ignored")
+	}
+}
+
+func TestClient_PingSendFailure(t *testing.T) {
+	client, manager := newTestClient()
+	manager.sendErr = errors.New("network unreachable")
+	contact := &Contact{ClientID: types.NewUInt128(1, 0), IP: net.IPv4(10, 0, 0, 1), UDPPort: 4672, Version: 8}
+
+	if err := client.Ping(context.Background(), contact); err != manager.sendErr {
+		t.Errorf("The send error must be returned, got %v", err)
+	}
+	client.replies.access.Lock()
+	expected := len(client.replies.expected)
+	client.replies.access.Unlock()
+	if expected != 0 {
+		t.Errorf("No pong must be expected from a contact not pinged, got %d", expected)
+	}
+}
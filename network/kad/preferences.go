@@ -0,0 +1,72 @@
+package kad
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	netCommon "sleepy/network/common"
+	"sleepy/types"
+)
+
+const minPreferencesFileSize = 22 // IP, unused field and Kad ID, the tags after them are ignored
+
+// Preferences is the content of an eMule preferencesKad.dat file, keeping the Kad ID between the runs, as the
+// contacts of the nodes.dat file are bucketed around it and the other nodes know us by it
+type Preferences struct {
+	// IP is our last known public IP, nil if unknown
+	IP       net.IP
+	ClientID types.UInt128
+}
+
+// ReadPreferencesFile reads an eMule preferencesKad.dat file from disk
+func ReadPreferencesFile(path string) (*Preferences, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < minPreferencesFileSize {
+		return nil, errors.New("preferences file too short")
+	}
+
+	reader := netCommon.NewReader(data)
+	preferences := &Preferences{}
+	preferences.IP, _ = reader.ReadIPv4()
+	if preferences.IP.Equal(net.IPv4zero) {
+		preferences.IP = nil
+	}
+	// Unused field
+	reader.Discard(2)
+	if preferences.ClientID, err = reader.ReadUInt128(); err != nil {
+		return nil, err
+	}
+	return preferences, nil
+}
+
+// WritePreferencesFile writes the [preferences] as an eMule preferencesKad.dat file, replacing the existing one once
+// fully written
+func WritePreferencesFile(path string, preferences *Preferences) error {
+	writer := netCommon.NewWriter()
+	writer.WriteIPv4(preferences.IP)
+	writer.WriteUInt16(0)
+	writer.WriteUInt128(preferences.ClientID)
+	// No tags
+	writer.WriteUInt8(0)
+	if writer.Err() != nil {
+		return writer.Err()
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(writer.Bytes())
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
@@ -0,0 +1,38 @@
+package kad
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sleepy/types"
+	"testing"
+)
+
+func TestPreferencesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "preferencesKad.dat")
+	preferences := &Preferences{IP: net.IPv4(1, 2, 3, 4), ClientID: types.NewUInt128(0x0123456789abcdef, 0xfedcba9876543210)}
+
+	if err := WritePreferencesFile(path, preferences); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	data, _ := os.ReadFile(path)
+	// The IP is written as Kad does, a little endian number in host order, and the file ends without tags
+	if len(data) != 23 || data[0] != 4 || data[3] != 1 || data[22] != 0 {
+		t.Errorf("Unexpected preferences file %x", data)
+	}
+
+	read, err := ReadPreferencesFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !read.IP.Equal(preferences.IP) || !read.ClientID.Equal(preferences.ClientID) {
+		t.Errorf("The preferences must be read as written, got %+v", read)
+	}
+
+	if err := os.WriteFile(path, data[:10], 0644); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, err := ReadPreferencesFile(path); err == nil {
+		t.Errorf("Truncated files must be rejected")
+	}
+}
@@ -3,60 +3,526 @@ package kad
 import (
 	"fmt"
 	"log"
+	"sleepy/network/ed2k/common"
+	kadBuddy "sleepy/network/kad/buddy"
+	kadCommon "sleepy/network/kad/common"
+	"sleepy/network/kad/index"
+	"sleepy/network/kad/message"
 	"sleepy/network/kad/packet/factory"
+	"sleepy/types"
+	"time"
 )
 
 func HandleBootstrapRequest(client *Client, r *UDPRequest) {
-	// Some clients send the remote ip and port, others don't
-	remoteIp := r.from.IP
-	remoteUdpPort := uint16(r.from.Port)
+	log.Println("Bootstrap request")
+	contacts := client.bootstrapPeers(r.from, nil)
+	log.Println(fmt.Sprintf("Sending %d contacts.", len(contacts)))
+	packet, err := factory.GetBootstrap2Response(client.config.ClientID, client.config.TcpPort, kadCommon.KadVersion, contacts)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = client.reply(r, packet)
+	if err != nil {
+		log.Println(err)
+	}
+}
 
-	remoteId, err := r.body.ReadUInt128()
-	if err == nil {
-		remoteIp, err = r.body.ReadIPv4()
-		if err != nil {
-			log.Fatalf("Error al leer la ip remota: %s", err.Error())
-		}
-		remoteUdpPort, err = r.body.ReadUInt16()
-		if err != nil {
-			log.Fatalf("Error al leer el puerto udp remoto: %s", err.Error())
+func HandleBootstrapResponse(client *Client, r *UDPRequest, w Response) {
+	if !client.consumeExpectedReply(r.from.IP, CommKad2BootstrapRes, nil) {
+		log.Printf("Ignoring unrequested bootstrap response from %s", r.from)
+		return
+	}
+
+	// If the router is empty, the contacts from the first bootstrap are assumed verified
+	assumeVerified := client.router.CountPeers() == 0
+
+	var response message.BootstrapResponse
+	if err := r.decode(&response); err != nil {
+		log.Printf("Invalid bootstrap response from %s: %s", r.from, err)
+		return
+	}
+
+	// The sender answered to our request, so his IP is verified
+	err := client.addContact(response.ClientID, r.from.IP, uint16(r.from.Port), response.TCPPort, response.Version, true, true)
+	if err != nil {
+		log.Printf("Bootstrap sender %s not added: %s", response.ClientID.ToHexString(), err)
+	}
+	client.updateContactObfuscation(response.ClientID, r)
+
+	contacts := make([]*Contact, 0, len(response.Contacts))
+	for _, contact := range response.Contacts {
+		contacts = append(contacts, contactFromMessage(contact))
+	}
+
+	log.Printf("Bootstrap response from %s with %d contacts", r.from, len(contacts))
+	client.addBootstrapContacts(contacts, assumeVerified)
+
+	// Look for ourselves to fill the router with the closest contacts
+	client.spawn(func() { client.backgroundLookup(client.config.ClientID) })
+
+	if _, known := client.ExternalUDPPort(); !known {
+		if err := client.CheckExternalUDPPort(); err != nil {
+			log.Printf("External UDP port not checked: %s", err)
 		}
 	}
+	client.checkFirewallIfUnknown()
+}
 
-	log.Println("Bootstrap request")
-	contacts := client.router.GetBootstrapPeers(20, remoteId)
-	log.Println(fmt.Sprintf("Se van a enviar %d contactos.", len(contacts)))
-	packet := factory.GetBootstrap1Response(contacts)
-	err = client.network.SendUDP(remoteIp, remoteUdpPort, packet)
+func HandleKad2Request(client *Client, r *UDPRequest, w Response) {
+	var request message.KadRequest
+	if err := r.decode(&request); err != nil {
+		log.Printf("Invalid kad request from %s: %s", r.from, err)
+		return
+	}
+	count := request.Kind & 0x1F
+	if count == 0 {
+		log.Printf("Invalid kad request from %s: no contacts requested", r.from)
+		return
+	}
+
+	// The request must be addressed to us, otherwise the sender has an outdated contact
+	if !request.Receiver.Equal(client.config.ClientID) {
+		log.Printf("Ignoring kad request from %s addressed to %s", r.from, request.Receiver.ToHexString())
+		return
+	}
+
+	contacts := client.router.GetClosestPeers(request.Target, int(count))
+	packet, err := factory.GetKad2Response(request.Target, contacts)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = client.reply(r, packet)
 	if err != nil {
 		log.Println(err)
 	}
 }
 
-func HandleBootstrapResponse(client *Client, r *UDPRequest, w Response) {
-	log.Println("Bootstrap response")
+func HandleKad2Response(client *Client, r *UDPRequest, w Response) {
+	var response message.KadResponse
+	if err := r.decode(&response); err != nil {
+		log.Printf("Invalid kad response from %s: %s", r.from, err)
+		return
+	}
+	if !client.consumeExpectedReply(r.from.IP, CommKad2Res, response.Target) {
+		log.Printf("Ignoring unrequested kad response from %s", r.from)
+		return
+	}
+
+	contacts := make([]*Contact, 0, len(response.Contacts))
+	for _, received := range response.Contacts {
+		contact := contactFromMessage(received)
+		contacts = append(contacts, contact)
+
+		// Contacts informed by a third party are added unverified and never update the known ones
+		_ = client.addContact(contact.ClientID, contact.IP, contact.UDPPort, contact.TCPPort, contact.Version, false, false)
+	}
+
+	client.deliverLookupResponse(response.Target, r.from, contacts)
 }
 
 func HandleFirewallRequest(client *Client, r *UDPRequest, w Response) {
-	log.Println("Firewall request")
+	var request message.FirewalledRequest
+	if err := r.decode(&request); err != nil {
+		log.Printf("Invalid firewall request from %s: %s", r.from, err)
+		return
+	}
+
+	packet, err := factory.GetFirewalledResponse(r.from.IP)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err = client.reply(r, packet); err != nil {
+		log.Println(err)
+	}
+
+	client.spawn(func() { client.checkRequesterTCP(r, request.TCPPort) })
+}
+
+func HandleFirewalledResponse(client *Client, r *UDPRequest, w Response) {
+	if !client.consumeExpectedReply(r.from.IP, CommKadFirewalledRes, nil) {
+		log.Printf("Ignoring unrequested firewalled response from %s", r.from)
+		return
+	}
+
+	var response message.FirewalledResponse
+	if err := r.decode(&response); err != nil {
+		log.Printf("Invalid firewalled response from %s: %s", r.from, err)
+		return
+	}
+
+	client.firewall.access.Lock()
+	client.firewall.publicIP = response.IP
+	client.firewall.access.Unlock()
+	client.recordFirewallAnswer(r.from.IP, func(answer *firewallAnswer) {
+		answer.answered = true
+	})
+}
+
+func HandleFirewalledAckResponse(client *Client, r *UDPRequest, w Response) {
+	if !client.consumeExpectedReply(r.from.IP, CommKadFirewalledAckRes, nil) {
+		log.Printf("Ignoring unrequested firewalled ack from %s", r.from)
+		return
+	}
+
+	client.recordFirewallAnswer(r.from.IP, func(answer *firewallAnswer) {
+		answer.tcpOpen = true
+	})
+}
+
+func HandleFirewallUDP(client *Client, r *UDPRequest, w Response) {
+	if !client.consumeExpectedReply(r.from.IP, CommKad2FirewallUDP, nil) {
+		log.Printf("Ignoring unrequested UDP firewall check from %s", r.from)
+		return
+	}
+
+	var check message.FirewallUDP
+	if err := r.decode(&check); err != nil {
+		log.Printf("Invalid UDP firewall check from %s: %s", r.from, err)
+		return
+	}
+	if check.ErrorCode != 0 {
+		log.Printf("UDP firewall check from %s failed with error %d", r.from, check.ErrorCode)
+		return
+	}
+
+	client.recordFirewallAnswer(r.from.IP, func(answer *firewallAnswer) {
+		answer.udpOpen = true
+	})
 }
 
 func HandleHelloRequest(client *Client, r *UDPRequest, w Response) {
-	log.Println("Hello request")
+	hello, err := readHello2(r)
+	if err != nil {
+		log.Printf("Invalid hello request from %s: %s", r.from, err)
+		return
+	}
+
+	// The IP is verified once the peer acknowledges our response, or if it already knows the key we gave to it
+	err = client.updateContactFromHello(hello, r.from.IP, r.validReceiverKey)
+	if err != nil {
+		log.Printf("Contact %s not updated: %s", hello.ClientID.ToHexString(), err)
+	}
+	client.updateContactObfuscation(hello.ClientID, r)
+
+	requestAck := false
+	if hello.Version >= common.ProtocolVersion8 {
+		peer, err := client.router.GetPeer(hello.ClientID)
+		requestAck = err == nil && peer != nil && !peer.IsIPVerified()
+	}
+
+	packet, err := factory.GetHello2Response(client.helloDetails(requestAck))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = client.reply(r, packet)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if requestAck {
+		client.expectSingleReply(r.from.IP, CommKad2HelloResAck)
+	}
 }
 
 func HandleHelloResponse(client *Client, r *UDPRequest, w Response) {
-	log.Println("Hello response")
+	if !client.consumeExpectedReply(r.from.IP, CommKad2HelloRes, nil) {
+		log.Printf("Ignoring unrequested hello response from %s", r.from)
+		return
+	}
+
+	hello, err := readHello2(r)
+	if err != nil {
+		log.Printf("Invalid hello response from %s: %s", r.from, err)
+		return
+	}
+
+	// The peer answered to our request, so his IP is verified
+	err = client.updateContactFromHello(hello, r.from.IP, true)
+	if err != nil {
+		log.Printf("Contact %s not updated: %s", hello.ClientID.ToHexString(), err)
+	}
+	client.updateContactObfuscation(hello.ClientID, r)
+
+	if hello.RequestsAck {
+		packet, err := factory.GetHello2ResponseAck(client.config.ClientID)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		err = client.reply(r, packet)
+		if err != nil {
+			log.Println(err)
+		}
+	}
 }
 
 func HandleHelloResponseAck(client *Client, r *UDPRequest, w Response) {
-	log.Println("Hello response ack")
+	if !client.consumeExpectedReply(r.from.IP, CommKad2HelloResAck, nil) {
+		log.Printf("Ignoring unrequested hello response ack from %s", r.from)
+		return
+	}
+
+	var ack message.HelloAck
+	if err := r.decode(&ack); err != nil {
+		log.Printf("Invalid hello response ack from %s: %s", r.from, err)
+		return
+	}
+
+	if !client.router.VerifyPeer(ack.ClientID, r.from.IP) {
+		log.Printf("Contact %s can't be verified from %s", ack.ClientID.ToHexString(), r.from)
+	}
 }
 
 func HandlePingRequest(client *Client, r *UDPRequest, w Response) {
-	log.Println("Ping request")
+	packet, err := factory.GetPong2Response(uint16(r.from.Port))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = client.reply(r, packet)
+	if err != nil {
+		log.Println(err)
+	}
 }
 
 func HandlePongResponse(client *Client, r *UDPRequest, w Response) {
-	log.Println("Pong response")
+	if !client.consumeExpectedReply(r.from.IP, CommKad2Pong, nil) {
+		log.Printf("Ignoring unrequested pong from %s", r.from)
+		return
+	}
+
+	var pong message.Pong
+	if err := r.decode(&pong); err != nil {
+		log.Printf("Invalid pong from %s: %s", r.from, err)
+		return
+	}
+	if pong.Port == 0 {
+		log.Printf("Ignoring pong from %s without port", r.from)
+		return
+	}
+
+	client.recordExternalPort(r.from.IP.String(), pong.Port)
+}
+
+func HandleSearchResponse(client *Client, r *UDPRequest, w Response) {
+	var response message.SearchResponse
+	if err := r.decode(&response); err != nil {
+		log.Printf("Invalid search response from %s: %s", r.from, err)
+		return
+	}
+
+	searches := client.getSearches(response.Target, r.from.IP)
+	if len(searches) == 0 {
+		log.Printf("Ignoring search response from %s for unknown target %s", r.from, response.Target.ToHexString())
+		return
+	}
+
+	for _, entry := range response.Entries {
+		for _, s := range searches {
+			if len(searches) == 1 || s.accepts(entry.Tags) {
+				s.deliver(r.from, entry.Answer, entry.Tags)
+				break
+			}
+		}
+	}
+}
+
+func HandlePublishResponse(client *Client, r *UDPRequest, w Response) {
+	var response message.PublishResponse
+	if err := r.decode(&response); err != nil {
+		log.Printf("Invalid publish response from %s: %s", r.from, err)
+		return
+	}
+	if !client.consumeExpectedReply(r.from.IP, CommKad2PublishRes, response.Target) {
+		log.Printf("Ignoring unrequested publish response from %s", r.from)
+		return
+	}
+
+	if !client.deliverPublishLoad(r.from.IP, response.Target, response.Load) {
+		log.Printf("Ignoring publish response from %s for unknown target %s", r.from, response.Target.ToHexString())
+	}
+}
+
+func HandleSearchKeyRequest(client *Client, r *UDPRequest, w Response) {
+	var request message.SearchKeyRequest
+	if err := r.decode(&request); err != nil {
+		log.Printf("Invalid keyword search from %s: %s", r.from, err)
+		return
+	}
+
+	var expression *SearchExpression
+	if len(request.Expression) > 0 {
+		var err error
+		expression, err = readSearchExpression(NewReader(request.Expression))
+		if err != nil {
+			log.Printf("Invalid keyword search from %s: %s", r.from, err)
+			return
+		}
+	}
+
+	entries := client.index.Get(index.KindKeyword, request.Keyword, maxIndexResults, func(entry *index.Entry) bool {
+		return expression == nil || expression.Matches(entry.Tags)
+	})
+	client.answerSearch(r, request.Keyword, entries, int(request.StartPosition))
+}
+
+func HandleSearchSourceRequest(client *Client, r *UDPRequest, w Response) {
+	var request message.SearchSourceRequest
+	if err := r.decode(&request); err != nil {
+		log.Printf("Invalid source search from %s: %s", r.from, err)
+		return
+	}
+
+	entries := client.index.Get(index.KindSource, request.FileHash, maxIndexResults, fileSizeFilter(request.FileSize))
+	client.answerSearch(r, request.FileHash, entries, int(request.StartPosition))
+}
+
+func HandleSearchNotesRequest(client *Client, r *UDPRequest, w Response) {
+	var request message.SearchNotesRequest
+	if err := r.decode(&request); err != nil {
+		log.Printf("Invalid notes search from %s: %s", r.from, err)
+		return
+	}
+
+	entries := client.index.Get(index.KindNotes, request.FileHash, maxIndexResults, fileSizeFilter(request.FileSize))
+	client.answerSearch(r, request.FileHash, entries, 0)
+}
+
+func HandlePublishKeyRequest(client *Client, r *UDPRequest, w Response) {
+	var request message.PublishKeyRequest
+	if err := r.decode(&request); err != nil {
+		log.Printf("Invalid keyword publish from %s: %s", r.from, err)
+		return
+	}
+	if !client.acceptsPublish(request.Keyword) {
+		log.Printf("Ignoring keyword publish from %s for the distant %s", r.from, request.Keyword.ToHexString())
+		return
+	}
+
+	load := uint8(0)
+	for _, entry := range request.Entries {
+		entryLoad, err := client.storeKeywordEntry(request.Keyword, entry.FileHash, r.from.IP, entry.Tags)
+		if err != nil {
+			log.Printf("Keyword entry from %s not stored: %s", r.from, err)
+			continue
+		}
+		load = entryLoad
+	}
+
+	client.acknowledgePublish(r, request.Keyword, load)
+}
+
+func HandlePublishSourceRequest(client *Client, r *UDPRequest, w Response) {
+	handlePublishFileEntry(client, r, index.KindSource, client.acknowledgePublish)
+}
+
+func HandlePublishNotesRequest(client *Client, r *UDPRequest, w Response) {
+	handlePublishFileEntry(client, r, index.KindNotes, client.acknowledgePublish)
+}
+
+// Store a source or notes entry, both sent as the file hash, the source hash and the tags, and [acknowledge] it
+func handlePublishFileEntry(client *Client, r *UDPRequest, kind index.Kind, acknowledge func(r *UDPRequest, target types.UInt128, load uint8)) {
+	// The notes publishes have the layout of the source ones
+	var request message.PublishSourceRequest
+	if err := r.decode(&request); err != nil {
+		log.Printf("Invalid publish from %s: %s", r.from, err)
+		return
+	}
+	fileHash, tags := request.FileHash, request.Tags
+	if !client.acceptsPublish(fileHash) {
+		log.Printf("Ignoring publish from %s for the distant %s", r.from, fileHash.ToHexString())
+		return
+	}
+
+	if kind == index.KindSource {
+		if _, ok := tags.GetUInt(kadCommon.TagSourceType); !ok {
+			log.Printf("Ignoring source publish from %s without source type", r.from)
+			return
+		}
+	}
+
+	entry := index.Entry{Key: fileHash, ID: request.Source, IP: r.from.IP, Tags: publishedSourceTags(tags, r.from.IP)}
+	load, err := client.index.Add(kind, entry)
+	if err != nil {
+		log.Printf("Entry from %s not stored: %s", r.from, err)
+	}
+
+	acknowledge(r, fileHash, load)
+}
+
+func HandleFindBuddyRequest(client *Client, r *UDPRequest, w Response) {
+	if client.TCPFirewallStatus() != FirewallOpen {
+		log.Printf("Ignoring find buddy request from %s, as our TCP port isn't known to be open", r.from)
+		return
+	}
+
+	var request message.FindBuddy
+	if err := r.decode(&request); err != nil {
+		log.Printf("Invalid find buddy request from %s: %s", r.from, err)
+		return
+	}
+
+	client.buddies.access.Lock()
+	served := client.buddies.served
+	if served != nil && (served.link != nil || served.expires.After(time.Now())) {
+		client.buddies.access.Unlock()
+		log.Printf("Ignoring find buddy request from %s, already serving as buddy", r.from)
+		return
+	}
+	client.buddies.served = &servedBuddy{ip: r.from.IP, userHash: request.UserHash, buddyID: request.BuddyID, expires: time.Now().Add(incomingBuddyTTL)}
+	client.buddies.access.Unlock()
+
+	packet, err := factory.GetFindBuddyResponse(request.BuddyID, client.sourceID(), client.config.TcpPort)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err = client.reply(r, packet); err != nil {
+		log.Println(err)
+	}
+}
+
+func HandleFindBuddyResponse(client *Client, r *UDPRequest, w Response) {
+	if !client.consumeExpectedReply(r.from.IP, CommKadFindbuddyRes, nil) {
+		log.Printf("Ignoring unrequested find buddy response from %s", r.from)
+		return
+	}
+
+	var response message.FindBuddy
+	if err := r.decode(&response); err != nil {
+		log.Printf("Invalid find buddy response from %s: %s", r.from, err)
+		return
+	}
+	if !response.BuddyID.Equal(types.Not(client.config.ClientID)) {
+		log.Printf("Ignoring find buddy response from %s for other client", r.from)
+		return
+	}
+
+	client.buddies.access.Lock()
+	if client.buddies.buddy != nil || client.buddies.connecting {
+		client.buddies.access.Unlock()
+		return
+	}
+	client.buddies.connecting = true
+	client.buddies.access.Unlock()
+
+	buddy := &Buddy{UserHash: response.UserHash, IP: r.from.IP, UDPPort: uint16(r.from.Port), TCPPort: response.TCPPort}
+	client.spawn(func() { client.connectBuddy(buddy) })
+}
+
+func HandleCallbackRequest(client *Client, r *UDPRequest, w Response) {
+	var request message.CallbackRequest
+	if err := r.decode(&request); err != nil {
+		log.Printf("Invalid callback request from %s: %s", r.from, err)
+		return
+	}
+
+	callback := &kadBuddy.Callback{BuddyID: request.BuddyID, FileID: request.FileID, IP: r.from.IP, TCPPort: request.TCPPort}
+	if err := client.relayCallback(request.BuddyID, callback); err != nil {
+		log.Printf("Callback from %s not relayed: %s", r.from, err)
+	}
 }
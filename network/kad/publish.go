@@ -0,0 +1,383 @@
+package kad
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"path/filepath"
+	"sleepy/network/kad/index"
+	kadPacket "sleepy/network/kad/packet"
+	"sleepy/network/kad/packet/factory"
+	"sleepy/types"
+	"strings"
+	"time"
+)
+
+const (
+	keywordRepublishInterval    = 24 * time.Hour     // As the eMule KADEMLIAREPUBLISHTIMEK
+	keywordMaxRepublishInterval = 7 * 24 * time.Hour // Interval of the keywords published in fully loaded nodes
+	sourceRepublishInterval     = 5 * time.Hour      // As the eMule KADEMLIAREPUBLISHTIMES
+	publishCheckInterval        = time.Minute
+	publishLifetime             = 140 * time.Second // As the eMule SEARCHSTORE*_LIFETIME
+	publishHighLoad             = 50                // Load percentage from which the keywords are republished less often
+	maxConcurrentPublishes      = 5
+	minKeywordLength            = 3
+	keywordSeparators           = " ()[]{}<>,._-!?:;\\/\""
+)
+
+// SharedFile is a file announced by the client to the Kad network
+type SharedFile struct {
+	Hash types.UInt128
+	Name string
+	Size uint64
+	Type string
+}
+
+type sharedFile struct {
+	SharedFile
+	nextPublish time.Time
+	publishing  bool
+	lastLoad    uint8
+}
+
+// A keyword announced for all the shared files whose name contains it
+type publishedKeyword struct {
+	word        string
+	target      types.UInt128
+	files       map[string]*sharedFile
+	nextPublish time.Time
+	publishing  bool
+	lastLoad    uint8
+}
+
+// A publish waiting for the PUBLISH_RES loads of a target
+type publish struct {
+	kind    index.Kind
+	target  types.UInt128
+	asked   map[string]int // Responses pending by IP, guarded by the publishes lock
+	started time.Time
+	loads   chan uint8
+}
+
+// KeywordsOf gets the keywords a file is published under: the lowercase words of his name with at least three bytes,
+// without the extension
+func KeywordsOf(name string) []string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return strings.ContainsRune(keywordSeparators, r)
+	})
+
+	extension := strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+	if len(words) > 1 && words[len(words)-1] == extension {
+		words = words[:len(words)-1]
+	}
+
+	keywords := make([]string, 0, len(words))
+	found := make(map[string]bool)
+	for _, word := range words {
+		if len(word) >= minKeywordLength && !found[word] {
+			found[word] = true
+			keywords = append(keywords, word)
+		}
+	}
+	return keywords
+}
+
+// ShareFile starts announcing the file and his keywords to the network
+func (client *Client) ShareFile(file SharedFile) error {
+	if file.Hash == nil || file.Name == "" {
+		return errors.New("shared files need a hash and a name")
+	}
+
+	client.publisherAccess.Lock()
+	defer client.publisherAccess.Unlock()
+
+	key := file.Hash.ToHexString()
+	if _, found := client.sharedFiles[key]; found {
+		return errors.New("the file is already shared")
+	}
+	shared := &sharedFile{SharedFile: file}
+	client.sharedFiles[key] = shared
+
+	for _, word := range KeywordsOf(file.Name) {
+		keyword, found := client.keywords[word]
+		if !found {
+			keyword = &publishedKeyword{word: word, target: KeywordHash(word), files: make(map[string]*sharedFile)}
+			client.keywords[word] = keyword
+		}
+		keyword.files[key] = shared
+		// New files are announced as soon as possible
+		keyword.nextPublish = time.Time{}
+	}
+	return nil
+}
+
+// UnshareFile stops announcing the file. The entries already published expire by themselves
+func (client *Client) UnshareFile(hash types.UInt128) {
+	client.publisherAccess.Lock()
+	defer client.publisherAccess.Unlock()
+
+	key := hash.ToHexString()
+	delete(client.sharedFiles, key)
+	for word, keyword := range client.keywords {
+		delete(keyword.files, key)
+		if len(keyword.files) == 0 {
+			delete(client.keywords, word)
+		}
+	}
+}
+
+// Check periodically the keywords and sources to publish until the client stops
+func (client *Client) runPublisher() {
+	ticker := time.NewTicker(publishCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		client.publishDue()
+
+		select {
+		case <-client.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Start the publishes whose time has come, keeping at most [maxConcurrentPublishes] running
+func (client *Client) publishDue() {
+	client.publisherAccess.Lock()
+	defer client.publisherAccess.Unlock()
+
+	now := time.Now()
+	for _, keyword := range client.keywords {
+		if client.runningPublishes >= maxConcurrentPublishes {
+			return
+		}
+		if !keyword.publishing && !keyword.nextPublish.After(now) {
+			keyword.publishing = true
+			client.runningPublishes++
+			keyword, entries := keyword, client.keywordEntries(keyword)
+			if !client.spawn(func() { client.publishKeyword(keyword, entries) }) {
+				// The client is stopping
+				keyword.publishing = false
+				client.runningPublishes--
+				return
+			}
+		}
+	}
+
+	for _, file := range client.sharedFiles {
+		if client.runningPublishes >= maxConcurrentPublishes {
+			return
+		}
+		if !file.publishing && !file.nextPublish.After(now) {
+			file.publishing = true
+			client.runningPublishes++
+			file := file
+			if !client.spawn(func() { client.publishSource(file) }) {
+				file.publishing = false
+				client.runningPublishes--
+				return
+			}
+		}
+	}
+}
+
+// Get the entries announced under a keyword, limited to the max allowed in a packet
+func (client *Client) keywordEntries(keyword *publishedKeyword) []factory.KeywordEntry {
+	entries := make([]factory.KeywordEntry, 0, len(keyword.files))
+	for _, file := range keyword.files {
+		if len(entries) >= factory.MaxPublishKeyEntries {
+			break
+		}
+		entries = append(entries, factory.KeywordEntry{
+			FileHash: file.Hash,
+			Name:     file.Name,
+			Size:     file.Size,
+			Type:     file.Type,
+			Sources:  1,
+		})
+	}
+	return entries
+}
+
+func (client *Client) publishKeyword(keyword *publishedKeyword, entries []factory.KeywordEntry) {
+	load, responses := client.publish(client.ctx, index.KindKeyword, keyword.target, func(contact *Contact) (*kadPacket.Packet, error) {
+		return factory.GetPublishKey2Request(keyword.target, entries)
+	})
+	log.Printf("Keyword %s published in %d nodes with a load of %d%%", keyword.word, responses, load)
+
+	client.publisherAccess.Lock()
+	defer client.publisherAccess.Unlock()
+	client.runningPublishes--
+	keyword.publishing = false
+	keyword.lastLoad = load
+	keyword.nextPublish = time.Now().Add(keywordRepublishDelay(load))
+}
+
+// Busy keywords are republished less often, up to a week for the fully loaded ones
+func keywordRepublishDelay(load uint8) time.Duration {
+	if load < publishHighLoad {
+		return keywordRepublishInterval
+	}
+	if load > 100 {
+		load = 100
+	}
+	return keywordMaxRepublishInterval * time.Duration(load) / 100
+}
+
+// Get the ID identifying the client as a source, the user hash or the client ID if not set
+func (client *Client) sourceID() types.UInt128 {
+	if client.config.UserHash != nil {
+		return client.config.UserHash
+	}
+	return client.config.ClientID
+}
+
+func (client *Client) publishSource(file *sharedFile) {
+	source := client.sourceID()
+	details := factory.SourceDetails{
+		Type:     SourceTypeOpen,
+		TCPPort:  client.config.TcpPort,
+		UDPPort:  client.config.UdpPort,
+		FileSize: file.Size,
+	}
+	// The firewalled sources are only reachable through their buddy, so they aren't published without one
+	if client.TCPFirewallStatus() == FirewallFirewalled {
+		found, ok := client.GetBuddy()
+		if !ok {
+			log.Printf("Source of %s not published: firewalled without buddy", file.Name)
+			client.publisherAccess.Lock()
+			defer client.publisherAccess.Unlock()
+			client.runningPublishes--
+			file.publishing = false
+			file.nextPublish = time.Now().Add(buddySearchInterval)
+			return
+		}
+		details.Type = SourceTypeBuddyV2
+		details.BuddyID = types.Not(client.config.ClientID)
+		details.BuddyIP = found.IP
+		details.BuddyPort = found.UDPPort
+	}
+
+	load, responses := client.publish(client.ctx, index.KindSource, file.Hash, func(contact *Contact) (*kadPacket.Packet, error) {
+		return factory.GetPublishSource2Request(file.Hash, source, details)
+	})
+	log.Printf("Source of %s published in %d nodes with a load of %d%%", file.Name, responses, load)
+
+	client.publisherAccess.Lock()
+	defer client.publisherAccess.Unlock()
+	client.runningPublishes--
+	file.publishing = false
+	file.lastLoad = load
+	file.nextPublish = time.Now().Add(sourceRepublishInterval)
+}
+
+// Send the request built by [buildRequest] to the closest contacts to the [target], and wait for their responses
+// until the context ends or the publish lifetime expires. Returns the average load informed and the number of responses
+func (client *Client) publish(ctx context.Context, kind index.Kind, target types.UInt128, buildRequest func(contact *Contact) (*kadPacket.Packet, error)) (uint8, int) {
+	ctx, cancel := context.WithTimeout(ctx, publishLifetime)
+	defer cancel()
+	ctx, cancelWithClient := client.withClient(ctx)
+	defer cancelWithClient()
+
+	p := &publish{
+		kind:    kind,
+		target:  target.Clone(),
+		asked:   make(map[string]int),
+		started: time.Now(),
+		loads:   make(chan uint8, lookupK),
+	}
+	key := operationKey(kind, target)
+	client.publishesAccess.Lock()
+	if _, found := client.publishes[key]; found {
+		client.publishesAccess.Unlock()
+		log.Printf("Publish of %s not run: already running", key)
+		return 0, 0
+	}
+	client.publishes[key] = p
+	client.publishesAccess.Unlock()
+
+	defer func() {
+		client.publishesAccess.Lock()
+		delete(client.publishes, key)
+		client.publishesAccess.Unlock()
+	}()
+
+	contacts, err := client.lookup(ctx, target, LookupStore)
+	if err != nil {
+		log.Printf("Publish of %s not run: %s", key, err)
+		return 0, 0
+	}
+
+	sent := 0
+	for _, contact := range contacts {
+		// The publishes are only sent as Kad2 requests
+		if !contact.speaksKad2() {
+			continue
+		}
+		packet, err := buildRequest(contact)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		client.setAsked(p, contact.IP, 1)
+		reply := client.trackReply(contact.ClientID, contact.IP, CommKad2PublishRes, target, publishLifetime)
+		if err = client.sendToContact(contact, packet); err != nil {
+			client.cancelReply(reply, err)
+			client.setAsked(p, contact.IP, -1)
+			log.Println(err)
+			continue
+		}
+		sent++
+	}
+
+	totalLoad, responses := 0, 0
+	for responses < sent {
+		select {
+		case load := <-p.loads:
+			totalLoad += int(load)
+			responses++
+		case <-ctx.Done():
+			sent = responses
+		}
+	}
+
+	if responses == 0 {
+		return 0, 0
+	}
+	return uint8(totalLoad / responses), responses
+}
+
+// Count a response more or less pending from the contact in [ip]
+func (client *Client) setAsked(p *publish, ip net.IP, delta int) {
+	client.publishesAccess.Lock()
+	defer client.publishesAccess.Unlock()
+	p.asked[ip.String()] += delta
+}
+
+// Deliver the load informed by a PUBLISH_RES from [ip] to a publish running for [target] that asked it, if any. The
+// source and notes publishes of a file get the same responses, they are delivered to the oldest one
+func (client *Client) deliverPublishLoad(ip net.IP, target types.UInt128, load uint8) bool {
+	client.publishesAccess.Lock()
+	defer client.publishesAccess.Unlock()
+
+	var p *publish
+	for _, kind := range []index.Kind{index.KindKeyword, index.KindSource, index.KindNotes} {
+		candidate, found := client.publishes[operationKey(kind, target)]
+		if found && candidate.asked[ip.String()] > 0 && (p == nil || candidate.started.Before(p.started)) {
+			p = candidate
+		}
+	}
+	if p == nil {
+		return false
+	}
+	p.asked[ip.String()]--
+
+	select {
+	case p.loads <- load:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,163 @@
+package kad
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"sleepy/network/kad/packet/factory"
+	kadTypes "sleepy/network/kad/types"
+	"sleepy/types"
+	"testing"
+	"time"
+)
+
+func TestKeywordsOf(t *testing.T) {
+	keywords := KeywordsOf("The.Big_Movie (2010) - [HD] big.avi")
+	expected := []string{"the", "big", "movie", "2010"}
+	if !reflect.DeepEqual(keywords, expected) {
+		t.Errorf("Keywords mismatch, expected %v, got %v", expected, keywords)
+	}
+}
+
+func TestKeywordRepublishDelay(t *testing.T) {
+	if keywordRepublishDelay(10) != keywordRepublishInterval {
+		t.Errorf("Keywords with low load must be republished at the standard interval")
+	}
+	if keywordRepublishDelay(100) != keywordMaxRepublishInterval {
+		t.Errorf("Keywords with full load must be republished at the max interval")
+	}
+}
+
+// Answer the lookups and publishes sent by the client as a node closest to every target, with a load of 80
+func answerPublishes(client *Client, manager *fakeManager) {
+	manager.onSend = func(sent sentPacket) {
+		var data []byte
+		switch sent.data[1] {
+		case CommKad2Req:
+			target, _ := NewReader(sent.data[3:]).ReadUInt128()
+			packet, _ := factory.GetKad2Response(target, []kadTypes.Peer{})
+			data = packet.GetData()
+		case CommKad2PublichKeyReq, CommKad2PublishSourceReq:
+			buffer := &bytes.Buffer{}
+			buffer.Write([]byte{0xE4, CommKad2PublishRes})
+			buffer.Write(sent.data[2:18])
+			buffer.WriteByte(80)
+			data = buffer.Bytes()
+		default:
+			return
+		}
+		go client.handleUDP(data, &net.UDPAddr{IP: sent.ip, Port: int(sent.port)})
+	}
+}
+
+// Wait until the running publishes finish
+func waitPublishes(t *testing.T, client *Client) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		client.publisherAccess.Lock()
+		running := client.runningPublishes
+		client.publisherAccess.Unlock()
+		if running == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("The publishes didn't finish in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClient_PublishDue(t *testing.T) {
+	client, manager := newTestClient()
+	node := newTestPeer(types.NewUInt128(1, 2), 1)
+	client.router.AddPeer(node)
+	answerPublishes(client, manager)
+
+	file := SharedFile{Hash: types.NewUInt128(0x1111, 0x2222), Name: "holidays.avi", Size: 0x1000}
+	if err := client.ShareFile(file); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if client.ShareFile(file) == nil {
+		t.Errorf("A file can't be shared twice")
+	}
+
+	client.publishDue()
+	waitPublishes(t, client)
+
+	keyRequests := manager.sentWithCommand(CommKad2PublichKeyReq)
+	if len(keyRequests) != 1 || !bytes.Equal(keyRequests[0].data[2:18], kadUInt128Bytes(KeywordHash("holidays"))) {
+		t.Errorf("The keyword must be published to the closest node")
+	}
+	if len(manager.sentWithCommand(CommKad2PublishSourceReq)) != 1 {
+		t.Errorf("The source must be published to the closest node")
+	}
+
+	client.publisherAccess.Lock()
+	defer client.publisherAccess.Unlock()
+	keyword := client.keywords["holidays"]
+	if keyword.lastLoad != 80 || time.Until(keyword.nextPublish) <= keywordRepublishInterval {
+		t.Errorf("The keyword load must delay his republish, load %d", keyword.lastLoad)
+	}
+	source := client.sharedFiles[file.Hash.ToHexString()]
+	if source.lastLoad != 80 || time.Until(source.nextPublish) <= sourceRepublishInterval-time.Minute {
+		t.Errorf("The source must be scheduled to be republished, load %d", source.lastLoad)
+	}
+}
+
+func TestClient_PublishDueSeveral(t *testing.T) {
+	client, manager := newTestClient()
+	client.router.AddPeer(newTestPeer(types.NewUInt128(1, 2), 1))
+	answerPublishes(client, manager)
+
+	files := []SharedFile{
+		{Hash: types.NewUInt128(0x1111, 0x2222), Name: "holidays.avi", Size: 0x1000},
+		{Hash: types.NewUInt128(0x3333, 0x4444), Name: "wedding.mkv", Size: 0x2000},
+	}
+	for _, file := range files {
+		if err := client.ShareFile(file); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+
+	client.publishDue()
+	waitPublishes(t, client)
+
+	published := func(command byte, target types.UInt128) bool {
+		for _, sent := range manager.sentWithCommand(command) {
+			if bytes.Equal(sent.data[2:18], kadUInt128Bytes(target)) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, word := range []string{"holidays", "wedding"} {
+		if !published(CommKad2PublichKeyReq, KeywordHash(word)) {
+			t.Errorf("The keyword %s must be published", word)
+		}
+	}
+	for _, file := range files {
+		if !published(CommKad2PublishSourceReq, file.Hash) {
+			t.Errorf("The source of %s must be published", file.Name)
+		}
+	}
+}
+
+func TestClient_PublishDueWhileStopping(t *testing.T) {
+	client, _ := newTestClient()
+	file := SharedFile{Hash: types.NewUInt128(0x1111, 0x2222), Name: "holidays.avi", Size: 0x1000}
+	if err := client.ShareFile(file); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	client.Stop()
+
+	client.publishDue()
+
+	client.publisherAccess.Lock()
+	defer client.publisherAccess.Unlock()
+	if client.runningPublishes != 0 {
+		t.Errorf("The publishes not started must not be counted, got %d", client.runningPublishes)
+	}
+	if client.keywords["holidays"].publishing || client.sharedFiles[file.Hash.ToHexString()].publishing {
+		t.Errorf("The publishes not started must not be marked as running")
+	}
+}
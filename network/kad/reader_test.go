@@ -1,35 +1,38 @@
 package kad
 
 import (
+	"bytes"
+	"sleepy/network/kad/common"
+	"sleepy/network/kad/packet/factory"
+	"sleepy/types"
 	"testing"
 )
 
-func TestReader_ReadByte(t *testing.T) {
-	data := []byte{0x00, 0x01, 0x02, 0x03}
-	reader := Reader{data: data, offset: 0}
-	reader.Discard(1)
-	read, err := reader.ReadByte()
+func TestReader_ReadTagsRoundTrip(t *testing.T) {
+	keyword := types.NewUInt128(1, 2)
+	packet, err := factory.GetPublishKey2Request(keyword, []factory.KeywordEntry{
+		{FileHash: types.NewUInt128(3, 4), Name: "holidays.avi", Size: 0x100000000, Type: "Video", Sources: 7},
+	})
 	if err != nil {
-		t.Errorf("Read errors: %s", err)
+		t.Fatalf("Unexpected error: %s", err)
 	}
-	if read != data[1] {
-		t.Errorf("Read byte error, got: %d, want: %d", read, data[1])
-	}
-	reader.Discard(1)
-	read, err = reader.ReadByte()
+
+	// Opcode, keyword, entry count and file hash precede the tags
+	data := packet.GetData()
+	reader := NewReader(data[2+16+2+16:])
+	tags, err := reader.ReadTags()
 	if err != nil {
-		t.Errorf("Read errors: %s", err)
+		t.Fatalf("Unexpected error: %s", err)
 	}
-	if read != data[3] {
-		t.Errorf("Read byte error, got: %d, want: %d", read, data[3])
+	if size, ok := tags.GetUInt(common.TagFileSize); !ok || size != 0x100000000 {
+		t.Errorf("The 64 bits file size must be read, got %d", size)
 	}
-}
 
-func TestReader_Correct(t *testing.T) {
-	data := []byte{0x00, 0x01, 0x02, 0x03}
-	reader := Reader{data: data, offset: 0}
-	err := reader.Discard(5)
-	if err == nil {
-		t.Errorf("Must has read errors: %s", err)
+	encoded, err := tags.Encode()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !bytes.Equal(encoded, data[2+16+2+16:]) {
+		t.Errorf("The read tags must be encoded to the same bytes")
 	}
 }
@@ -3,7 +3,7 @@ package kad
 import (
 	"context"
 	"net"
-	"sleepy/types"
+	"sleepy/network/kad/message"
 )
 
 type Request struct {
@@ -14,12 +14,13 @@ type Request struct {
 type UDPRequest struct {
 	Request
 	from *net.UDPAddr
+	// Obfuscation details of the datagram, the keys are zero for the plain ones
+	obfuscated       bool
+	senderKey        uint32
+	validReceiverKey bool
 }
 
-type Bootstrap1Request struct {
-	Request
-	ClientID types.UInt128
-	Address  net.IP
-	UDPPort  uint16
-	TCPPort  uint16
+// Decode the payload of the request into the [msg]
+func (request *Request) decode(msg message.Message) error {
+	return msg.Decode(request.body.Remaining())
 }
@@ -227,9 +227,11 @@ func (bucket *kBucket) IsFull() bool {
 func (bucket *kBucket) pushToEnd(peer kadTypes.Peer) error {
 	bucket.peersAccess.Lock()
 
-	for position, currPeer := range bucket.peers {
+	for position := 0; position < bucket.peersCount; position++ {
+		currPeer := bucket.peers[position]
 		if peer.Equal(currPeer) {
-			bucket.peers = append(append(bucket.peers[0:position], bucket.peers[position+1:bucket.peersCount]...), peer)
+			copy(bucket.peers[position:], bucket.peers[position+1:bucket.peersCount])
+			bucket.peers[bucket.peersCount-1] = currPeer
 			bucket.peersAccess.Unlock()
 			return nil
 		}
@@ -0,0 +1,262 @@
+package router
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	kadTypes "sleepy/network/kad/types"
+	"sleepy/types"
+)
+
+const (
+	nodesFileVersion          = 2 // Version written, the newest one with contact versions, UDP keys and verified flag
+	nodesFileBootstrapVersion = 3 // Version used by the bootstrap only edition
+	maxNodesFileEntries       = 5000
+)
+
+// NodesFile is the content of an eMule nodes.dat file
+type NodesFile struct {
+	Version uint32
+	// BootstrapOnly is set for the special bootstrap edition, whose contacts must be only used to bootstrap
+	BootstrapOnly bool
+	Peers         []kadTypes.Peer
+}
+
+// ReadNodesFile reads an eMule nodes.dat file from disk
+func ReadNodesFile(path string) (*NodesFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadNodes(bufio.NewReader(file))
+}
+
+// ReadNodes reads the content of an eMule nodes.dat file, in any of the versions 0, 1, 2 or the bootstrap edition
+func ReadNodes(reader io.Reader) (*NodesFile, error) {
+	nodes := &NodesFile{Peers: make([]kadTypes.Peer, 0)}
+
+	count, err := readNodesUInt32(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// Version 0 files start directly with the number of contacts, newer ones start with a zero
+	if count == 0 {
+		if nodes.Version, err = readNodesUInt32(reader); err != nil {
+			return nil, err
+		}
+
+		if nodes.Version == nodesFileBootstrapVersion {
+			edition, err := readNodesUInt32(reader)
+			if err != nil {
+				return nil, err
+			}
+			nodes.BootstrapOnly = edition == 1
+		}
+
+		if nodes.Version >= 1 && nodes.Version <= nodesFileBootstrapVersion {
+			if count, err = readNodesUInt32(reader); err != nil {
+				return nil, err
+			}
+		} else {
+			return nil, errors.New("unknown nodes file version")
+		}
+	}
+
+	if count > maxNodesFileEntries {
+		return nil, errors.New("too many contacts in the nodes file")
+	}
+
+	for ; count > 0; count-- {
+		peer, valid, err := readNodesContact(reader, nodes.Version, nodes.BootstrapOnly)
+		if err != nil {
+			return nil, err
+		}
+		if valid {
+			nodes.Peers = append(nodes.Peers, peer)
+		}
+	}
+
+	return nodes, nil
+}
+
+// Read a nodes file contact and check if it's valid to be used
+func readNodesContact(reader io.Reader, version uint32, bootstrapOnly bool) (kadTypes.Peer, bool, error) {
+	id, err := readNodesUInt128(reader)
+	if err != nil {
+		return nil, false, err
+	}
+	ip, err := readNodesIPv4(reader)
+	if err != nil {
+		return nil, false, err
+	}
+	udpPort, err := readNodesUInt16(reader)
+	if err != nil {
+		return nil, false, err
+	}
+	tcpPort, err := readNodesUInt16(reader)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var contactType, contactVersion uint8
+	if version >= 1 {
+		contactVersion, err = readNodesUInt8(reader)
+	} else {
+		contactType, err = readNodesUInt8(reader)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	udpKey := kadTypes.UDPKey{}
+	verified := false
+	if version >= 2 && !bootstrapOnly {
+		if udpKey.Key, err = readNodesUInt32(reader); err != nil {
+			return nil, false, err
+		}
+		if udpKey.IP, err = readNodesIPv4(reader); err != nil {
+			return nil, false, err
+		}
+		verifiedFlag, err := readNodesUInt8(reader)
+		if err != nil {
+			return nil, false, err
+		}
+		verified = verifiedFlag != 0
+	}
+
+	peer := kadTypes.NewPeer(id)
+	peer.SetIP(ip, verified)
+	peer.SetUDPPort(udpPort)
+	peer.SetTCPPort(tcpPort)
+	peer.SetProtocolVersion(contactVersion)
+	peer.SetUDPKey(udpKey)
+
+	valid := !ip.IsUnspecified() && udpPort != 0 && contactType < kadTypes.ExpiredPeerType
+	// Old versions could be used for DNS attacks
+	if udpPort == 53 && contactVersion <= 5 {
+		valid = false
+	}
+
+	return peer, valid, nil
+}
+
+// WriteNodesFile writes the peers to disk as an eMule nodes.dat file, replacing the previous one
+func WriteNodesFile(path string, peers []kadTypes.Peer) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmpFile)
+	err = WriteNodes(writer, peers)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// WriteNodes writes the peers as an eMule nodes.dat file of version 2
+func WriteNodes(writer io.Writer, peers []kadTypes.Peer) error {
+	header := []uint32{0, nodesFileVersion, uint32(len(peers))}
+	if err := binary.Write(writer, binary.LittleEndian, header); err != nil {
+		return err
+	}
+
+	for _, peer := range peers {
+		if err := writeNodesContact(writer, peer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeNodesContact(writer io.Writer, peer kadTypes.Peer) error {
+	udpKey := peer.GetUDPKey()
+	verified := uint8(0)
+	if peer.IsIPVerified() {
+		verified = 1
+	}
+
+	data := make([]byte, 0, 16+4+2+2+1+4+4+1)
+	data = append(data, nodesUInt128Bytes(peer.GetID())...)
+	data = append(data, nodesIPv4Bytes(peer.GetIP())...)
+	data = binary.LittleEndian.AppendUint16(data, peer.GetUDPPort())
+	data = binary.LittleEndian.AppendUint16(data, peer.GetTCPPort())
+	data = append(data, peer.GetProtocolVersion())
+	data = binary.LittleEndian.AppendUint32(data, udpKey.Key)
+	data = append(data, nodesIPv4Bytes(udpKey.IP)...)
+	data = append(data, verified)
+
+	_, err := writer.Write(data)
+	return err
+}
+
+func readNodesUInt8(reader io.Reader) (uint8, error) {
+	var value uint8
+	err := binary.Read(reader, binary.LittleEndian, &value)
+	return value, err
+}
+
+func readNodesUInt16(reader io.Reader) (uint16, error) {
+	var value uint16
+	err := binary.Read(reader, binary.LittleEndian, &value)
+	return value, err
+}
+
+func readNodesUInt32(reader io.Reader) (uint32, error) {
+	var value uint32
+	err := binary.Read(reader, binary.LittleEndian, &value)
+	return value, err
+}
+
+// Read a 128 bits number stored as four little endian 32 bits words from the most significant
+func readNodesUInt128(reader io.Reader) (types.UInt128, error) {
+	buffer := make([]byte, 16)
+	if _, err := io.ReadFull(reader, buffer); err != nil {
+		return nil, err
+	}
+	for word := 0; word < 16; word += 4 {
+		buffer[word], buffer[word+1], buffer[word+2], buffer[word+3] = buffer[word+3], buffer[word+2], buffer[word+1], buffer[word]
+	}
+	return types.NewUInt128FromByteArray(buffer)
+}
+
+// Read an IP stored as a little endian 32 bits number in host order
+func readNodesIPv4(reader io.Reader) (net.IP, error) {
+	buffer := make([]byte, 4)
+	if _, err := io.ReadFull(reader, buffer); err != nil {
+		return nil, err
+	}
+	return net.IPv4(buffer[3], buffer[2], buffer[1], buffer[0]), nil
+}
+
+func nodesUInt128Bytes(value types.UInt128) []byte {
+	data := value.ToBytes()
+	for word := 0; word < 16; word += 4 {
+		data[word], data[word+1], data[word+2], data[word+3] = data[word+3], data[word+2], data[word+1], data[word]
+	}
+	return data
+}
+
+func nodesIPv4Bytes(ip net.IP) []byte {
+	ipv4 := ip.To4()
+	if ipv4 == nil {
+		return []byte{0, 0, 0, 0}
+	}
+	return []byte{ipv4[3], ipv4[2], ipv4[1], ipv4[0]}
+}
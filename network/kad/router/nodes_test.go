@@ -0,0 +1,114 @@
+package router
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	kadTypes "sleepy/network/kad/types"
+	"sleepy/types"
+	"testing"
+)
+
+func TestNodes_WriteAndRead(t *testing.T) {
+	peer := kadTypes.NewPeer(types.NewUInt128(0x0123456789abcdef, 0xfedcba9876543210))
+	peer.SetIP(net.IPv4(1, 2, 3, 4), true)
+	peer.SetUDPPort(4672)
+	peer.SetTCPPort(4662)
+	peer.SetProtocolVersion(8)
+	peer.SetUDPKey(kadTypes.UDPKey{Key: 0xdeadbeef, IP: net.IPv4(5, 6, 7, 8)})
+
+	buffer := &bytes.Buffer{}
+	if err := WriteNodes(buffer, []kadTypes.Peer{peer}); err != nil {
+		t.Fatalf("Unexpected error writing nodes: %s", err)
+	}
+
+	nodes, err := ReadNodes(buffer)
+	if err != nil {
+		t.Fatalf("Unexpected error reading nodes: %s", err)
+	}
+	if nodes.Version != nodesFileVersion || nodes.BootstrapOnly || len(nodes.Peers) != 1 {
+		t.Fatalf("Nodes file mismatch: version %d, %d peers", nodes.Version, len(nodes.Peers))
+	}
+
+	read := nodes.Peers[0]
+	if !read.Equal(peer) || !read.GetIP().Equal(peer.GetIP()) || !read.IsIPVerified() {
+		t.Errorf("Peer identity mismatch, got 0x%s %s", read.GetID().ToHexString(), read.GetIP())
+	}
+	if read.GetUDPPort() != 4672 || read.GetTCPPort() != 4662 || read.GetProtocolVersion() != 8 {
+		t.Errorf("Peer details mismatch")
+	}
+	if read.GetUDPKey().Key != 0xdeadbeef || !read.GetUDPKey().IP.Equal(net.IPv4(5, 6, 7, 8)) {
+		t.Errorf("Peer UDP key mismatch")
+	}
+}
+
+func TestNodes_ReadVersion0(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	binary.Write(buffer, binary.LittleEndian, uint32(2))
+	// Valid contact
+	buffer.Write(make([]byte, 12))
+	buffer.Write([]byte{1, 0, 0, 0})
+	buffer.Write([]byte{4, 3, 2, 1})
+	binary.Write(buffer, binary.LittleEndian, []uint16{4672, 4662})
+	buffer.WriteByte(kadTypes.NewPeerType)
+	// Expired contact
+	buffer.Write(make([]byte, 12))
+	buffer.Write([]byte{2, 0, 0, 0})
+	buffer.Write([]byte{4, 3, 2, 1})
+	binary.Write(buffer, binary.LittleEndian, []uint16{4672, 4662})
+	buffer.WriteByte(kadTypes.ExpiredPeerType)
+
+	nodes, err := ReadNodes(buffer)
+	if err != nil {
+		t.Fatalf("Unexpected error reading nodes: %s", err)
+	}
+	if nodes.Version != 0 || len(nodes.Peers) != 1 {
+		t.Fatalf("Version 0 file must contain a unique valid peer, %d found", len(nodes.Peers))
+	}
+	if !nodes.Peers[0].GetID().Equal(types.NewUInt128FromInt(1)) || !nodes.Peers[0].GetIP().Equal(net.IPv4(1, 2, 3, 4)) {
+		t.Errorf("Peer mismatch, got 0x%s %s", nodes.Peers[0].GetID().ToHexString(), nodes.Peers[0].GetIP())
+	}
+}
+
+func TestNodes_ReadBootstrapEdition(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	binary.Write(buffer, binary.LittleEndian, []uint32{0, 3, 1, 1})
+	buffer.Write(make([]byte, 12))
+	buffer.Write([]byte{1, 0, 0, 0})
+	buffer.Write([]byte{4, 3, 2, 1})
+	binary.Write(buffer, binary.LittleEndian, []uint16{4672, 4662})
+	buffer.WriteByte(8)
+
+	nodes, err := ReadNodes(buffer)
+	if err != nil {
+		t.Fatalf("Unexpected error reading nodes: %s", err)
+	}
+	if !nodes.BootstrapOnly || len(nodes.Peers) != 1 {
+		t.Errorf("Bootstrap edition must be detected with his unique peer")
+	}
+}
+
+func TestRouter_SaveAndLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.dat")
+	router := NewRouter(types.NewUInt128FromInt(0xff00ff))
+
+	for i := 1; i <= 5; i++ {
+		peer := kadTypes.NewPeer(types.NewUInt128(uint64(i), uint64(i)))
+		peer.SetIP(net.IPv4(10, 0, 0, byte(i)), false)
+		peer.SetUDPPort(4672)
+		router.AddPeer(peer)
+	}
+
+	if err := router.SaveFile(path); err != nil {
+		t.Fatalf("Unexpected error saving: %s", err)
+	}
+
+	loaded, err := LoadRouterFromFile(types.NewUInt128FromInt(0xff00ff), path)
+	if err != nil {
+		t.Fatalf("Unexpected error loading: %s", err)
+	}
+	if loaded.CountPeers() != 5 {
+		t.Errorf("Loaded router must contain 5 peers, %d found", loaded.CountPeers())
+	}
+}
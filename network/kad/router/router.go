@@ -1,11 +1,15 @@
 package router
 
 import (
+	"context"
 	"errors"
 	"math/rand"
+	"net"
+	"sleepy/network/ipfilter"
 	kadTypes "sleepy/network/kad/types"
 	"sleepy/types"
 	"sleepy/utils/event"
+	"sync"
 	"time"
 )
 
@@ -14,6 +18,26 @@ type Router interface {
 	AddPeer(peer kadTypes.Peer) error
 	// GetBootstrapPeers returns a list of peers of [max] size prepared to do a bootstrap
 	GetBootstrapPeers(max int, closedTo types.UInt128) []kadTypes.Peer
+	// GetPeer returns the peer with the provided id
+	GetPeer(id types.UInt128) (kadTypes.Peer, error)
+	// ContainsPeer checks if the router contains a peer with the provided id
+	ContainsPeer(id types.UInt128) bool
+	// CountPeers returns the number of peers in the router
+	CountPeers() int
+	// VerifyPeer sets the peer as verified if the provided ip is equal than the known one
+	VerifyPeer(id types.UInt128, ip net.IP) bool
+	// SetPeerAlive refresh the expiration of the peer with the provided id
+	SetPeerAlive(id types.UInt128) error
+	// SaveFile writes the router peers to a nodes.dat file
+	SaveFile(path string) error
+	// GetClosestPeers returns the [max] verified and alive peers closest to the provided id
+	GetClosestPeers(to types.UInt128, max int) []kadTypes.Peer
+	// PeerLookupRequestEvent is fired with a PeerIdEventArgs when the router needs a lookup of an id
+	PeerLookupRequestEvent() *event.Handler
+	// SetIPFilter sets the filter of the IPs rejected by AddPeer
+	SetIPFilter(filter *ipfilter.Filter)
+	// Stop ends the periodic checks of the zones, returning once all of them have finished
+	Stop()
 }
 
 // The router is the special zone in the root of a zone tree
@@ -22,27 +46,48 @@ type routerImp struct {
 	randomGenerator        *rand.Rand
 	peerUpdateRequestEvent *event.Emitter
 	peerLookupRequestEvent *event.Emitter
+	filter                 *ipfilter.Filter
+	// Done when the router stops, ending the checks of all the zones
+	ctx    context.Context
+	cancel context.CancelFunc
+	checks sync.WaitGroup
 }
 
 var _ Router = &routerImp{}
 
-// Load a router zone tree from file
+// Load a router zone tree from a nodes.dat file
 func LoadRouterFromFile(localId types.UInt128, path string) (*routerImp, error) {
-	return nil, errors.New("not implemented yet")
+	nodes, err := ReadNodesFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if nodes.BootstrapOnly {
+		return nil, errors.New("the nodes file contains only bootstrap contacts")
+	}
+
+	router := newRouter(localId)
+	for _, peer := range nodes.Peers {
+		// Contacts rejected by the router are simply not loaded
+		_ = router.AddPeer(peer)
+	}
+	return router, nil
 }
 
 // Create a new zone tree (routerImp) from the local peer GetID
 func NewRouter(id types.UInt128) Router {
+	return newRouter(id)
+}
+
+func newRouter(id types.UInt128) *routerImp {
 	rz := &routerImp{
 		zone: zone{
-			localId:           id.Clone(),
-			zoneIndex:         types.NewUInt128FromInt(0),
-			parent:            nil,
-			leftChild:         nil,
-			rightChild:        nil,
-			level:             0,
-			bucket:            newKBucket(),
-			randomLookupTimer: nil,
+			localId:    id.Clone(),
+			zoneIndex:  types.NewUInt128FromInt(0),
+			parent:     nil,
+			leftChild:  nil,
+			rightChild: nil,
+			level:      0,
+			bucket:     newKBucket(),
 		},
 		randomGenerator:        rand.New(rand.NewSource(time.Now().UnixNano())),
 		peerUpdateRequestEvent: event.NewEvent(),
@@ -50,6 +95,7 @@ func NewRouter(id types.UInt128) Router {
 	}
 
 	rz.zone.root = rz
+	rz.ctx, rz.cancel = context.WithCancel(context.Background())
 
 	rz.startChecks()
 
@@ -66,8 +112,29 @@ func (router *routerImp) PeerLookupRequestEvent() *event.Handler {
 	return router.peerLookupRequestEvent.GetHandler()
 }
 
+func (router *routerImp) SetIPFilter(filter *ipfilter.Filter) {
+	router.filter = filter
+}
+
+// Add a peer to the zone tree, unless its IP is filtered
+func (router *routerImp) AddPeer(peer kadTypes.Peer) error {
+	if router.filter != nil && router.filter.IsFiltered(peer.GetIP()) {
+		return errors.New("the peer IP is filtered")
+	}
+	return router.zone.AddPeer(peer)
+}
+
+func (router *routerImp) Stop() {
+	router.cancel()
+	router.checks.Wait()
+}
+
+// Save the alive peers to a nodes.dat file
 func (router *routerImp) SaveFile(path string) error {
-	return errors.New("not implemented yet")
+	peers := kadTypes.Filter(router.Peers(), func(peer kadTypes.Peer) bool {
+		return peer.IsAlive()
+	})
+	return WriteNodesFile(path, peers)
 }
 
 func (router *routerImp) GetBootstrapPeers(max int, _ types.UInt128) []kadTypes.Peer {
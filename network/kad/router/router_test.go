@@ -1,7 +1,10 @@
 package router
 
 import (
+	"math"
+	"sleepy/types"
 	"testing"
+	"time"
 )
 
 func TestRouter_getRoot(t *testing.T) {
@@ -15,3 +18,26 @@ func TestRouter_getRoot(t *testing.T) {
 		t.Errorf("The test zone parent must be the test router: %p %p", testZone.Root(), testRouter)
 	}
 }
+
+func TestRouter_Stop(t *testing.T) {
+	router := newRouter(types.NewUInt128FromInt(0xff00ff))
+	child := newChildZone(&router.zone, true)
+	child.stopChecks()
+
+	stopped := make(chan struct{})
+	go func() {
+		router.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("The router must stop without waiting for the zone timers")
+	}
+}
+
+func TestRouter_LargeID(t *testing.T) {
+	// The zone timers must not depend on the ID, whose low word overflows a duration in seconds
+	router := newRouter(types.NewUInt128(math.MaxUint64, 0))
+	router.Stop()
+}
@@ -1,8 +1,8 @@
 package router
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"math/rand"
 	"net"
 	"sleepy/network/ed2k/common"
@@ -15,6 +15,10 @@ import (
 
 const (
 	maxLevels = 6
+	// Time between the checks of the peers of a zone, as the eMule small timer
+	zoneRefreshInterval = time.Minute
+	// Bound of the random delay added to the interval, so the zones don't check their peers at once
+	zoneRefreshJitter = 10 * time.Second
 )
 
 type PeerEventArgs struct {
@@ -29,22 +33,21 @@ type PeerIdEventArgs struct {
 
 // zone is node inside a binary tree of k-buckets
 type zone struct {
-	localId           types.UInt128
-	zoneIndex         types.UInt128
-	parent            *zone
-	root              *routerImp
-	leftChild         *zone
-	rightChild        *zone
-	level             uint8
-	bucket            *kBucket
-	updatePeersTimer  *time.Ticker
-	randomLookupTimer *time.Ticker
-	checkStopFlag     bool
-	zoneAccess        sync.Mutex
+	localId    types.UInt128
+	zoneIndex  types.UInt128
+	parent     *zone
+	root       *routerImp
+	leftChild  *zone
+	rightChild *zone
+	level      uint8
+	bucket     *kBucket
+	// Stops the checks of the zone, set while they run
+	cancelChecks context.CancelFunc
+	zoneAccess   sync.Mutex
 }
 
 // Create a child zone from a parent instance
-func newChildZone(parent zone, isRightChild bool) *zone {
+func newChildZone(parent *zone, isRightChild bool) *zone {
 	zoneIndexCalculated := parent.zoneIndex.Clone()
 	zoneIndexCalculated.LeftShift(1)
 	if isRightChild {
@@ -52,16 +55,14 @@ func newChildZone(parent zone, isRightChild bool) *zone {
 	}
 
 	rz := &zone{
-		localId:           parent.localId,
-		zoneIndex:         zoneIndexCalculated,
-		parent:            &parent,
-		root:              parent.Root(),
-		leftChild:         nil,
-		rightChild:        nil,
-		level:             parent.level + 1,
-		bucket:            newKBucket(),
-		updatePeersTimer:  nil,
-		randomLookupTimer: nil,
+		localId:    parent.localId,
+		zoneIndex:  zoneIndexCalculated,
+		parent:     parent,
+		root:       parent.Root(),
+		leftChild:  nil,
+		rightChild: nil,
+		level:      parent.level + 1,
+		bucket:     newKBucket(),
 	}
 
 	rz.startChecks()
@@ -70,7 +71,7 @@ func newChildZone(parent zone, isRightChild bool) *zone {
 }
 
 // Create the two child zones from the parent instance
-func newChildZones(parent zone) (*zone, *zone) {
+func newChildZones(parent *zone) (*zone, *zone) {
 	return newChildZone(parent, false), newChildZone(parent, true)
 }
 
@@ -97,16 +98,21 @@ func (zn *zone) Parent() *zone {
 	return zn.parent
 }
 
-// Start all check subroutines
+// Start all check subroutines, that run until they are stopped or the router stops
 func (zn *zone) startChecks() {
-	zn.checkStopFlag = false
-	go zn.runUpdatePeersTimer()
-	go zn.runRandomLookupTimer()
+	ctx, cancel := context.WithCancel(zn.root.ctx)
+	zn.cancelChecks = cancel
+	zn.root.checks.Add(2)
+	go zn.runUpdatePeersTimer(ctx)
+	go zn.runRandomLookupTimer(ctx)
 }
 
 // Stop all check subroutines
 func (zn *zone) stopChecks() {
-	zn.checkStopFlag = true
+	if zn.cancelChecks != nil {
+		zn.cancelChecks()
+		zn.cancelChecks = nil
+	}
 }
 
 // Check if the object is a leaf (is not, is a branch)
@@ -132,14 +138,17 @@ func (zn *zone) maxDepth() int {
 	}
 }
 
-// Run a timer to do random lookup of peers
-func (zn *zone) runRandomLookupTimer() {
-	zn.randomLookupTimer = time.NewTicker(10 * time.Second)
-	for range zn.randomLookupTimer.C {
-		if zn.checkStopFlag {
-			// TODO: Find other immediate way to stop
-			break
-		} else {
+// Run a timer to do random lookup of peers until the [ctx] is done
+func (zn *zone) runRandomLookupTimer(ctx context.Context) {
+	defer zn.root.checks.Done()
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 			zn.onRandomLookupTimer()
 		}
 	}
@@ -148,10 +157,13 @@ func (zn *zone) runRandomLookupTimer() {
 // Handle the RandomLookup timer and run a lookup of a random peer inside each leaf (onBigTimer)
 func (zn *zone) onRandomLookupTimer() {
 	zn.zoneAccess.Lock()
-	if zn.isLeaf() && zn.level < maxLevels || float32(zn.bucket.CountPeers()) >= (maxBucketSize*0.8) {
-		// Generate a random ID inside this zn
-		randId := zn.zoneIndex.Clone()
-		randId.LeftShift(uint(zn.level))
+	if zn.isLeaf() && (zn.level < maxLevels || float32(zn.bucket.CountPeers()) >= (maxBucketSize*0.8)) {
+		// Generate a random ID inside this zn: his index as the distance prefix followed by random bits
+		prefix := zn.zoneIndex.Clone()
+		prefix.LeftShift(uint(128 - int(zn.level)))
+		randId := types.NewUInt128(rand.Uint64(), rand.Uint64())
+		randId.RightShift(uint(zn.level))
+		randId.Or(prefix)
 		randId.Xor(zn.localId)
 
 		// Emit event. The KAD client will insert the peer if it finds it
@@ -160,15 +172,17 @@ func (zn *zone) onRandomLookupTimer() {
 	zn.zoneAccess.Unlock()
 }
 
-// Run a timer to do periodic check of the peers
-func (zn *zone) runUpdatePeersTimer() {
-	idLow, _ := zn.localId.ToUInt64()
-	zn.updatePeersTimer = time.NewTicker(time.Duration(idLow) * time.Second)
-	for range zn.updatePeersTimer.C {
-		if zn.checkStopFlag {
-			// TODO: Find other immediate way to stop
-			break
-		} else {
+// Run a timer to do periodic check of the peers until the [ctx] is done
+func (zn *zone) runUpdatePeersTimer(ctx context.Context) {
+	defer zn.root.checks.Done()
+	ticker := time.NewTicker(zoneRefreshInterval + time.Duration(rand.Int63n(int64(zoneRefreshJitter))))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 			zn.onUpdatePeersTimer()
 		}
 	}
@@ -272,7 +286,7 @@ func (zn *zone) consolidate() {
 func (zn *zone) split() error {
 	if zn.canSplit() {
 		zn.stopChecks()
-		zn.leftChild, zn.rightChild = newChildZones(*zn)
+		zn.leftChild, zn.rightChild = newChildZones(zn)
 
 		for _, currPeer := range zn.bucket.Peers() {
 			distance := currPeer.GetDistance(zn.localId)
@@ -343,8 +357,6 @@ func (zn *zone) AddPeer(peer types2.Peer) error {
 			return errors.New("the router can't contains itself")
 		}
 	}
-
-	return nil
 }
 
 // Get a peer from his id
@@ -396,7 +408,7 @@ func (zn *zone) GetRandomPeer() (types2.Peer, error) {
 // Get a slice of all peers
 func (zn *zone) Peers() []types2.Peer {
 	if zn.isLeaf() {
-		return zn.bucket.peers
+		return zn.bucket.Peers()
 	} else {
 		return append(zn.leftChild.Peers(), zn.rightChild.Peers()...)
 	}
@@ -412,7 +424,6 @@ func (zn *zone) GetTopPeers(maxPeers int, maxDepth int) []types2.Peer {
 	} else if maxDepth <= 0 {
 		peers = zn.GetRandomBucketPeers()
 	} else {
-		fmt.Println(zn.leftChild)
 		peers = zn.leftChild.GetTopPeers(maxPeers, maxDepth-1)
 
 		if len(peers) < maxPeers {
@@ -459,7 +470,7 @@ func (zn *zone) GetClosestPeers(to types.UInt128, max int) []types2.Peer {
 		return zn.bucket.GetClosestPeers(to, max)
 	} else {
 		children := [2]*zone{zn.leftChild, zn.rightChild}
-		rPos := to.GetBit(int(zn.level))
+		rPos := types.Xor(zn.localId, to).GetBit(int(zn.level))
 
 		// Get from the closest branch
 		peers := children[rPos].GetClosestPeers(to, max)
@@ -490,6 +501,20 @@ func (zn *zone) ContainsPeer(id types.UInt128) bool {
 	}
 }
 
+// Set a peer as alive, refreshing his expiration and moving it to the end of his bucket
+func (zn *zone) SetPeerAlive(id types.UInt128) error {
+	if zn.isLeaf() {
+		return zn.bucket.SetPeerAlive(id)
+	} else {
+		distance := types.Xor(zn.localId, id)
+		if distance.GetBit(zn.Level()) == 0 {
+			return zn.leftChild.SetPeerAlive(id)
+		} else {
+			return zn.rightChild.SetPeerAlive(id)
+		}
+	}
+}
+
 // Set a peer as verified
 func (zn *zone) VerifyPeer(id types.UInt128, ip net.IP) bool {
 	peer, err := zn.GetPeer(id)
@@ -28,7 +28,7 @@ func TestZone_AddPeer(t *testing.T) {
 			} else if peerIn == nil {
 				t.Errorf("Peer can't be null")
 			} else if !peerIn.Equal(peer) {
-				t.Errorf("Z peer not equal, 0x%s expected, 0x%s found", peer.GetID().ToHexString(), peerIn.Id().ToHexString())
+				t.Errorf("Z peer not equal, 0x%s expected, 0x%s found", peer.GetID().ToHexString(), peerIn.GetID().ToHexString())
 			}
 		} else {
 			t.Errorf("zone must contains the peer, but not found.")
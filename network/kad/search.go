@@ -0,0 +1,207 @@
+package kad
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sleepy/network/common/tag"
+	"sleepy/network/kad/common"
+	"sleepy/network/kad/index"
+	kadPacket "sleepy/network/kad/packet"
+	"sleepy/types"
+	"sync"
+	"time"
+)
+
+// Max duration of a search, as the eMule SEARCH*_LIFETIME constants
+const searchLifetime = 45 * time.Second
+
+// Function called with each entry of the search responses
+type searchResultHandler func(s *search, from *net.UDPAddr, answer types.UInt128, tags tag.List)
+
+// A search waiting for the responses of a target
+type search struct {
+	kind     index.Kind
+	target   types.UInt128
+	queried  map[string]bool // IPs of the contacts asked, guarded by the searches lock
+	onResult searchResultHandler
+	done     chan struct{}
+	finished bool
+	access   sync.RWMutex
+}
+
+// Key of the searches and publishes of the [kind] about the [target]. The file hashes are the target of both the
+// source and the notes operations
+func operationKey(kind index.Kind, target types.UInt128) string {
+	return fmt.Sprintf("%d/%s", kind, target.ToHexString())
+}
+
+// Register a search of the [kind] for the [target]. Only one search by kind and target can run, as the responses
+// only carry the target
+func (client *Client) newSearch(kind index.Kind, target types.UInt128, onResult searchResultHandler) (*search, error) {
+	s := &search{
+		kind:     kind,
+		target:   target.Clone(),
+		queried:  make(map[string]bool),
+		onResult: onResult,
+		done:     make(chan struct{}),
+	}
+
+	client.searchesAccess.Lock()
+	defer client.searchesAccess.Unlock()
+	key := operationKey(kind, target)
+	if _, found := client.searches[key]; found {
+		return nil, errors.New("the same search is already running")
+	}
+	client.searches[key] = s
+	return s, nil
+}
+
+// Unregister the search, returning once the results being delivered are handled
+func (client *Client) removeSearch(s *search) {
+	client.searchesAccess.Lock()
+	delete(client.searches, operationKey(s.kind, s.target))
+	client.searchesAccess.Unlock()
+
+	close(s.done)
+	s.access.Lock()
+	s.finished = true
+	s.access.Unlock()
+}
+
+// Deliver a search response entry, unless the search has finished
+func (s *search) deliver(from *net.UDPAddr, answer types.UInt128, tags tag.List) {
+	s.access.RLock()
+	defer s.access.RUnlock()
+	if !s.finished {
+		s.onResult(s, from, answer, tags)
+	}
+}
+
+// Check if an entry may answer the search. The source and notes searches of a file get the same responses, only
+// the sources have a source type
+func (s *search) accepts(tags tag.List) bool {
+	_, source := tags.GetUInt(common.TagSourceType)
+	switch s.kind {
+	case index.KindSource:
+		return source
+	case index.KindNotes:
+		return !source
+	}
+	return true
+}
+
+// Get the searches for the [target] that asked the contact in [ip]
+func (client *Client) getSearches(target types.UInt128, ip net.IP) []*search {
+	client.searchesAccess.Lock()
+	defer client.searchesAccess.Unlock()
+
+	searches := make([]*search, 0, 1)
+	for _, kind := range []index.Kind{index.KindKeyword, index.KindSource, index.KindNotes} {
+		if s, found := client.searches[operationKey(kind, target)]; found && s.queried[ip.String()] {
+			searches = append(searches, s)
+		}
+	}
+	return searches
+}
+
+// Set if the contact in [ip] is asked by the search
+func (client *Client) setQueried(s *search, ip net.IP, queried bool) {
+	client.searchesAccess.Lock()
+	defer client.searchesAccess.Unlock()
+	if queried {
+		s.queried[ip.String()] = true
+	} else {
+		delete(s.queried, ip.String())
+	}
+}
+
+// Run a registered search: look for the closest contacts to the target and send them the request built by
+// [buildRequest], then wait for the responses until the context ends or the search lifetime expires
+func (client *Client) runSearch(ctx context.Context, s *search, buildRequest func(contact *Contact) (*kadPacket.Packet, error)) {
+	defer client.removeSearch(s)
+
+	ctx, cancel := context.WithTimeout(ctx, searchLifetime)
+	defer cancel()
+	ctx, cancelWithClient := client.withClient(ctx)
+	defer cancelWithClient()
+
+	contacts, err := client.lookup(ctx, s.target, LookupFindValue)
+	if err != nil {
+		log.Printf("Search of %s not run: %s", s.target.ToHexString(), err)
+		return
+	}
+
+	for _, contact := range contacts {
+		// The searches are only sent as Kad2 requests
+		if !contact.speaksKad2() {
+			continue
+		}
+		packet, err := buildRequest(contact)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		// The responses may arrive before the send returns
+		client.setQueried(s, contact.IP, true)
+		err = client.sendToContact(contact, packet)
+		if err != nil {
+			client.setQueried(s, contact.IP, false)
+			log.Println(err)
+		}
+	}
+
+	<-ctx.Done()
+}
+
+// Start a search whose entries are decoded by [decode] and streamed through the returned channel, skipping the
+// duplicated keys and stopping after [max] entries. The channel is closed when the search finishes
+func streamSearch[T any](
+	client *Client,
+	ctx context.Context,
+	kind index.Kind,
+	target types.UInt128,
+	max int,
+	decode func(from *net.UDPAddr, answer types.UInt128, tags tag.List) (T, string, bool),
+	buildRequest func(contact *Contact) (*kadPacket.Packet, error),
+) (<-chan T, error) {
+	results := make(chan T, 64)
+	found := make(map[string]bool)
+	var foundAccess sync.Mutex
+
+	s, err := client.newSearch(kind, target, func(s *search, from *net.UDPAddr, answer types.UInt128, tags tag.List) {
+		result, key, ok := decode(from, answer, tags)
+		if !ok {
+			return
+		}
+
+		foundAccess.Lock()
+		if found[key] || len(found) >= max {
+			foundAccess.Unlock()
+			return
+		}
+		found[key] = true
+		foundAccess.Unlock()
+
+		select {
+		case results <- result:
+		case <-s.done:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	started := client.spawn(func() {
+		client.runSearch(ctx, s, buildRequest)
+		close(results)
+	})
+	if !started {
+		client.removeSearch(s)
+		return nil, ErrClientStopped
+	}
+
+	return results, nil
+}
@@ -0,0 +1,249 @@
+package kad
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sleepy/network/common/tag"
+	"sleepy/network/kad/common"
+	"strings"
+)
+
+// Operators of the search expressions, encoded as in the ed2k server searches
+const (
+	searchOperatorBoolean = 0x00
+	searchOperatorString  = 0x01
+	searchOperatorMeta    = 0x02
+	searchOperatorUInt32  = 0x03
+	searchOperatorUInt64  = 0x08
+
+	searchBooleanAnd = 0x00
+	searchBooleanOr  = 0x01
+	searchBooleanNot = 0x02
+)
+
+// Comparisons of the numeric search expressions
+const (
+	SearchEqual        = uint8(0x00)
+	SearchGreater      = uint8(0x01)
+	SearchLess         = uint8(0x02)
+	SearchGreaterEqual = uint8(0x03)
+	SearchLessEqual    = uint8(0x04)
+	SearchNotEqual     = uint8(0x05)
+)
+
+// SearchExpression is a tree of conditions the results of a keyword search must match
+type SearchExpression struct {
+	operator   byte
+	boolean    byte
+	text       string
+	tagName    byte
+	number     uint64
+	comparison uint8
+	left       *SearchExpression
+	right      *SearchExpression
+}
+
+// SearchAnd matches the results matching both expressions
+func SearchAnd(left *SearchExpression, right *SearchExpression) *SearchExpression {
+	return &SearchExpression{operator: searchOperatorBoolean, boolean: searchBooleanAnd, left: left, right: right}
+}
+
+// SearchOr matches the results matching any of the expressions
+func SearchOr(left *SearchExpression, right *SearchExpression) *SearchExpression {
+	return &SearchExpression{operator: searchOperatorBoolean, boolean: searchBooleanOr, left: left, right: right}
+}
+
+// SearchNot matches the results matching [left] but not [right]
+func SearchNot(left *SearchExpression, right *SearchExpression) *SearchExpression {
+	return &SearchExpression{operator: searchOperatorBoolean, boolean: searchBooleanNot, left: left, right: right}
+}
+
+// SearchWord matches the results whose name contains the word
+func SearchWord(word string) *SearchExpression {
+	return &SearchExpression{operator: searchOperatorString, text: word}
+}
+
+// SearchMeta matches the results with a string tag of the value, as the file type
+func SearchMeta(tagName byte, value string) *SearchExpression {
+	return &SearchExpression{operator: searchOperatorMeta, tagName: tagName, text: value}
+}
+
+// SearchNumber matches the results with a numeric tag satisfying the comparison, as the file size
+func SearchNumber(tagName byte, comparison uint8, value uint64) *SearchExpression {
+	return &SearchExpression{operator: searchOperatorUInt64, tagName: tagName, comparison: comparison, number: value}
+}
+
+// Encode the expression tree in prefix order
+func (expr *SearchExpression) Encode() []byte {
+	buffer := &bytes.Buffer{}
+	expr.encode(buffer)
+	return buffer.Bytes()
+}
+
+func (expr *SearchExpression) encode(buffer *bytes.Buffer) {
+	switch expr.operator {
+	case searchOperatorBoolean:
+		buffer.WriteByte(searchOperatorBoolean)
+		buffer.WriteByte(expr.boolean)
+		expr.left.encode(buffer)
+		expr.right.encode(buffer)
+	case searchOperatorString:
+		buffer.WriteByte(searchOperatorString)
+		writeSearchString(buffer, expr.text)
+	case searchOperatorMeta:
+		buffer.WriteByte(searchOperatorMeta)
+		writeSearchString(buffer, expr.text)
+		writeSearchTagName(buffer, expr.tagName)
+	default:
+		if expr.number > 0xFFFFFFFF {
+			buffer.WriteByte(searchOperatorUInt64)
+			binary.Write(buffer, binary.LittleEndian, expr.number)
+		} else {
+			buffer.WriteByte(searchOperatorUInt32)
+			binary.Write(buffer, binary.LittleEndian, uint32(expr.number))
+		}
+		buffer.WriteByte(expr.comparison)
+		writeSearchTagName(buffer, expr.tagName)
+	}
+}
+
+func writeSearchString(buffer *bytes.Buffer, text string) {
+	binary.Write(buffer, binary.LittleEndian, uint16(len(text)))
+	buffer.WriteString(text)
+}
+
+func writeSearchTagName(buffer *bytes.Buffer, name byte) {
+	binary.Write(buffer, binary.LittleEndian, uint16(1))
+	buffer.WriteByte(name)
+}
+
+// Max depth of the received expression trees, to refuse the abusive ones
+const maxSearchExpressionDepth = 24
+
+// Decode an expression tree encoded in prefix order
+func readSearchExpression(reader *Reader) (*SearchExpression, error) {
+	return readSearchExpressionNode(reader, 0)
+}
+
+func readSearchExpressionNode(reader *Reader, depth int) (*SearchExpression, error) {
+	if depth > maxSearchExpressionDepth {
+		return nil, errors.New("search expression too deep")
+	}
+
+	operator, err := reader.ReadUInt8()
+	if err != nil {
+		return nil, err
+	}
+
+	expr := &SearchExpression{operator: operator}
+	switch operator {
+	case searchOperatorBoolean:
+		if expr.boolean, err = reader.ReadUInt8(); err != nil {
+			return nil, err
+		}
+		if expr.boolean > searchBooleanNot {
+			return nil, errors.New("unknown search boolean operator")
+		}
+		if expr.left, err = readSearchExpressionNode(reader, depth+1); err != nil {
+			return nil, err
+		}
+		if expr.right, err = readSearchExpressionNode(reader, depth+1); err != nil {
+			return nil, err
+		}
+	case searchOperatorString:
+		if expr.text, err = readSearchString(reader); err != nil {
+			return nil, err
+		}
+		expr.text = strings.ToLower(expr.text)
+	case searchOperatorMeta:
+		if expr.text, err = readSearchString(reader); err != nil {
+			return nil, err
+		}
+		if expr.tagName, err = readSearchTagName(reader); err != nil {
+			return nil, err
+		}
+	case searchOperatorUInt32, searchOperatorUInt64:
+		if operator == searchOperatorUInt32 {
+			value, err := reader.ReadUInt32()
+			if err != nil {
+				return nil, err
+			}
+			expr.number = uint64(value)
+		} else if expr.number, err = reader.ReadUInt64(); err != nil {
+			return nil, err
+		}
+		expr.operator = searchOperatorUInt64
+		if expr.comparison, err = reader.ReadUInt8(); err != nil {
+			return nil, err
+		}
+		if expr.tagName, err = readSearchTagName(reader); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("unknown search operator")
+	}
+	return expr, nil
+}
+
+func readSearchString(reader *Reader) (string, error) {
+	size, err := reader.ReadUInt16()
+	if err != nil {
+		return "", err
+	}
+	return reader.ReadString(uint(size))
+}
+
+// Read a tag name, the names longer than one byte aren't used by Kad and get a name matching no tag
+func readSearchTagName(reader *Reader) (byte, error) {
+	name, err := readSearchString(reader)
+	if err != nil {
+		return 0, err
+	}
+	if len(name) != 1 {
+		return 0, nil
+	}
+	return name[0], nil
+}
+
+// Matches checks if the tags of a keyword entry satisfy the expression
+func (expr *SearchExpression) Matches(tags tag.List) bool {
+	switch expr.operator {
+	case searchOperatorBoolean:
+		switch expr.boolean {
+		case searchBooleanAnd:
+			return expr.left.Matches(tags) && expr.right.Matches(tags)
+		case searchBooleanOr:
+			return expr.left.Matches(tags) || expr.right.Matches(tags)
+		default:
+			return expr.left.Matches(tags) && !expr.right.Matches(tags)
+		}
+	case searchOperatorString:
+		name, _ := tags.GetString(common.TagFileName)
+		return strings.Contains(strings.ToLower(name), strings.ToLower(expr.text))
+	case searchOperatorMeta:
+		value, ok := tags.GetString(expr.tagName)
+		return ok && strings.EqualFold(value, expr.text)
+	default:
+		value, ok := tags.GetUInt(expr.tagName)
+		if !ok {
+			return false
+		}
+		switch expr.comparison {
+		case SearchEqual:
+			return value == expr.number
+		case SearchGreater:
+			return value > expr.number
+		case SearchLess:
+			return value < expr.number
+		case SearchGreaterEqual:
+			return value >= expr.number
+		case SearchLessEqual:
+			return value <= expr.number
+		case SearchNotEqual:
+			return value != expr.number
+		default:
+			return false
+		}
+	}
+}
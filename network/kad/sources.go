@@ -0,0 +1,94 @@
+package kad
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"sleepy/network/common/tag"
+	"sleepy/network/kad/common"
+	"sleepy/network/kad/index"
+	kadPacket "sleepy/network/kad/packet"
+	"sleepy/network/kad/packet/factory"
+	"sleepy/types"
+)
+
+// Max number of results of a source search, as the eMule SEARCHFILE_TOTAL
+const maxSourceResults = 300
+
+// Types of the published sources
+const (
+	SourceTypeOpen           = uint8(1) // Open source, as published by old clients
+	SourceTypeBuddy          = uint8(3) // Firewalled source reachable through his buddy
+	SourceTypeOpenV2         = uint8(4) // Open source
+	SourceTypeBuddyV2        = uint8(5) // Firewalled source reachable through his buddy, supporting the new protocols
+	SourceTypeDirectCallback = uint8(6) // Firewalled source accepting direct UDP callbacks
+)
+
+// SourceResult is a peer sharing a file, found by a source search
+type SourceResult struct {
+	ClientID types.UInt128
+	IP       net.IP
+	TCPPort  uint16
+	UDPPort  uint16
+	Type     uint8
+	// Buddy of the firewalled sources, the buddy ID is nil for other sources
+	BuddyID   types.UInt128
+	BuddyIP   net.IP
+	BuddyPort uint16
+	// CryptOptions are the obfuscation options supported by the source
+	CryptOptions uint8
+	Tags         tag.List
+}
+
+// IsFirewalled checks if the source can only be contacted through a buddy or a callback
+func (source *SourceResult) IsFirewalled() bool {
+	return source.Type == SourceTypeBuddy || source.Type == SourceTypeBuddyV2 || source.Type == SourceTypeDirectCallback
+}
+
+// SearchSources searches the peers sharing the file with the [fileHash] and [fileSize]. The sources are streamed
+// through the returned channel, that is closed when the search finishes
+func (client *Client) SearchSources(ctx context.Context, fileHash types.UInt128, fileSize uint64) (<-chan *SourceResult, error) {
+	return streamSearch(client, ctx, index.KindSource, fileHash, maxSourceResults,
+		func(from *net.UDPAddr, answer types.UInt128, tags tag.List) (*SourceResult, string, bool) {
+			source := newSourceResult(answer, tags)
+			return source, answer.ToHexString(), source.IP != nil && source.TCPPort != 0
+		},
+		func(contact *Contact) (*kadPacket.Packet, error) {
+			return factory.GetSearchSource2Request(fileHash, 0, fileSize)
+		})
+}
+
+func newSourceResult(clientId types.UInt128, tags tag.List) *SourceResult {
+	source := &SourceResult{ClientID: clientId, Tags: tags}
+
+	if sourceType, ok := tags.GetUInt(common.TagSourceType); ok {
+		source.Type = uint8(sourceType)
+	}
+	if ip, ok := tags.GetUInt(common.TagSourceIP); ok {
+		source.IP = tagIntAsIP(ip)
+	}
+	if port, ok := tags.GetUInt(common.TagSourcePort); ok {
+		source.TCPPort = uint16(port)
+	}
+	if port, ok := tags.GetUInt(common.TagSourceUDPPort); ok {
+		source.UDPPort = uint16(port)
+	}
+	if options, ok := tags.GetUInt(common.TagEncryption); ok {
+		source.CryptOptions = uint8(options)
+	}
+
+	// The firewalled sources announce their buddy through the server tags
+	if buddyHash, ok := tags.GetString(common.TagBuddyHash); ok {
+		if buddyId, err := hex.DecodeString(buddyHash); err == nil && len(buddyId) == 16 {
+			source.BuddyID, _ = types.NewUInt128FromByteArray(buddyId)
+		}
+	}
+	if ip, ok := tags.GetUInt(common.TagServerIP); ok {
+		source.BuddyIP = tagIntAsIP(ip)
+	}
+	if port, ok := tags.GetUInt(common.TagServerPort); ok {
+		source.BuddyPort = uint16(port)
+	}
+
+	return source
+}
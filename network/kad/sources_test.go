@@ -0,0 +1,84 @@
+package kad
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"sleepy/network/kad/packet/factory"
+	kadTypes "sleepy/network/kad/types"
+	"sleepy/types"
+	"testing"
+	"time"
+)
+
+func TestClient_SearchSources(t *testing.T) {
+	client, manager := newTestClient()
+	node := newTestPeer(types.NewUInt128(1, 2), 1)
+	client.router.AddPeer(node)
+	fileHash := types.NewUInt128(0x1111, 0x2222)
+	sourceId := types.NewUInt128(0x3333, 0x4444)
+	buddyId := types.NewUInt128(0x5555, 0x6666)
+
+	manager.onSend = func(sent sentPacket) {
+		target, _ := NewReader(sent.data[2:]).ReadUInt128()
+		var data []byte
+		switch sent.data[1] {
+		case CommKad2Req:
+			target, _ = NewReader(sent.data[3:]).ReadUInt128()
+			packet, _ := factory.GetKad2Response(target, []kadTypes.Peer{})
+			data = packet.GetData()
+		case CommKad2SearchSourceReq:
+			buffer := &bytes.Buffer{}
+			buffer.Write([]byte{0xE4, CommKad2SearchRes})
+			buffer.Write(kadUInt128Bytes(node.GetID()))
+			buffer.Write(kadUInt128Bytes(target))
+			binary.Write(buffer, binary.LittleEndian, uint16(1))
+			buffer.Write(kadUInt128Bytes(sourceId))
+			buffer.WriteByte(7)
+			buffer.Write([]byte{0x09, 0x01, 0x00, 0xFF, SourceTypeBuddyV2})
+			buffer.Write([]byte{0x03, 0x01, 0x00, 0xFE, 0x04, 0x03, 0x02, 0x01})
+			buffer.Write([]byte{0x08, 0x01, 0x00, 0xFD, 0x36, 0x12})
+			buffer.Write([]byte{0x08, 0x01, 0x00, 0xFC, 0x40, 0x12})
+			buffer.Write([]byte{0x02, 0x01, 0x00, 0xF8, 0x20, 0x00})
+			buffer.WriteString(buddyId.ToHexString())
+			buffer.Write([]byte{0x03, 0x01, 0x00, 0xFB, 0x08, 0x07, 0x06, 0x05})
+			buffer.Write([]byte{0x08, 0x01, 0x00, 0xFA, 0x40, 0x12})
+			data = buffer.Bytes()
+		default:
+			return
+		}
+		go client.handleUDP(data, &net.UDPAddr{IP: sent.ip, Port: int(sent.port)})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	results, err := client.SearchSources(ctx, fileHash, 0x1000)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	found := make([]*SourceResult, 0)
+	for result := range results {
+		found = append(found, result)
+	}
+
+	if len(found) != 1 {
+		t.Fatalf("A unique source must be found, %d found", len(found))
+	}
+	source := found[0]
+	if !source.ClientID.Equal(sourceId) || !source.IP.Equal(net.IPv4(1, 2, 3, 4)) || source.TCPPort != 4662 || source.UDPPort != 4672 {
+		t.Errorf("Source mismatch: %+v", source)
+	}
+	if !source.IsFirewalled() || source.BuddyID == nil || !source.BuddyID.Equal(buddyId) || !source.BuddyIP.Equal(net.IPv4(5, 6, 7, 8)) || source.BuddyPort != 4672 {
+		t.Errorf("Source buddy mismatch: %+v", source)
+	}
+
+	requests := manager.sentWithCommand(CommKad2SearchSourceReq)
+	if len(requests) != 1 {
+		t.Fatalf("The search request must be sent to the closest node")
+	}
+	if size := binary.LittleEndian.Uint64(requests[0].data[20:]); size != 0x1000 {
+		t.Errorf("The file size must be sent, got %d", size)
+	}
+}
@@ -0,0 +1,126 @@
+package kad
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"sleepy/network/common/tag"
+	"sleepy/network/kad/common"
+	"sleepy/network/kad/index"
+	kadPacket "sleepy/network/kad/packet"
+	"sleepy/network/kad/packet/factory"
+	"sleepy/types"
+	"time"
+)
+
+const (
+	publishTolerance         = 1 << 24 // Max distance of the published keys in the most significant 32 bits
+	maxSearchResponseEntries = 50      // Entries by search response packet
+	maxIndexResults          = 300     // Entries answered to a search, as the eMule SEARCH*_TOTAL
+	indexCleanInterval       = 30 * time.Minute
+)
+
+// Check if a published key is close enough to be stored by the local node
+func (client *Client) acceptsPublish(key types.UInt128) bool {
+	distance := types.Xor(client.config.ClientID, key)
+	_, hi := distance.ToUInt64()
+	return hi>>32 <= publishTolerance
+}
+
+// Send the entries found for a search in as many Kad2 packets as needed, skipping the first [start] ones
+func (client *Client) answerSearch(r *UDPRequest, target types.UInt128, entries []*index.Entry, start int) {
+	client.answerSearchWith(r, entries, start, func(answers []factory.SearchEntry) (*kadPacket.Packet, error) {
+		return factory.GetSearch2Response(client.config.ClientID, target, answers)
+	})
+}
+
+// Send the entries found for a search in the packets built by [buildResponse], skipping the first [start] ones
+func (client *Client) answerSearchWith(r *UDPRequest, entries []*index.Entry, start int, buildResponse func(answers []factory.SearchEntry) (*kadPacket.Packet, error)) {
+	if start >= len(entries) {
+		return
+	}
+	entries = entries[start:]
+
+	for len(entries) > 0 {
+		count := len(entries)
+		if count > maxSearchResponseEntries {
+			count = maxSearchResponseEntries
+		}
+
+		answers := make([]factory.SearchEntry, 0, count)
+		for _, entry := range entries[:count] {
+			answers = append(answers, factory.SearchEntry{Answer: entry.ID, Tags: entry.Tags})
+		}
+		entries = entries[count:]
+
+		packet, err := buildResponse(answers)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		err = client.reply(r, packet)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// Store a published keyword entry, rejecting the ones without the name and size of the file
+func (client *Client) storeKeywordEntry(keyword types.UInt128, fileHash types.UInt128, from net.IP, tags tag.List) (uint8, error) {
+	if _, ok := tags.GetString(common.TagFileName); !ok {
+		return 0, errors.New("keyword entry without file name")
+	}
+	if _, ok := tags.GetUInt(common.TagFileSize); !ok {
+		return 0, errors.New("keyword entry without file size")
+	}
+	return client.index.Add(index.KindKeyword, index.Entry{Key: keyword, ID: fileHash, IP: from, Tags: tags})
+}
+
+func (client *Client) acknowledgePublish(r *UDPRequest, target types.UInt128, load uint8) {
+	packet, err := factory.GetPublish2Response(target, load)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = client.reply(r, packet)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Tags of a source or notes entry, with the IP of the publisher as seen by us
+func publishedSourceTags(tags tag.List, from net.IP) tag.List {
+	sourceTags := tags.Without(tag.ID(common.TagSourceIP))
+	if ipv4 := from.To4(); ipv4 != nil {
+		sourceTags = append(sourceTags, tag.NewUInt32(tag.ID(common.TagSourceIP), binary.BigEndian.Uint32(ipv4)))
+	}
+	return sourceTags
+}
+
+// Accept the entries whose file size matches the searched one, if any
+func fileSizeFilter(size uint64) func(entry *index.Entry) bool {
+	return func(entry *index.Entry) bool {
+		if size == 0 {
+			return true
+		}
+		entrySize, ok := entry.Tags.GetUInt(common.TagFileSize)
+		return !ok || entrySize == size
+	}
+}
+
+// Remove periodically the expired entries of the index until the client stops
+func (client *Client) runIndexCleaner() {
+	ticker := time.NewTicker(indexCleanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-client.ctx.Done():
+			return
+		case <-ticker.C:
+			removed := client.index.Clean()
+			log.Printf("Removed %d expired index entries", removed)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package kad
+
+import (
+	"net"
+	"sleepy/network/common/tag"
+	"sleepy/network/kad/common"
+	"sleepy/network/kad/index"
+	"sleepy/network/kad/packet/factory"
+	"sleepy/types"
+	"testing"
+)
+
+// Read the entries of a search response sent by the client
+func readSentSearchResponse(t *testing.T, sent sentPacket) map[string]tag.List {
+	reader := NewReader(sent.data[2:])
+	reader.ReadUInt128()
+	reader.ReadUInt128()
+	count, err := reader.ReadUInt16()
+	if err != nil {
+		t.Fatalf("Invalid search response: %s", err)
+	}
+
+	entries := make(map[string]tag.List)
+	for ; count > 0; count-- {
+		answer, err := reader.ReadUInt128()
+		if err != nil {
+			t.Fatalf("Invalid search response: %s", err)
+		}
+		tags, err := reader.ReadTags()
+		if err != nil {
+			t.Fatalf("Invalid search response: %s", err)
+		}
+		entries[answer.ToHexString()] = tags
+	}
+	return entries
+}
+
+func TestSearchExpression_Matches(t *testing.T) {
+	expression := SearchNot(
+		SearchAnd(SearchWord("holidays"), SearchNumber(common.TagFileSize, SearchGreater, 0x800)),
+		SearchMeta(common.TagFileType, "Audio"))
+	decoded, err := readSearchExpression(NewReader(expression.Encode()))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	video := tag.List{
+		tag.NewString(tag.ID(common.TagFileName), "Holidays.avi"),
+		tag.NewUInt32(tag.ID(common.TagFileSize), 0x1000),
+		tag.NewString(tag.ID(common.TagFileType), "Video"),
+	}
+	audio := tag.List{
+		tag.NewString(tag.ID(common.TagFileName), "Holidays.mp3"),
+		tag.NewUInt32(tag.ID(common.TagFileSize), 0x1000),
+		tag.NewString(tag.ID(common.TagFileType), "Audio"),
+	}
+	small := tag.List{tag.NewString(tag.ID(common.TagFileName), "Holidays.txt"), tag.NewUInt32(tag.ID(common.TagFileSize), 0x10)}
+	if !decoded.Matches(video) || decoded.Matches(audio) || decoded.Matches(small) {
+		t.Errorf("The decoded expression must only match the video")
+	}
+}
+
+func TestClient_StoreAndSearchKeyword(t *testing.T) {
+	client, manager := newTestClient()
+	from := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672}
+	keyword := client.config.ClientID.Clone()
+	fileHash := types.NewUInt128(0x1111, 0x2222)
+
+	publish, _ := factory.GetPublishKey2Request(keyword, []factory.KeywordEntry{
+		{FileHash: fileHash, Name: "holidays.avi", Size: 0x1000},
+		{FileHash: types.NewUInt128(0x3333, 0x4444), Name: "holidays.mp3", Size: 0x1000},
+	})
+	client.handleUDP(publish.GetData(), from)
+
+	acks := manager.sentWithCommand(CommKad2PublishRes)
+	if len(acks) != 1 || client.index.Count(index.KindKeyword) != 2 {
+		t.Fatalf("The keyword entries must be stored and acknowledged")
+	}
+
+	search, _ := factory.GetSearchKey2Request(keyword, 0, SearchWord("avi").Encode())
+	client.handleUDP(search.GetData(), from)
+
+	responses := manager.sentWithCommand(CommKad2SearchRes)
+	if len(responses) != 1 {
+		t.Fatalf("The search must be answered")
+	}
+	entries := readSentSearchResponse(t, responses[0])
+	if len(entries) != 1 || entries[fileHash.ToHexString()][0].Value != "holidays.avi" {
+		t.Errorf("Only the file matching the expression must be answered, got %v", entries)
+	}
+}
+
+func TestClient_StoreAndSearchSource(t *testing.T) {
+	client, manager := newTestClient()
+	from := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672}
+	fileHash := client.config.ClientID.Clone()
+	source := types.NewUInt128(0x5555, 0x6666)
+
+	publish, _ := factory.GetPublishSource2Request(fileHash, source, factory.SourceDetails{
+		Type:     SourceTypeOpen,
+		TCPPort:  4662,
+		UDPPort:  4672,
+		FileSize: 0x1000,
+	})
+	client.handleUDP(publish.GetData(), from)
+	if len(manager.sentWithCommand(CommKad2PublishRes)) != 1 {
+		t.Fatalf("The source publish must be acknowledged")
+	}
+
+	otherSize, _ := factory.GetSearchSource2Request(fileHash, 0, 0x2000)
+	client.handleUDP(otherSize.GetData(), from)
+	if len(manager.sentWithCommand(CommKad2SearchRes)) != 0 {
+		t.Errorf("The sources of other file sizes must not be answered")
+	}
+
+	search, _ := factory.GetSearchSource2Request(fileHash, 0, 0x1000)
+	client.handleUDP(search.GetData(), from)
+	responses := manager.sentWithCommand(CommKad2SearchRes)
+	if len(responses) != 1 {
+		t.Fatalf("The search must be answered")
+	}
+	entries := readSentSearchResponse(t, responses[0])
+	result := newSourceResult(source, entries[source.ToHexString()])
+	if !result.IP.Equal(from.IP) || result.TCPPort != 4662 || result.UDPPort != 4672 {
+		t.Errorf("The source must be answered with the publisher IP, got %+v", result)
+	}
+}
+
+func TestClient_DistantPublish(t *testing.T) {
+	client, manager := newTestClient()
+	distant := types.Not(client.config.ClientID)
+
+	publish, _ := factory.GetPublishSource2Request(distant, types.NewUInt128(1, 1), factory.SourceDetails{Type: SourceTypeOpen, TCPPort: 4662})
+	client.handleUDP(publish.GetData(), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672})
+
+	if client.index.Count(index.KindSource) != 0 || len(manager.sentWithCommand(CommKad2PublishRes)) != 0 {
+		t.Errorf("The publishes of distant keys must be ignored")
+	}
+}
@@ -0,0 +1,10 @@
+package kad
+
+import (
+	"net"
+)
+
+// Get an IP stored in an integer tag, in host order
+func tagIntAsIP(value uint64) net.IP {
+	return net.IPv4(byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+}
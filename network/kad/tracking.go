@@ -0,0 +1,179 @@
+package kad
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sleepy/types"
+	"sync"
+	"time"
+)
+
+// Time a reply is waited when the request doesn't set it
+const expectedReplyTTL = 3 * time.Minute
+
+// ErrReplyTimeout is returned while waiting a reply that doesn't arrive in time
+var ErrReplyTimeout = errors.New("reply timeout")
+
+// ErrClientStopped is returned while waiting a reply when the client stops
+var ErrClientStopped = errors.New("client stopped")
+
+// ExpectedReply is a reply expected for a request sent to a peer
+type ExpectedReply struct {
+	key    string
+	target types.UInt128 // Target the reply must be about, any if nil
+	peerID types.UInt128 // Peer degraded if the reply doesn't arrive in time, none if nil
+	timer  *time.Timer
+	done   chan struct{}
+	err    error
+}
+
+// Wait blocks until the reply arrives, fails with ErrReplyTimeout if it expires, or with the [ctx] error if it ends
+func (reply *ExpectedReply) Wait(ctx context.Context) error {
+	select {
+	case <-reply.done:
+		return reply.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Replies expected by key, in the order the requests were sent
+type replyTracker struct {
+	expected map[string][]*ExpectedReply
+	access   sync.Mutex
+}
+
+// Key used to track the reply [opCode] expected from an [ip]
+func expectedReplyKey(ip net.IP, opCode byte) string {
+	return fmt.Sprintf("%s/%d", ip.String(), opCode)
+}
+
+// Register that a reply with [opCode] is expected from [ip], because a request has been sent to it
+func (client *Client) expectReply(ip net.IP, opCode byte) *ExpectedReply {
+	return client.trackReply(nil, ip, opCode, nil, expectedReplyTTL)
+}
+
+// Register that a reply with [opCode] about the [target] is expected from the peer with [peerID] in [ip]. If it
+// doesn't arrive before the [timeout] the peer is degraded, as it's probably gone. The [target] and [peerID] are
+// optional
+func (client *Client) trackReply(peerID types.UInt128, ip net.IP, opCode byte, target types.UInt128, timeout time.Duration) *ExpectedReply {
+	client.replies.access.Lock()
+	defer client.replies.access.Unlock()
+	return client.addReply(peerID, ip, opCode, target, timeout)
+}
+
+// Register that a reply with [opCode] is expected from [ip], unless one is already expected. It's used for the
+// replies to the requests started by the peers, so that a peer repeating them doesn't grow the tracker
+func (client *Client) expectSingleReply(ip net.IP, opCode byte) {
+	client.replies.access.Lock()
+	defer client.replies.access.Unlock()
+	for _, reply := range client.replies.expected[expectedReplyKey(ip, opCode)] {
+		// The expired ones are being removed
+		if reply.timer.Reset(expectedReplyTTL) {
+			return
+		}
+	}
+	client.addReply(nil, ip, opCode, nil, expectedReplyTTL)
+}
+
+// Register an expected reply, with the tracker locked
+func (client *Client) addReply(peerID types.UInt128, ip net.IP, opCode byte, target types.UInt128, timeout time.Duration) *ExpectedReply {
+	reply := &ExpectedReply{key: expectedReplyKey(ip, opCode), target: target, peerID: peerID, done: make(chan struct{})}
+	if client.ctx.Err() != nil {
+		// The replies are no longer tracked
+		reply.err = ErrClientStopped
+		close(reply.done)
+		return reply
+	}
+	client.replies.expected[reply.key] = append(client.replies.expected[reply.key], reply)
+	reply.timer = time.AfterFunc(timeout, func() {
+		client.expireReply(reply)
+	})
+	return reply
+}
+
+// Check if a reply with [opCode] about the [target] was expected from [ip] and resolve the oldest matching one. A nil
+// [target] matches any of them
+func (client *Client) consumeExpectedReply(ip net.IP, opCode byte, target types.UInt128) bool {
+	client.replies.access.Lock()
+	defer client.replies.access.Unlock()
+
+	key := expectedReplyKey(ip, opCode)
+	for i, reply := range client.replies.expected[key] {
+		if target != nil && reply.target != nil && !reply.target.Equal(target) {
+			continue
+		}
+		client.removeReply(key, i)
+		reply.timer.Stop()
+		close(reply.done)
+		return true
+	}
+	return false
+}
+
+// Remove an expired reply and degrade his peer, waking up the callers waiting for it
+func (client *Client) expireReply(reply *ExpectedReply) {
+	if !client.takeReply(reply) {
+		// Already answered
+		return
+	}
+
+	reply.err = ErrReplyTimeout
+	close(reply.done)
+
+	if reply.peerID != nil {
+		if peer, err := client.router.GetPeer(reply.peerID); err == nil && peer != nil {
+			peer.DegradeType()
+		}
+	}
+}
+
+// Forget an expected reply whose request couldn't be sent, waking up the callers waiting for it with [err]. His
+// peer isn't degraded, as it was never asked
+func (client *Client) cancelReply(reply *ExpectedReply, err error) {
+	if !client.takeReply(reply) {
+		return
+	}
+	reply.timer.Stop()
+	reply.err = err
+	close(reply.done)
+}
+
+// Remove a reply if it's still expected, telling if it was
+func (client *Client) takeReply(reply *ExpectedReply) bool {
+	client.replies.access.Lock()
+	defer client.replies.access.Unlock()
+	for i, pending := range client.replies.expected[reply.key] {
+		if pending == reply {
+			client.removeReply(reply.key, i)
+			return true
+		}
+	}
+	return false
+}
+
+// Remove the expected reply in the position [i] of the [key], with the tracker locked
+func (client *Client) removeReply(key string, i int) {
+	replies := client.replies.expected[key]
+	if len(replies) == 1 {
+		delete(client.replies.expected, key)
+		return
+	}
+	client.replies.expected[key] = append(replies[:i:i], replies[i+1:]...)
+}
+
+// Forget all the expected replies, waking up the callers waiting for them with ErrClientStopped
+func (client *Client) cancelReplies() {
+	client.replies.access.Lock()
+	defer client.replies.access.Unlock()
+	for key, replies := range client.replies.expected {
+		for _, reply := range replies {
+			reply.timer.Stop()
+			reply.err = ErrClientStopped
+			close(reply.done)
+		}
+		delete(client.replies.expected, key)
+	}
+}
@@ -0,0 +1,81 @@
+package kad
+
+import (
+	"context"
+	"net"
+	kadTypes "sleepy/network/kad/types"
+	"sleepy/types"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Peer counting his degradations, as the real ones ignore them shortly after being created
+type degradeCountingPeer struct {
+	kadTypes.Peer
+	degraded int32
+}
+
+func (peer *degradeCountingPeer) DegradeType() {
+	atomic.AddInt32(&peer.degraded, 1)
+	peer.Peer.DegradeType()
+}
+
+func TestClient_ReplyTimeout(t *testing.T) {
+	client, _ := newTestClient()
+	peer := &degradeCountingPeer{Peer: newTestPeer(types.NewUInt128(1, 0), 1)}
+	if err := client.router.AddPeer(peer); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	reply := client.trackReply(peer.GetID(), peer.GetIP(), CommKad2Pong, nil, 20*time.Millisecond)
+	if err := reply.Wait(context.Background()); err != ErrReplyTimeout {
+		t.Fatalf("The reply must time out, got %v", err)
+	}
+
+	if atomic.LoadInt32(&peer.degraded) != 1 {
+		t.Errorf("The peer not answering must be degraded")
+	}
+	if client.consumeExpectedReply(peer.GetIP(), CommKad2Pong, nil) {
+		t.Errorf("The late replies must be ignored")
+	}
+}
+
+func TestClient_ReplyTarget(t *testing.T) {
+	client, _ := newTestClient()
+	ip := net.IPv4(10, 0, 0, 1)
+	target := types.NewUInt128(1, 1)
+
+	reply := client.trackReply(nil, ip, CommKad2Res, target, time.Minute)
+	if client.consumeExpectedReply(ip, CommKad2Res, types.NewUInt128(2, 2)) {
+		t.Errorf("The replies about other targets must not match")
+	}
+	if !client.consumeExpectedReply(ip, CommKad2Res, target) {
+		t.Fatalf("The reply about the target must match")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := reply.Wait(ctx); err != nil {
+		t.Errorf("The waiting caller must be resolved, got %s", err)
+	}
+	if client.consumeExpectedReply(ip, CommKad2Res, target) {
+		t.Errorf("A reply must only be consumed once")
+	}
+}
+
+func TestClient_Ping(t *testing.T) {
+	client, manager := newTestClient()
+	contact := &Contact{ClientID: types.NewUInt128(1, 0), IP: net.IPv4(10, 0, 0, 1), UDPPort: 4672, Version: 8}
+	manager.onSend = func(sent sentPacket) {
+		if sent.data[1] == CommKad2Ping {
+			go client.handleUDP([]byte{0xE4, CommKad2Pong, 0x40, 0x12}, &net.UDPAddr{IP: sent.ip, Port: int(sent.port)})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.Ping(ctx, contact); err != nil {
+		t.Errorf("The pong must resolve the ping, got %s", err)
+	}
+}
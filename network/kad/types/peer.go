@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net"
 	"sleepy/types"
+	"sync"
 	"time"
 )
 
@@ -15,6 +16,12 @@ const (
 	LongTimePeerType = byte(0x00)
 )
 
+// UDPKey is the key a peer gave us to obfuscate the packets sent to it, only valid while our public IP is [IP]
+type UDPKey struct {
+	Key uint32
+	IP  net.IP
+}
+
 type Peer interface {
 	Equal(other Peer) bool
 	GetID() types.UInt128
@@ -34,6 +41,11 @@ type Peer interface {
 	SetTCPPort(port uint16)
 	GetProtocolVersion() uint8
 	SetProtocolVersion(version uint8)
+	GetUDPKey() UDPKey
+	SetUDPKey(key UDPKey)
+	// SupportsObfuscation checks if the peer is known to accept obfuscated packets
+	SupportsObfuscation() bool
+	SetObfuscationSupport(supported bool)
 	GetCreatedAt() time.Time
 	GetExpiresAt() time.Time
 	// SetExpiration set the expiration time
@@ -55,12 +67,15 @@ type peerImp struct {
 	udpPort         uint16
 	tcpPort         uint16
 	protocolVersion uint8
+	udpKey          UDPKey
+	obfuscation     bool
 	ipVerified      bool
 	created         time.Time
 	expires         time.Time
 	typeCode        byte
 	typeUpdated     time.Time
 	useCounter      uint
+	access          sync.RWMutex
 }
 
 func newEmptyPeer() *peerImp {
@@ -70,6 +85,7 @@ func newEmptyPeer() *peerImp {
 		udpPort:         0,
 		tcpPort:         0,
 		protocolVersion: 0,
+		udpKey:          UDPKey{},
 		ipVerified:      false,
 		created:         time.Now(),
 		expires:         time.Time{},
@@ -92,19 +108,25 @@ func (peer *peerImp) GetID() types.UInt128 {
 }
 
 func (peer *peerImp) SetIP(ip net.IP, verified bool) {
+	peer.access.Lock()
+	defer peer.access.Unlock()
 	peer.ip = make(net.IP, len(ip))
 	copy(peer.ip, ip)
 	peer.ipVerified = verified
 }
 
 func (peer *peerImp) GetIP() net.IP {
+	peer.access.RLock()
+	defer peer.access.RUnlock()
 	cpy := make(net.IP, len(peer.ip))
 	copy(cpy, peer.ip)
 	return cpy
 }
 
 func (peer *peerImp) VerifyIp(ip net.IP) bool {
-	if !ip.Equal(peer.GetIP()) {
+	peer.access.Lock()
+	defer peer.access.Unlock()
+	if !ip.Equal(peer.ip) {
 		peer.ipVerified = false
 		return false
 	} else {
@@ -115,26 +137,36 @@ func (peer *peerImp) VerifyIp(ip net.IP) bool {
 
 // Check if the current IP is verified
 func (peer *peerImp) IsIPVerified() bool {
+	peer.access.RLock()
+	defer peer.access.RUnlock()
 	return peer.ipVerified
 }
 
 // Set the UDP port of the peer
 func (peer *peerImp) SetUDPPort(port uint16) {
+	peer.access.Lock()
+	defer peer.access.Unlock()
 	peer.udpPort = port
 }
 
 // Get the UDP port of the peer
 func (peer *peerImp) GetUDPPort() uint16 {
+	peer.access.RLock()
+	defer peer.access.RUnlock()
 	return peer.udpPort
 }
 
 // Set the TCP port of the peer
 func (peer *peerImp) SetTCPPort(port uint16) {
+	peer.access.Lock()
+	defer peer.access.Unlock()
 	peer.tcpPort = port
 }
 
 // Get the TCP port of the peer
 func (peer *peerImp) GetTCPPort() uint16 {
+	peer.access.RLock()
+	defer peer.access.RUnlock()
 	return peer.tcpPort
 }
 
@@ -144,9 +176,11 @@ func (peer *peerImp) GetDistance(id types.UInt128) types.UInt128 {
 
 // Check if the peer is alive
 func (peer *peerImp) IsAlive() bool {
+	peer.access.Lock()
+	defer peer.access.Unlock()
 	if peer.typeCode < ExpiredPeerType {
 		// If expiration time is past
-		if peer.expires.Before(time.Now()) && peer.GetExpiresAt().After(time.Time{}) {
+		if peer.expires.Before(time.Now()) && peer.expires.After(time.Time{}) {
 			peer.typeCode = ExpiredPeerType
 			return false
 		} else {
@@ -162,46 +196,92 @@ func (peer *peerImp) IsAlive() bool {
 }
 
 func (peer *peerImp) GetCreatedAt() time.Time {
+	peer.access.RLock()
+	defer peer.access.RUnlock()
 	return peer.created
 }
 
 func (peer *peerImp) GetExpiresAt() time.Time {
+	peer.access.RLock()
+	defer peer.access.RUnlock()
 	return peer.expires
 }
 
 func (peer *peerImp) SetExpiration(expires time.Time) {
+	peer.access.Lock()
+	defer peer.access.Unlock()
 	peer.expires = expires
 }
 
 func (peer *peerImp) GetTypeCode() byte {
+	peer.access.RLock()
+	defer peer.access.RUnlock()
 	return peer.typeCode
 }
 
 func (peer *peerImp) GetTypeUpdatedAt() time.Time {
+	peer.access.RLock()
+	defer peer.access.RUnlock()
 	return peer.typeUpdated
 }
 
 // Get the protocol version
 func (peer *peerImp) GetProtocolVersion() uint8 {
+	peer.access.RLock()
+	defer peer.access.RUnlock()
 	return peer.protocolVersion
 }
 
 // Set the protocol version
 func (peer *peerImp) SetProtocolVersion(version uint8) {
+	peer.access.Lock()
+	defer peer.access.Unlock()
 	peer.protocolVersion = version
 }
 
+// Get the UDP key given by the peer
+func (peer *peerImp) GetUDPKey() UDPKey {
+	peer.access.RLock()
+	defer peer.access.RUnlock()
+	return peer.udpKey
+}
+
+// Set the UDP key given by the peer
+func (peer *peerImp) SetUDPKey(key UDPKey) {
+	peer.access.Lock()
+	defer peer.access.Unlock()
+	peer.udpKey = key
+}
+
+func (peer *peerImp) SupportsObfuscation() bool {
+	peer.access.RLock()
+	defer peer.access.RUnlock()
+	return peer.obfuscation
+}
+
+func (peer *peerImp) SetObfuscationSupport(supported bool) {
+	peer.access.Lock()
+	defer peer.access.Unlock()
+	peer.obfuscation = supported
+}
+
 func (peer *peerImp) InUse() bool {
+	peer.access.RLock()
+	defer peer.access.RUnlock()
 	return peer.useCounter > 0
 }
 
 // Add a use flag
 func (peer *peerImp) AddUse() {
+	peer.access.Lock()
+	defer peer.access.Unlock()
 	peer.useCounter++
 }
 
 // Remove a use flag
 func (peer *peerImp) RemoveUse() {
+	peer.access.Lock()
+	defer peer.access.Unlock()
 	if peer.useCounter > 0 {
 		peer.useCounter--
 	} else {
@@ -216,6 +296,8 @@ func (peer *peerImp) Equal(otherPeer Peer) bool {
 }
 
 func (peer *peerImp) DegradeType() {
+	peer.access.Lock()
+	defer peer.access.Unlock()
 	// If type rechecked less than 10 seconds ago or is expired, ignore
 	if time.Now().Sub(peer.typeUpdated) < time.Second*10 || peer.typeCode == ExpiredPeerType {
 		return
@@ -229,6 +311,8 @@ func (peer *peerImp) DegradeType() {
 
 // Update peer type based on internal times
 func (peer *peerImp) UpdateType() {
+	peer.access.Lock()
+	defer peer.access.Unlock()
 	hoursOnline := time.Now().Sub(peer.created)
 
 	if hoursOnline > 2*time.Hour {
@@ -245,6 +329,8 @@ func (peer *peerImp) UpdateType() {
 
 // Get the time on which peer has been viewed last time
 func (peer *peerImp) LastSeen() time.Time {
+	peer.access.RLock()
+	defer peer.access.RUnlock()
 	if !peer.expires.Equal(time.Time{}) {
 		if peer.typeCode == OneHourPeerType {
 			return peer.expires.Add(-time.Hour)
@@ -259,15 +345,32 @@ func (peer *peerImp) LastSeen() time.Time {
 
 func (peer *peerImp) UpdateFrom(otherPeer Peer) error {
 	if peer.Equal(otherPeer) {
-		peer.ip = otherPeer.GetIP()
-		peer.udpPort = otherPeer.GetUDPPort()
-		peer.tcpPort = otherPeer.GetTCPPort()
-		peer.protocolVersion = otherPeer.GetProtocolVersion()
-		peer.ipVerified = otherPeer.IsIPVerified()
-		peer.created = otherPeer.GetCreatedAt()
-		peer.expires = otherPeer.GetExpiresAt()
-		peer.typeCode = otherPeer.GetTypeCode()
-		peer.typeUpdated = otherPeer.GetTypeUpdatedAt()
+		// Read the other peer before locking, as it may be the same one
+		ip := otherPeer.GetIP()
+		udpPort := otherPeer.GetUDPPort()
+		tcpPort := otherPeer.GetTCPPort()
+		protocolVersion := otherPeer.GetProtocolVersion()
+		udpKey := otherPeer.GetUDPKey()
+		obfuscation := otherPeer.SupportsObfuscation()
+		ipVerified := otherPeer.IsIPVerified()
+		created := otherPeer.GetCreatedAt()
+		expires := otherPeer.GetExpiresAt()
+		typeCode := otherPeer.GetTypeCode()
+		typeUpdated := otherPeer.GetTypeUpdatedAt()
+
+		peer.access.Lock()
+		defer peer.access.Unlock()
+		peer.ip = ip
+		peer.udpPort = udpPort
+		peer.tcpPort = tcpPort
+		peer.protocolVersion = protocolVersion
+		peer.udpKey = udpKey
+		peer.obfuscation = obfuscation
+		peer.ipVerified = ipVerified
+		peer.created = created
+		peer.expires = expires
+		peer.typeCode = typeCode
+		peer.typeUpdated = typeUpdated
 		return nil
 	} else {
 		return errors.New("the peer information only can be updated with the information of other peer with the same id")
@@ -1,37 +1,348 @@
 package network
 
 import (
-	"fmt"
+	"bufio"
+	"errors"
+	"log"
 	"net"
 	"sleepy/network/common/udp"
+	ed2kCommon "sleepy/network/ed2k/common"
+	"sleepy/network/ipfilter"
+	"strconv"
+	"sync"
+	"time"
 )
 
+const (
+	udpWorkers      = 16   // Goroutines handling the received datagrams
+	udpQueueSize    = 256  // Datagrams waiting to be handled, the ones received while full are dropped
+	maxDatagramSize = 8192 // Bigger than any datagram of the eD2k and Kad protocols
+	// Time given to the accepted connections to send their protocol byte, and the header of their first message if ed2k
+	tcpProtocolTimeout = 10 * time.Second
+	ed2kHeaderSize     = 6 // Protocol byte, size and opcode
+)
+
+// UDPHandler handles a datagram received from [from], including its protocol byte
+type UDPHandler func(data []byte, from *net.UDPAddr) error
+
+// TCPHandler handles an accepted connection, that must be closed by the handler. The protocol byte is not consumed
+type TCPHandler func(conn net.Conn)
+
 type Manager interface {
+	// Start binds the sockets and dispatches the received data to the registered handlers until Stop is called
+	Start() error
+	// Stop closes the sockets, returning once the datagrams being handled are done. The accepted connections
+	// belong to their handlers
+	Stop()
+	// UDPPort returns the local port of the UDP socket
+	UDPPort() uint16
+	// TCPPort returns the local port of the TCP listener, zero if there is none
+	TCPPort() uint16
+	// SendUDP sends the [packet] to [ip]:[port] from the UDP socket
 	SendUDP(ip net.IP, port uint16, packet udp.Packet) error
+	// DialTCP opens a TCP connection to [ip]:[port], failing if it's not established before the [timeout]
+	DialTCP(ip net.IP, port uint16, timeout time.Duration) (net.Conn, error)
+	// HandleUDP registers the handler of the datagrams starting with the [protocol] byte. A nil handler unregisters it
+	HandleUDP(protocol byte, handler UDPHandler)
+	// HandleUnknownUDP registers the handler of the datagrams of unregistered protocols, as the obfuscated ones
+	HandleUnknownUDP(handler UDPHandler)
+	// HandleTCP registers the handler of the connections starting with the [protocol] byte. A nil handler
+	// unregisters it. The ed2k connections are dispatched by HandleEd2kTCP instead
+	HandleTCP(protocol byte, handler TCPHandler)
+	// HandleEd2kTCP registers the handler of the ed2k connections whose first message has the [opcode], so the
+	// clients sharing the TCP port get their own messages. A nil handler unregisters it
+	HandleEd2kTCP(opcode byte, handler TCPHandler)
+	// IPFilter returns the filter of the addresses blocked in both directions
+	IPFilter() *ipfilter.Filter
+}
+
+// Datagram received, waiting to be handled
+type datagram struct {
+	data []byte
+	from *net.UDPAddr
 }
 
 type manager struct {
+	udpPort uint16
+	tcpPort uint16
+	filter  *ipfilter.Filter
+
+	udpConn     *net.UDPConn
+	tcpListener net.Listener
+	datagrams   chan datagram
+	stop        chan struct{}
+	// Goroutines reading the sockets and handling the datagrams, waited by Stop
+	routines sync.WaitGroup
+
+	udpHandlers       map[byte]UDPHandler
+	unknownUDPHandler UDPHandler
+	tcpHandlers       map[byte]TCPHandler
+	ed2kHandlers      map[byte]TCPHandler
+	access            sync.RWMutex
 }
 
 var _ Manager = &manager{}
 
-func NewManager() Manager {
-	return &manager{}
+// NewManager creates a manager using the [udpPort] and the [tcpPort] once started. The TCP connections aren't
+// accepted if [tcpPort] is zero
+func NewManager(udpPort uint16, tcpPort uint16) Manager {
+	return &manager{
+		udpPort:      udpPort,
+		tcpPort:      tcpPort,
+		filter:       ipfilter.NewFilter(ipfilter.DefaultLevel),
+		udpHandlers:  make(map[byte]UDPHandler),
+		tcpHandlers:  make(map[byte]TCPHandler),
+		ed2kHandlers: make(map[byte]TCPHandler),
+	}
 }
 
-func (m *manager) SendUDP(ip net.IP, port uint16, packet udp.Packet) error {
-	fmt.Printf("Mandando paquete a %s:%d => ", ip.String(), port)
-	fmt.Println(packet.GetData())
+func (m *manager) Start() error {
+	m.access.Lock()
+	defer m.access.Unlock()
+	if m.udpConn != nil {
+		return errors.New("the manager is already started")
+	}
 
-	conn, err := net.Dial("udp", fmt.Sprintf("%s:%d", ip.String(), port))
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: int(m.udpPort)})
 	if err != nil {
 		return err
 	}
+	if m.tcpPort != 0 {
+		tcpListener, err := net.Listen("tcp", ":"+strconv.Itoa(int(m.tcpPort)))
+		if err != nil {
+			udpConn.Close()
+			return err
+		}
+		m.tcpListener = tcpListener
+		m.routines.Add(1)
+		go m.listenTCP(tcpListener)
+	}
+	m.udpConn = udpConn
+	m.datagrams = make(chan datagram, udpQueueSize)
+	m.stop = make(chan struct{})
+
+	m.routines.Add(udpWorkers + 1)
+	for i := 0; i < udpWorkers; i++ {
+		go m.runUDPWorker(m.datagrams, m.stop)
+	}
+	go m.listenUDP(udpConn, m.datagrams, m.stop)
+	return nil
+}
+
+func (m *manager) Stop() {
+	m.access.Lock()
+	if m.udpConn == nil {
+		m.access.Unlock()
+		return
+	}
+
+	close(m.stop)
+	m.udpConn.Close()
+	m.udpConn = nil
+	if m.tcpListener != nil {
+		m.tcpListener.Close()
+		m.tcpListener = nil
+	}
+	m.access.Unlock()
+
+	// Waited unlocked, as the handlers running may use the manager
+	m.routines.Wait()
+}
+
+func (m *manager) UDPPort() uint16 {
+	m.access.RLock()
+	defer m.access.RUnlock()
+	if m.udpConn != nil {
+		return uint16(m.udpConn.LocalAddr().(*net.UDPAddr).Port)
+	}
+	return m.udpPort
+}
+
+func (m *manager) TCPPort() uint16 {
+	m.access.RLock()
+	defer m.access.RUnlock()
+	if m.tcpListener != nil {
+		return uint16(m.tcpListener.Addr().(*net.TCPAddr).Port)
+	}
+	return m.tcpPort
+}
+
+func (m *manager) IPFilter() *ipfilter.Filter {
+	return m.filter
+}
+
+func (m *manager) HandleUDP(protocol byte, handler UDPHandler) {
+	m.access.Lock()
+	defer m.access.Unlock()
+	if handler == nil {
+		delete(m.udpHandlers, protocol)
+	} else {
+		m.udpHandlers[protocol] = handler
+	}
+}
+
+func (m *manager) HandleUnknownUDP(handler UDPHandler) {
+	m.access.Lock()
+	defer m.access.Unlock()
+	m.unknownUDPHandler = handler
+}
+
+func (m *manager) HandleTCP(protocol byte, handler TCPHandler) {
+	m.access.Lock()
+	defer m.access.Unlock()
+	if handler == nil {
+		delete(m.tcpHandlers, protocol)
+	} else {
+		m.tcpHandlers[protocol] = handler
+	}
+}
+
+func (m *manager) HandleEd2kTCP(opcode byte, handler TCPHandler) {
+	m.access.Lock()
+	defer m.access.Unlock()
+	if handler == nil {
+		delete(m.ed2kHandlers, opcode)
+	} else {
+		m.ed2kHandlers[opcode] = handler
+	}
+}
+
+func (m *manager) SendUDP(ip net.IP, port uint16, packet udp.Packet) error {
+	if m.filter.IsFiltered(ip) {
+		return errors.New("the address " + ip.String() + " is filtered")
+	}
+
+	m.access.RLock()
+	udpConn := m.udpConn
+	m.access.RUnlock()
+	if udpConn == nil {
+		return errors.New("the manager isn't started")
+	}
+
+	_, err := udpConn.WriteToUDP(packet.GetData(), &net.UDPAddr{IP: ip, Port: int(port)})
+	return err
+}
+
+func (m *manager) DialTCP(ip net.IP, port uint16, timeout time.Duration) (net.Conn, error) {
+	if m.filter.IsFiltered(ip) {
+		return nil, errors.New("the address " + ip.String() + " is filtered")
+	}
+	return net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(int(port))), timeout)
+}
+
+// Read the datagrams of [udpConn] and queue them for the workers, until [stop] is closed
+func (m *manager) listenUDP(udpConn *net.UDPConn, datagrams chan<- datagram, stop <-chan struct{}) {
+	defer m.routines.Done()
+	buf := make([]byte, maxDatagramSize)
+
+	for {
+		n, addr, err := udpConn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-stop:
+				return
+			default:
+				log.Printf("UDP read error: %s", err)
+				continue
+			}
+		}
+		if n == 0 || m.filter.IsFiltered(addr.IP) {
+			continue
+		}
+
+		// The buffer is reused by the next read
+		data := make([]byte, n)
+		copy(data, buf[0:n])
+		select {
+		case datagrams <- datagram{data: data, from: addr}:
+		default:
+			log.Printf("Dropping datagram from %s: too many pending", addr)
+		}
+	}
+}
 
-	_, err = conn.Write(packet.GetData())
+// Handle the queued datagrams until [stop] is closed
+func (m *manager) runUDPWorker(datagrams <-chan datagram, stop <-chan struct{}) {
+	defer m.routines.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		case received := <-datagrams:
+			m.access.RLock()
+			handler, ok := m.udpHandlers[received.data[0]]
+			if !ok {
+				handler = m.unknownUDPHandler
+			}
+			m.access.RUnlock()
+
+			if handler == nil {
+				continue
+			}
+			if err := handler(received.data, received.from); err != nil {
+				log.Printf("Datagram handle error from %s: %s", received.from, err)
+			}
+		}
+	}
+}
+
+// Accept the connections of [listener] until it's closed
+func (m *manager) listenTCP(listener net.Listener) {
+	defer m.routines.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("TCP accept error: %s", err)
+			continue
+		}
+		go m.handleTCP(conn)
+	}
+}
+
+// Pass an accepted connection to the handler of its protocol byte, or of its first opcode if ed2k
+func (m *manager) handleTCP(conn net.Conn) {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && m.filter.IsFiltered(addr.IP) {
+		conn.Close()
+		return
+	}
+
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(tcpProtocolTimeout))
+	header, err := reader.Peek(1)
+	if err == nil && header[0] == ed2kCommon.ProtEd2kTCP {
+		header, err = reader.Peek(ed2kHeaderSize)
+	}
+	conn.SetReadDeadline(time.Time{})
 	if err != nil {
-		return err
+		conn.Close()
+		return
 	}
 
-	return nil
+	m.access.RLock()
+	var handler TCPHandler
+	var ok bool
+	if header[0] == ed2kCommon.ProtEd2kTCP {
+		handler, ok = m.ed2kHandlers[header[ed2kHeaderSize-1]]
+	} else {
+		handler, ok = m.tcpHandlers[header[0]]
+	}
+	m.access.RUnlock()
+	if !ok {
+		conn.Close()
+		return
+	}
+	handler(&peekedConn{Conn: conn, reader: reader})
+}
+
+// Connection whose first bytes were already read to a buffer
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *peekedConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
 }
@@ -0,0 +1,204 @@
+package network
+
+import (
+	"io"
+	"net"
+	"sleepy/network/common/udp"
+	"testing"
+	"time"
+)
+
+type received struct {
+	data []byte
+	from *net.UDPAddr
+}
+
+func rawPacket(data []byte) udp.Packet {
+	packet := udp.NewRawPacket()
+	packet.AppendBytes(data)
+	return packet
+}
+
+func startTestManager(t *testing.T, tcpPort uint16) *manager {
+	m := NewManager(0, tcpPort).(*manager)
+	if err := m.Start(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return m
+}
+
+func receivedTo(m *manager, protocol byte) chan received {
+	datagrams := make(chan received, 4)
+	m.HandleUDP(protocol, func(data []byte, from *net.UDPAddr) error {
+		datagrams <- received{data: data, from: from}
+		return nil
+	})
+	return datagrams
+}
+
+func TestManager_UDP(t *testing.T) {
+	sender := startTestManager(t, 0)
+	defer sender.Stop()
+	receiver := startTestManager(t, 0)
+	defer receiver.Stop()
+
+	kad := receivedTo(receiver, 0xe4)
+	unknown := make(chan received, 4)
+	receiver.HandleUnknownUDP(func(data []byte, from *net.UDPAddr) error {
+		unknown <- received{data: data, from: from}
+		return nil
+	})
+
+	localhost := net.IPv4(127, 0, 0, 1)
+	sender.SendUDP(localhost, receiver.UDPPort(), rawPacket([]byte{0xe4, 0x01}))
+	select {
+	case datagram := <-kad:
+		if datagram.data[1] != 0x01 || datagram.from.Port != int(sender.UDPPort()) {
+			t.Errorf("The datagram must be sent from the bound port, got %v from %s", datagram.data, datagram.from)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("The datagram must be handled by the protocol handler")
+	}
+
+	sender.SendUDP(localhost, receiver.UDPPort(), rawPacket([]byte{0x12, 0x34}))
+	select {
+	case <-unknown:
+	case <-time.After(time.Second):
+		t.Fatalf("The datagrams of unknown protocols must be handled by the unknown handler")
+	}
+
+	receiver.IPFilter().Add(localhost, localhost, 0, "Test")
+	sender.SendUDP(localhost, receiver.UDPPort(), rawPacket([]byte{0xe4, 0x02}))
+	select {
+	case <-kad:
+		t.Errorf("The datagrams from filtered IPs must be dropped")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := receiver.SendUDP(localhost, sender.UDPPort(), rawPacket([]byte{0xe4})); err == nil {
+		t.Errorf("Sending to filtered IPs must fail")
+	}
+}
+
+func TestManager_TCP(t *testing.T) {
+	m := NewManager(0, 0).(*manager)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer listener.Close()
+	m.routines.Add(1)
+	go m.listenTCP(listener)
+
+	messages := make(chan []byte, 1)
+	m.HandleTCP(0xc5, func(conn net.Conn) {
+		defer conn.Close()
+		message := make([]byte, 3)
+		if _, err := io.ReadFull(conn, message); err == nil {
+			messages <- message
+		}
+	})
+
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+	conn, err := m.DialTCP(net.IPv4(127, 0, 0, 1), port, time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte{0xc5, 0x01, 0x02})
+
+	select {
+	case message := <-messages:
+		if message[0] != 0xc5 || message[2] != 0x02 {
+			t.Errorf("The handler must read the whole message, got %v", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("The connection must be handled by the protocol handler")
+	}
+
+	other, err := m.DialTCP(net.IPv4(127, 0, 0, 1), port, time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer other.Close()
+	other.Write([]byte{0xd4})
+	other.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := other.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("The connections of unknown protocols must be closed, got %v", err)
+	}
+}
+
+func TestManager_Ed2kTCP(t *testing.T) {
+	m := NewManager(0, 0).(*manager)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer listener.Close()
+	m.routines.Add(1)
+	go m.listenTCP(listener)
+
+	hellos := make(chan []byte, 1)
+	m.HandleEd2kTCP(0x01, func(conn net.Conn) {
+		defer conn.Close()
+		message := make([]byte, 7)
+		if _, err := io.ReadFull(conn, message); err == nil {
+			hellos <- message
+		}
+	})
+
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+	conn, err := m.DialTCP(net.IPv4(127, 0, 0, 1), port, time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte{0xe3, 0x02, 0x00, 0x00, 0x00, 0x01, 0xff})
+
+	select {
+	case message := <-hellos:
+		if message[5] != 0x01 || message[6] != 0xff {
+			t.Errorf("The handler must read the whole message, got %v", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("The connection must be handled by the opcode handler")
+	}
+
+	other, err := m.DialTCP(net.IPv4(127, 0, 0, 1), port, time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer other.Close()
+	other.Write([]byte{0xe3, 0x01, 0x00, 0x00, 0x00, 0x4c})
+	other.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := other.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("The ed2k connections of unknown opcodes must be closed, got %v", err)
+	}
+}
+
+func TestManager_StopWaitsHandlers(t *testing.T) {
+	sender := startTestManager(t, 0)
+	defer sender.Stop()
+	receiver := startTestManager(t, 0)
+
+	started := make(chan struct{})
+	finished := false
+	receiver.HandleUDP(0xe4, func(data []byte, from *net.UDPAddr) error {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		// The handlers may use the manager while it stops
+		receiver.HandleUDP(0xe4, nil)
+		finished = true
+		return nil
+	})
+
+	sender.SendUDP(net.IPv4(127, 0, 0, 1), receiver.UDPPort(), rawPacket([]byte{0xe4, 0x01}))
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatalf("The datagram must be handled")
+	}
+	receiver.Stop()
+	if !finished {
+		t.Errorf("Stop must wait for the datagrams being handled")
+	}
+}
@@ -0,0 +1,135 @@
+package md4
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// Size of an MD4 checksum in bytes
+const Size = 16
+
+// BlockSize of MD4 in bytes
+const BlockSize = 64
+
+type digest struct {
+	state  [4]uint32
+	buffer [BlockSize]byte
+	filled int
+	length uint64
+}
+
+var _ hash.Hash = &digest{}
+
+// New creates a hash.Hash computing the MD4 checksum (RFC 1320), the hash used by the ed2k and kad networks
+func New() hash.Hash {
+	d := new(digest)
+	d.Reset()
+	return d
+}
+
+// Sum returns the MD4 checksum of the data
+func Sum(data []byte) [Size]byte {
+	d := New()
+	d.Write(data)
+	var sum [Size]byte
+	copy(sum[:], d.Sum(nil))
+	return sum
+}
+
+func (d *digest) Reset() {
+	d.state = [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}
+	d.filled = 0
+	d.length = 0
+}
+
+func (d *digest) Size() int {
+	return Size
+}
+
+func (d *digest) BlockSize() int {
+	return BlockSize
+}
+
+func (d *digest) Write(data []byte) (int, error) {
+	written := len(data)
+	d.length += uint64(written)
+
+	if d.filled > 0 {
+		copied := copy(d.buffer[d.filled:], data)
+		d.filled += copied
+		data = data[copied:]
+		if d.filled < BlockSize {
+			return written, nil
+		}
+		d.block(d.buffer[:])
+		d.filled = 0
+	}
+
+	for len(data) >= BlockSize {
+		d.block(data[:BlockSize])
+		data = data[BlockSize:]
+	}
+
+	d.filled = copy(d.buffer[:], data)
+	return written, nil
+}
+
+func (d *digest) Sum(in []byte) []byte {
+	// Work on a copy, so the caller can keep writing
+	final := *d
+
+	padding := make([]byte, BlockSize+8)
+	padding[0] = 0x80
+	padLen := BlockSize - int(final.length%BlockSize)
+	if padLen < 9 {
+		padLen += BlockSize
+	}
+	binary.LittleEndian.PutUint64(padding[padLen-8:], final.length<<3)
+	final.Write(padding[:padLen])
+
+	sum := make([]byte, Size)
+	for i, value := range final.state {
+		binary.LittleEndian.PutUint32(sum[i*4:], value)
+	}
+	return append(in, sum...)
+}
+
+// Process a 64 bytes block
+func (d *digest) block(data []byte) {
+	var x [16]uint32
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint32(data[i*4:])
+	}
+
+	a, b, c, dd := d.state[0], d.state[1], d.state[2], d.state[3]
+
+	// Round 1
+	for _, i := range [4]int{0, 4, 8, 12} {
+		a = bits.RotateLeft32(a+((b&c)|(^b&dd))+x[i], 3)
+		dd = bits.RotateLeft32(dd+((a&b)|(^a&c))+x[i+1], 7)
+		c = bits.RotateLeft32(c+((dd&a)|(^dd&b))+x[i+2], 11)
+		b = bits.RotateLeft32(b+((c&dd)|(^c&a))+x[i+3], 19)
+	}
+
+	// Round 2
+	for _, i := range [4]int{0, 1, 2, 3} {
+		a = bits.RotateLeft32(a+((b&c)|(b&dd)|(c&dd))+x[i]+0x5a827999, 3)
+		dd = bits.RotateLeft32(dd+((a&b)|(a&c)|(b&c))+x[i+4]+0x5a827999, 5)
+		c = bits.RotateLeft32(c+((dd&a)|(dd&b)|(a&b))+x[i+8]+0x5a827999, 9)
+		b = bits.RotateLeft32(b+((c&dd)|(c&a)|(dd&a))+x[i+12]+0x5a827999, 13)
+	}
+
+	// Round 3
+	for _, i := range [4]int{0, 2, 1, 3} {
+		a = bits.RotateLeft32(a+(b^c^dd)+x[i]+0x6ed9eba1, 3)
+		dd = bits.RotateLeft32(dd+(a^b^c)+x[i+8]+0x6ed9eba1, 9)
+		c = bits.RotateLeft32(c+(dd^a^b)+x[i+4]+0x6ed9eba1, 11)
+		b = bits.RotateLeft32(b+(c^dd^a)+x[i+12]+0x6ed9eba1, 15)
+	}
+
+	d.state[0] += a
+	d.state[1] += b
+	d.state[2] += c
+	d.state[3] += dd
+}
@@ -0,0 +1,44 @@
+package md4
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// Test suite from RFC 1320
+func TestSum(t *testing.T) {
+	vectors := map[string]string{
+		"":                           "31d6cfe0d16ae931b73c59d7e0c089c0",
+		"a":                          "bde52cb31de33e46245e05fbdbd6fb24",
+		"abc":                        "a448017aaf21d8525fc10ae87aa6729d",
+		"message digest":             "d9130a8164549fe818874806e1c7014b",
+		"abcdefghijklmnopqrstuvwxyz": "d79e1c308aa5bbcdeea8ed63df412da9",
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789":                   "043f8582f241db351ce627e153e7f0e4",
+		"12345678901234567890123456789012345678901234567890123456789012345678901234567890": "e33b4ddc9c38f2199c3e7b164fcc0536",
+	}
+
+	for input, expected := range vectors {
+		sum := Sum([]byte(input))
+		if hex.EncodeToString(sum[:]) != expected {
+			t.Errorf("MD4(%q) = %x, want %s", input, sum, expected)
+		}
+	}
+}
+
+func TestDigest_Write(t *testing.T) {
+	input := strings.Repeat("1234567890", 20)
+	d := New()
+	for i := 0; i < len(input); i += 7 {
+		end := i + 7
+		if end > len(input) {
+			end = len(input)
+		}
+		d.Write([]byte(input[i:end]))
+	}
+
+	whole := Sum([]byte(input))
+	if hex.EncodeToString(d.Sum(nil)) != hex.EncodeToString(whole[:]) {
+		t.Errorf("Chunked writes must produce the same checksum")
+	}
+}